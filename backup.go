@@ -0,0 +1,513 @@
+package gozw
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// A controller backup starts with backupMagic and a format version byte,
+// followed by sections. Each section is a type byte, the length of its data
+// as a 4-byte big-endian integer, the data, and the CRC-32 (IEEE) of
+// everything before it in the section. The last section is always an end
+// section with no data.
+var backupMagic = []byte("GOZWBKUP")
+
+const backupVersion = 1
+
+// Backup section types.
+const (
+	backupSectionEnd         byte = 0x00
+	backupSectionInfo             = 0x01
+	backupSectionNVM              = 0x02
+	backupSectionDatabase         = 0x03
+	backupSectionNetworkKeys      = 0x04
+)
+
+// maxBackupSectionLength guards against allocating absurd amounts of memory
+// for a corrupt length.
+const maxBackupSectionLength = 64 << 20
+
+// NVM access methods, which differ between controller generations.
+const (
+	nvmTypeExt           = "ext"
+	nvmTypeBackupRestore = "backup-restore"
+)
+
+// nvmChunkSize is the number of bytes read or written per NVM request.
+const nvmChunkSize = 48
+
+// backupInfo describes the controller a backup was taken from.
+type backupInfo struct {
+	HomeID      uint32    `json:"home_id"`
+	NodeID      uint16    `json:"node_id"`
+	APIVersion  string    `json:"api_version"`
+	LibraryType string    `json:"library_type"`
+	NVMType     string    `json:"nvm_type"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type controllerBackup struct {
+	info        backupInfo
+	nvm         []byte
+	database    []byte
+	networkKeys []byte
+}
+
+// BackupController writes a backup of the controller to w: the raw NVM image
+// (which holds the network, including the home ID and routing tables), the
+// node database, and the S0 and S2 network keys, which are encrypted with a
+// storage key from the client's KeyProvider.
+//
+// 700/800-series controllers stop their radio while the NVM is read.
+func (c *Client) BackupController(ctx context.Context, w io.Writer) error {
+	nvmType, err := c.nvmType()
+	if err != nil {
+		return err
+	}
+
+	image, err := c.readNVM(ctx, nvmType)
+	if err != nil {
+		return errors.Wrap(err, "read nvm")
+	}
+
+	var database bytes.Buffer
+	err = c.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&database)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "read db")
+	}
+
+	networkKeys, err := sealKey(c.keys, c.networkKeys())
+	if err != nil {
+		return errors.Wrap(err, "encrypt network keys")
+	}
+
+	controller := c.controller()
+	info, err := json.Marshal(backupInfo{
+		HomeID:      controller.HomeID,
+		NodeID:      controller.NodeID,
+		APIVersion:  controller.APIVersion,
+		LibraryType: controller.APILibraryType,
+		NVMType:     nvmType,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(append(append([]byte(nil), backupMagic...), backupVersion)); err != nil {
+		return err
+	}
+
+	sections := []struct {
+		kind byte
+		data []byte
+	}{
+		{backupSectionInfo, info},
+		{backupSectionNVM, image},
+		{backupSectionDatabase, database.Bytes()},
+		{backupSectionNetworkKeys, networkKeys},
+		{backupSectionEnd, nil},
+	}
+
+	for _, section := range sections {
+		if err = writeBackupSection(w, section.kind, section.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreController restores a backup written by BackupController. The whole
+// backup is read and validated before anything is written; the controller must
+// use the same NVM access method as the one it was taken from. The NVM is
+// written and the controller reset, after which the node database and network
+// keys are replaced and the controller and its nodes reinitialized.
+//
+// The backup's network keys are decrypted with the client's KeyProvider, so a
+// backup can only be restored by an installation using the same provider (for
+// PassphraseKeyProvider, the same passphrase). Use ExportNetworkKeys and
+// ImportNetworkKeys to move the keys to one that doesn't.
+func (c *Client) RestoreController(ctx context.Context, r io.Reader) error {
+	backup, err := readBackup(r)
+	if err != nil {
+		return errors.Wrap(err, "read backup")
+	}
+
+	networkKeys, err := openNetworkKeys(c.keys, backup.networkKeys)
+	if err != nil {
+		return errors.Wrap(err, "decrypt network keys")
+	}
+
+	nvmType, err := c.nvmType()
+	if err != nil {
+		return err
+	}
+
+	if backup.info.NVMType != nvmType {
+		return fmt.Errorf("Backup NVM type %q doesn't match controller's (%q)", backup.info.NVMType, nvmType)
+	}
+
+	if apiVersion := c.controller().APIVersion; backup.info.APIVersion != apiVersion {
+		c.l.Warn("restoring backup from a different controller version",
+			zap.String("backup", backup.info.APIVersion),
+			zap.String("controller", apiVersion),
+		)
+	}
+
+	// open the node database before touching the controller, so a corrupt
+	// database is caught early
+	database, cleanup, err := openBackupDb(backup.database)
+	if err != nil {
+		return errors.Wrap(err, "open backup db")
+	}
+	defer cleanup()
+
+	if err = c.writeNVM(ctx, nvmType, backup.nvm); err != nil {
+		return errors.Wrap(err, "write nvm")
+	}
+
+	c.serialAPI.SoftReset(ctx)
+
+	if err = c.restoreDb(database); err != nil {
+		return errors.Wrap(err, "restore db")
+	}
+
+	// the copied controller bucket holds the keys encrypted for the
+	// installation the backup was taken from
+	if err = c.setNetworkKeys(networkKeys); err != nil {
+		return err
+	}
+
+	c.resetState()
+
+	return c.initZWave(ctx)
+}
+
+// nvmType returns how the controller's NVM is accessed.
+func (c *Client) nvmType() (string, error) {
+	controller := c.controller()
+
+	switch {
+	case controller.SupportsFunction(protocol.FnNVMBackupRestore):
+		return nvmTypeBackupRestore, nil
+	case controller.SupportsFunction(protocol.FnNVMExtReadLongBuffer) &&
+		controller.SupportsFunction(protocol.FnNVMExtWriteLongBuffer):
+		return nvmTypeExt, nil
+	default:
+		return "", errors.New("Controller doesn't support NVM backup")
+	}
+}
+
+func (c *Client) readNVM(ctx context.Context, nvmType string) ([]byte, error) {
+	if nvmType == nvmTypeExt {
+		id, err := c.serialAPI.NVMGetID(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if id.Size == 0 {
+			return nil, errors.New("Unknown NVM size")
+		}
+
+		image := make([]byte, 0, id.Size)
+		for offset := uint32(0); offset < id.Size; offset += nvmChunkSize {
+			length := id.Size - offset
+			if length > nvmChunkSize {
+				length = nvmChunkSize
+			}
+
+			data, err := c.serialAPI.NVMExtReadLongBuffer(ctx, offset, uint16(length))
+			if err != nil {
+				return nil, errors.Wrapf(err, "read offset %d", offset)
+			}
+
+			image = append(image, data...)
+		}
+
+		return image, nil
+	}
+
+	size, err := c.serialAPI.NVMBackupOpen(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	defer c.closeNVM()
+
+	image := make([]byte, 0, size)
+	for len(image) < int(size) {
+		length := int(size) - len(image)
+		if length > nvmChunkSize {
+			length = nvmChunkSize
+		}
+
+		data, eof, err := c.serialAPI.NVMBackupRead(ctx, uint16(len(image)), byte(length))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read offset %d", len(image))
+		}
+
+		image = append(image, data...)
+
+		if eof || len(data) == 0 {
+			break
+		}
+	}
+
+	if len(image) != int(size) {
+		return nil, fmt.Errorf("Read %d bytes of %d byte NVM", len(image), size)
+	}
+
+	return image, nil
+}
+
+func (c *Client) writeNVM(ctx context.Context, nvmType string, image []byte) error {
+	if nvmType == nvmTypeExt {
+		id, err := c.serialAPI.NVMGetID(ctx)
+		if err != nil {
+			return err
+		}
+
+		if int(id.Size) != len(image) {
+			return fmt.Errorf("Backup NVM size %d doesn't match controller's (%d)", len(image), id.Size)
+		}
+
+		for offset := 0; offset < len(image); offset += nvmChunkSize {
+			end := offset + nvmChunkSize
+			if end > len(image) {
+				end = len(image)
+			}
+
+			if err = c.serialAPI.NVMExtWriteLongBuffer(ctx, uint32(offset), image[offset:end]); err != nil {
+				return errors.Wrapf(err, "write offset %d", offset)
+			}
+		}
+
+		return nil
+	}
+
+	size, err := c.serialAPI.NVMBackupOpen(ctx)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer c.closeNVM()
+
+	if int(size) != len(image) {
+		return fmt.Errorf("Backup NVM size %d doesn't match controller's (%d)", len(image), size)
+	}
+
+	for offset := 0; offset < len(image); offset += nvmChunkSize {
+		end := offset + nvmChunkSize
+		if end > len(image) {
+			end = len(image)
+		}
+
+		if err = c.serialAPI.NVMBackupWrite(ctx, uint16(offset), image[offset:end]); err != nil {
+			return errors.Wrapf(err, "write offset %d", offset)
+		}
+	}
+
+	return nil
+}
+
+// closeNVM closes the NVM after a backup or restore. It uses the client's
+// context, since the radio stays off until the NVM is closed, even if the
+// backup was canceled.
+func (c *Client) closeNVM() {
+	if err := c.serialAPI.NVMBackupClose(c.ctx); err != nil {
+		c.l.Error("closing nvm", zap.Error(err))
+	}
+}
+
+// openBackupDb writes a node database image to a temporary file and opens it
+// read-only. cleanup closes and removes it.
+func openBackupDb(image []byte) (db *bolt.DB, cleanup func(), err error) {
+	f, err := ioutil.TempFile("", "gozw-restore")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := f.Name()
+	_, err = f.Write(image)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, nil, err
+	}
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		os.Remove(path)
+		return nil, nil, err
+	}
+
+	cleanup = func() {
+		db.Close()
+		os.Remove(path)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, name := range []string{"nodes", "controller"} {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("Missing %s bucket", name)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return db, cleanup, nil
+}
+
+// restoreDb replaces the stored node and controller information with the
+// backup's.
+func (c *Client) restoreDb(backup *bolt.DB) error {
+	return backup.View(func(src *bolt.Tx) error {
+		return c.db.Update(func(tx *bolt.Tx) error {
+			for _, name := range []string{"nodes", "controller"} {
+				if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+
+				bucket, err := tx.CreateBucket([]byte(name))
+				if err != nil {
+					return err
+				}
+
+				err = src.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+					return bucket.Put(k, v)
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			// the backup may predate 16-bit node IDs
+			return migrateNodeKeys(tx)
+		})
+	})
+}
+
+func writeBackupSection(w io.Writer, kind byte, data []byte) error {
+	header := make([]byte, 5)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+
+	sum := crc32.NewIEEE()
+	sum.Write(header)
+	sum.Write(data)
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, sum.Sum32())
+
+	for _, buf := range [][]byte{header, data, checksum} {
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readBackup reads and validates a backup. Unknown sections are skipped, so
+// that later versions can add sections without breaking older readers of the
+// same format version.
+func readBackup(r io.Reader) (*controllerBackup, error) {
+	header := make([]byte, len(backupMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+
+	if !bytes.Equal(header[:len(backupMagic)], backupMagic) {
+		return nil, errors.New("Not a controller backup")
+	}
+
+	if version := header[len(backupMagic)]; version != backupVersion {
+		return nil, fmt.Errorf("Unsupported backup version %d", version)
+	}
+
+	sections := map[byte][]byte{}
+
+	for {
+		sectionHeader := make([]byte, 5)
+		if _, err := io.ReadFull(r, sectionHeader); err != nil {
+			return nil, errors.Wrap(err, "read section header")
+		}
+
+		kind, length := sectionHeader[0], binary.BigEndian.Uint32(sectionHeader[1:])
+		if length > maxBackupSectionLength {
+			return nil, fmt.Errorf("Section %d too long (%d bytes)", kind, length)
+		}
+
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrapf(err, "read section %d", kind)
+		}
+
+		data, checksum := data[:length], binary.BigEndian.Uint32(data[length:])
+
+		sum := crc32.NewIEEE()
+		sum.Write(sectionHeader)
+		sum.Write(data)
+		if sum.Sum32() != checksum {
+			return nil, fmt.Errorf("Checksum mismatch in section %d", kind)
+		}
+
+		if kind == backupSectionEnd {
+			break
+		}
+
+		if _, ok := sections[kind]; ok {
+			return nil, fmt.Errorf("Duplicate section %d", kind)
+		}
+
+		sections[kind] = data
+	}
+
+	if n, _ := r.Read(make([]byte, 1)); n != 0 {
+		return nil, errors.New("Trailing data after end of backup")
+	}
+
+	for _, kind := range []byte{backupSectionInfo, backupSectionNVM, backupSectionDatabase, backupSectionNetworkKeys} {
+		if _, ok := sections[kind]; !ok {
+			return nil, fmt.Errorf("Missing section %d", kind)
+		}
+	}
+
+	backup := &controllerBackup{
+		nvm:         sections[backupSectionNVM],
+		database:    sections[backupSectionDatabase],
+		networkKeys: sections[backupSectionNetworkKeys],
+	}
+
+	if err := json.Unmarshal(sections[backupSectionInfo], &backup.info); err != nil {
+		return nil, errors.Wrap(err, "decode info")
+	}
+
+	if len(backup.nvm) == 0 {
+		return nil, errors.New("Empty NVM image")
+	}
+
+	return backup, nil
+}
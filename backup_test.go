@@ -0,0 +1,105 @@
+package gozw
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientBackupRestore(t *testing.T) {
+	for _, backupRestore := range []bool{false, true} {
+		name := nvmTypeExt
+		if backupRestore {
+			name = nvmTypeBackupRestore
+		}
+
+		t.Run(name, func(t *testing.T) {
+			image := make([]byte, 1024)
+			for i := range image {
+				image[i] = byte(i * 7)
+			}
+
+			controller := emulator.NewController()
+			controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+			controller.SetNVM(image, backupRestore)
+
+			client := newTestClient(t, controller)
+			ctx := context.Background()
+
+			var backup bytes.Buffer
+			require.NoError(t, client.BackupController(ctx, &backup))
+			s2Key := client.S2NetworkKey(security.KeyS2Authenticated)
+
+			// change everything the backup covers; an installation with a
+			// different storage key can't restore it
+			controller.SetNVM(make([]byte, len(image)), backupRestore)
+			require.NoError(t, client.resetDb())
+			client.useNetworkKey(make([]byte, 16))
+			client.setS2Keys(make([]byte, 16*len(security.S2KeyClasses)))
+
+			keys := client.keys
+			client.keys = StaticKeyProvider(bytes.Repeat([]byte{1}, 32))
+			assert.Equal(t, ErrNetworkKeyDecrypt, errors.Cause(client.RestoreController(ctx, bytes.NewReader(backup.Bytes()))))
+			assert.Equal(t, make([]byte, len(image)), controller.NVM())
+			assert.NotContains(t, backup.String(), string(testNetworkKey))
+
+			client.keys = keys
+			require.NoError(t, client.RestoreController(ctx, bytes.NewReader(backup.Bytes())))
+
+			assert.Equal(t, image, controller.NVM())
+			assert.Equal(t, testNetworkKey, client.NetworkKey())
+			assert.Equal(t, s2Key, client.S2NetworkKey(security.KeyS2Authenticated))
+			client.db.View(func(tx *bolt.Tx) error {
+				assert.NotNil(t, tx.Bucket([]byte("nodes")).Get(nodeKey(2)))
+				return nil
+			})
+			assert.Len(t, client.Nodes(), 2)
+		})
+	}
+}
+
+func TestClientRestoreInvalidBackup(t *testing.T) {
+	image := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	controller := emulator.NewController()
+	controller.SetNVM(image, true)
+
+	client := newTestClient(t, controller)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	require.NoError(t, client.BackupController(ctx, &buf))
+	backup := buf.Bytes()
+
+	corrupt := append([]byte(nil), backup...)
+	corrupt[len(corrupt)/2] ^= 0xFF
+
+	for name, data := range map[string][]byte{
+		"corrupt":   corrupt,
+		"truncated": backup[:len(backup)-9],
+		"trailing":  append(append([]byte(nil), backup...), 0),
+		"magic":     append([]byte("NOTABKUP"), backup[8:]...),
+	} {
+		controller.SetNVM(make([]byte, len(image)), true)
+
+		err := client.RestoreController(ctx, bytes.NewReader(data))
+		assert.Error(t, err, name)
+		assert.Equal(t, make([]byte, len(image)), controller.NVM(), name)
+	}
+
+	// an ExtNVM controller can't take a backup/restore image
+	other := emulator.NewController()
+	other.SetNVM(make([]byte, 8), false)
+
+	err := newTestClient(t, other).RestoreController(ctx, bytes.NewReader(backup))
+	assert.Error(t, err)
+	assert.Equal(t, make([]byte, 8), other.NVM())
+}
@@ -0,0 +1,273 @@
+package gozw
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"sync"
+
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// VirtualNodeHandler answers a command a node sent to a virtual node, parsed
+// with the version the handler was registered for. It returns the reply the
+// virtual node sends back, or nil.
+type VirtualNodeHandler func(node *VirtualNode, srcNodeID uint16, command cc.Command) encoding.BinaryMarshaler
+
+// VirtualNode is a virtual slave node hosted by a bridge controller, which
+// other controllers see as a separate node. It lets the application expose
+// devices that aren't Z-Wave devices to the network.
+//
+// Virtual nodes don't support security: they are never included securely, so
+// they only handle unencapsulated commands, and Security or Security 2
+// encapsulated commands sent to them are dropped.
+type VirtualNode struct {
+	NodeID uint16
+
+	client *Client
+
+	lock     sync.Mutex
+	handlers map[cc.CommandClassID]virtualNodeHandler
+	nodeInfo *nodeInformation
+}
+
+type virtualNodeHandler struct {
+	version uint8
+	handler VirtualNodeHandler
+}
+
+// bridgeState holds the virtual nodes of a bridge controller.
+type bridgeState struct {
+	lock  sync.Mutex
+	nodes map[uint16]*VirtualNode
+}
+
+func newVirtualNode(client *Client, nodeID uint16) *VirtualNode {
+	return &VirtualNode{
+		NodeID:   nodeID,
+		client:   client,
+		handlers: map[cc.CommandClassID]virtualNodeHandler{},
+	}
+}
+
+// initVirtualNodes loads the virtual nodes of a bridge controller, keeping the
+// handlers of those already known, and sets their node information frames
+// again since these only last until the controller is reset.
+func (c *Client) initVirtualNodes(ctx context.Context) error {
+	if controller := c.controller(); !controller.SupportsFunction(protocol.FnGetVirtualNodes) {
+		return nil
+	}
+
+	nodeIDs, err := c.serialAPI.GetVirtualNodes(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get virtual nodes")
+	}
+
+	c.bridge.lock.Lock()
+	nodes := make(map[uint16]*VirtualNode, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		if node, ok := c.bridge.nodes[nodeID]; ok {
+			nodes[nodeID] = node
+		} else {
+			nodes[nodeID] = newVirtualNode(c, nodeID)
+		}
+	}
+	c.bridge.nodes = nodes
+	c.bridge.lock.Unlock()
+
+	for _, node := range nodes {
+		if err = node.applyNodeInformation(ctx); err != nil {
+			return errors.Wrap(err, "set virtual node information")
+		}
+	}
+
+	return nil
+}
+
+// isVirtualNode reports whether a node is one of the controller's virtual
+// nodes.
+func (c *Client) isVirtualNode(nodeID uint16) bool {
+	_, err := c.VirtualNode(nodeID)
+	return err == nil
+}
+
+// VirtualNodes returns the virtual nodes hosted by a bridge controller.
+func (c *Client) VirtualNodes() map[uint16]*VirtualNode {
+	c.bridge.lock.Lock()
+	defer c.bridge.lock.Unlock()
+
+	nodes := make(map[uint16]*VirtualNode, len(c.bridge.nodes))
+	for nodeID, node := range c.bridge.nodes {
+		nodes[nodeID] = node
+	}
+
+	return nodes
+}
+
+// VirtualNode returns a single virtual node.
+func (c *Client) VirtualNode(nodeID uint16) (*VirtualNode, error) {
+	c.bridge.lock.Lock()
+	defer c.bridge.lock.Unlock()
+
+	if node, ok := c.bridge.nodes[nodeID]; ok {
+		return node, nil
+	}
+
+	return nil, errors.New("Virtual node not found")
+}
+
+// AddVirtualNode adds a virtual node to the network. The controller must be a
+// bridge controller able to assign node IDs (e.g. the primary controller).
+func (c *Client) AddVirtualNode(ctx context.Context) (*VirtualNode, error) {
+	nodeID, err := c.serialAPI.SetSlaveLearnMode(ctx, 0, protocol.SlaveLearnModeAdd)
+	if err != nil {
+		return nil, err
+	}
+
+	if nodeID == 0 {
+		return nil, errors.New("no node id assigned")
+	}
+
+	node := newVirtualNode(c, nodeID)
+
+	c.bridge.lock.Lock()
+	if c.bridge.nodes == nil {
+		c.bridge.nodes = map[uint16]*VirtualNode{}
+	}
+	c.bridge.nodes[nodeID] = node
+	c.bridge.lock.Unlock()
+
+	return node, nil
+}
+
+// RemoveVirtualNode removes a virtual node from the network.
+func (c *Client) RemoveVirtualNode(ctx context.Context, nodeID uint16) error {
+	if _, err := c.serialAPI.SetSlaveLearnMode(ctx, nodeID, protocol.SlaveLearnModeRemove); err != nil {
+		return err
+	}
+
+	c.bridge.lock.Lock()
+	delete(c.bridge.nodes, nodeID)
+	c.bridge.lock.Unlock()
+
+	return nil
+}
+
+// routeToVirtualNode hands an application command addressed to a virtual node
+// to it. It returns whether the command was for a virtual node.
+func (c *Client) routeToVirtualNode(cmd serialapi.ApplicationCommand) bool {
+	if cmd.CommandID != protocol.FnApplicationCommandHandlerBridge || cmd.DstNodeID == c.controller().NodeID {
+		return false
+	}
+
+	node, err := c.VirtualNode(cmd.DstNodeID)
+	if err != nil {
+		return false
+	}
+
+	go node.receiveApplicationCommand(cmd)
+
+	return true
+}
+
+// HandleCommandClass registers a handler for commands of the given command
+// class sent to the virtual node. A nil handler removes the handler. Commands
+// without a handler are ignored.
+func (n *VirtualNode) HandleCommandClass(commandClass cc.CommandClassID, version uint8, handler VirtualNodeHandler) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if handler == nil {
+		delete(n.handlers, commandClass)
+		return
+	}
+
+	n.handlers[commandClass] = virtualNodeHandler{version: version, handler: handler}
+}
+
+// SetNodeInformation sets the virtual node's node information frame: its
+// device options (a combination of protocol.ApplicationNodeInfo* flags),
+// device classes and supported command classes.
+func (n *VirtualNode) SetNodeInformation(ctx context.Context, deviceOptions, generic, specific byte, commandClasses []cc.CommandClassID) error {
+	n.lock.Lock()
+	n.nodeInfo = &nodeInformation{
+		deviceOptions:  deviceOptions,
+		generic:        generic,
+		specific:       specific,
+		commandClasses: append([]cc.CommandClassID(nil), commandClasses...),
+	}
+	n.lock.Unlock()
+
+	return n.applyNodeInformation(ctx)
+}
+
+func (n *VirtualNode) applyNodeInformation(ctx context.Context) error {
+	n.lock.Lock()
+	info := n.nodeInfo
+	n.lock.Unlock()
+
+	if info == nil {
+		return nil
+	}
+
+	return n.client.serialAPI.SetSlaveNodeInformation(ctx, n.NodeID, info.deviceOptions, info.generic, info.specific, info.commandClassBytes())
+}
+
+// SendNodeInformation sends the virtual node's node information frame to a
+// node, or to all nodes with protocol.NodeBroadcast (e.g. to be included by
+// another controller).
+func (n *VirtualNode) SendNodeInformation(ctx context.Context, dstNodeID uint16) (*serialapi.TransmitReport, error) {
+	return n.client.serialAPI.SendSlaveNodeInformation(ctx, n.NodeID, dstNodeID, protocol.DefaultTransmitOptions)
+}
+
+// SendCommand sends a command from the virtual node to a node.
+func (n *VirtualNode) SendCommand(ctx context.Context, dstNodeID uint16, command encoding.BinaryMarshaler, txOptions byte) (*serialapi.TransmitReport, error) {
+	payload, err := command.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return n.client.serialAPI.SendDataBridge(ctx, n.NodeID, dstNodeID, payload, txOptions)
+}
+
+func (n *VirtualNode) receiveApplicationCommand(cmd serialapi.ApplicationCommand) {
+	if len(cmd.CommandData) < 2 {
+		return
+	}
+
+	n.lock.Lock()
+	handler, ok := n.handlers[cc.CommandClassID(cmd.CommandData[0])]
+	n.lock.Unlock()
+
+	l := n.client.l.With(zap.String("virtual_node", fmt.Sprint(n.NodeID)), zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
+
+	switch cc.CommandClassID(cmd.CommandData[0]) {
+	case cc.Security, cc.Security2:
+		l.Warn("dropping secure command; virtual nodes only support unencapsulated commands")
+		return
+	}
+
+	if !ok {
+		l.Debug("unhandled virtual node command", zap.String("commandClass", cc.CommandClassID(cmd.CommandData[0]).String()))
+		return
+	}
+
+	command, err := cc.Parse(handler.version, cmd.CommandData)
+	if err != nil {
+		l.Error("error parsing command class", zap.Error(err))
+		return
+	}
+
+	reply := handler.handler(n, cmd.SrcNodeID, command)
+	if reply == nil {
+		return
+	}
+
+	if _, err = n.SendCommand(n.client.ctx, cmd.SrcNodeID, reply, protocol.DefaultTransmitOptions); err != nil {
+		l.Warn("sending virtual node reply", zap.Error(err))
+	}
+}
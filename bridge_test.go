@@ -0,0 +1,88 @@
+package gozw
+
+import (
+	"context"
+	"encoding"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/cc"
+	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientVirtualNodes(t *testing.T) {
+	controller := emulator.NewController()
+	controller.EnableBridge()
+	node := emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary))
+	controller.AddNode(node)
+
+	client := newTestClient(t, controller)
+	ctx := context.Background()
+
+	virtual, err := client.AddVirtualNode(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, virtual.NodeID)
+	assert.Equal(t, []uint16{3}, controller.VirtualNodes())
+
+	require.NoError(t, virtual.SetNodeInformation(ctx, protocol.ApplicationNodeInfoListening, 0x10, 0x01, []cc.CommandClassID{cc.SwitchBinary}))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]byte{protocol.ApplicationNodeInfoListening, 0x10, 0x01, 1, byte(cc.SwitchBinary)}, controller.VirtualNodeInformation(3))
+	}, time.Second, 10*time.Millisecond)
+
+	var value uint32
+	virtual.HandleCommandClass(cc.SwitchBinary, 1, func(v *VirtualNode, srcNodeID uint16, command cc.Command) encoding.BinaryMarshaler {
+		switch command := command.(type) {
+		case *switchbinary.Set:
+			atomic.StoreUint32(&value, uint32(command.SwitchValue))
+		case *switchbinary.Get:
+			return &switchbinary.Report{Value: byte(atomic.LoadUint32(&value))}
+		}
+		return nil
+	})
+
+	// Commands for the virtual node go to its handler rather than the
+	// controller's
+	node.EmitTo(3, []byte{byte(cc.SwitchBinary), byte(switchbinary.CommandSet), 0xFF})
+	assert.Eventually(t, func() bool { return atomic.LoadUint32(&value) == 0xFF }, time.Second, 10*time.Millisecond)
+
+	node.EmitTo(3, []byte{byte(cc.SwitchBinary), byte(switchbinary.CommandGet)})
+	assert.Eventually(t, func() bool {
+		for _, cmd := range node.Received() {
+			if assert.ObjectsAreEqual([]byte{byte(cc.SwitchBinary), byte(switchbinary.CommandReport), 0xFF}, cmd) {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = virtual.SendNodeInformation(ctx, protocol.NodeBroadcast)
+	assert.NoError(t, err)
+
+	_, err = virtual.SendCommand(ctx, 4, &switchbinary.Report{Value: 0x00}, protocol.DefaultTransmitOptions)
+	assert.Equal(t, serialapi.ErrTransmitNoAck, err)
+
+	// Virtual nodes are kept across a reset, and aren't interviewed as nodes
+	require.NoError(t, client.softReset(ctx))
+	kept, err := client.VirtualNode(3)
+	require.NoError(t, err)
+	assert.True(t, kept == virtual)
+	assert.NotContains(t, client.Nodes(), uint16(3))
+
+	require.NoError(t, client.RemoveVirtualNode(ctx, 3))
+	assert.Empty(t, controller.VirtualNodes())
+	assert.Empty(t, client.VirtualNodes())
+}
+
+func TestClientVirtualNodesUnsupported(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	_, err := client.AddVirtualNode(context.Background())
+	assert.Equal(t, serialapi.ErrUnsupportedFunction{FunctionID: protocol.FnSetSlaveLearnMode}, err)
+}
@@ -0,0 +1,74 @@
+// THIS FILE IS AUTO-GENERATED BY ZWGEN
+// DO NOT MODIFY
+
+package security2
+
+import (
+	"encoding/gob"
+	"errors"
+
+	"github.com/gozwave/gozw/cc"
+)
+
+const CommandCommandsSupportedReport cc.CommandID = 0x0E
+
+func init() {
+	gob.Register(CommandsSupportedReport{})
+	cc.Register(cc.CommandIdentifier{
+		CommandClass: cc.CommandClassID(0x9F),
+		Command:      cc.CommandID(0x0E),
+		Version:      1,
+	}, NewCommandsSupportedReport)
+}
+
+func NewCommandsSupportedReport() cc.Command {
+	return &CommandsSupportedReport{}
+}
+
+// <no value>
+type CommandsSupportedReport struct {
+	CommandClass []byte
+}
+
+func (cmd CommandsSupportedReport) CommandClassID() cc.CommandClassID {
+	return 0x9F
+}
+
+func (cmd CommandsSupportedReport) CommandID() cc.CommandID {
+	return CommandCommandsSupportedReport
+}
+
+func (cmd CommandsSupportedReport) CommandIDString() string {
+	return "SECURITY_2_COMMANDS_SUPPORTED_REPORT"
+}
+
+func (cmd *CommandsSupportedReport) UnmarshalBinary(data []byte) error {
+	// According to the docs, we must copy data if we wish to retain it after returning
+
+	payload := make([]byte, len(data))
+	copy(payload, data)
+
+	if len(payload) < 2 {
+		return errors.New("Payload length underflow")
+	}
+
+	i := 2
+
+	if len(payload) <= i {
+		return nil
+	}
+
+	cmd.CommandClass = payload[i:]
+
+	return nil
+}
+
+func (cmd *CommandsSupportedReport) MarshalBinary() (payload []byte, err error) {
+	payload = make([]byte, 2)
+	payload[0] = byte(cmd.CommandClassID())
+	payload[1] = byte(cmd.CommandID())
+
+	payload = append(payload, cmd.CommandClass...)
+
+	return
+}
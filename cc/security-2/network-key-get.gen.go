@@ -0,0 +1,75 @@
+// THIS FILE IS AUTO-GENERATED BY ZWGEN
+// DO NOT MODIFY
+
+package security2
+
+import (
+	"encoding/gob"
+	"errors"
+
+	"github.com/gozwave/gozw/cc"
+)
+
+const CommandNetworkKeyGet cc.CommandID = 0x09
+
+func init() {
+	gob.Register(NetworkKeyGet{})
+	cc.Register(cc.CommandIdentifier{
+		CommandClass: cc.CommandClassID(0x9F),
+		Command:      cc.CommandID(0x09),
+		Version:      1,
+	}, NewNetworkKeyGet)
+}
+
+func NewNetworkKeyGet() cc.Command {
+	return &NetworkKeyGet{}
+}
+
+// <no value>
+type NetworkKeyGet struct {
+	RequestedKey byte
+}
+
+func (cmd NetworkKeyGet) CommandClassID() cc.CommandClassID {
+	return 0x9F
+}
+
+func (cmd NetworkKeyGet) CommandID() cc.CommandID {
+	return CommandNetworkKeyGet
+}
+
+func (cmd NetworkKeyGet) CommandIDString() string {
+	return "SECURITY_2_NETWORK_KEY_GET"
+}
+
+func (cmd *NetworkKeyGet) UnmarshalBinary(data []byte) error {
+	// According to the docs, we must copy data if we wish to retain it after returning
+
+	payload := make([]byte, len(data))
+	copy(payload, data)
+
+	if len(payload) < 2 {
+		return errors.New("Payload length underflow")
+	}
+
+	i := 2
+
+	if len(payload) <= i {
+		return errors.New("slice index out of bounds")
+	}
+
+	cmd.RequestedKey = payload[i]
+	i++
+
+	return nil
+}
+
+func (cmd *NetworkKeyGet) MarshalBinary() (payload []byte, err error) {
+	payload = make([]byte, 2)
+	payload[0] = byte(cmd.CommandClassID())
+	payload[1] = byte(cmd.CommandID())
+
+	payload = append(payload, cmd.RequestedKey)
+
+	return
+}
@@ -0,0 +1,128 @@
+package gozw
+
+import (
+	"time"
+
+	"github.com/gozwave/gozw/transport"
+	"go.uber.org/zap"
+)
+
+// serialAPIReadyTimeout is how long to wait for FnSerialAPIReady after the
+// transport has been reopened. Older controllers never send it, so the
+// controller is assumed to be ready once this expires.
+const serialAPIReadyTimeout = 3 * time.Second
+
+// If reinitializing the controller fails, it is retried with exponential
+// backoff between these delays.
+const (
+	minReinitDelay = 1 * time.Second
+	maxReinitDelay = 30 * time.Second
+)
+
+// ConnectionState describes the connection between the client and the
+// controller.
+type ConnectionState int
+
+const (
+	// ConnectionLost means the transport failed. Requests are held in the
+	// session queue until the connection is ready again.
+	ConnectionLost ConnectionState = iota
+	// ConnectionReopened means the transport has been reopened, but the
+	// controller has not been reinitialized yet.
+	ConnectionReopened
+	// ConnectionReady means the controller has been reinitialized and queued
+	// requests are being sent again.
+	ConnectionReady
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionLost:
+		return "lost"
+	case ConnectionReopened:
+		return "reopened"
+	case ConnectionReady:
+		return "ready"
+	default:
+		return "unknown"
+	}
+}
+
+// SetConnectionStateCallback will set the callback for connection state
+// changes.
+func (c *Client) SetConnectionStateCallback(callback func(c *Client, state ConnectionState)) {
+	c.ConnectionStateCallback = callback
+}
+
+func (c *Client) handleConnectionStates(notifier transport.StateNotifier) {
+	for {
+		select {
+		case state := <-notifier.ConnectionStates():
+			switch state {
+			case transport.Disconnected:
+				c.l.Warn("lost connection to controller")
+				c.sessionLayer.Pause()
+
+				// Discard any stale notification so we wait for the next one
+				select {
+				case <-c.serialAPI.SerialAPIReady():
+				default:
+				}
+
+				c.setConnectionState(ConnectionLost)
+
+			case transport.Connected:
+				c.l.Info("connection to controller reopened")
+				c.setConnectionState(ConnectionReopened)
+				c.recoverController()
+			}
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// recoverController waits for the controller to become ready after the
+// transport was reopened, then resumes the session and reinitializes the
+// controller, retrying until it succeeds.
+func (c *Client) recoverController() {
+	select {
+	case <-c.serialAPI.SerialAPIReady():
+	case <-time.After(serialAPIReadyTimeout):
+		c.l.Debug("no serial api ready notification, assuming controller is ready")
+	case <-c.ctx.Done():
+		return
+	}
+
+	c.sessionLayer.Resume()
+
+	delay := minReinitDelay
+	for {
+		err := c.initZWave(c.ctx)
+		if err == nil {
+			break
+		}
+
+		c.l.Error("reinitializing z-wave", zap.Error(err), zap.String("retry", delay.String()))
+
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			return
+		}
+
+		delay *= 2
+		if delay > maxReinitDelay {
+			delay = maxReinitDelay
+		}
+	}
+
+	c.setConnectionState(ConnectionReady)
+}
+
+func (c *Client) setConnectionState(state ConnectionState) {
+	if c.ConnectionStateCallback != nil {
+		c.ConnectionStateCallback(c, state)
+	}
+}
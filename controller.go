@@ -1,16 +1,77 @@
 package gozw
 
+import "github.com/gozwave/gozw/protocol"
+
 // Controller contains information for the controller.
 type Controller struct {
-	APIVersion          string `json:"apiversion"`
-	APILibraryType      string `json:"apilibrary_type"`
-	HomeID              uint32 `json:"home_id"`
-	NodeID              byte   `json:"node_id"`
-	Version             byte   `json:"version"`
-	APIType             string `json:"apitype"`
-	IsPrimaryController bool   `json:"is_primary_controller"`
-	ApplicationVersion  byte   `json:"application_version"`
-	ApplicationRevision byte   `json:"application_revision"`
-	SupportedFunctions  []byte `json:"supported_functions"`
-	NodeList            []byte `json:"node_list"`
+	APIVersion          string   `json:"apiversion"`
+	APILibraryType      string   `json:"apilibrary_type"`
+	HomeID              uint32   `json:"home_id"`
+	NodeID              uint16   `json:"node_id"`
+	Version             byte     `json:"version"`
+	APIType             string   `json:"apitype"`
+	IsPrimaryController bool     `json:"is_primary_controller"`
+	ApplicationVersion  byte     `json:"application_version"`
+	ApplicationRevision byte     `json:"application_revision"`
+	SupportedFunctions  []byte   `json:"supported_functions"`
+	NodeList            []uint16 `json:"node_list"`
+
+	// LibraryType is the protocol.Library* type of the controller's firmware,
+	// and ManufacturerID, ProductType and ProductID identify it.
+	LibraryType    byte   `json:"library_type"`
+	ManufacturerID uint16 `json:"manufacturer_id"`
+	ProductType    uint16 `json:"product_type"`
+	ProductID      uint16 `json:"product_id"`
+
+	// SupportedSetupCommands are the Serial API setup subcommands the
+	// controller supports, and MaxPayloadSize the largest command it can send
+	// in one frame (0 if it can't tell).
+	SupportedSetupCommands []byte `json:"supported_setup_commands"`
+	MaxPayloadSize         byte   `json:"max_payload_size"`
+
+	// SUCNodeID is the node ID of the network's static update controller, or
+	// 0 if there is none. IsSIS is true if this controller is the SUC and
+	// also a SUC ID server (SIS).
+	SUCNodeID uint16 `json:"suc_node_id"`
+	IsSIS     bool   `json:"is_sis"`
+}
+
+// IsSUC returns whether this controller is the network's static update
+// controller.
+func (c *Controller) IsSUC() bool {
+	return c.SUCNodeID != 0 && c.SUCNodeID == c.NodeID
+}
+
+// SupportsLongRange returns whether the controller supports Z-Wave Long
+// Range, which needs 16-bit node IDs.
+func (c *Controller) SupportsLongRange() bool {
+	return c.SupportsSetupCommand(protocol.SerialAPISetupSetNodeIDType) && c.SupportsFunction(protocol.FnGetLongRangeNodes)
+}
+
+// SupportsFunction returns whether the controller supports the given Serial
+// API function.
+func (c *Controller) SupportsFunction(functionID byte) bool {
+	for _, fn := range c.SupportedFunctions {
+		if fn == functionID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SupportsSetupCommand returns whether the controller supports the given
+// Serial API setup subcommand.
+func (c *Controller) SupportsSetupCommand(subcommand byte) bool {
+	if !c.SupportsFunction(protocol.FnSerialAPISetup) {
+		return false
+	}
+
+	for _, command := range c.SupportedSetupCommands {
+		if command == subcommand {
+			return true
+		}
+	}
+
+	return false
 }
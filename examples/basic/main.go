@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/gozwave/gozw"
 	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/protocol"
 	"github.com/davecgh/go-spew/spew"
 )
 
-var networkKey = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-
 func main() {
-	client, err := gozw.NewDefaultClient("/tmp/data.db", "/dev/ttyACM0", 115200, networkKey)
+	passphrase := os.Getenv("GOZW_PASSPHRASE")
+	if passphrase == "" {
+		log.Fatal("GOZW_PASSPHRASE must be set to encrypt the network keys")
+	}
+
+	client, err := gozw.NewDefaultClient("/tmp/data.db", "/dev/ttyACM0", 115200, gozw.PassphraseKeyProvider(passphrase))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -30,7 +36,10 @@ func main() {
 		log.Fatalf("retrieve node: %v", err)
 	}
 
-	err = node.SendCommand(&switchbinary.Get{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = node.SendCommand(ctx, &switchbinary.Get{}, protocol.DefaultTransmitOptions)
 	if err != nil {
 		log.Fatalf("send command: %v", err)
 	}
@@ -50,6 +50,15 @@ func NewRequestFrame(payload []byte) *Frame {
 	}
 }
 
+// NewResponseFrame will build a new response frame.
+func NewResponseFrame(payload []byte) *Frame {
+	return &Frame{
+		Header:  HeaderData,
+		Type:    TypeResponse,
+		Payload: payload,
+	}
+}
+
 // NewNakFrame returns a new  nak frame.
 func NewNakFrame() *Frame {
 	return &Frame{
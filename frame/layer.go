@@ -3,15 +3,34 @@ package frame
 import (
 	"context"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
+// Host retransmission parameters from INS12350 section 6.
+const (
+	ackTimeout         = 1600 * time.Millisecond
+	maxRetransmissions = 3
+	retransmitBase     = 100 * time.Millisecond
+	retransmitStep     = 1 * time.Second
+)
+
+var (
+	// ErrAckTimeout means the controller did not acknowledge a frame in time.
+	ErrAckTimeout = errors.New("ack timed out")
+	// ErrNak means the controller rejected a frame (e.g. bad checksum).
+	ErrNak = errors.New("frame rejected with nak")
+	// ErrCan means the controller dropped a frame because it collided with
+	// a frame it was sending.
+	ErrCan = errors.New("frame dropped with can")
+)
+
 // ILayer is an interface for a frame layer.
 type ILayer interface {
-	Write(frame *Frame)
+	Write(frame *Frame) error
 	GetOutputChannel() <-chan Frame
 }
 
@@ -19,6 +38,10 @@ type ILayer interface {
 type Layer struct {
 	transportLayer io.ReadWriter
 
+	// writeLock keeps ACK/NAK/CAN frames written by callers from interleaving
+	// with data frames written by bgWork on stream transports
+	writeLock sync.Mutex
+
 	frameParser      *Parser
 	parserInput      chan<- byte
 	parserOutput     <-chan *ParseEvent
@@ -26,12 +49,17 @@ type Layer struct {
 
 	l *zap.Logger
 
-	pendingWrites chan *Frame
+	pendingWrites chan *pendingWrite
 	frameOutput   chan Frame
 
 	ctx context.Context
 }
 
+type pendingWrite struct {
+	frame  *Frame
+	result chan error
+}
+
 // NewFrameLayer will return a new frame layer.
 func NewFrameLayer(ctx context.Context, transportLayer io.ReadWriter, logger *zap.Logger) (*Layer, error) {
 	if _, ok := transportLayer.(io.ByteReader); !ok {
@@ -55,7 +83,7 @@ func NewFrameLayer(ctx context.Context, transportLayer io.ReadWriter, logger *za
 		naks:           naks,
 		cans:           cans,
 		l:              logger,
-		pendingWrites:  make(chan *Frame),
+		pendingWrites:  make(chan *pendingWrite),
 		frameOutput:    make(chan Frame, 5),
 		ctx:            ctx,
 	}
@@ -72,18 +100,7 @@ func (l *Layer) bgWork() {
 	for {
 		select {
 		case frameIn := <-l.parserOutput:
-			l.l.Debug("parser output received")
-
-			if frameIn.status == ParseOk {
-				l.sendAck()
-				l.l.Debug("received frame successfully, writing output")
-				l.frameOutput <- frameIn.frame
-			} else if frameIn.status == ParseNotOk {
-				l.l.Warn("received frame, parse not ok")
-				l.sendNak()
-			} else {
-				// @todo handle timeout(?)
-			}
+			l.handleParseEvent(frameIn)
 
 		case <-l.acks:
 			l.l.Debug("rx ack")
@@ -92,32 +109,151 @@ func (l *Layer) bgWork() {
 		case <-l.cans:
 			l.l.Debug("rx can")
 
-		case frameToWrite := <-l.pendingWrites:
+		case write := <-l.pendingWrites:
 			l.l.Debug("frame received, writing to transport")
-			// this method never returns an error, so ignore it
-			buf, _ := frameToWrite.MarshalBinary()
+			write.result <- l.transmit(write.frame)
 
-			l.writeToTransport(buf)
-			// TODO: this needs to time out
+		case <-l.ctx.Done():
+			l.l.Info("closing frame layer bg work")
+			return
+		}
+	}
+}
 
-			// <-l.acks
-			select {
-			case <-l.acks:
-				l.l.Debug("received ack")
-			case <-time.After(1 * time.Second):
-				l.l.Error("ack timed out")
+func (l *Layer) handleParseEvent(frameIn *ParseEvent) {
+	l.l.Debug("parser output received")
+
+	if frameIn.status == ParseOk {
+		l.sendAck()
+		l.l.Debug("received frame successfully, writing output")
+		l.frameOutput <- frameIn.frame
+	} else if frameIn.status == ParseNotOk {
+		l.l.Warn("received frame, parse not ok")
+		l.sendNak()
+	} else {
+		// @todo handle timeout(?)
+	}
+}
+
+// transmit writes a data frame and waits for it to be acknowledged. If the
+// controller doesn't ACK the frame in time, or responds with NAK or CAN, the
+// frame is retransmitted up to three times, waiting 100ms + n*1s before the
+// n-th retransmission (counting from zero).
+func (l *Layer) transmit(frame *Frame) error {
+	// this method never returns an error, so ignore it
+	buf, _ := frame.MarshalBinary()
+
+	var err error
+	for attempt := 0; attempt <= maxRetransmissions; attempt++ {
+		if attempt > 0 {
+			l.l.Warn("retransmitting frame", zap.Int("attempt", attempt), zap.String("reason", err.Error()))
+
+			if err := l.wait(retransmitBase + time.Duration(attempt-1)*retransmitStep); err != nil {
+				return err
 			}
+		}
+
+		l.drainAcks()
+
+		if _, err := l.writeToTransport(buf); err != nil {
+			return errors.Wrap(err, "write frame")
+		}
+
+		if err = l.awaitAck(); err == nil {
+			return nil
+		} else if err == l.ctx.Err() {
+			return err
+		}
+	}
+
+	l.l.Error("frame not delivered", zap.String("reason", err.Error()))
+
+	return errors.Wrapf(err, "frame not delivered after %d retransmissions", maxRetransmissions)
+}
+
+// awaitAck waits for the controller to ACK the last frame written. Frames
+// received in the meantime are handled as usual.
+func (l *Layer) awaitAck() error {
+	timeout := time.NewTimer(ackTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-l.acks:
+			l.l.Debug("received ack")
+			return nil
+		case <-l.naks:
+			return ErrNak
+		case <-l.cans:
+			return ErrCan
+		case frameIn := <-l.parserOutput:
+			l.handleParseEvent(frameIn)
+		case <-timeout.C:
+			return ErrAckTimeout
 		case <-l.ctx.Done():
-			l.l.Info("closing frame layer bg work")
+			return l.ctx.Err()
+		}
+	}
+}
+
+// wait waits before a retransmission while handling received frames.
+func (l *Layer) wait(delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case frameIn := <-l.parserOutput:
+			l.handleParseEvent(frameIn)
+		case <-l.acks:
+		case <-l.naks:
+		case <-l.cans:
+		case <-timer.C:
+			return nil
+		case <-l.ctx.Done():
+			return l.ctx.Err()
+		}
+	}
+}
+
+// drainAcks discards ACK/NAK/CAN frames that arrived outside a transmission.
+func (l *Layer) drainAcks() {
+	for {
+		select {
+		case <-l.acks:
+		case <-l.naks:
+		case <-l.cans:
+		default:
 			return
 		}
 	}
 }
 
-func (l *Layer) Write(frame *Frame) {
-	go func() {
-		l.pendingWrites <- frame
-	}()
+// Write will write a frame to the transport and block until it has been
+// delivered. Data frames are retransmitted as specified in INS12350; the
+// returned error describes why the frame was not delivered after the last
+// attempt. ACK, NAK and CAN frames are written immediately.
+func (l *Layer) Write(frame *Frame) error {
+	if !frame.IsData() {
+		buf, _ := frame.MarshalBinary()
+		_, err := l.writeToTransport(buf)
+		return err
+	}
+
+	write := &pendingWrite{frame: frame, result: make(chan error, 1)}
+
+	select {
+	case l.pendingWrites <- write:
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	}
+
+	select {
+	case err := <-write.result:
+		return err
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	}
 }
 
 // GetOutputChannel will return the output channel.
@@ -125,31 +261,48 @@ func (l *Layer) GetOutputChannel() <-chan Frame {
 	return l.frameOutput
 }
 
+// bgRead feeds bytes from the transport to the parser. Transports that can
+// recover from errors (such as transport.ReconnectingTransport) block until they
+// have, so an error here means the transport is gone for good.
 func (l *Layer) bgRead() {
 	for {
 		byt, err := l.transportLayer.(io.ByteReader).ReadByte()
-		if err == io.EOF {
-			// TODO: handle EOF
+		if err != nil {
+			select {
+			case <-l.ctx.Done():
+			default:
+				if err == io.EOF {
+					l.l.Info("transport closed")
+				} else {
+					l.l.Error("error reading from transport", zap.String("err", err.Error()))
+				}
+			}
 			return
-		} else if err != nil {
-			// TODO: handle more gracefully
-			l.l.Fatal("error reading from transport", zap.String("err", err.Error()))
 		}
 
-		l.parserInput <- byt
+		select {
+		case l.parserInput <- byt:
+		case <-l.ctx.Done():
+			return
+		}
 	}
 }
 
+// writeToTransport writes a whole frame. All writes to the transport go
+// through here.
 func (l *Layer) writeToTransport(buf []byte) (int, error) {
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+
 	return l.transportLayer.Write(buf)
 }
 
 func (l *Layer) sendAck() error {
-	_, err := l.transportLayer.Write([]byte{HeaderAck})
+	_, err := l.writeToTransport([]byte{HeaderAck})
 	return err
 }
 
 func (l *Layer) sendNak() error {
-	_, err := l.transportLayer.Write([]byte{HeaderNak})
+	_, err := l.writeToTransport([]byte{HeaderNak})
 	return err
 }
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gozwave/gozw/testutil"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -79,6 +83,149 @@ func TestBadIncomingFrameResultsInNak(t *testing.T) {
 	assert.EqualValues(t, []byte{HeaderNak}, buf.BytesWritten.Bytes())
 }
 
+// readFrame reads a single data frame written by the host. It runs on helper
+// goroutines, so failures are only reported with assert.
+func readFrame(t *testing.T, device io.Reader) []byte {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(device, buf); !assert.NoError(t, err) {
+		return nil
+	}
+
+	rest := make([]byte, buf[1])
+	if _, err := io.ReadFull(device, rest); !assert.NoError(t, err) {
+		return nil
+	}
+
+	return append(buf, rest...)
+}
+
 func TestOutgoingFrameWrittenCorrectly(t *testing.T) {
-	// @todo write me
+	t.Parallel()
+
+	host, device := testutil.NewPipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	frameLayer, _ := NewFrameLayer(ctx, host, zap.NewNop())
+
+	frame := NewRequestFrame([]byte{0x15})
+	expected, _ := frame.MarshalBinary()
+
+	go func() {
+		assert.Equal(t, expected, readFrame(t, device))
+		device.Write([]byte{HeaderAck})
+	}()
+
+	assert.NoError(t, frameLayer.Write(frame))
+}
+
+func TestOutgoingFrameRetransmittedAfterNakAndCan(t *testing.T) {
+	t.Parallel()
+
+	host, device := testutil.NewPipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	frameLayer, _ := NewFrameLayer(ctx, host, zap.NewNop())
+
+	frame := NewRequestFrame([]byte{0x15})
+	expected, _ := frame.MarshalBinary()
+
+	go func() {
+		for _, reply := range []byte{HeaderNak, HeaderCan, HeaderAck} {
+			assert.Equal(t, expected, readFrame(t, device))
+			device.Write([]byte{reply})
+		}
+	}()
+
+	start := time.Now()
+	assert.NoError(t, frameLayer.Write(frame))
+
+	// 100ms before the first retransmission, 1.1s before the second
+	assert.True(t, time.Since(start) >= 1200*time.Millisecond)
+}
+
+func TestOutgoingFrameFailsAfterThreeRetransmissions(t *testing.T) {
+	t.Parallel()
+
+	host, device := testutil.NewPipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	frameLayer, _ := NewFrameLayer(ctx, host, zap.NewNop())
+
+	attempts := make(chan []byte, 5)
+	go func() {
+		for {
+			attempts <- readFrame(t, device)
+			if _, err := device.Write([]byte{HeaderNak}); err != nil {
+				return
+			}
+		}
+	}()
+
+	err := frameLayer.Write(NewRequestFrame([]byte{0x15}))
+	require.Error(t, err)
+	assert.Equal(t, ErrNak, errors.Cause(err))
+	assert.Len(t, attempts, 4)
+}
+
+// serialTransport is a transport that counts writes that overlapped another
+// write. Nothing is ever read from it.
+type serialTransport struct {
+	ctx      context.Context
+	writing  int32
+	overlaps int32
+}
+
+func (s *serialTransport) Read(p []byte) (int, error) {
+	<-s.ctx.Done()
+	return 0, io.EOF
+}
+
+func (s *serialTransport) ReadByte() (byte, error) {
+	<-s.ctx.Done()
+	return 0, io.EOF
+}
+
+func (s *serialTransport) Write(buf []byte) (int, error) {
+	if atomic.AddInt32(&s.writing, 1) > 1 {
+		atomic.AddInt32(&s.overlaps, 1)
+	}
+
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&s.writing, -1)
+
+	return len(buf), nil
+}
+
+func TestWritesAreSerialized(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := &serialTransport{ctx: ctx}
+	frameLayer, err := NewFrameLayer(ctx, transport, zap.NewNop())
+	require.NoError(t, err)
+
+	// a data frame is (re)transmitted by the frame layer while ACKs are
+	// written by callers
+	go frameLayer.Write(NewRequestFrame([]byte{0x15}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, frameLayer.Write(NewAckFrame()))
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Zero(t, atomic.LoadInt32(&transport.overlaps))
 }
@@ -21,7 +21,10 @@ const (
 	ParseTimeout
 
 	minFrameSize uint8 = 3
-	maxFrameSize uint8 = 88
+
+	// maxFrameSize leaves room for the 128-byte node bitmasks sent by Long
+	// Range capable controllers, not just the 88 bytes of classic frames.
+	maxFrameSize uint8 = 160
 	readTimeout        = 1500 * time.Millisecond
 )
 
@@ -5,6 +5,7 @@ import (
 	"encoding"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gozwave/gozw/cc"
@@ -39,15 +40,35 @@ const (
 type Client struct {
 	Controller Controller
 
+	sessionLayer  session.ILayer
 	serialAPI     serialapi.ILayer
 	securityLayer security.ILayer
+	s2Layer       *security.S2Layer
 
+	keys KeyProvider
+
+	// lock guards nodes, Controller and networkKey, which are read by the
+	// handler goroutines while the controller is (re)initialized or joins
+	// another network
+	lock       sync.RWMutex
+	nodes      map[uint16]*Node
 	networkKey []byte
-	nodes      map[byte]*Node
 
 	// REPLACE THIS WITH A GENERIC CALLBACK FUNCTION
 	// EventBus EventBus.Bus
-	EventCallback func(*Client, byte, cc.Command)
+	EventCallback func(*Client, uint16, cc.Command)
+
+	// ConnectionStateCallback is called whenever the connection to the
+	// controller is lost, reopened or ready again.
+	ConnectionStateCallback func(*Client, ConnectionState)
+
+	// SecurityEventCallback is called whenever a secure message is rejected.
+	SecurityEventCallback func(*Client, SecurityEvent)
+
+	// S2GrantKeysCallback and S2DSKCallback are called during S2 inclusion;
+	// see SetS2InclusionCallbacks.
+	S2GrantKeysCallback func(*Client, uint16, byte) (byte, error)
+	S2DSKCallback       func(*Client, uint16, string, bool) (uint16, error)
 
 	l  *zap.Logger
 	db *bolt.DB
@@ -55,51 +76,89 @@ type Client struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	secureInclusionStep map[byte]chan error
+	secureInclusionStep inclusionSteps
+
+	// secureSends serializes secure messages to each node, from getting the
+	// node's nonce until the message has been sent
+	secureSends nodeLocks
+
+	learn     learnState
+	responder responderState
+	bridge    bridgeState
+	s2        s2State
 }
 
-func NewDefaultClient(dbName, serialPort string, baudRate int, networkKey []byte) (*Client, error) {
+// NewDefaultClient will return a new client. The controller is opened with
+// transport.Open, so serialPort may be either a local device path or a network
+// address such as tcp://host:port or rfc2217://host:port.
+func NewDefaultClient(dbName, serialPort string, baudRate int, keys KeyProvider) (*Client, error) {
+	transport, err := transport.Open(serialPort, baudRate)
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing transport")
+	}
+
+	return NewClient(dbName, transport, keys)
+}
+
+// NewClient will return a new client that talks to the controller over the
+// given transport (which may, for example, be wrapped in a transport.Recorder).
+// If the transport implements transport.StateNotifier, the client recovers
+// automatically when the connection is reopened.
+//
+// The network keys are kept in the database, encrypted with a storage key from
+// keys. A random S0 network key is generated on first start; use
+// ImportNetworkKey beforehand to keep using an existing one.
+func NewClient(dbName string, t transport.Transport, keys KeyProvider) (*Client, error) {
 	logger, err := NewLogger()
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize logger")
 	}
 
 	client := Client{
-		Controller:          Controller{},
-		networkKey:          networkKey,
-		nodes:               map[byte]*Node{},
-		EventCallback:       DefaultEventCallback,
-		l:                   logger,
-		secureInclusionStep: map[byte]chan error{},
+		Controller:    Controller{},
+		keys:          keys,
+		nodes:         map[uint16]*Node{},
+		EventCallback: DefaultEventCallback,
+		l:             logger,
 	}
 
-	client.ctx, client.cancel = context.WithCancel(context.Background())
+	client.registerDefaultCommandHandlers()
 
-	transport, err := transport.NewSerialPortTransport(serialPort, baudRate)
-	if err != nil {
-		return nil, errors.Wrap(err, "initializing transport")
-	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
 
-	frameLayer, err := frame.NewFrameLayer(client.ctx, transport, logger)
+	frameLayer, err := frame.NewFrameLayer(client.ctx, t, logger)
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize frame layer")
 	}
 
-	sessionLayer := session.NewSessionLayer(client.ctx, frameLayer, logger)
+	client.sessionLayer = session.NewSessionLayer(client.ctx, frameLayer, logger)
 
-	client.serialAPI = serialapi.NewLayer(client.ctx, sessionLayer, logger)
+	client.serialAPI = serialapi.NewLayer(client.ctx, client.sessionLayer, logger)
 
-	client.securityLayer = security.NewLayer(client.networkKey, logger)
+	client.s2Layer = security.NewS2Layer(logger)
 
 	err = client.initDb(dbName)
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize db")
 	}
 
+	err = client.loadNetworkKey()
+	if err != nil {
+		client.cancel()
+		client.db.Close()
+		return nil, errors.Wrap(err, "load network key")
+	}
+
+	client.securityLayer = security.NewLayer(client.networkKey, logger)
+
 	go client.handleApplicationCommands()
 	go client.handleControllerUpdates()
 
-	err = client.initZWave()
+	if notifier, ok := t.(transport.StateNotifier); ok {
+		go client.handleConnectionStates(notifier)
+	}
+
+	err = client.initZWave(client.ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "initializing z-wave")
 	}
@@ -117,7 +176,7 @@ func (c *Client) initDb(dbName string) (err error) {
 		return
 	}
 
-	c.db.Update(func(tx *bolt.Tx) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte("nodes"))
 		if err != nil {
 			return err
@@ -128,10 +187,54 @@ func (c *Client) initDb(dbName string) (err error) {
 			return err
 		}
 
+		return migrateNodeKeys(tx)
+	})
+}
+
+// migrateNodeKeys moves nodes stored under the 1-byte keys used before node
+// IDs were widened for Z-Wave Long Range to their 2-byte keys.
+func migrateNodeKeys(tx *bolt.Tx) error {
+	bucket := tx.Bucket([]byte("nodes"))
+
+	old := map[byte][]byte{}
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(k) == 1 {
+			old[k[0]] = append([]byte(nil), v...)
+		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	for nodeID, data := range old {
+		if err = bucket.Put(nodeKey(uint16(nodeID)), data); err != nil {
+			return err
+		}
+
+		if err = bucket.Delete([]byte{nodeID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resetDb deletes everything stored about the network.
+func (c *Client) resetDb() error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"nodes", "controller"} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 //  NewLogger builds a  new logger.
@@ -161,12 +264,23 @@ func NewLogger() (*zap.Logger, error) {
 }
 
 // Nodes will return all nodes
-func (c *Client) Nodes() map[byte]*Node {
-	return c.nodes
+func (c *Client) Nodes() map[uint16]*Node {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	nodes := make(map[uint16]*Node, len(c.nodes))
+	for nodeID, node := range c.nodes {
+		nodes[nodeID] = node
+	}
+
+	return nodes
 }
 
 // Node will retrieve a single node.
-func (c *Client) Node(nodeID byte) (*Node, error) {
+func (c *Client) Node(nodeID uint16) (*Node, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
 	if node, ok := c.nodes[nodeID]; ok {
 		return node, nil
 	}
@@ -174,40 +288,139 @@ func (c *Client) Node(nodeID byte) (*Node, error) {
 	return nil, errors.New("Node not found")
 }
 
-func (c *Client) initZWave() error {
-	version, err := c.serialAPI.GetVersion()
+// addNode adds a node to the client's nodes, replacing any node with the same
+// ID.
+func (c *Client) addNode(node *Node) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.nodes[node.NodeID] = node
+}
+
+// controller returns a copy of the controller information, which may be used
+// from any goroutine.
+func (c *Client) controller() Controller {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.Controller
+}
+
+// updateController changes the controller information.
+func (c *Client) updateController(update func(controller *Controller)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	update(&c.Controller)
+}
+
+// resetState forgets the controller and all nodes, before the controller is
+// reinitialized in a different network.
+func (c *Client) resetState() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.nodes = map[uint16]*Node{}
+	c.Controller = Controller{}
+}
+
+func (c *Client) initZWave(ctx context.Context) error {
+	version, err := c.serialAPI.GetVersion(ctx)
 	if err != nil {
 		return err
 	}
 
-	c.Controller.APIVersion = version.Version
-	c.Controller.APILibraryType = version.GetLibraryTypeString()
+	c.updateController(func(controller *Controller) {
+		controller.APIVersion = version.Version
+		controller.APILibraryType = version.GetLibraryTypeString()
+		controller.LibraryType = version.LibraryType
+	})
 
-	c.Controller.HomeID, c.Controller.NodeID, err = c.serialAPI.MemoryGetID()
+	serialAPICapabilities, err := c.serialAPI.GetCapabilities(ctx)
 	if err != nil {
 		return err
 	}
 
-	serialAPICapabilities, err := c.serialAPI.GetCapabilities()
+	c.updateController(func(controller *Controller) {
+		controller.ApplicationVersion = serialAPICapabilities.ApplicationVersion
+		controller.ApplicationRevision = serialAPICapabilities.ApplicationRevision
+		controller.ManufacturerID = uint16(serialAPICapabilities.Manufacturer1)<<8 | uint16(serialAPICapabilities.Manufacturer2)
+		controller.ProductType = uint16(serialAPICapabilities.ProductType1)<<8 | uint16(serialAPICapabilities.ProductType2)
+		controller.ProductID = uint16(serialAPICapabilities.ProductID1)<<8 | uint16(serialAPICapabilities.ProductID2)
+		controller.SupportedFunctions = serialAPICapabilities.GetSupportedFunctions()
+	})
+
+	if err = c.initSerialAPISetup(ctx); err != nil {
+		return err
+	}
+
+	// The node information frame only lasts until the controller is reset
+	if err = c.applyNodeInformation(ctx); err != nil {
+		return errors.Wrap(err, "set node information")
+	}
+
+	// Long Range node IDs only fit in 16-bit node ID frames, which change the
+	// layout of every frame carrying a node ID from here on
+	controller := c.controller()
+	if controller.SupportsLongRange() {
+		if err = c.serialAPI.SetNodeIDType(ctx, protocol.NodeIDType16Bit); err != nil {
+			return errors.Wrap(err, "enable 16-bit node ids")
+		}
+	}
+
+	homeID, nodeID, err := c.serialAPI.MemoryGetID(ctx)
 	if err != nil {
 		return err
 	}
 
-	c.Controller.ApplicationVersion = serialAPICapabilities.ApplicationVersion
-	c.Controller.ApplicationRevision = serialAPICapabilities.ApplicationRevision
-	c.Controller.SupportedFunctions = serialAPICapabilities.GetSupportedFunctions()
+	c.updateController(func(controller *Controller) {
+		controller.HomeID, controller.NodeID = homeID, nodeID
+	})
+
+	if err = c.initS2(); err != nil {
+		return err
+	}
 
-	initData, err := c.serialAPI.GetInitAppData()
+	initData, err := c.serialAPI.GetInitAppData(ctx)
 	if err != nil {
 		return err
 	}
 
-	c.Controller.Version = initData.Version
-	c.Controller.APIType = initData.GetAPIType()
-	c.Controller.IsPrimaryController = initData.IsPrimaryController()
-	c.Controller.NodeList = initData.GetNodeIDs()
+	nodeList := initData.GetNodeIDs()
+	if controller.SupportsLongRange() {
+		longRangeNodes, err := c.serialAPI.GetLongRangeNodes(ctx)
+		if err != nil {
+			return errors.Wrap(err, "get long range nodes")
+		}
+
+		nodeList = append(nodeList, longRangeNodes...)
+	}
+
+	sucNodeID, err := c.serialAPI.GetSUCNodeID(ctx)
+	if err != nil {
+		c.l.Warn("getting SUC node id", zap.Error(err))
+	}
+
+	c.updateController(func(controller *Controller) {
+		controller.Version = initData.Version
+		controller.APIType = initData.GetAPIType()
+		controller.IsPrimaryController = initData.IsPrimaryController()
+		controller.IsSIS = initData.IsSIS()
+		controller.NodeList = nodeList
+		controller.SUCNodeID = sucNodeID
+	})
+
+	if err = c.initVirtualNodes(ctx); err != nil {
+		return err
+	}
+
+	for _, nodeID := range nodeList {
+		// Keep existing nodes when reinitializing after a reconnect; virtual
+		// nodes are listed too but are hosted by the controller itself
+		if _, err := c.Node(nodeID); err == nil || c.isVirtualNode(nodeID) {
+			continue
+		}
 
-	for _, nodeID := range c.Controller.NodeList {
 		node, err := NewNode(c, nodeID)
 
 		if err != nil {
@@ -215,24 +428,56 @@ func (c *Client) initZWave() error {
 			continue
 		}
 
-		c.nodes[nodeID] = node
+		c.addNode(node)
 	}
 
 	return nil
 }
 
+// QueueDepths returns the number of requests waiting to be sent to the
+// controller, by priority.
+func (c *Client) QueueDepths() map[session.Priority]int {
+	return c.sessionLayer.QueueDepths()
+}
+
 // Shutdown will stop the client.
 func (c *Client) Shutdown() error {
 	c.cancel()
 	return nil
 }
 
-func (c *Client) AddNode() (*Node, error) {
-	newNodeInfo, err := c.serialAPI.AddNode()
+// AddNode will put the controller into inclusion mode and block until a node
+// has been added and interviewed, or ctx is done.
+func (c *Client) AddNode(ctx context.Context) (*Node, error) {
+	newNodeInfo, err := c.serialAPI.AddNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.addedNode(ctx, newNodeInfo, nil)
+}
+
+// AddNodeDSK includes the node with the given DSK (the 16-byte device specific
+// key from its label or QR code) with SmartStart, then interviews it like
+// AddNode. Z-Wave Long Range nodes can only be included this way; set
+// longRange to include the node with the Long Range protocol, which requires a
+// controller that supports it (see Controller.SupportsLongRange).
+func (c *Client) AddNodeDSK(ctx context.Context, dsk []byte, longRange bool) (*Node, error) {
+	if controller := c.controller(); longRange && !controller.SupportsLongRange() {
+		return nil, errors.New("Controller doesn't support Long Range")
+	}
+
+	newNodeInfo, err := c.serialAPI.AddNodeDSK(ctx, dsk, longRange)
 	if err != nil {
 		return nil, err
 	}
 
+	return c.addedNode(ctx, newNodeInfo, dsk)
+}
+
+// addedNode sets up and interviews a node reported by inclusion. dsk is the
+// node's DSK, if it is known.
+func (c *Client) addedNode(ctx context.Context, newNodeInfo *serialapi.AddRemoveNodeCallback, dsk []byte) (*Node, error) {
 	if newNodeInfo == nil {
 		return nil, errors.New("Adding node failed")
 	}
@@ -243,16 +488,36 @@ func (c *Client) AddNode() (*Node, error) {
 	}
 
 	node.setFromAddNodeCallback(newNodeInfo)
-	c.nodes[node.NodeID] = node
+	c.addNode(node)
+
+	if err = c.interviewNode(ctx, node, dsk); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
 
-	if node.IsSecure() {
+// interviewNode runs secure inclusion (S2 if the node supports it, otherwise
+// S0) and the node interview for a newly included node, then associates the
+// node's lifeline with the controller.
+func (c *Client) interviewNode(ctx context.Context, node *Node, dsk []byte) error {
+	if supported, _ := node.commandClassSupport(cc.Security2); supported {
+		if err := c.includeS2Node(ctx, node, dsk); err != nil {
+			return err
+		}
+	} else if node.IsSecure() {
 		c.l.Debug("starting secure inclusion")
-		err = c.includeSecureNode(node)
-		if err != nil {
-			return nil, err
+		if err := c.includeSecureNode(ctx, node); err != nil {
+			return err
 		}
 	}
 
+	// discard a completion left over from an earlier interview
+	select {
+	case <-node.queryStageVersionsComplete:
+	default:
+	}
+
 	node.nextQueryStage()
 
 	select {
@@ -260,15 +525,19 @@ func (c *Client) AddNode() (*Node, error) {
 		c.l.Info("node queries complete")
 	case <-time.After(time.Second * 30):
 		c.l.Warn("node query timeout", zap.String("node", fmt.Sprint(node.NodeID)))
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	node.AddAssociation(1, 1)
+	node.AddAssociation(ctx, 1, 1)
 
-	return node, nil
+	return nil
 }
 
-func (c *Client) RemoveNode() (byte, error) {
-	result, err := c.serialAPI.RemoveNode()
+// RemoveNode will put the controller into exclusion mode and block until a
+// node has been removed, or ctx is done.
+func (c *Client) RemoveNode(ctx context.Context) (uint16, error) {
+	result, err := c.serialAPI.RemoveNode(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -280,20 +549,86 @@ func (c *Client) RemoveNode() (byte, error) {
 	return result.Source, nil
 }
 
-func (c *Client) RemoveFailedNode(nodeID byte) (ok bool, err error) {
-	return c.serialAPI.RemoveFailedNode(nodeID)
+// RemoveFailedNode will remove a node that the controller considers failed.
+func (c *Client) RemoveFailedNode(ctx context.Context, nodeID uint16) (ok bool, err error) {
+	return c.serialAPI.RemoveFailedNode(ctx, nodeID)
+}
+
+// ReplaceFailedNode will replace a failed node with a new device, which keeps
+// the node ID. The new device must be put into learn mode once the controller
+// is ready, as for AddNode. It blocks until the device has been included and
+// interviewed, or ctx is done.
+//
+// Everything learned from the old device is discarded, but the node's stored
+// associations are kept and set on the new device.
+func (c *Client) ReplaceFailedNode(ctx context.Context, nodeID uint16) (*Node, error) {
+	node, err := c.Node(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.serialAPI.ReplaceFailedNode(ctx, nodeID); err != nil {
+		return nil, err
+	}
+
+	node.resetDeviceInfo()
+	if err = node.initialize(ctx); err != nil {
+		return nil, err
+	}
+
+	if err = node.requestNodeInfo(ctx); err != nil {
+		return nil, err
+	}
+
+	if err = c.interviewNode(ctx, node, nil); err != nil {
+		return nil, err
+	}
+
+	for groupID, nodeIDs := range node.associations() {
+		if err = node.AddAssociation(ctx, groupID, nodeIDs...); err != nil {
+			c.l.Warn("restoring association failed", zap.Int("node", int(nodeID)), zap.Int("group", int(groupID)), zap.Error(err))
+		}
+	}
+
+	return node, nil
 }
 
 func (c *Client) handleApplicationCommands() {
 	for {
 		select {
 		case cmd := <-c.serialAPI.ControllerCommands():
+			if len(cmd.CommandData) == 0 {
+				continue
+			}
+
+			// Only bridge controllers say which node a command was sent to;
+			// secure messages are authenticated with it
+			if cmd.CommandID == protocol.FnApplicationCommandHandler {
+				cmd.DstNodeID = c.controller().NodeID
+			}
+
+			if c.routeToVirtualNode(cmd) {
+				continue
+			}
+
 			switch cc.CommandClassID(cmd.CommandData[0]) {
 
 			case cc.Security:
 				c.interceptSecurityCommandClass(cmd)
 
+			case cc.Security2:
+				c.interceptSecurity2CommandClass(cmd)
+
+			case cc.ControllerReplication:
+				// the controller replicates the network itself; it only needs
+				// to know that the command was handled
+				c.serialAPI.ReplicationCommandComplete()
+
 			default:
+				if c.respondToCommand(cmd, false) {
+					break
+				}
+
 				if node, err := c.Node(cmd.SrcNodeID); err == nil {
 					go node.receiveApplicationCommand(cmd)
 				} else {
@@ -309,12 +644,12 @@ func (c *Client) handleApplicationCommands() {
 }
 
 // SetEventCallback will set the event callback for any events received
-func (c *Client) SetEventCallback(callback func(c *Client, nodeID byte, e cc.Command)) {
+func (c *Client) SetEventCallback(callback func(c *Client, nodeID uint16, e cc.Command)) {
 	c.EventCallback = callback
 }
 
 // DefaultEventCallback is the default callback for handling events.
-func DefaultEventCallback(c *Client, nodeID byte, e cc.Command) {
+func DefaultEventCallback(c *Client, nodeID uint16, e cc.Command) {
 	c.l.Info("event received", zap.Any("event", e), zap.Int("nodeID", int(nodeID)))
 }
 
@@ -326,12 +661,16 @@ func (c *Client) handleControllerUpdates() {
 
 			case protocol.UpdateStateNodeInfoReceived,
 				protocol.UpdateStateNodeInfoReqFailed:
-				if node, ok := c.nodes[update.NodeID]; ok {
+				if node, err := c.Node(update.NodeID); err == nil {
 					node.receiveControllerUpdate(update)
 				} else {
 					c.l.Debug("controller update:", zap.String("data", spew.Sdump(update)))
 				}
 
+			case protocol.UpdateStateSucID:
+				c.l.Info("SUC node id changed", zap.Int("node", int(update.NodeID)))
+				c.setSUCNodeID(update.NodeID)
+
 			default:
 				c.l.Debug("controller update:", zap.String("data", spew.Sdump(update)))
 
@@ -343,26 +682,70 @@ func (c *Client) handleControllerUpdates() {
 	}
 }
 
-func (c *Client) SendData(dstNode byte, payload encoding.BinaryMarshaler) error {
+// SendData sends payload to the destination node and blocks until the
+// controller reports the transmission result, or ctx is done. Unless ctx sets
+// another priority with session.WithPriority, the request is sent ahead of
+// queued background traffic such as node interviews.
+//
+// txOptions is a combination of protocol.TransmitOption* flags (usually
+// protocol.DefaultTransmitOptions). The transmit report is returned whenever
+// the controller reported on the transmission, even if it failed, in which
+// case the error is one of the serialapi.ErrTransmit* sentinels.
+func (c *Client) SendData(ctx context.Context, dstNode uint16, payload encoding.BinaryMarshaler, txOptions byte) (*serialapi.TransmitReport, error) {
 	marshaled, err := payload.MarshalBinary()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = c.serialAPI.SendData(dstNode, marshaled)
-	return err
+	if _, ok := session.PriorityFromContext(ctx); !ok {
+		ctx = session.WithPriority(ctx, session.PriorityInteractive)
+	}
+
+	return c.serialAPI.SendData(ctx, dstNode, marshaled, txOptions)
 }
 
 // SendDataSecure encapsulates payload in a security encapsulation command and
-// sends it to the destination node.
-func (c *Client) SendDataSecure(dstNode byte, message encoding.BinaryMarshaler) error {
+// sends it to the destination node: with S2, using the highest key granted to
+// the node, or else with S0. The options and report are the same as for
+// SendData, and apply to the encapsulated message. Concurrent secure sends to
+// the same node are sent one after the other.
+func (c *Client) SendDataSecure(ctx context.Context, dstNode uint16, message encoding.BinaryMarshaler, txOptions byte) (*serialapi.TransmitReport, error) {
+	if node, err := c.Node(dstNode); err == nil {
+		if keyClass := node.s2KeyClass(); keyClass != 0 {
+			return c.sendDataS2(ctx, dstNode, message, keyClass, txOptions)
+		}
+	}
+
 	// This function wraps the private sendDataSecure because no external packages
 	// should ever call this while in inclusion mode (and doing so would be incorrect)
-	return c.sendDataSecure(dstNode, message, false)
+	return c.sendDataSecure(ctx, dstNode, message, txOptions, false)
+}
+
+// nodeLocks holds a lock for each node.
+type nodeLocks struct {
+	lock  sync.Mutex
+	nodes map[uint16]*sync.Mutex
+}
+
+// acquire locks the node's lock and returns the function that unlocks it.
+func (l *nodeLocks) acquire(nodeID uint16) func() {
+	l.lock.Lock()
+	if l.nodes == nil {
+		l.nodes = map[uint16]*sync.Mutex{}
+	}
+	nodeLock, ok := l.nodes[nodeID]
+	if !ok {
+		nodeLock = &sync.Mutex{}
+		l.nodes[nodeID] = nodeLock
+	}
+	l.lock.Unlock()
+
+	nodeLock.Lock()
+	return nodeLock.Unlock
 }
 
-func (c *Client) requestNonceForNode(dstNode byte) (security.Nonce, error) {
-	err := c.SendData(dstNode, &zwsec.NonceGet{})
+func (c *Client) requestNonceForNode(ctx context.Context, dstNode uint16) (security.Nonce, error) {
+	_, err := c.SendData(ctx, dstNode, &zwsec.NonceGet{}, protocol.DefaultTransmitOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -370,14 +753,14 @@ func (c *Client) requestNonceForNode(dstNode byte) (security.Nonce, error) {
 	return c.securityLayer.WaitForExternalNonce(dstNode)
 }
 
-func (c *Client) getOrRequestNonceForNode(dstNode byte) (nonce security.Nonce, err error) {
+func (c *Client) getOrRequestNonceForNode(ctx context.Context, dstNode uint16) (nonce security.Nonce, err error) {
 	if nonce, err = c.securityLayer.GetExternalNonce(dstNode); err == nil {
 		return nonce, nil
 	}
 
 	for i := 0; i < 3; i++ {
-		nonce, err = c.requestNonceForNode(dstNode)
-		if err == nil {
+		nonce, err = c.requestNonceForNode(ctx, dstNode)
+		if err == nil || ctx.Err() != nil {
 			break
 		}
 
@@ -388,46 +771,80 @@ func (c *Client) getOrRequestNonceForNode(dstNode byte) (nonce security.Nonce, e
 	return nonce, err
 }
 
-func (c *Client) sendDataSecure(dstNode byte, message encoding.BinaryMarshaler, inclusionMode bool) error {
-	// Previously, this function would just split and prepare the payload based on
-	// whether it should be split after figuring out whether to segment. For now,
-	// we're just going to assume that we will never have to worry about segmenting.
-	// It wasn't too hard to implement before, but since I couldn't find a real payload
-	// big enough, it wasn't possible to verify the implementation, so I didn't port
-	// it while refactoring (for simplicity's sake).
+// securePayloadMaxSize returns the largest payload that fits in a single
+// secure frame sent with the given transmit options.
+func securePayloadMaxSize(txOptions byte) int {
+	switch {
+	case txOptions&protocol.TransmitOptionExplore != 0:
+		return SecurePayloadMaxSizeExplore
+	case txOptions&protocol.TransmitOptionNoRoute != 0:
+		return SecurePayloadMaxSizeNoRoute
+	default:
+		return SecurePayloadMaxSizeAutoRoute
+	}
+}
 
+// sendDataSecure encrypts and sends a message. Messages too large for a single
+// frame are sequenced: split in two frames sharing a sequence counter, the
+// first of which asks the node for the nonce to encrypt the second with.
+func (c *Client) sendDataSecure(ctx context.Context, dstNode uint16, message encoding.BinaryMarshaler, txOptions byte, inclusionMode bool) (*serialapi.TransmitReport, error) {
 	payload, err := message.MarshalBinary()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	maxSize := securePayloadMaxSize(txOptions)
+	if len(payload) > 2*maxSize {
+		return nil, fmt.Errorf("payload too large for a secure message (%d > %d bytes)", len(payload), 2*maxSize)
 	}
 
+	// Nonces are single-use, so concurrent sends would take each other's nonces
+	defer c.secureSends.acquire(dstNode)()
+
 	// Get a nonce from the other node
-	receiverNonce, err := c.getOrRequestNonceForNode(dstNode)
+	receiverNonce, err := c.getOrRequestNonceForNode(ctx, dstNode)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	senderNonce, err := c.securityLayer.GenerateInternalNonce()
+	if len(payload) <= maxSize {
+		return c.sendSecureFrame(ctx, dstNode, zwsec.CommandMessageEncapsulation, 0, payload, receiverNonce, txOptions, inclusionMode)
+	}
+
+	counter := c.securityLayer.NextSequenceCounter(dstNode) & security.SecuritySequenceCounterMask
+	header := counter | security.SecuritySequenceSequencedFlag
+
+	_, err = c.sendSecureFrame(ctx, dstNode, zwsec.CommandMessageEncapsulationNonceGet, header, payload[:maxSize], receiverNonce, txOptions, inclusionMode)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// The node answers the first frame with a nonce report
+	if receiverNonce, err = c.securityLayer.WaitForExternalNonce(dstNode); err != nil {
+		if receiverNonce, err = c.getOrRequestNonceForNode(ctx, dstNode); err != nil {
+			return nil, err
+		}
 	}
 
-	var securityByte byte
-	// var securityByte byte = sequenceCounter & SecuritySequenceCounterMask
-	// if sequenced {
-	// 	securityByte |= SecuritySequenceSequencedFlag
-	//
-	// 	if isSecondFrame {
-	// 		securityByte |= SecuritySequenceSecondFrameFlag
-	// 	}
-	// }
+	header |= security.SecuritySequenceSecondFrameFlag
 
-	securePayload := append([]byte{securityByte}, payload...)
+	return c.sendSecureFrame(ctx, dstNode, zwsec.CommandMessageEncapsulation, header, payload[maxSize:], receiverNonce, txOptions, inclusionMode)
+}
+
+// sendSecureFrame encrypts a single frame, preceded by the given security
+// header, with a nonce from the node.
+func (c *Client) sendSecureFrame(ctx context.Context, dstNode uint16, commandID cc.CommandID, header byte, payload []byte, receiverNonce security.Nonce, txOptions byte, inclusionMode bool) (*serialapi.TransmitReport, error) {
+	senderNonce, err := c.securityLayer.GenerateInternalNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	securePayload := append([]byte{header}, payload...)
 
 	encapsulatedMessage, err := c.securityLayer.EncapsulateMessage(
-		1,
+		c.controller().NodeID,
 		dstNode,
-		zwsec.CommandMessageEncapsulation, // @todo CC should be determined by sequencing
+		commandID,
 		senderNonce,
 		receiverNonce,
 		securePayload,
@@ -436,47 +853,107 @@ func (c *Client) sendDataSecure(dstNode byte, message encoding.BinaryMarshaler,
 
 	if err != nil {
 		c.l.Error("failed to encrypt message", zap.String("err", err.Error()), zap.String("node", fmt.Sprint(dstNode)))
-		return err
+		return nil, err
 	}
 
-	return c.SendData(dstNode, encapsulatedMessage)
+	return c.SendData(ctx, dstNode, encapsulatedMessage, txOptions)
 }
 
-func (c *Client) includeSecureNode(node *Node) error {
-	c.secureInclusionStep[node.NodeID] = make(chan error)
-	c.SendData(node.NodeID, &zwsec.SchemeGet{})
+func (c *Client) includeSecureNode(ctx context.Context, node *Node) error {
+	if err := c.checkInclusionKey(); err != nil {
+		return err
+	}
 
-	defer close(c.secureInclusionStep[node.NodeID])
-	defer delete(c.secureInclusionStep, node.NodeID)
+	step := c.secureInclusionStep.begin(node.NodeID)
+	defer c.secureInclusionStep.end(node.NodeID)
+
+	c.SendData(ctx, node.NodeID, &zwsec.SchemeGet{}, protocol.DefaultTransmitOptions)
 
 	c.l.Info("requesting security scheme")
 
 	select {
-	case err := <-c.secureInclusionStep[node.NodeID]:
+	case err := <-step:
 		if err != nil {
 			return err
 		}
 	case <-time.After(time.Second * 10):
 		return errors.New("Secure inclusion timeout")
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
 	c.l.Info("sending network key")
+	node.lock.Lock()
 	node.NetworkKeySent = true
+	node.lock.Unlock()
 
 	c.sendDataSecure(
+		ctx,
 		node.NodeID,
-		&zwsec.NetworkKeySet{NetworkKeyByte: c.networkKey},
+		&zwsec.NetworkKeySet{NetworkKeyByte: c.NetworkKey()},
+		protocol.DefaultTransmitOptions,
 		true,
 	)
 
 	select {
-	case err := <-c.secureInclusionStep[node.NodeID]:
+	case err := <-step:
 		return err
 	case <-time.After(time.Second * 20):
 		return errors.New("Secure inclusion timeout")
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// inclusionSteps holds a channel for each node in secure inclusion, which is
+// signaled when the node has completed the next step, such as a security
+// scheme report or network key verify.
+type inclusionSteps struct {
+	lock  sync.Mutex
+	nodes map[uint16]chan error
+}
+
+// begin starts waiting for steps from the node.
+func (s *inclusionSteps) begin(nodeID uint16) chan error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.nodes == nil {
+		s.nodes = map[uint16]chan error{}
+	}
+
+	step := make(chan error, 1)
+	s.nodes[nodeID] = step
+
+	return step
+}
+
+func (s *inclusionSteps) end(nodeID uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.nodes, nodeID)
+}
+
+// signal reports a completed step, returning false if the node isn't in
+// secure inclusion.
+func (s *inclusionSteps) signal(nodeID uint16) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	step, ok := s.nodes[nodeID]
+	if !ok {
+		return false
+	}
+
+	select {
+	case step <- nil:
+	default:
+	}
+
+	return true
+}
+
 func (c *Client) interceptSecurityCommandClass(cmd serialapi.ApplicationCommand) {
 	command, err := cc.Parse(1, cmd.CommandData)
 	if err != nil {
@@ -488,47 +965,76 @@ func (c *Client) interceptSecurityCommandClass(cmd serialapi.ApplicationCommand)
 
 	case *zwsec.MessageEncapsulation, *zwsec.MessageEncapsulationNonceGet:
 		c.l.Info("rx secure message", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
-		// @todo determine whether to bother with sequenced messages. According to
-		// openzwave, they didn't bother to implement it because they never ran across
-		// a situation where a frame was large enough that it needed to be sequenced.
-		// in any case, the following is the following is the process to follow with
-		// or without sequencing:
-
-		// 1. decrypt message
-		// 2. if it's the first half of a sequenced message, wait for the second half
-		// 2.5  if it's an EncapsulationGetNonce, then send a NonceReport back to the sender
-		// 3. if it's the second half of a sequenced message, reassemble the payloads
-		// 4. emit the decrypted (possibly recombined) message back
 
 		var decrypted []byte
-		node, err := c.Node(cmd.SrcNodeID)
+		if c.learn.awaitingKey() {
+			// the including controller encrypts the network key with the
+			// inclusion key
+			decrypted, err = c.securityLayer.DecryptMessage(cmd, true)
+		} else {
+			node, nodeErr := c.Node(cmd.SrcNodeID)
+			if nodeErr != nil {
+				return
+			}
+
+			node.lock.Lock()
+			keySent := node.NetworkKeySent
+			node.lock.Unlock()
+
+			// until the network key is sent, the node encrypts with the
+			// inclusion key
+			decrypted, err = c.securityLayer.DecryptMessage(cmd, !keySent)
+		}
+
 		if err != nil {
+			c.securityEvent(cmd.SrcNodeID, err)
 			return
 		}
 
-		if !node.NetworkKeySent {
-			decrypted, err = c.securityLayer.DecryptMessage(cmd, true)
-		} else {
-			decrypted, err = c.securityLayer.DecryptMessage(cmd, false)
+		// the sender needs another nonce, e.g. to stream several frames or for
+		// the second frame of a sequenced message
+		if _, ok := command.(*zwsec.MessageEncapsulationNonceGet); ok {
+			c.sendNonceReport(cmd.SrcNodeID)
 		}
 
+		payload, err := c.securityLayer.ReassembleMessage(cmd.SrcNodeID, decrypted)
 		if err != nil {
+			c.securityEvent(cmd.SrcNodeID, err)
 			return
 		}
 
-		c.l.Info("received encapsulated message", zap.String("data", spew.Sdump(decrypted)))
+		if payload == nil {
+			c.l.Debug("received first frame of sequenced message", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
+			return
+		}
+
+		c.l.Info("received encapsulated message", zap.String("data", spew.Sdump(payload)))
+
+		if len(payload) < 2 {
+			return
+		}
 
-		if decrypted[1] == byte(cc.Security) &&
-			decrypted[2] == byte(zwsec.CommandNetworkKeyVerify) {
+		if payload[0] == byte(cc.Security) &&
+			payload[1] == byte(zwsec.CommandNetworkKeyVerify) {
 			c.l.Info("network key verify", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
-			if ch, ok := c.secureInclusionStep[cmd.SrcNodeID]; ok {
-				ch <- nil
-			}
+			c.secureInclusionStep.signal(cmd.SrcNodeID)
+			return
+		}
+
+		if len(payload) > 2 &&
+			payload[0] == byte(cc.Security) &&
+			payload[1] == byte(zwsec.CommandNetworkKeySet) {
+			c.l.Info("network key set", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
+			c.receiveNetworkKey(cmd.SrcNodeID, payload[2:])
+			return
+		}
+
+		cmd.CommandData = payload
+		if c.respondToCommand(cmd, true) {
 			return
 		}
 
-		if node, ok := c.nodes[cmd.SrcNodeID]; ok {
-			cmd.CommandData = decrypted[1:]
+		if node, err := c.Node(cmd.SrcNodeID); err == nil {
 			go node.receiveApplicationCommand(cmd)
 		} else {
 			c.l.Warn("received secure command for unknown node", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
@@ -536,22 +1042,19 @@ func (c *Client) interceptSecurityCommandClass(cmd serialapi.ApplicationCommand)
 
 	case *zwsec.NonceGet:
 		c.l.Info("nonce get", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
-		nonce, err := c.securityLayer.GenerateInternalNonce()
-		if err != nil {
-		}
-
-		reply := &zwsec.NonceReport{NonceByte: nonce}
-		c.SendData(cmd.SrcNodeID, reply)
+		c.sendNonceReport(cmd.SrcNodeID)
 
 	case *zwsec.NonceReport:
 		c.l.Info("nonce report", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
 		c.securityLayer.ReceiveNonce(cmd.SrcNodeID, *command.(*zwsec.NonceReport))
 
+	case *zwsec.SchemeGet:
+		c.l.Info("security scheme get", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
+		c.receiveSchemeGet(cmd.SrcNodeID)
+
 	case *zwsec.SchemeReport:
 		c.l.Info("security scheme report", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
-		if ch, ok := c.secureInclusionStep[cmd.SrcNodeID]; ok {
-			ch <- nil
-		} else {
+		if !c.secureInclusionStep.signal(cmd.SrcNodeID) {
 			c.l.Warn("not in secure inclusion mode", zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
 		}
 
@@ -559,3 +1062,15 @@ func (c *Client) interceptSecurityCommandClass(cmd serialapi.ApplicationCommand)
 		c.l.Warn("unexpected security command", zap.String("data", spew.Sdump(cmd)))
 	}
 }
+
+// sendNonceReport sends a node a new nonce to encrypt its next message with.
+func (c *Client) sendNonceReport(nodeID uint16) {
+	nonce, err := c.securityLayer.GenerateInternalNonce()
+	if err != nil {
+		c.l.Error("generating nonce", zap.Error(err))
+		return
+	}
+
+	reply := &zwsec.NonceReport{NonceByte: nonce}
+	c.SendData(c.ctx, nodeID, reply, protocol.DefaultTransmitOptions)
+}
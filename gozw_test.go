@@ -0,0 +1,402 @@
+package gozw
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/cc/association"
+	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/cc/version"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/gozwave/gozw/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+)
+
+var testNetworkKey = []byte{
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+}
+
+// testKeys encrypts the network keys stored by test clients.
+var testKeys = StaticKeyProvider(make([]byte, 32))
+
+// openTestClient returns a client whose database holds testNetworkKey.
+func openTestClient(t *testing.T, dbName string, tr transport.Transport) (*Client, error) {
+	require.NoError(t, ImportNetworkKey(dbName, testKeys, testNetworkKey))
+
+	return NewClient(dbName, tr, testKeys)
+}
+
+// newTestClient returns a client connected to an emulated controller.
+func newTestClient(t *testing.T, controller *emulator.Controller) *Client {
+	dir, err := ioutil.TempDir("", "gozw")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	client, err := openTestClient(t, filepath.Join(dir, "test.db"), controller.Start(ctx))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		client.Shutdown()
+		cancel()
+		client.db.Close()
+		os.RemoveAll(dir)
+	})
+
+	return client
+}
+
+func TestClientInitialization(t *testing.T) {
+	controller := emulator.NewController()
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+	controller.AddNode(emulator.NewVirtualNode(5, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	client := newTestClient(t, controller)
+
+	assert.Equal(t, controller.HomeID, client.Controller.HomeID)
+	assert.EqualValues(t, 1, client.Controller.NodeID)
+	assert.Equal(t, "Z-Wave 4.05", client.Controller.APIVersion)
+	assert.Equal(t, "Static Controller", client.Controller.APILibraryType)
+	assert.Equal(t, []uint16{1, 2, 5}, client.Controller.NodeList)
+	assert.Len(t, client.Nodes(), 3)
+
+	node, err := client.Node(2)
+	require.NoError(t, err)
+	assert.True(t, node.IsListening())
+	assert.EqualValues(t, 0x10, node.GenericDeviceClass)
+}
+
+func TestClientSendDataAndReceiveReport(t *testing.T) {
+	controller := emulator.NewController()
+
+	switchNode := emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary))
+	switchNode.OnCommand = func(command []byte) [][]byte {
+		if command[0] == byte(cc.SwitchBinary) && command[1] == byte(switchbinary.CommandGet) {
+			return [][]byte{{byte(cc.SwitchBinary), byte(switchbinary.CommandReport), 0xFF}}
+		}
+		return nil
+	}
+	controller.AddNode(switchNode)
+
+	client := newTestClient(t, controller)
+
+	events := make(chan cc.Command, 1)
+	client.SetEventCallback(func(c *Client, nodeID uint16, e cc.Command) {
+		if nodeID == 2 {
+			events <- e
+		}
+	})
+
+	report, err := client.SendData(context.Background(), 2, &switchbinary.Get{}, protocol.DefaultTransmitOptions)
+	require.NoError(t, err)
+	assert.Equal(t, 100*time.Millisecond, report.TxTime)
+	assert.Equal(t, [][]byte{{byte(cc.SwitchBinary), byte(switchbinary.CommandGet)}}, switchNode.Received())
+
+	select {
+	case e := <-events:
+		require.IsType(t, &switchbinary.Report{}, e)
+		assert.EqualValues(t, 0xFF, e.(*switchbinary.Report).Value)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no report received")
+	}
+
+	// Sending to a node that doesn't exist should fail with no ack
+	_, err = client.SendData(context.Background(), 9, &switchbinary.Get{}, protocol.DefaultTransmitOptions)
+	assert.Equal(t, serialapi.ErrTransmitNoAck, err)
+}
+
+func TestClientMulticast(t *testing.T) {
+	controller := emulator.NewController()
+	for _, nodeID := range []uint16{2, 3, 4} {
+		controller.AddNode(emulator.NewVirtualNode(nodeID, 0x10, 0x01, byte(cc.SwitchBinary)))
+	}
+
+	client := newTestClient(t, controller)
+
+	results := client.Multicast(context.Background(), []uint16{2, 3, 9}, &switchbinary.Set{SwitchValue: 0x00})
+
+	require.Len(t, results, 3)
+	assert.NoError(t, results[2].Err)
+	assert.NoError(t, results[3].Err)
+	assert.False(t, results[2].Secure)
+	assert.Error(t, results[9].Err)
+
+	set := []byte{byte(cc.SwitchBinary), byte(switchbinary.CommandSet), 0x00}
+	assert.Equal(t, [][]byte{set}, controller.Node(2).Received())
+	assert.Equal(t, [][]byte{set}, controller.Node(3).Received())
+	assert.Empty(t, controller.Node(4).Received())
+
+	multicasts := 0
+	for _, request := range controller.Requests() {
+		if request[0] == protocol.FnSendDataMulti {
+			multicasts++
+		}
+	}
+	assert.Equal(t, 1, multicasts)
+}
+
+func TestClientHealNetwork(t *testing.T) {
+	interval := healNodeInterval
+	healNodeInterval = 0
+	defer func() { healNodeInterval = interval }()
+
+	controller := emulator.NewController()
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	failed := emulator.NewVirtualNode(3, 0x10, 0x01, byte(cc.SwitchBinary))
+	failed.Failed = true
+	controller.AddNode(failed)
+
+	sleeping := emulator.NewVirtualNode(4, 0x20, 0x01)
+	sleeping.Capability = 0x53
+	controller.AddNode(sleeping)
+
+	client := newTestClient(t, controller)
+
+	node, err := client.Node(2)
+	require.NoError(t, err)
+	node.Associations = map[byte][]byte{1: {1, 3}}
+
+	var progress []HealProgress
+	results, err := client.HealNetwork(context.Background(), func(p HealProgress) {
+		progress = append(progress, p)
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	assert.EqualValues(t, 2, results[0].NodeID)
+	assert.NoError(t, results[0].Err())
+	assert.Equal(t, map[uint16]error{1: nil, 3: nil}, results[0].ReturnRoutes)
+
+	assert.EqualValues(t, 3, results[1].NodeID)
+	assert.Equal(t, serialapi.ErrNeighborUpdateFailed, results[1].NeighborUpdate)
+	assert.Equal(t, serialapi.ErrTransmitNoAck, results[1].DeleteReturnRoute)
+	assert.Error(t, results[1].Err())
+
+	require.Len(t, progress, 2)
+	assert.Equal(t, 1, progress[0].Done)
+	assert.Equal(t, 2, progress[1].Done)
+	assert.Equal(t, 2, progress[1].Total)
+
+	var assigned [][]byte
+	for _, request := range controller.Requests() {
+		if request[0] == protocol.FnAssignReturnRoute {
+			assigned = append(assigned, request[1:3])
+		}
+	}
+	assert.Equal(t, [][]byte{{2, 1}, {2, 3}}, assigned)
+}
+
+func TestClientAddNodeCanceled(t *testing.T) {
+	controller := emulator.NewController()
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	client := newTestClient(t, controller)
+
+	// No node is waiting to be included, so inclusion never completes
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err := client.AddNode(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// The session is released and can be used again
+	_, err = client.SendData(context.Background(), 2, &switchbinary.Get{}, protocol.DefaultTransmitOptions)
+	assert.NoError(t, err)
+}
+
+func TestClientReplaceFailedNode(t *testing.T) {
+	controller := emulator.NewController()
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	failed := emulator.NewVirtualNode(3, 0x10, 0x01, byte(cc.SwitchBinary))
+	failed.Failed = true
+	controller.AddNode(failed)
+
+	client := newTestClient(t, controller)
+
+	node, err := client.Node(3)
+	require.NoError(t, err)
+	require.True(t, node.Failing)
+	node.ManufacturerID = 0x1234
+	node.Associations = map[byte][]byte{2: {5}}
+
+	replacement := emulator.NewVirtualNode(0, 0x10, 0x01, byte(cc.SwitchBinary), byte(cc.Version), byte(cc.Association))
+	replacement.OnCommand = func(command []byte) [][]byte {
+		if command[0] == byte(cc.Version) && command[1] == byte(version.CommandCommandClassGet) {
+			return [][]byte{{byte(cc.Version), byte(version.CommandCommandClassReport), command[2], 1}}
+		}
+		return nil
+	}
+	controller.QueueInclusion(replacement)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = client.ReplaceFailedNode(ctx, 2)
+	assert.Error(t, err, "node 2 isn't failing")
+
+	replaced, err := client.ReplaceFailedNode(ctx, 3)
+	require.NoError(t, err)
+	assert.True(t, replaced == node)
+	assert.False(t, replaced.Failing)
+	assert.Zero(t, replaced.ManufacturerID)
+	assert.True(t, replaced.CommandClasses.Supports(cc.Version))
+	assert.Equal(t, map[byte][]byte{1: {1}, 2: {5}}, replaced.Associations)
+
+	assert.Contains(t, replacement.Received(), []byte{byte(cc.Association), byte(association.CommandSet), 2, 5})
+	assert.True(t, controller.Node(3) == replacement)
+
+	controller.Handle(protocol.FnReplaceFailedNode, func(c *emulator.Controller, payload []byte) {
+		c.Respond(protocol.FnReplaceFailedNode, 0)
+		c.Send(protocol.FnReplaceFailedNode, payload[2])
+	})
+
+	_, err = client.ReplaceFailedNode(ctx, 3)
+	assert.Error(t, err, "truncated callback")
+}
+
+func TestClientRecoversFromLostTransport(t *testing.T) {
+	controller := emulator.NewController()
+	controller.SendsReady = true
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	dir, err := ioutil.TempDir("", "gozw")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr, err := transport.NewReconnectingTransport(func() (transport.Transport, error) {
+		return controller.Start(ctx), nil
+	})
+	require.NoError(t, err)
+
+	client, err := openTestClient(t, filepath.Join(dir, "test.db"), tr)
+	require.NoError(t, err)
+	defer client.db.Close()
+	defer client.Shutdown()
+
+	states := make(chan ConnectionState, 3)
+	client.SetConnectionStateCallback(func(c *Client, state ConnectionState) {
+		states <- state
+	})
+
+	node, err := client.Node(2)
+	require.NoError(t, err)
+
+	controller.Unplug()
+
+	for _, expected := range []ConnectionState{ConnectionLost, ConnectionReopened, ConnectionReady} {
+		select {
+		case state := <-states:
+			assert.Equal(t, expected, state)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+
+	// Existing nodes are kept and requests work again
+	recovered, err := client.Node(2)
+	require.NoError(t, err)
+	assert.True(t, node == recovered)
+	_, err = client.SendData(context.Background(), 2, &switchbinary.Get{}, protocol.DefaultTransmitOptions)
+	assert.NoError(t, err)
+}
+
+func TestClientRetriesReinitialization(t *testing.T) {
+	controller := emulator.NewController()
+	controller.SendsReady = true
+
+	dir, err := ioutil.TempDir("", "gozw")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr, err := transport.NewReconnectingTransport(func() (transport.Transport, error) {
+		return controller.Start(ctx), nil
+	})
+	require.NoError(t, err)
+
+	client, err := openTestClient(t, filepath.Join(dir, "test.db"), tr)
+	require.NoError(t, err)
+	defer client.db.Close()
+	defer client.Shutdown()
+
+	states := make(chan ConnectionState, 3)
+	client.SetConnectionStateCallback(func(c *Client, state ConnectionState) {
+		states <- state
+	})
+
+	// The first reinitialization fails on a malformed setup response
+	failed := false
+	controller.Handle(protocol.FnSerialAPISetup, func(c *emulator.Controller, payload []byte) {
+		if !failed {
+			failed = true
+			c.Respond(protocol.FnSerialAPISetup)
+			return
+		}
+
+		c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupGetSupportedCommands, 0)
+	})
+
+	controller.Unplug()
+
+	for _, expected := range []ConnectionState{ConnectionLost, ConnectionReopened, ConnectionReady} {
+		select {
+		case state := <-states:
+			assert.Equal(t, expected, state)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+
+	assert.True(t, failed)
+}
+
+func TestClientMigratesNodeKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gozw")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dbName := filepath.Join(dir, "test.db")
+
+	// A node stored under the 1-byte key used before Long Range support
+	db, err := bolt.Open(dbName, 0600, &bolt.Options{})
+	require.NoError(t, err)
+	data, err := msgpack.Marshal(&Node{NodeID: 2, ManufacturerID: 0x1234})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("nodes"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte{2}, data)
+	}))
+	require.NoError(t, db.Close())
+
+	client := &Client{}
+	require.NoError(t, client.initDb(dbName))
+	defer client.db.Close()
+
+	client.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("nodes"))
+		assert.Nil(t, bucket.Get([]byte{2}))
+		assert.Equal(t, data, bucket.Get(nodeKey(2)))
+		return nil
+	})
+}
@@ -0,0 +1,156 @@
+package gozw
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+	"go.uber.org/zap"
+)
+
+// healNodeInterval is the pause between healing two nodes, which leaves the
+// network some room for regular traffic.
+var healNodeInterval = 5 * time.Second
+
+// HealResult is the outcome of healing a single node.
+type HealResult struct {
+	NodeID uint16
+
+	// NeighborUpdate is the error from requesting the node's neighbor update,
+	// if any.
+	NeighborUpdate error
+
+	// ReturnRoutes holds the error (or nil) from assigning return routes
+	// towards each destination: the controller and the node's association
+	// targets. DeleteReturnRoute is set if deleting the stale routes failed.
+	ReturnRoutes      map[uint16]error
+	DeleteReturnRoute error
+}
+
+// Err returns the first error that occurred while healing the node.
+func (r *HealResult) Err() error {
+	if r.NeighborUpdate != nil {
+		return r.NeighborUpdate
+	}
+
+	if r.DeleteReturnRoute != nil {
+		return r.DeleteReturnRoute
+	}
+
+	destinations := make([]int, 0, len(r.ReturnRoutes))
+	for destID := range r.ReturnRoutes {
+		destinations = append(destinations, int(destID))
+	}
+	sort.Ints(destinations)
+
+	for _, destID := range destinations {
+		if err := r.ReturnRoutes[uint16(destID)]; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HealProgress is passed to the HealNetwork progress callback after each node.
+type HealProgress struct {
+	Result *HealResult
+
+	// Done is the number of nodes healed so far, out of Total.
+	Done  int
+	Total int
+}
+
+// HealNetwork rebuilds the routing information of all listening nodes, one
+// node at a time: each node is asked to rediscover its neighbors, its return
+// routes are deleted, and new ones are assigned towards the controller and
+// the node's association targets. Failures don't stop the process; they are
+// reported in the results. progress (which may be nil) is called after each
+// node. If ctx is done, the results so far are returned with ctx.Err().
+//
+// Healing is slow and keeps the controller busy, so it runs in the
+// background priority and pauses between nodes.
+func (c *Client) HealNetwork(ctx context.Context, progress func(HealProgress)) ([]*HealResult, error) {
+	if _, ok := session.PriorityFromContext(ctx); !ok {
+		ctx = session.WithPriority(ctx, session.PriorityBackground)
+	}
+
+	nodes := c.healableNodes()
+	results := make([]*HealResult, 0, len(nodes))
+
+	for i, node := range nodes {
+		if i > 0 {
+			select {
+			case <-time.After(healNodeInterval):
+			case <-ctx.Done():
+				return results, ctx.Err()
+			}
+		}
+
+		result := c.healNode(ctx, node)
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		if err := result.Err(); err != nil {
+			c.l.Warn("healing node failed", zap.Int("node", int(node.NodeID)), zap.Error(err))
+		}
+
+		results = append(results, result)
+
+		if progress != nil {
+			progress(HealProgress{Result: result, Done: i + 1, Total: len(nodes)})
+		}
+	}
+
+	return results, nil
+}
+
+// healableNodes returns the listening nodes other than the controller, ordered
+// by node ID. Sleeping nodes can't be reached until they wake up, and Long
+// Range nodes talk to the controller directly, without routes.
+func (c *Client) healableNodes() []*Node {
+	controllerID := c.controller().NodeID
+
+	nodes := []*Node{}
+	for nodeID, node := range c.Nodes() {
+		if nodeID != controllerID && node.IsListening() && !protocol.IsLongRange(nodeID) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+
+	return nodes
+}
+
+func (c *Client) healNode(ctx context.Context, node *Node) *HealResult {
+	result := &HealResult{
+		NodeID:       node.NodeID,
+		ReturnRoutes: map[uint16]error{},
+	}
+
+	result.NeighborUpdate = c.serialAPI.RequestNodeNeighborUpdate(ctx, node.NodeID)
+
+	result.DeleteReturnRoute = c.serialAPI.DeleteReturnRoute(ctx, node.NodeID)
+	if result.DeleteReturnRoute != nil {
+		return result
+	}
+
+	destinations := []uint16{c.controller().NodeID}
+	for _, target := range node.AssociationTargets() {
+		destinations = append(destinations, uint16(target))
+	}
+
+	for _, destID := range destinations {
+		if _, ok := result.ReturnRoutes[destID]; ok || destID == node.NodeID {
+			continue
+		}
+
+		result.ReturnRoutes[destID] = c.serialAPI.AssignReturnRoute(ctx, node.NodeID, destID)
+	}
+
+	return result
+}
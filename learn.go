@@ -0,0 +1,194 @@
+package gozw
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	zwsec "github.com/gozwave/gozw/cc/security"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// learnModeSchemeTimeout is how long to wait, after joining a network, for the
+// including controller to start the security key exchange. If it doesn't, the
+// controller was included without security.
+var learnModeSchemeTimeout = 10 * time.Second
+
+// learnState tracks the security key exchange while the controller is being
+// included into another network.
+type learnState struct {
+	lock            sync.Mutex
+	active          bool
+	keyReceived     bool
+	schemeRequested chan struct{}
+	keyVerified     chan error
+}
+
+func (l *learnState) begin() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.active = true
+	l.keyReceived = false
+	l.schemeRequested = make(chan struct{}, 1)
+	l.keyVerified = make(chan error, 1)
+}
+
+func (l *learnState) end() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.active = false
+}
+
+// awaitingKey returns whether the network key is expected from the including
+// controller, in which case secure messages are encrypted with the temporary
+// inclusion key.
+func (l *learnState) awaitingKey() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.active && !l.keyReceived
+}
+
+// FactoryReset resets the controller to its factory defaults, which starts a
+// new network with a new home ID, and wipes all stored node and controller
+// information. The network key is kept.
+func (c *Client) FactoryReset(ctx context.Context) error {
+	if err := c.serialAPI.SetDefault(ctx); err != nil {
+		return errors.Wrap(err, "set default")
+	}
+
+	return c.resetNetwork(ctx)
+}
+
+// StartLearnMode puts the controller into learn mode so that another
+// controller can include it into its network as a secondary controller. It
+// blocks until the controller has been included (and has received the network
+// key, if the including controller uses security), or ctx is done.
+//
+// The node list is replicated by the controller itself; everything stored
+// about the previous network is wiped and the controller and its nodes are
+// reinitialized. If a network key was received, it replaces the client's
+// network key; see NetworkKey.
+func (c *Client) StartLearnMode(ctx context.Context) error {
+	c.learn.begin()
+	defer c.learn.end()
+
+	nodeID, err := c.serialAPI.SetLearnMode(ctx, protocol.LearnModeNetworkWide)
+	if err != nil {
+		return errors.Wrap(err, "set learn mode")
+	}
+
+	c.l.Info("joined network", zap.Int("node", int(nodeID)))
+
+	// messages are sent from the new node ID from here on
+	c.updateController(func(controller *Controller) {
+		controller.NodeID = nodeID
+	})
+
+	select {
+	case <-c.learn.schemeRequested:
+		c.l.Info("waiting for network key")
+
+		select {
+		case err = <-c.learn.keyVerified:
+			if err != nil {
+				return errors.Wrap(err, "network key verify")
+			}
+		case <-time.After(MaxSecureInclusionDuration):
+			return errors.New("Secure inclusion timeout")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	case <-time.After(learnModeSchemeTimeout):
+		c.l.Info("included without security")
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return c.resetNetwork(ctx)
+}
+
+// NetworkKey returns the network key in use, which changes when the
+// controller joins another network with StartLearnMode.
+func (c *Client) NetworkKey() []byte {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.networkKey
+}
+
+// resetNetwork wipes all stored node and controller information and
+// reinitializes the controller and its nodes.
+func (c *Client) resetNetwork(ctx context.Context) error {
+	if err := c.resetDb(); err != nil {
+		return errors.Wrap(err, "reset db")
+	}
+
+	// the network key is kept (or was received in learn mode)
+	if err := c.storeNetworkKey(c.NetworkKey()); err != nil {
+		return errors.Wrap(err, "store network key")
+	}
+
+	c.resetState()
+
+	return c.initZWave(ctx)
+}
+
+// receiveSchemeGet answers the including controller's request for supported
+// security schemes while in learn mode.
+func (c *Client) receiveSchemeGet(srcNode uint16) {
+	c.learn.lock.Lock()
+	active, requested := c.learn.active, c.learn.schemeRequested
+	c.learn.lock.Unlock()
+
+	if !active {
+		c.l.Warn("security scheme get outside of learn mode", zap.Int("node", int(srcNode)))
+		return
+	}
+
+	// only S0 is supported, which is signaled by not setting any other scheme
+	c.SendData(c.ctx, srcNode, &zwsec.SchemeReport{}, protocol.DefaultTransmitOptions)
+
+	select {
+	case requested <- struct{}{}:
+	default:
+	}
+}
+
+// receiveNetworkKey switches to the network key sent by the including
+// controller and confirms it with a network key verify encrypted with the new
+// key.
+func (c *Client) receiveNetworkKey(srcNode uint16, networkKey []byte) {
+	if err := validateNetworkKey(networkKey); err != nil {
+		c.securityEvent(srcNode, errors.Wrap(err, "network key set"))
+		return
+	}
+
+	c.learn.lock.Lock()
+	if !c.learn.active || c.learn.keyReceived {
+		c.learn.lock.Unlock()
+		c.l.Warn("unexpected network key", zap.Int("node", int(srcNode)))
+		return
+	}
+	c.learn.keyReceived = true
+	verified := c.learn.keyVerified
+	c.learn.lock.Unlock()
+
+	key := make([]byte, len(networkKey))
+	copy(key, networkKey)
+
+	c.useNetworkKey(key)
+
+	// the nonce for the verify arrives through the application command handler,
+	// so it mustn't be blocked waiting for it
+	go func() {
+		_, err := c.sendDataSecure(c.ctx, srcNode, &zwsec.NetworkKeyVerify{}, protocol.DefaultTransmitOptions, false)
+		verified <- err
+	}()
+}
@@ -0,0 +1,164 @@
+package gozw
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gozwave/gozw/cc"
+	zwsec "github.com/gozwave/gozw/cc/security"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestClientFactoryReset(t *testing.T) {
+	controller := emulator.NewController()
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	client := newTestClient(t, controller)
+	homeID := client.Controller.HomeID
+	require.Len(t, client.Nodes(), 2)
+
+	require.NoError(t, client.FactoryReset(context.Background()))
+
+	assert.NotEqual(t, homeID, client.Controller.HomeID)
+	assert.Equal(t, []uint16{1}, client.Controller.NodeList)
+	assert.Len(t, client.Nodes(), 1)
+
+	client.db.View(func(tx *bolt.Tx) error {
+		assert.Nil(t, tx.Bucket([]byte("nodes")).Get(nodeKey(2)))
+		return nil
+	})
+}
+
+// includingController plays the primary controller's side of the S0 network
+// key exchange, sending sentKey in the Network Key Set. That is normally its
+// own networkKey.
+func includingController(t *testing.T, networkKey, sentKey []byte, verified chan<- bool) *emulator.VirtualNode {
+	primary := emulator.NewVirtualNode(1, 0x02, 0x01, byte(cc.Security))
+	layer := security.NewLayer(networkKey, zap.NewNop())
+
+	primary.OnCommand = func(command []byte) [][]byte {
+		if command[0] != byte(cc.Security) {
+			return nil
+		}
+
+		switch cc.CommandID(command[1]) {
+		case zwsec.CommandSchemeReport:
+			return [][]byte{{byte(cc.Security), byte(zwsec.CommandNonceGet)}}
+
+		case zwsec.CommandNonceReport:
+			keySet := append([]byte{0, byte(cc.Security), byte(zwsec.CommandNetworkKeySet)}, sentKey...)
+			msg, err := layer.EncapsulateMessage(1, 5, zwsec.CommandMessageEncapsulation, security.GenerateNonce(), command[2:10], keySet, true)
+			require.NoError(t, err)
+
+			encapsulated, _ := msg.MarshalBinary()
+			return [][]byte{encapsulated}
+
+		case zwsec.CommandNonceGet:
+			nonce, err := layer.GenerateInternalNonce()
+			require.NoError(t, err)
+			return [][]byte{append([]byte{byte(cc.Security), byte(zwsec.CommandNonceReport)}, nonce...)}
+
+		case zwsec.CommandMessageEncapsulation:
+			decrypted, err := layer.DecryptMessage(serialapi.ApplicationCommand{SrcNodeID: 5, DstNodeID: 1, CommandData: command}, false)
+			if err == nil && bytes.Equal(decrypted[1:3], []byte{byte(cc.Security), byte(zwsec.CommandNetworkKeyVerify)}) {
+				verified <- true
+			}
+		}
+
+		return nil
+	}
+
+	return primary
+}
+
+func TestClientLearnMode(t *testing.T) {
+	controller := emulator.NewController()
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	client := newTestClient(t, controller)
+
+	networkKey := []byte{
+		0xF1, 0xF2, 0xF3, 0xF4, 0xF5, 0xF6, 0xF7, 0xF8,
+		0xF9, 0xFA, 0xFB, 0xFC, 0xFD, 0xFE, 0xFF, 0xF0,
+	}
+	verified := make(chan bool, 1)
+
+	controller.QueueLearn(&emulator.Network{
+		HomeID:    0xDEADBEEF,
+		NodeID:    5,
+		PrimaryID: 1,
+		Nodes: []*emulator.VirtualNode{
+			includingController(t, networkKey, networkKey, verified),
+			emulator.NewVirtualNode(7, 0x10, 0x01, byte(cc.SwitchBinary)),
+		},
+		Commands: [][]byte{{byte(cc.Security), byte(zwsec.CommandSchemeGet), 0x00}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, client.StartLearnMode(ctx))
+
+	select {
+	case <-verified:
+	default:
+		t.Fatal("network key not verified")
+	}
+
+	assert.Equal(t, networkKey, client.NetworkKey())
+	assert.EqualValues(t, 0xDEADBEEF, client.Controller.HomeID)
+	assert.EqualValues(t, 5, client.Controller.NodeID)
+	assert.False(t, client.Controller.IsPrimaryController)
+	assert.Equal(t, []uint16{1, 5, 7}, client.Controller.NodeList)
+
+	_, err := client.Node(2)
+	assert.Error(t, err)
+	_, err = client.Node(7)
+	assert.NoError(t, err)
+
+	assert.Contains(t, controller.Requests(), []byte{protocol.FnReplicationCommandComplete})
+}
+
+func TestClientLearnModeRejectsInvalidNetworkKey(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	events := make(chan SecurityEvent, 10)
+	client.SetSecurityEventCallback(func(c *Client, event SecurityEvent) {
+		events <- event
+	})
+
+	networkKey := bytes.Repeat([]byte{0xF1}, 16)
+	controller.QueueLearn(&emulator.Network{
+		HomeID:    0xDEADBEEF,
+		NodeID:    5,
+		PrimaryID: 1,
+		Nodes: []*emulator.VirtualNode{
+			includingController(t, networkKey, networkKey[:8], make(chan bool, 1)),
+		},
+		Commands: [][]byte{{byte(cc.Security), byte(zwsec.CommandSchemeGet), 0x00}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	assert.Error(t, client.StartLearnMode(ctx))
+
+	select {
+	case event := <-events:
+		assert.EqualValues(t, 1, event.NodeID)
+	default:
+		t.Fatal("no security event")
+	}
+
+	assert.Equal(t, testNetworkKey, client.NetworkKey())
+}
@@ -0,0 +1,80 @@
+package gozw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/cc"
+	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/cc/version"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientLongRange(t *testing.T) {
+	controller := emulator.NewController()
+	controller.EnableLongRange()
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+	controller.AddNode(emulator.NewVirtualNode(300, 0x10, 0x01, byte(cc.SwitchBinary)))
+	controller.AddNode(emulator.NewVirtualNode(1400, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	client := newTestClient(t, controller)
+
+	require.True(t, client.Controller.SupportsLongRange())
+	assert.Equal(t, []uint16{1, 2, 300, 1400}, client.Controller.NodeList)
+
+	node, err := client.Node(300)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0x10, node.GenericDeviceClass)
+
+	_, err = client.SendData(context.Background(), 300, &switchbinary.Get{}, protocol.DefaultTransmitOptions)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{byte(cc.SwitchBinary), byte(switchbinary.CommandGet)}}, controller.Node(300).Received())
+
+	results := client.Multicast(context.Background(), []uint16{2, 1400}, &switchbinary.Set{SwitchValue: 0xFF})
+	assert.NoError(t, results[2].Err)
+	assert.NoError(t, results[1400].Err)
+	assert.Len(t, controller.Node(1400).Received(), 1)
+
+	classic, longRange, err := client.Broadcast(context.Background(), &switchbinary.Set{SwitchValue: 0x00}, protocol.DefaultTransmitOptions)
+	require.NoError(t, err)
+	assert.NotNil(t, classic)
+	assert.NotNil(t, longRange)
+	assert.Len(t, controller.Node(2).Received(), 2)
+	assert.Len(t, controller.Node(1400).Received(), 2)
+
+	included := emulator.NewVirtualNode(0, 0x10, 0x01, byte(cc.SwitchBinary), byte(cc.Version))
+	included.OnCommand = func(command []byte) [][]byte {
+		if command[0] == byte(cc.Version) && command[1] == byte(version.CommandCommandClassGet) {
+			return [][]byte{{byte(cc.Version), byte(version.CommandCommandClassReport), command[2], 1}}
+		}
+		return nil
+	}
+	controller.QueueInclusion(included)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dsk := []byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+
+	added, err := client.AddNodeDSK(ctx, dsk, true)
+	require.NoError(t, err)
+	assert.EqualValues(t, 256, added.NodeID)
+	assert.True(t, controller.Node(256) == included)
+}
+
+func TestClientLongRangeUnsupported(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	assert.False(t, client.Controller.SupportsLongRange())
+
+	_, err := client.AddNodeDSK(context.Background(), make([]byte, 16), true)
+	assert.Error(t, err)
+}
@@ -0,0 +1,193 @@
+package gozw
+
+import (
+	"context"
+
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/session"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// MulticastResult is the outcome of Multicast for a single node.
+type MulticastResult struct {
+	// Secure is true if the command was sent to the node securely: with a
+	// secure singlecast, or with an S2 multicast and its singlecast follow-up.
+	Secure bool
+
+	Report *serialapi.TransmitReport
+	Err    error
+}
+
+// Multicast sends command to several nodes. Nodes that only support the
+// command class securely are sent an S2 multicast if they share an S2 key,
+// followed by a singlecast follow-up to each of them, whose result is
+// reported; S0 has no group keys, so S0 nodes are sent the command with a
+// secure singlecast. Long Range nodes don't support multicast at all and are
+// sent a singlecast too. The other nodes share a single multicast frame; as
+// multicast frames aren't acknowledged, their result only says whether the
+// controller sent it.
+func (c *Client) Multicast(ctx context.Context, nodeIDs []uint16, command cc.Command) map[uint16]*MulticastResult {
+	if _, ok := session.PriorityFromContext(ctx); !ok {
+		ctx = session.WithPriority(ctx, session.PriorityInteractive)
+	}
+
+	results := map[uint16]*MulticastResult{}
+	commandClass := cc.CommandClassID(command.CommandClassID())
+
+	var insecure, secure, longRange []uint16
+	s2Groups := map[byte][]uint16{}
+	for _, nodeID := range nodeIDs {
+		if _, ok := results[nodeID]; ok {
+			continue
+		}
+
+		node, err := c.Node(nodeID)
+		if err != nil {
+			results[nodeID] = &MulticastResult{Err: err}
+			continue
+		}
+
+		_, secureOnly := node.commandClassSupport(commandClass)
+		keyClass := node.s2KeyClass()
+
+		switch {
+		case secureOnly && keyClass != 0:
+			results[nodeID] = &MulticastResult{Secure: true}
+			s2Groups[keyClass] = append(s2Groups[keyClass], nodeID)
+		case secureOnly:
+			results[nodeID] = &MulticastResult{Secure: true}
+			secure = append(secure, nodeID)
+		case protocol.IsLongRange(nodeID):
+			results[nodeID] = &MulticastResult{}
+			longRange = append(longRange, nodeID)
+		default:
+			results[nodeID] = &MulticastResult{}
+			insecure = append(insecure, nodeID)
+		}
+	}
+
+	switch len(insecure) {
+	case 0:
+	case 1:
+		// no point in a multicast; a singlecast is acknowledged
+		report, err := c.SendData(ctx, insecure[0], command, protocol.DefaultTransmitOptions)
+		results[insecure[0]].Report, results[insecure[0]].Err = report, err
+	default:
+		payload, err := command.MarshalBinary()
+		if err != nil {
+			err = errors.Wrap(err, "marshal command")
+		}
+
+		var report *serialapi.TransmitReport
+		if err == nil {
+			report, err = c.serialAPI.SendDataMulti(ctx, insecure, payload, protocol.DefaultTransmitOptions)
+		}
+
+		for _, nodeID := range insecure {
+			results[nodeID].Report, results[nodeID].Err = report, err
+		}
+	}
+
+	for keyClass, group := range s2Groups {
+		if len(group) == 1 {
+			secure = append(secure, group[0])
+			continue
+		}
+
+		c.multicastS2(ctx, group, keyClass, command, results)
+	}
+
+	for _, nodeID := range secure {
+		report, err := c.SendDataSecure(ctx, nodeID, command, protocol.DefaultTransmitOptions)
+		results[nodeID].Report, results[nodeID].Err = report, err
+	}
+
+	for _, nodeID := range longRange {
+		report, err := c.SendData(ctx, nodeID, command, protocol.DefaultTransmitOptions)
+		results[nodeID].Report, results[nodeID].Err = report, err
+	}
+
+	return results
+}
+
+// Broadcast sends command to every classic node in the network. Broadcast
+// frames are not acknowledged and can't be encrypted, so the ack transmit
+// option is dropped. Long Range nodes have a broadcast of their own, which is
+// sent as well if there are any; its report is nil otherwise. Both broadcasts
+// are attempted even if one fails, and the returned error covers both.
+func (c *Client) Broadcast(ctx context.Context, command cc.Command, txOptions byte) (classic, longRange *serialapi.TransmitReport, err error) {
+	txOptions &^= protocol.TransmitOptionAck
+
+	hasLongRange := false
+	for _, nodeID := range c.controller().NodeList {
+		if protocol.IsLongRange(nodeID) {
+			hasLongRange = true
+			break
+		}
+	}
+
+	classic, err = c.SendData(ctx, protocol.NodeBroadcast, command, txOptions)
+	if err != nil {
+		err = errors.Wrap(err, "classic broadcast")
+	}
+
+	if !hasLongRange {
+		return classic, nil, err
+	}
+
+	longRange, lrErr := c.SendData(ctx, protocol.NodeBroadcastLongRange, command, txOptions)
+	switch {
+	case lrErr == nil:
+	case err == nil:
+		err = errors.Wrap(lrErr, "long range broadcast")
+	default:
+		err = errors.Errorf("%v; long range broadcast: %v", err, lrErr)
+	}
+
+	return classic, longRange, err
+}
+
+// multicastS2 sends command to S2 nodes sharing a key with an S2 multicast,
+// then to each node with a singlecast follow-up, which lets nodes that missed
+// the multicast (or don't know the group's MPAN yet) catch up.
+func (c *Client) multicastS2(ctx context.Context, nodeIDs []uint16, keyClass byte, command cc.Command, results map[uint16]*MulticastResult) {
+	payload, err := command.MarshalBinary()
+	if err != nil {
+		err = errors.Wrap(err, "marshal command")
+	}
+
+	var groupID byte
+	if err == nil {
+		groupID, err = c.s2Layer.MulticastGroup(nodeIDs, keyClass)
+	}
+
+	var msg *security.S2Message
+	if err == nil {
+		msg, err = c.s2Layer.EncapsulateMulticast(c.controller().NodeID, groupID, payload)
+	}
+
+	var multicast []byte
+	if err == nil {
+		multicast, err = msg.MarshalBinary()
+	}
+
+	if err != nil {
+		for _, nodeID := range nodeIDs {
+			results[nodeID].Err = err
+		}
+		return
+	}
+
+	if _, err = c.serialAPI.SendDataMulti(ctx, nodeIDs, multicast, protocol.DefaultTransmitOptions); err != nil {
+		c.l.Warn("S2 multicast failed", zap.Error(err))
+	}
+
+	for _, nodeID := range nodeIDs {
+		report, err := c.sendS2Payload(ctx, nodeID, payload, keyClass, groupID, protocol.DefaultTransmitOptions)
+		results[nodeID].Report, results[nodeID].Err = report, err
+	}
+}
@@ -0,0 +1,367 @@
+package gozw
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/boltdb/bolt"
+	"github.com/gozwave/gozw/security"
+	"github.com/pkg/errors"
+)
+
+// networkKeyDbKey is where the S0 network key is kept in the controller
+// bucket, encrypted with the client's KeyProvider.
+var networkKeyDbKey = []byte("networkKey")
+
+// Sealed keys are stored as a version byte, a random salt for the
+// KeyProvider, and the AES-256-GCM nonce and ciphertext.
+const (
+	sealedKeyVersion  byte = 0x01
+	sealedKeySaltSize      = 16
+	storageKeySize         = 32
+
+	passphraseIterations = 100000
+)
+
+// ErrInsecureNetworkKey is returned when a node would be securely included with
+// an all-zero S0 network key, which gives no protection at all.
+var ErrInsecureNetworkKey = errors.New("Refusing to use an all-zero network key")
+
+// ErrNetworkKeyDecrypt is returned when a stored or exported network key
+// can't be decrypted, because the passphrase or storage key is wrong or the
+// data is corrupt.
+var ErrNetworkKeyDecrypt = errors.New("Failed to decrypt network key")
+
+// A KeyProvider supplies the storage key that network keys are encrypted with
+// before they are written to the database. It may derive the key from a
+// passphrase, or fetch it from an OS keyring or similar secret store.
+type KeyProvider interface {
+	// StorageKey returns the 32-byte storage key for the given salt, which is
+	// random and stored next to every encrypted key.
+	StorageKey(salt []byte) ([]byte, error)
+}
+
+type passphraseKeyProvider string
+
+// PassphraseKeyProvider returns a KeyProvider that derives storage keys from
+// a passphrase with PBKDF2-SHA256.
+func PassphraseKeyProvider(passphrase string) KeyProvider {
+	return passphraseKeyProvider(passphrase)
+}
+
+func (p passphraseKeyProvider) StorageKey(salt []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return nil, errors.New("Empty passphrase")
+	}
+
+	return pbkdf2SHA256([]byte(p), salt, passphraseIterations, storageKeySize), nil
+}
+
+// pbkdf2SHA256 derives a key from a password with PBKDF2 (RFC 8018), using
+// HMAC-SHA256 as the pseudorandom function.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	key := make([]byte, 0, keyLen)
+	index := make([]byte, 4)
+
+	for block := uint32(1); len(key) < keyLen; block++ {
+		binary.BigEndian.PutUint32(index, block)
+
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(index)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
+
+type staticKeyProvider []byte
+
+// StaticKeyProvider returns a KeyProvider that always uses the given 32-byte
+// storage key, e.g. one kept in an OS keyring.
+func StaticKeyProvider(key []byte) KeyProvider {
+	return staticKeyProvider(append([]byte(nil), key...))
+}
+
+func (p staticKeyProvider) StorageKey(salt []byte) ([]byte, error) {
+	return []byte(p), nil
+}
+
+// sealKey encrypts a key with a storage key from the given provider.
+func sealKey(keys KeyProvider, plaintext []byte) ([]byte, error) {
+	salt := make([]byte, sealedKeySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := storageCipher(keys, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append([]byte{sealedKeyVersion}, salt...)
+	sealed = append(sealed, nonce...)
+
+	return aead.Seal(sealed, nonce, plaintext, sealed[:1]), nil
+}
+
+// openKey decrypts a key sealed with sealKey.
+func openKey(keys KeyProvider, sealed []byte) ([]byte, error) {
+	if len(sealed) < 1+sealedKeySaltSize || sealed[0] != sealedKeyVersion {
+		return nil, ErrNetworkKeyDecrypt
+	}
+
+	aead, err := storageCipher(keys, sealed[1:1+sealedKeySaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	data := sealed[1+sealedKeySaltSize:]
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrNetworkKeyDecrypt
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], sealed[:1])
+	if err != nil {
+		return nil, ErrNetworkKeyDecrypt
+	}
+
+	return plaintext, nil
+}
+
+func storageCipher(keys KeyProvider, salt []byte) (cipher.AEAD, error) {
+	if keys == nil {
+		return nil, errors.New("No key provider")
+	}
+
+	key, err := keys.StorageKey(salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage key")
+	}
+
+	if len(key) != storageKeySize {
+		return nil, errors.Errorf("Invalid storage key length %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func isZeroKey(key []byte) bool {
+	return bytes.Equal(key, make([]byte, len(key)))
+}
+
+func validateNetworkKey(key []byte) error {
+	if len(key) != 16 {
+		return errors.Errorf("Invalid network key length %d", len(key))
+	}
+
+	return nil
+}
+
+// ImportNetworkKey stores an existing S0 network key in the database before a
+// client is created, so that a network set up with a key that used to be
+// passed to NewClient keeps working.
+func ImportNetworkKey(dbName string, keys KeyProvider, networkKey []byte) error {
+	if err := validateNetworkKey(networkKey); err != nil {
+		return err
+	}
+
+	sealed, err := sealKey(keys, networkKey)
+	if err != nil {
+		return errors.Wrap(err, "encrypt network key")
+	}
+
+	db, err := bolt.Open(dbName, 0600, &bolt.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("controller"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(networkKeyDbKey, sealed)
+	})
+}
+
+// loadNetworkKey decrypts the stored S0 network key, generating and storing a
+// random one on first start. It runs before the security layer is created.
+func (c *Client) loadNetworkKey() error {
+	var sealed []byte
+	c.db.View(func(tx *bolt.Tx) error {
+		sealed = append([]byte(nil), tx.Bucket([]byte("controller")).Get(networkKeyDbKey)...)
+		return nil
+	})
+
+	if len(sealed) == 0 {
+		key := make([]byte, 16)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+
+		c.l.Info("generated network key")
+		c.useNetworkKey(key)
+
+		return c.storeNetworkKey(key)
+	}
+
+	key, err := openKey(c.keys, sealed)
+	if err != nil {
+		return err
+	}
+
+	if err = validateNetworkKey(key); err != nil {
+		return err
+	}
+
+	if isZeroKey(key) {
+		c.l.Warn("network key is all zeros; secure inclusion is disabled")
+	}
+
+	c.useNetworkKey(key)
+
+	return nil
+}
+
+// setNetworkKey switches to and stores the given S0 network key.
+func (c *Client) setNetworkKey(key []byte) error {
+	if err := c.storeNetworkKey(key); err != nil {
+		return err
+	}
+
+	c.useNetworkKey(key)
+
+	return nil
+}
+
+// useNetworkKey switches to the given S0 network key without storing it. The
+// security layer doesn't exist yet while the stored key is loaded.
+func (c *Client) useNetworkKey(key []byte) {
+	c.lock.Lock()
+	c.networkKey = key
+	c.lock.Unlock()
+
+	if c.securityLayer != nil {
+		c.securityLayer.SetNetworkKey(key)
+	}
+}
+
+func (c *Client) storeNetworkKey(key []byte) error {
+	if err := validateNetworkKey(key); err != nil {
+		return err
+	}
+
+	sealed, err := sealKey(c.keys, key)
+	if err != nil {
+		return errors.Wrap(err, "encrypt network key")
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("controller")).Put(networkKeyDbKey, sealed)
+	})
+}
+
+// ExportNetworkKeys returns the S0 and S2 network keys encrypted with the
+// given passphrase, for moving the network to another installation with
+// ImportNetworkKeys.
+func (c *Client) ExportNetworkKeys(passphrase string) ([]byte, error) {
+	return sealKey(PassphraseKeyProvider(passphrase), c.networkKeys())
+}
+
+// ImportNetworkKeys replaces the S0 and S2 network keys with ones exported by
+// ExportNetworkKeys, and stores them encrypted with the client's KeyProvider.
+func (c *Client) ImportNetworkKeys(data []byte, passphrase string) error {
+	keys, err := openNetworkKeys(PassphraseKeyProvider(passphrase), data)
+	if err != nil {
+		return err
+	}
+
+	if err = c.setNetworkKeys(keys); err != nil {
+		return err
+	}
+
+	c.l.Info("imported network keys")
+
+	return nil
+}
+
+// networkKeys returns the S0 network key followed by the S2 network keys.
+func (c *Client) networkKeys() []byte {
+	keys := append([]byte(nil), c.NetworkKey()...)
+	for _, keyClass := range security.S2KeyClasses {
+		keys = append(keys, c.S2NetworkKey(keyClass)...)
+	}
+
+	return keys
+}
+
+// openNetworkKeys decrypts keys sealed from networkKeys, as by
+// ExportNetworkKeys.
+func openNetworkKeys(keys KeyProvider, data []byte) ([]byte, error) {
+	networkKeys, err := openKey(keys, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(networkKeys) != 16*(1+len(security.S2KeyClasses)) {
+		return nil, errors.Errorf("Invalid exported keys length %d", len(networkKeys))
+	}
+
+	return networkKeys, nil
+}
+
+// setNetworkKeys switches to and stores keys decrypted by openNetworkKeys.
+func (c *Client) setNetworkKeys(keys []byte) error {
+	if err := c.storeS2Keys(keys[16:]); err != nil {
+		return errors.Wrap(err, "store S2 network keys")
+	}
+
+	if err := c.setNetworkKey(keys[:16]); err != nil {
+		return errors.Wrap(err, "store network key")
+	}
+
+	c.setS2Keys(keys[16:])
+
+	return nil
+}
+
+// checkInclusionKey refuses secure inclusion with an all-zero network key.
+func (c *Client) checkInclusionKey() error {
+	if isZeroKey(c.NetworkKey()) {
+		return ErrInsecureNetworkKey
+	}
+
+	return nil
+}
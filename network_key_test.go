@@ -0,0 +1,122 @@
+package gozw
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientGeneratesNetworkKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gozw")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dbName := filepath.Join(dir, "test.db")
+	controller := emulator.NewController()
+
+	open := func(keys KeyProvider) (*Client, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		client, err := NewClient(dbName, controller.Start(ctx), keys)
+		if err == nil {
+			t.Cleanup(func() {
+				client.Shutdown()
+				client.db.Close()
+			})
+		}
+
+		return client, err
+	}
+
+	client, err := open(PassphraseKeyProvider("correct horse"))
+	require.NoError(t, err)
+
+	networkKey := client.NetworkKey()
+	assert.Len(t, networkKey, 16)
+	assert.False(t, isZeroKey(networkKey))
+
+	client.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket([]byte("controller"))
+		assert.NotContains(t, string(stored.Get(networkKeyDbKey)), string(networkKey))
+		assert.NotContains(t, string(stored.Get(s2KeysDbKey)), string(client.S2NetworkKey(security.KeyS2Access)))
+		return nil
+	})
+
+	exported, err := client.ExportNetworkKeys("export")
+	require.NoError(t, err)
+	s2Key := client.S2NetworkKey(security.KeyS2Access)
+
+	client.Shutdown()
+	client.db.Close()
+
+	_, err = open(PassphraseKeyProvider("wrong"))
+	assert.Equal(t, ErrNetworkKeyDecrypt, errors.Cause(err))
+
+	client, err = open(PassphraseKeyProvider("correct horse"))
+	require.NoError(t, err)
+	assert.Equal(t, networkKey, client.NetworkKey())
+	assert.Equal(t, s2Key, client.S2NetworkKey(security.KeyS2Access))
+
+	// the keys can be moved to another installation
+	require.NoError(t, client.resetDb())
+	require.NoError(t, client.loadNetworkKey())
+	require.NoError(t, client.initS2())
+	assert.NotEqual(t, networkKey, client.NetworkKey())
+
+	assert.Equal(t, ErrNetworkKeyDecrypt, client.ImportNetworkKeys(exported, "wrong"))
+	require.NoError(t, client.ImportNetworkKeys(exported, "export"))
+	assert.Equal(t, networkKey, client.NetworkKey())
+	assert.Equal(t, s2Key, client.S2NetworkKey(security.KeyS2Access))
+
+	require.NoError(t, client.loadNetworkKey())
+	require.NoError(t, client.initS2())
+	assert.Equal(t, networkKey, client.NetworkKey())
+	assert.Equal(t, s2Key, client.S2NetworkKey(security.KeyS2Access))
+
+	// unsealed keys are not accepted
+	require.NoError(t, client.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("controller")).Put(s2KeysDbKey, make([]byte, 16*len(security.S2KeyClasses)))
+	}))
+	assert.Error(t, client.initS2())
+}
+
+func TestClientRefusesZeroNetworkKey(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	require.NoError(t, client.setNetworkKey(make([]byte, 16)))
+
+	node := &Node{NodeID: 2}
+	assert.Equal(t, ErrInsecureNetworkKey, client.includeSecureNode(context.Background(), node))
+	assert.False(t, node.NetworkKeySent)
+
+	granted, err := client.grantS2Keys(2, security.KeyS2Unauthenticated|security.KeyS0, false)
+	require.NoError(t, err)
+	assert.Equal(t, security.KeyS2Unauthenticated, granted)
+
+	assert.Error(t, ImportNetworkKey(filepath.Join(os.TempDir(), "unused.db"), testKeys, []byte{1, 2, 3}))
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// test vectors from RFC 7914, section 11
+	assert.Equal(t,
+		"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)),
+	)
+
+	assert.Equal(t,
+		"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		hex.EncodeToString(pbkdf2SHA256([]byte("Password"), []byte("NaCl"), 80000, 64)),
+	)
+}
@@ -1,7 +1,11 @@
 package gozw
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/gozwave/gozw/cc"
@@ -10,10 +14,13 @@ import (
 	manufacturerspecific "github.com/gozwave/gozw/cc/manufacturer-specific"
 	manufacturerspecificv2 "github.com/gozwave/gozw/cc/manufacturer-specific-v2"
 	"github.com/gozwave/gozw/cc/security"
+	security2 "github.com/gozwave/gozw/cc/security-2"
 	"github.com/gozwave/gozw/cc/version"
 	versionv2 "github.com/gozwave/gozw/cc/version-v2"
 	"github.com/gozwave/gozw/protocol"
+	zwsecurity "github.com/gozwave/gozw/security"
 	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/session"
 	"github.com/gozwave/gozw/util"
 	"github.com/boltdb/bolt"
 	"github.com/davecgh/go-spew/spew"
@@ -23,9 +30,10 @@ import (
 )
 
 type Node struct {
-	NodeID byte
+	NodeID uint16
 
 	Capability          byte
+	Security            byte
 	BasicDeviceClass    byte
 	GenericDeviceClass  byte
 	SpecificDeviceClass byte
@@ -36,10 +44,18 @@ type Node struct {
 
 	NetworkKeySent bool
 
+	// GrantedKeys are the keys granted to the node during S2 inclusion, a
+	// combination of security.Key* classes.
+	GrantedKeys byte
+
 	ManufacturerID uint16
 	ProductTypeID  uint16
 	ProductID      uint16
 
+	// Associations holds the node IDs in each association group, as far as
+	// they are known from AddAssociation and association reports.
+	Associations map[byte][]byte
+
 	QueryStageSecurity     bool
 	QueryStageManufacturer bool
 	QueryStageVersions     bool
@@ -47,11 +63,16 @@ type Node struct {
 	queryStageSecurityComplete     chan bool
 	queryStageManufacturerComplete chan bool
 	queryStageVersionsComplete     chan bool
+	nodeInfoReceived               chan bool
+
+	// lock guards the node's fields, which are updated by the goroutines that
+	// receive commands from the node while it is being interviewed
+	lock sync.Mutex
 
 	client *Client
 }
 
-func NewNode(client *Client, nodeID byte) (*Node, error) {
+func NewNode(client *Client, nodeID uint16) (*Node, error) {
 	node := &Node{
 		NodeID: nodeID,
 
@@ -63,14 +84,15 @@ func NewNode(client *Client, nodeID byte) (*Node, error) {
 
 		queryStageSecurityComplete:     make(chan bool),
 		queryStageManufacturerComplete: make(chan bool),
-		queryStageVersionsComplete:     make(chan bool),
+		queryStageVersionsComplete:     make(chan bool, 1),
+		nodeInfoReceived:               make(chan bool, 1),
 
 		client: client,
 	}
 
 	err := node.loadFromDb()
 	if err != nil {
-		initErr := node.initialize()
+		initErr := node.initialize(client.ctx)
 		if initErr != nil {
 			return nil, initErr
 		}
@@ -85,7 +107,7 @@ func (n *Node) loadFromDb() error {
 	var data []byte
 	err := n.client.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("nodes"))
-		data = bucket.Get([]byte{n.NodeID})
+		data = bucket.Get(nodeKey(n.NodeID))
 
 		if len(data) == 0 {
 			return errors.New("Node not found")
@@ -106,8 +128,8 @@ func (n *Node) loadFromDb() error {
 	return nil
 }
 
-func (n *Node) initialize() error {
-	nodeInfo, err := n.client.serialAPI.GetNodeProtocolInfo(n.NodeID)
+func (n *Node) initialize(ctx context.Context) error {
+	nodeInfo, err := n.client.serialAPI.GetNodeProtocolInfo(ctx, n.NodeID)
 	if err != nil {
 		fmt.Println(err)
 	} else {
@@ -115,43 +137,91 @@ func (n *Node) initialize() error {
 		n.setFromNodeProtocolInfo(nodeInfo)
 	}
 
-	if n.NodeID == 1 {
-		// self is never failing
-		n.Failing = false
-	} else {
-		failing, err := n.client.serialAPI.IsFailedNode(n.NodeID)
+	// self is never failing
+	failing := false
+	if n.NodeID != 1 {
+		failing, err = n.client.serialAPI.IsFailedNode(ctx, n.NodeID)
 		if err != nil {
 			fmt.Println(err)
 			return nil
 		}
-
-		n.Failing = failing
 	}
 
+	n.lock.Lock()
+	n.Failing = failing
+	n.lock.Unlock()
+
 	return n.saveToDb()
 
 }
 
+// nodeKey returns the key a node is stored under: its ID as a 2-byte
+// big-endian integer.
+func nodeKey(nodeID uint16) []byte {
+	return []byte{byte(nodeID >> 8), byte(nodeID)}
+}
+
 func (n *Node) saveToDb() error {
+	n.lock.Lock()
 	data, err := msgpack.Marshal(n)
+	n.lock.Unlock()
 	if err != nil {
 		return err
 	}
 
 	return n.client.db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("nodes"))
-		return bucket.Put([]byte{n.NodeID}, data)
+		return bucket.Put(nodeKey(n.NodeID), data)
 	})
 }
 
 func (n *Node) IsSecure() bool {
-	return n.CommandClasses.Supports(cc.Security)
+	n.lock.Lock()
+	supported := n.CommandClasses.Supports(cc.Security)
+	n.lock.Unlock()
+
+	return supported || n.s2KeyClass() != 0
+}
+
+// s2KeyClass returns the highest S2 key class granted to the node, which
+// secure messages to it are encrypted with, or 0 if it was granted none.
+func (n *Node) s2KeyClass() byte {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for i := len(zwsecurity.S2KeyClasses) - 1; i >= 0; i-- {
+		if n.GrantedKeys&zwsecurity.S2KeyClasses[i] != 0 {
+			return zwsecurity.S2KeyClasses[i]
+		}
+	}
+
+	return 0
 }
 
 func (n *Node) IsListening() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	return n.Capability&0x80 == 0x80
 }
 
+// IsFLiRS returns whether a node is a frequently listening (FLiRS) node.
+func (n *Node) IsFLiRS() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.Security&0x60 != 0
+}
+
+// commandClassSupport returns whether the node supports a command class, and
+// whether it is only supported securely.
+func (n *Node) commandClassSupport(id cc.CommandClassID) (supported, secure bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.CommandClasses.Supports(id), n.CommandClasses.IsSecure(id)
+}
+
 func (n *Node) GetBasicDeviceClassName() string {
 	return protocol.GetBasicDeviceTypeName(n.BasicDeviceClass)
 }
@@ -164,42 +234,55 @@ func (n *Node) GetSpecificDeviceClassName() string {
 	return protocol.GetSpecificDeviceTypeName(n.GenericDeviceClass, n.SpecificDeviceClass)
 }
 
-func (n *Node) SendCommand(command cc.Command) error {
+// SendCommand sends a command to the node, encapsulating it if the command
+// class is only supported securely. See Client.SendData for txOptions and the
+// returned report.
+func (n *Node) SendCommand(ctx context.Context, command cc.Command, txOptions byte) (*serialapi.TransmitReport, error) {
 	commandClass := cc.CommandClassID(command.CommandClassID())
 
 	if commandClass == cc.Security {
 		switch command.(type) {
 		case *security.CommandsSupportedGet, *security.CommandsSupportedReport:
-			return n.client.SendDataSecure(n.NodeID, command)
+			return n.client.SendDataSecure(ctx, n.NodeID, command, txOptions)
+		}
+	}
+
+	if commandClass == cc.Security2 {
+		switch command.(type) {
+		case *security2.CommandsSupportedGet, *security2.CommandsSupportedReport:
+			return n.client.SendDataSecure(ctx, n.NodeID, command, txOptions)
 		}
 	}
 
-	if !n.CommandClasses.Supports(commandClass) {
-		return errors.New("Command class not supported")
+	supported, secure := n.commandClassSupport(commandClass)
+	if !supported {
+		return nil, errors.New("Command class not supported")
 	}
 
-	if n.CommandClasses.IsSecure(commandClass) {
-		return n.client.SendDataSecure(n.NodeID, command)
+	if secure {
+		return n.client.SendDataSecure(ctx, n.NodeID, command, txOptions)
 	}
 
-	return n.client.SendData(n.NodeID, command)
+	return n.client.SendData(ctx, n.NodeID, command, txOptions)
 }
 
-func (n *Node) SendRawCommand(payload []byte) error {
+// SendRawCommand is like SendCommand, for an already encoded command.
+func (n *Node) SendRawCommand(ctx context.Context, payload []byte, txOptions byte) (*serialapi.TransmitReport, error) {
 	commandClass := cc.CommandClassID(payload[0])
 
-	if !n.CommandClasses.Supports(commandClass) {
-		return errors.New("Command class not supported")
+	supported, secure := n.commandClassSupport(commandClass)
+	if !supported {
+		return nil, errors.New("Command class not supported")
 	}
 
-	if n.CommandClasses.IsSecure(commandClass) {
-		return n.client.SendDataSecure(n.NodeID, util.ByteMarshaler(payload))
+	if secure {
+		return n.client.SendDataSecure(ctx, n.NodeID, util.ByteMarshaler(payload), txOptions)
 	}
 
-	return n.client.SendData(n.NodeID, util.ByteMarshaler(payload))
+	return n.client.SendData(ctx, n.NodeID, util.ByteMarshaler(payload), txOptions)
 }
 
-func (n *Node) AddAssociation(groupID byte, nodeIDs ...byte) error {
+func (n *Node) AddAssociation(ctx context.Context, groupID byte, nodeIDs ...byte) error {
 	// sort of an arbitrary limit for now, but I'm not sure what it should be
 	if len(nodeIDs) > 20 {
 		return errors.New("Too many associated nodes")
@@ -207,32 +290,99 @@ func (n *Node) AddAssociation(groupID byte, nodeIDs ...byte) error {
 
 	fmt.Println("Associating")
 
-	return n.SendCommand(&association.Set{
+	_, err := n.SendCommand(ctx, &association.Set{
 		GroupingIdentifier: groupID,
 		NodeId:             nodeIDs,
-	})
+	}, protocol.DefaultTransmitOptions)
+	if err != nil {
+		return err
+	}
+
+	n.addAssociations(groupID, nodeIDs...)
+
+	return n.saveToDb()
+}
+
+// AssociationTargets returns the IDs of all nodes in any of the node's
+// association groups.
+func (n *Node) AssociationTargets() []byte {
+	seen := map[byte]bool{}
+	targets := []byte{}
+
+	for _, nodeIDs := range n.associations() {
+		for _, nodeID := range nodeIDs {
+			if !seen[nodeID] {
+				seen[nodeID] = true
+				targets = append(targets, nodeID)
+			}
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	return targets
+}
+
+// associations returns a copy of the node's association groups.
+func (n *Node) associations() map[byte][]byte {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	associations := map[byte][]byte{}
+	for groupID, nodeIDs := range n.Associations {
+		associations[groupID] = append([]byte(nil), nodeIDs...)
+	}
+
+	return associations
+}
+
+func (n *Node) addAssociations(groupID byte, nodeIDs ...byte) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.Associations == nil {
+		n.Associations = map[byte][]byte{}
+	}
+
+	for _, nodeID := range nodeIDs {
+		if bytes.IndexByte(n.Associations[groupID], nodeID) < 0 {
+			n.Associations[groupID] = append(n.Associations[groupID], nodeID)
+		}
+	}
 }
 
-func (n *Node) LoadSupportedSecurityCommands() error {
-	return n.client.SendDataSecure(n.NodeID, &security.CommandsSupportedGet{})
+func (n *Node) LoadSupportedSecurityCommands(ctx context.Context) error {
+	if n.s2KeyClass() != 0 {
+		_, err := n.client.SendDataSecure(ctx, n.NodeID, &security2.CommandsSupportedGet{}, protocol.DefaultTransmitOptions)
+		return err
+	}
+
+	_, err := n.client.SendDataSecure(ctx, n.NodeID, &security.CommandsSupportedGet{}, protocol.DefaultTransmitOptions)
+	return err
 }
 
-func (n *Node) RequestNodeInformationFrame() error {
+func (n *Node) RequestNodeInformationFrame(ctx context.Context) error {
 
-	_, err := n.client.serialAPI.RequestNodeInfo(n.NodeID)
+	_, err := n.client.serialAPI.RequestNodeInfo(ctx, n.NodeID)
 	return err
 }
 
-func (n *Node) LoadCommandClassVersions() error {
+func (n *Node) LoadCommandClassVersions(ctx context.Context) error {
+	n.lock.Lock()
+	commandClasses := []cc.CommandClassSupport{}
 	for _, commandClass := range n.CommandClasses {
-		time.Sleep(1 * time.Second)
+		commandClasses = append(commandClasses, *commandClass)
+	}
+	n.lock.Unlock()
+
+	for _, commandClass := range commandClasses {
 		cmd := &version.CommandClassGet{RequestedCommandClass: byte(commandClass.CommandClass)}
 		var err error
 
 		if !commandClass.Secure {
-			err = n.client.SendData(n.NodeID, cmd)
+			_, err = n.client.SendData(ctx, n.NodeID, cmd, protocol.DefaultTransmitOptions)
 		} else {
-			err = n.client.SendDataSecure(n.NodeID, cmd)
+			_, err = n.client.SendDataSecure(ctx, n.NodeID, cmd, protocol.DefaultTransmitOptions)
 		}
 
 		if err != nil {
@@ -243,23 +393,32 @@ func (n *Node) LoadCommandClassVersions() error {
 	return nil
 }
 
-func (n *Node) LoadManufacturerInfo() error {
-	return n.SendCommand(&manufacturerspecific.Get{})
+func (n *Node) LoadManufacturerInfo(ctx context.Context) error {
+	_, err := n.SendCommand(ctx, &manufacturerspecific.Get{}, protocol.DefaultTransmitOptions)
+	return err
 }
 
+// nextQueryStage continues the node interview. Interview requests are sent
+// with background priority so they don't delay interactive requests.
 func (n *Node) nextQueryStage() {
-	if !n.QueryStageSecurity && n.IsSecure() {
-		n.LoadSupportedSecurityCommands()
+	ctx := session.WithPriority(n.client.ctx, session.PriorityBackground)
+
+	n.lock.Lock()
+	securityDone, versionsDone, manufacturerDone := n.QueryStageSecurity, n.QueryStageVersions, n.QueryStageManufacturer
+	n.lock.Unlock()
+
+	if !securityDone && n.IsSecure() {
+		n.LoadSupportedSecurityCommands(ctx)
 		return
 	}
 
-	if !n.QueryStageVersions {
-		n.LoadCommandClassVersions()
+	if !versionsDone {
+		n.LoadCommandClassVersions(ctx)
 		return
 	}
 
-	if !n.QueryStageManufacturer {
-		n.LoadManufacturerInfo()
+	if !manufacturerDone {
+		n.LoadManufacturerInfo(ctx)
 		return
 	}
 }
@@ -278,9 +437,62 @@ func (n *Node) emitNodeEvent(event cc.Command) {
 func (n *Node) receiveControllerUpdate(update serialapi.ControllerUpdate) {
 	n.setFromApplicationControllerUpdate(update)
 	n.saveToDb()
+
+	if update.Status == protocol.UpdateStateNodeInfoReceived {
+		select {
+		case n.nodeInfoReceived <- true:
+		default:
+		}
+	}
+}
+
+// requestNodeInfo requests the node information frame and waits for the node's
+// command classes to be updated from it.
+func (n *Node) requestNodeInfo(ctx context.Context) error {
+	select {
+	case <-n.nodeInfoReceived:
+	default:
+	}
+
+	if err := n.RequestNodeInformationFrame(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case <-n.nodeInfoReceived:
+		return nil
+	case <-time.After(10 * time.Second):
+		return errors.New("Node information timeout")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resetDeviceInfo discards everything learned from the device, such as after
+// the device has been replaced. Associations are kept.
+func (n *Node) resetDeviceInfo() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.Capability = 0
+	n.Security = 0
+	n.BasicDeviceClass = 0
+	n.GenericDeviceClass = 0
+	n.SpecificDeviceClass = 0
+	n.Failing = false
+	n.CommandClasses = cc.CommandClassSet{}
+	n.NetworkKeySent = false
+	n.GrantedKeys = 0
+	n.ManufacturerID = 0
+	n.ProductTypeID = 0
+	n.ProductID = 0
+	n.QueryStageSecurity = false
+	n.QueryStageManufacturer = false
+	n.QueryStageVersions = false
 }
 
 func (n *Node) setFromAddNodeCallback(nodeInfo *serialapi.AddRemoveNodeCallback) {
+	n.lock.Lock()
 	n.NodeID = nodeInfo.Source
 	n.BasicDeviceClass = nodeInfo.Basic
 	n.GenericDeviceClass = nodeInfo.Generic
@@ -289,11 +501,13 @@ func (n *Node) setFromAddNodeCallback(nodeInfo *serialapi.AddRemoveNodeCallback)
 	for _, cmd := range nodeInfo.CommandClasses {
 		n.CommandClasses.Add(cc.CommandClassID(cmd))
 	}
+	n.lock.Unlock()
 
 	n.saveToDb()
 }
 
 func (n *Node) setFromApplicationControllerUpdate(nodeInfo serialapi.ControllerUpdate) {
+	n.lock.Lock()
 	n.BasicDeviceClass = nodeInfo.Basic
 	n.GenericDeviceClass = nodeInfo.Generic
 	n.SpecificDeviceClass = nodeInfo.Specific
@@ -301,59 +515,85 @@ func (n *Node) setFromApplicationControllerUpdate(nodeInfo serialapi.ControllerU
 	for _, cmd := range nodeInfo.CommandClasses {
 		n.CommandClasses.Add(cc.CommandClassID(cmd))
 	}
+	n.lock.Unlock()
 
 	n.saveToDb()
 }
 
 func (n *Node) setFromNodeProtocolInfo(nodeInfo *serialapi.NodeProtocolInfo) {
+	n.lock.Lock()
 	n.Capability = nodeInfo.Capability
+	n.Security = nodeInfo.Security
 	n.BasicDeviceClass = nodeInfo.BasicDeviceClass
 	n.GenericDeviceClass = nodeInfo.GenericDeviceClass
 	n.SpecificDeviceClass = nodeInfo.SpecificDeviceClass
+	n.lock.Unlock()
 
 	n.saveToDb()
 }
 
 func (n *Node) receiveSecurityCommandsSupportedReport(cmd security.CommandsSupportedReport) {
-	for _, supported := range cmd.CommandClassSupport {
+	n.receiveSecureCommandClasses(cmd.CommandClassSupport)
+}
+
+// receiveSecureCommandClasses marks the command classes from an S0 or S2
+// commands supported report as supported securely (up to the mark before the
+// controlled ones) and continues the interview.
+func (n *Node) receiveSecureCommandClasses(commandClasses []byte) {
+	n.lock.Lock()
+	for _, supported := range commandClasses {
+		if supported == 0xEF {
+			break
+		}
+
 		n.CommandClasses.SetSecure(cc.CommandClassID(supported), true)
 	}
 
+	n.QueryStageSecurity = true
+	n.lock.Unlock()
+
 	select {
 	case n.queryStageSecurityComplete <- true:
 	default:
 	}
 
-	n.QueryStageSecurity = true
 	n.saveToDb()
 	n.nextQueryStage()
 }
 
 func (n *Node) receiveManufacturerInfo(mfgId, productTypeId, productId uint16) {
+	n.lock.Lock()
 	n.ManufacturerID = mfgId
 	n.ProductTypeID = productTypeId
 	n.ProductID = productId
+	n.QueryStageManufacturer = true
+	n.lock.Unlock()
 
 	select {
 	case n.queryStageManufacturerComplete <- true:
 	default:
 	}
 
-	n.QueryStageManufacturer = true
 	n.saveToDb()
 	n.nextQueryStage()
 }
 
 func (n *Node) receiveCommandClassVersion(id cc.CommandClassID, version uint8) {
+	n.lock.Lock()
 	n.CommandClasses.SetVersion(id, version)
 
-	if n.CommandClasses.AllVersionsReceived() {
+	complete := n.CommandClasses.AllVersionsReceived()
+	if complete {
+		n.QueryStageVersions = true
+	}
+	n.lock.Unlock()
+
+	if complete {
 		select {
 		case n.queryStageVersionsComplete <- true:
 		default:
 		}
 
-		n.QueryStageVersions = true
 		defer n.nextQueryStage()
 	}
 
@@ -362,11 +602,14 @@ func (n *Node) receiveCommandClassVersion(id cc.CommandClassID, version uint8) {
 
 func (n *Node) receiveApplicationCommand(cmd serialapi.ApplicationCommand) {
 	commandClassID := cc.CommandClassID(cmd.CommandData[0])
+
+	n.lock.Lock()
 	ver := n.CommandClasses.GetVersion(commandClassID)
+	n.lock.Unlock()
 	if ver == 0 {
 		ver = 1
 
-		if !(commandClassID == cc.Version || commandClassID == cc.Security) {
+		if !(commandClassID == cc.Version || commandClassID == cc.Security || commandClassID == cc.Security2) {
 			n.client.l.Error("no version loaded", zap.String("commandClass", commandClassID.String()))
 		}
 	}
@@ -394,6 +637,9 @@ func (n *Node) receiveApplicationCommand(cmd serialapi.ApplicationCommand) {
 		n.receiveSecurityCommandsSupportedReport(*command.(*security.CommandsSupportedReport))
 		fmt.Println(n.GetSupportedSecureCommandClassStrings())
 
+	case *security2.CommandsSupportedReport:
+		n.receiveSecureCommandClasses(command.(*security2.CommandsSupportedReport).CommandClass)
+
 	case *manufacturerspecific.Report:
 		spew.Dump(command.(*manufacturerspecific.Report))
 		report := *command.(*manufacturerspecific.Report)
@@ -406,6 +652,12 @@ func (n *Node) receiveApplicationCommand(cmd serialapi.ApplicationCommand) {
 		n.receiveManufacturerInfo(report.ManufacturerId, report.ProductTypeId, report.ProductId)
 		n.emitNodeEvent(command)
 
+	case *association.Report:
+		report := command.(*association.Report)
+		n.addAssociations(report.GroupingIdentifier, report.Nodeid...)
+		n.saveToDb()
+		n.emitNodeEvent(command)
+
 	case *version.CommandClassReport:
 		spew.Dump(command.(*version.CommandClassReport))
 		report := command.(*version.CommandClassReport)
@@ -454,6 +706,9 @@ func (n *Node) String() string {
 	str += fmt.Sprintf("  Product ID: %#x\n", n.ProductID)
 	str += fmt.Sprintf("  Supported command classes:\n")
 
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	for _, cmd := range n.CommandClasses {
 		if cmd.Secure {
 			str += fmt.Sprintf("    - %s (v%d) (secure)\n", cmd.CommandClass.String(), cmd.Version)
@@ -466,6 +721,9 @@ func (n *Node) String() string {
 }
 
 func (n *Node) GetSupportedCommandClassStrings() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	strings := commandClassSetToStrings(n.CommandClasses.ListBySecureStatus(false))
 	if len(strings) == 0 {
 		return []string{
@@ -477,6 +735,9 @@ func (n *Node) GetSupportedCommandClassStrings() []string {
 }
 
 func (n *Node) GetSupportedSecureCommandClassStrings() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	strings := commandClassSetToStrings(n.CommandClasses.ListBySecureStatus(true))
 	return strings
 }
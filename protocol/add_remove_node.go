@@ -1,12 +1,19 @@
 package protocol
 
 const (
-	AddNodeAny        byte = 1
-	AddNodeController      = 2
-	AddNodeSlave           = 3
-	AddNodeExisting        = 4
-	AddNodeStop            = 5
-	AddNodeStopFailed      = 6
+	AddNodeAny           byte = 1
+	AddNodeController         = 2
+	AddNodeSlave              = 3
+	AddNodeExisting           = 4
+	AddNodeStop               = 5
+	AddNodeStopFailed         = 6
+	AddNodeSmartStartDSK      = 8
+)
+
+// Protocols a node can be included with, for AddNodeSmartStartDSK.
+const (
+	AddNodeProtocolZWave     byte = 0x00
+	AddNodeProtocolLongRange      = 0x01
 )
 
 const (
@@ -0,0 +1,20 @@
+package protocol
+
+// Node ID ranges. Classic Z-Wave nodes use IDs 1-232; Z-Wave Long Range nodes
+// use IDs 256-4000, which only fit in Serial API frames in 16-bit node ID mode.
+const (
+	MaxClassicNodeID   uint16 = 232
+	MinLongRangeNodeID uint16 = 256
+	MaxLongRangeNodeID uint16 = 4000
+)
+
+// Node ID types, for SerialAPISetupSetNodeIDType.
+const (
+	NodeIDType8Bit  byte = 0x01
+	NodeIDType16Bit      = 0x02
+)
+
+// IsLongRange returns whether nodeID is a Z-Wave Long Range node ID.
+func IsLongRange(nodeID uint16) bool {
+	return nodeID >= MinLongRangeNodeID && nodeID <= MaxLongRangeNodeID
+}
@@ -9,6 +9,7 @@ const (
 	FnSerialAPIGetCapabilities                 = 0x07
 	FnSerialAPISoftReset                       = 0x08
 	FnGetProtocolVersion                       = 0x09
+	FnSerialAPISetup                           = 0x0B
 	FnSendNodeInformation                      = 0x12
 	FnSendData                                 = 0x13
 	FnSendDataMulti                            = 0x14
@@ -19,19 +20,35 @@ const (
 	FnSetRoutingInfo                           = 0x1B
 	FnRFPowerLevelRediscoverySet               = 0x1E
 	FnMemoryGetID                              = 0x20
+	FnNVMGetID                                 = 0x29
+	FnNVMExtReadLongBuffer                     = 0x2A
+	FnNVMExtWriteLongBuffer                    = 0x2B
+	FnNVMBackupRestore                         = 0x2E
 	FnGetNodeProtocolInfo                      = 0x41
 	FnSetDefault                               = 0x42
+	FnReplicationCommandComplete               = 0x44
 	FnAssignReturnRoute                        = 0x46
 	FnDeleteReturnRoute                        = 0x47
 	FnRequestNodeNeighborUpdate                = 0x48
 	FnApplicationControllerUpdate              = 0x49
 	FnAddNodeToNetwork                         = 0x4a
 	FnRemoveNodeFromNetwork                    = 0x4b
+	FnSetLearnMode                             = 0x50
 	FnRequestNetworkUpdate                     = 0x53
+	FnSetSUCNodeID                             = 0x54
+	FnGetSUCNodeID                             = 0x56
 	FnRequestNodeInfo                          = 0x60
 	FnRemoveFailingNode                        = 0x61
 	FnIsNodeFailed                             = 0x62
+	FnReplaceFailedNode                        = 0x63
+	FnGetRoutingInfo                           = 0x80
+	FnSerialAPISlaveNodeInformation            = 0xA0
+	FnSendSlaveNodeInformation                 = 0xA2
+	FnSetSlaveLearnMode                        = 0xA4
+	FnGetVirtualNodes                          = 0xA5
 	FnApplicationCommandHandlerBridge          = 0xA8
+	FnSendDataBridge                           = 0xA9
+	FnGetLongRangeNodes                        = 0xDA
 	FnSerialAPIReady                           = 0xEF
 )
 
@@ -48,6 +65,14 @@ const (
 	LibraryAvDevice              = 0x0B
 )
 
+// Device options for FnSerialAPIApplicationNodeInformation.
+const (
+	ApplicationNodeInfoListening             byte = 0x01
+	ApplicationNodeInfoOptionalFunctionality      = 0x02
+	ApplicationFreqListeningMode1000ms            = 0x10
+	ApplicationFreqListeningMode250ms             = 0x20
+)
+
 const (
 	UpdateStateNodeInfoReceived  byte = 0x84
 	UpdateStateNodeInfoReqDone        = 0x82
@@ -66,3 +91,99 @@ const (
 	FailedNodeReplaceDone        = 4
 	FailedNodeReplaceFailed      = 5
 )
+
+const (
+	RequestNeighborUpdateStarted byte = 0x21
+	RequestNeighborUpdateDone         = 0x22
+	RequestNeighborUpdateFailed       = 0x23
+)
+
+const (
+	LearnModeDisable     byte = 0x00
+	LearnModeClassic          = 0x01
+	LearnModeNetworkWide      = 0x02
+)
+
+const (
+	LearnModeStatusStarted byte = 0x01
+	LearnModeStatusDone         = 0x06
+	LearnModeStatusFailed       = 0x07
+)
+
+// Modes for FnSetSlaveLearnMode.
+const (
+	SlaveLearnModeDisable byte = 0x00
+	SlaveLearnModeEnable       = 0x01
+	SlaveLearnModeAdd          = 0x02
+	SlaveLearnModeRemove       = 0x03
+)
+
+// Statuses reported in FnSetSlaveLearnMode callbacks.
+const (
+	SlaveLearnStatusComplete        byte = 0x00
+	SlaveLearnStatusNodeIDDone           = 0x01
+	SlaveLearnStatusRangeInfoUpdate      = 0x02
+)
+
+const (
+	SUCUpdateDone     byte = 0x00
+	SUCUpdateAbort         = 0x01
+	SUCUpdateWait          = 0x02
+	SUCUpdateDisabled      = 0x03
+	SUCUpdateOverflow      = 0x04
+)
+
+const (
+	SUCSetSucceeded byte = 0x05
+	SUCSetFailed         = 0x06
+)
+
+// SUCFuncNodeIDServer is the SUC capability that makes it a SIS (SUC ID
+// server), which lets other controllers include nodes.
+const SUCFuncNodeIDServer byte = 0x01
+
+const (
+	NVMBackupRestoreOpen  byte = 0x00
+	NVMBackupRestoreRead       = 0x01
+	NVMBackupRestoreWrite      = 0x02
+	NVMBackupRestoreClose      = 0x03
+)
+
+const (
+	NVMBackupRestoreStatusOK        byte = 0x00
+	NVMBackupRestoreStatusEndOfFile      = 0xFF
+)
+
+// Serial API setup (FnSerialAPISetup) subcommands.
+const (
+	SerialAPISetupGetSupportedCommands byte = 0x01
+	SerialAPISetupSetTxStatusReport         = 0x02
+	SerialAPISetupSetPowerlevel             = 0x04
+	SerialAPISetupGetPowerlevel             = 0x08
+	SerialAPISetupGetMaxPayloadSize         = 0x10
+	SerialAPISetupGetRFRegion               = 0x20
+	SerialAPISetupSetRFRegion               = 0x40
+	SerialAPISetupSetNodeIDType             = 0x80
+)
+
+// SerialAPISetupUnsupported is the subcommand a controller responds with when
+// it doesn't support the requested subcommand.
+const SerialAPISetupUnsupported byte = 0x00
+
+// RF regions, for the SerialAPISetupGetRFRegion and SerialAPISetupSetRFRegion
+// subcommands.
+const (
+	RFRegionEurope       byte = 0x00
+	RFRegionUSA               = 0x01
+	RFRegionAustraliaNZ       = 0x02
+	RFRegionHongKong          = 0x03
+	RFRegionIndia             = 0x05
+	RFRegionIsrael            = 0x06
+	RFRegionRussia            = 0x07
+	RFRegionChina             = 0x08
+	RFRegionUSALongRange      = 0x09
+	RFRegionJapan             = 0x20
+	RFRegionKorea             = 0x21
+	RFRegionUnknown           = 0xFE
+	RFRegionDefault           = 0xFF
+)
@@ -8,6 +8,16 @@ const (
 	TransmitOptionExplore        = 0x20
 )
 
+// Destination node IDs for broadcast frames to classic and Long Range nodes.
+const (
+	NodeBroadcast          uint16 = 0xFF
+	NodeBroadcastLongRange uint16 = 0xFFF
+)
+
+// DefaultTransmitOptions requests an acknowledgement from the destination and
+// lets the controller route the frame, falling back to explorer frames.
+const DefaultTransmitOptions = TransmitOptionAck | TransmitOptionAutoRoute | TransmitOptionExplore
+
 const (
 	TransmitCompleteOk      byte = 0x00
 	TransmitCompleteNoAck        = 0x01
@@ -15,3 +25,18 @@ const (
 	TransmitRoutingNotIdle       = 0x03
 	TransmitCompleteNoRoute      = 0x04
 )
+
+// Route speeds reported in the extended transmit status report.
+const (
+	RouteSpeed9k6  byte = 0x01
+	RouteSpeed40k       = 0x02
+	RouteSpeed100k      = 0x03
+)
+
+// Receive status flags of application commands.
+const (
+	ReceiveStatusRoutedBusy byte = 0x01
+	ReceiveStatusLowPower        = 0x02
+	ReceiveStatusTypeBroad       = 0x04
+	ReceiveStatusTypeMulti       = 0x08
+)
@@ -0,0 +1,308 @@
+package gozw
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"sync"
+
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/cc/association"
+	manufacturerspecific "github.com/gozwave/gozw/cc/manufacturer-specific"
+	"github.com/gozwave/gozw/cc/version"
+	zwaveplusinfo "github.com/gozwave/gozw/cc/zwaveplus-info-v2"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/serialapi"
+	"go.uber.org/zap"
+)
+
+// CommandHandler answers a command a node sent to the controller, parsed with
+// the version the handler was registered for. It returns the reply to send
+// back to the node, or nil if it doesn't answer the command, in which case the
+// command is handled like any other command from the node.
+type CommandHandler func(c *Client, nodeID uint16, command cc.Command) encoding.BinaryMarshaler
+
+// What the controller reports about itself in Z-Wave Plus Info Reports.
+const (
+	zwavePlusVersion               byte   = 2
+	zwavePlusRoleCentralStatic     byte   = 0x00
+	zwavePlusRoleSubStatic         byte   = 0x01
+	zwavePlusNodeType              byte   = 0x00
+	zwavePlusIconCentralController uint16 = 0x0100
+	maxLifelineAssociations               = 5
+	lifelineGroup                  byte   = 1
+)
+
+type commandHandler struct {
+	version uint8
+	handler CommandHandler
+}
+
+// nodeInformation is the node information frame set with SetNodeInformation.
+type nodeInformation struct {
+	deviceOptions  byte
+	generic        byte
+	specific       byte
+	commandClasses []cc.CommandClassID
+}
+
+func (info *nodeInformation) commandClassBytes() []byte {
+	commandClasses := make([]byte, len(info.commandClasses))
+	for i, commandClass := range info.commandClasses {
+		commandClasses[i] = byte(commandClass)
+	}
+
+	return commandClasses
+}
+
+// responderState holds what the controller answers commands from other nodes
+// with.
+type responderState struct {
+	lock     sync.Mutex
+	handlers map[cc.CommandClassID]commandHandler
+	nodeInfo *nodeInformation
+
+	// lifeline holds the nodes associated with the controller's lifeline
+	// group by other controllers
+	lifeline []byte
+}
+
+// SetNodeInformation sets the node information frame the controller sends to
+// other nodes: its device options (a combination of
+// protocol.ApplicationNodeInfo* flags), device classes and supported command
+// classes. Version Command Class Reports sent on the controller's behalf
+// report version 1 for command classes listed here without a handler.
+func (c *Client) SetNodeInformation(ctx context.Context, deviceOptions, generic, specific byte, commandClasses []cc.CommandClassID) error {
+	c.responder.lock.Lock()
+	c.responder.nodeInfo = &nodeInformation{
+		deviceOptions:  deviceOptions,
+		generic:        generic,
+		specific:       specific,
+		commandClasses: append([]cc.CommandClassID(nil), commandClasses...),
+	}
+	c.responder.lock.Unlock()
+
+	return c.applyNodeInformation(ctx)
+}
+
+// applyNodeInformation sends the node information frame set with
+// SetNodeInformation, if any, to the controller.
+func (c *Client) applyNodeInformation(ctx context.Context) error {
+	c.responder.lock.Lock()
+	info := c.responder.nodeInfo
+	c.responder.lock.Unlock()
+
+	if info == nil {
+		return nil
+	}
+
+	return c.serialAPI.SetApplicationNodeInformation(ctx, info.deviceOptions, info.generic, info.specific, info.commandClassBytes())
+}
+
+// HandleCommandClass registers a handler for commands of the given command
+// class sent to the controller, replacing the built-in handler (if any). The
+// version is the one reported for the command class in Version Command Class
+// Reports. A nil handler removes the handler.
+//
+// Built-in handlers answer Version, Manufacturer Specific, Z-Wave Plus Info
+// and Association Gets.
+func (c *Client) HandleCommandClass(commandClass cc.CommandClassID, version uint8, handler CommandHandler) {
+	c.responder.lock.Lock()
+	defer c.responder.lock.Unlock()
+
+	if handler == nil {
+		delete(c.responder.handlers, commandClass)
+		return
+	}
+
+	c.responder.handlers[commandClass] = commandHandler{version: version, handler: handler}
+}
+
+func (c *Client) registerDefaultCommandHandlers() {
+	c.responder.handlers = map[cc.CommandClassID]commandHandler{}
+
+	c.HandleCommandClass(cc.Version, 1, handleVersionCommand)
+	c.HandleCommandClass(cc.ManufacturerSpecific, 1, handleManufacturerSpecificCommand)
+	c.HandleCommandClass(cc.ZwaveplusInfo, 2, handleZWavePlusInfoCommand)
+	c.HandleCommandClass(cc.Association, 1, handleAssociationCommand)
+}
+
+// respondToCommand runs the handler for an application command, if there is
+// one, and sends its reply back to the node (securely if the command was
+// received securely). It returns whether the command was answered.
+func (c *Client) respondToCommand(cmd serialapi.ApplicationCommand, secure bool) bool {
+	if len(cmd.CommandData) < 2 {
+		return false
+	}
+
+	c.responder.lock.Lock()
+	handler, ok := c.responder.handlers[cc.CommandClassID(cmd.CommandData[0])]
+	c.responder.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	command, err := cc.Parse(handler.version, cmd.CommandData)
+	if err != nil {
+		return false
+	}
+
+	reply := handler.handler(c, cmd.SrcNodeID, command)
+	if reply == nil {
+		return false
+	}
+
+	c.l.Debug("answering command", zap.String("node", fmt.Sprint(cmd.SrcNodeID)), zap.String("command", command.CommandIDString()))
+
+	go func() {
+		var err error
+		if secure {
+			_, err = c.SendDataSecure(c.ctx, cmd.SrcNodeID, reply, protocol.DefaultTransmitOptions)
+		} else {
+			_, err = c.SendData(c.ctx, cmd.SrcNodeID, reply, protocol.DefaultTransmitOptions)
+		}
+
+		if err != nil {
+			c.l.Warn("sending reply", zap.String("node", fmt.Sprint(cmd.SrcNodeID)), zap.Error(err))
+		}
+	}()
+
+	return true
+}
+
+// commandClassVersion returns the version of a command class the controller
+// supports, or 0 if it doesn't.
+func (c *Client) commandClassVersion(commandClass cc.CommandClassID) byte {
+	c.responder.lock.Lock()
+	defer c.responder.lock.Unlock()
+
+	if handler, ok := c.responder.handlers[commandClass]; ok {
+		return handler.version
+	}
+
+	if c.responder.nodeInfo != nil {
+		for _, supported := range c.responder.nodeInfo.commandClasses {
+			if supported == commandClass {
+				return 1
+			}
+		}
+	}
+
+	return 0
+}
+
+func handleVersionCommand(c *Client, nodeID uint16, command cc.Command) encoding.BinaryMarshaler {
+	switch command := command.(type) {
+	case *version.Get:
+		controller := c.controller()
+
+		var major, minor byte
+		fmt.Sscanf(controller.APIVersion, "Z-Wave %d.%d", &major, &minor)
+
+		return &version.Report{
+			ZWaveLibraryType:        controller.LibraryType,
+			ZWaveProtocolVersion:    major,
+			ZWaveProtocolSubVersion: minor,
+			ApplicationVersion:      controller.ApplicationVersion,
+			ApplicationSubVersion:   controller.ApplicationRevision,
+		}
+
+	case *version.CommandClassGet:
+		return &version.CommandClassReport{
+			RequestedCommandClass: command.RequestedCommandClass,
+			CommandClassVersion:   c.commandClassVersion(cc.CommandClassID(command.RequestedCommandClass)),
+		}
+	}
+
+	return nil
+}
+
+func handleManufacturerSpecificCommand(c *Client, nodeID uint16, command cc.Command) encoding.BinaryMarshaler {
+	if _, ok := command.(*manufacturerspecific.Get); !ok {
+		return nil
+	}
+
+	controller := c.controller()
+
+	return &manufacturerspecific.Report{
+		ManufacturerId: controller.ManufacturerID,
+		ProductTypeId:  controller.ProductType,
+		ProductId:      controller.ProductID,
+	}
+}
+
+func handleZWavePlusInfoCommand(c *Client, nodeID uint16, command cc.Command) encoding.BinaryMarshaler {
+	if _, ok := command.(*zwaveplusinfo.Get); !ok {
+		return nil
+	}
+
+	role := zwavePlusRoleCentralStatic
+	if !c.controller().IsPrimaryController {
+		role = zwavePlusRoleSubStatic
+	}
+
+	return &zwaveplusinfo.Report{
+		ZWaveVersion:      zwavePlusVersion,
+		RoleType:          role,
+		NodeType:          zwavePlusNodeType,
+		InstallerIconType: zwavePlusIconCentralController,
+		UserIconType:      zwavePlusIconCentralController,
+	}
+}
+
+// handleAssociationCommand manages the controller's lifeline group, the only
+// association group it has.
+func handleAssociationCommand(c *Client, nodeID uint16, command cc.Command) encoding.BinaryMarshaler {
+	c.responder.lock.Lock()
+	defer c.responder.lock.Unlock()
+
+	switch command := command.(type) {
+	case *association.GroupingsGet:
+		return &association.GroupingsReport{SupportedGroupings: 1}
+
+	case *association.Get:
+		// Unsupported groups are reported as the lifeline group
+		return &association.Report{
+			GroupingIdentifier: lifelineGroup,
+			MaxNodesSupported:  maxLifelineAssociations,
+			Nodeid:             append([]byte(nil), c.responder.lifeline...),
+		}
+
+	case *association.Set:
+		if command.GroupingIdentifier == lifelineGroup {
+			for _, target := range command.NodeId {
+				if len(c.responder.lifeline) < maxLifelineAssociations && !containsNode(c.responder.lifeline, target) {
+					c.responder.lifeline = append(c.responder.lifeline, target)
+				}
+			}
+		}
+
+	case *association.Remove:
+		if command.GroupingIdentifier == lifelineGroup || command.GroupingIdentifier == 0 {
+			if len(command.NodeId) == 0 {
+				c.responder.lifeline = nil
+			}
+
+			kept := c.responder.lifeline[:0]
+			for _, target := range c.responder.lifeline {
+				if !containsNode(command.NodeId, target) {
+					kept = append(kept, target)
+				}
+			}
+			c.responder.lifeline = kept
+		}
+	}
+
+	return nil
+}
+
+func containsNode(nodeIDs []byte, nodeID byte) bool {
+	for _, id := range nodeIDs {
+		if id == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package gozw
+
+import (
+	"context"
+	"encoding"
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/cc/association"
+	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/cc/version"
+	zwaveplusinfo "github.com/gozwave/gozw/cc/zwaveplus-info-v2"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSetNodeInformation(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	err := client.SetNodeInformation(context.Background(), protocol.ApplicationNodeInfoListening, 0x02, 0x07,
+		[]cc.CommandClassID{cc.ZwaveplusInfo, cc.Version, cc.SwitchBinary})
+	require.NoError(t, err)
+
+	// The function has no response, so the controller may not have handled it yet
+	expected := []byte{protocol.ApplicationNodeInfoListening, 0x02, 0x07, 3, 0x5E, 0x86, 0x25}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, controller.NodeInformation())
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClientRespondsToGets(t *testing.T) {
+	controller := emulator.NewController()
+	node := emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary))
+	controller.AddNode(node)
+
+	client := newTestClient(t, controller)
+	require.NoError(t, client.SetNodeInformation(context.Background(), protocol.ApplicationNodeInfoListening, 0x02, 0x07,
+		[]cc.CommandClassID{cc.ZwaveplusInfo, cc.Version, cc.SwitchBinary}))
+
+	received := func(command encoding.BinaryMarshaler) func() bool {
+		expected, err := command.MarshalBinary()
+		require.NoError(t, err)
+
+		return func() bool {
+			for _, cmd := range node.Received() {
+				if assert.ObjectsAreEqual(expected, cmd) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	node.Emit([]byte{byte(cc.ZwaveplusInfo), byte(zwaveplusinfo.CommandGet)})
+	assert.Eventually(t, received(&zwaveplusinfo.Report{
+		ZWaveVersion:      2,
+		RoleType:          0x00,
+		InstallerIconType: 0x0100,
+		UserIconType:      0x0100,
+	}), time.Second, 10*time.Millisecond)
+
+	node.Emit([]byte{byte(cc.Version), byte(version.CommandCommandClassGet), byte(cc.SwitchBinary)})
+	assert.Eventually(t, received(&version.CommandClassReport{
+		RequestedCommandClass: byte(cc.SwitchBinary),
+		CommandClassVersion:   1,
+	}), time.Second, 10*time.Millisecond)
+
+	node.Emit([]byte{byte(cc.Association), byte(association.CommandSet), 1, 2})
+	node.Emit([]byte{byte(cc.Association), byte(association.CommandGet), 1})
+	assert.Eventually(t, received(&association.Report{
+		GroupingIdentifier: 1,
+		MaxNodesSupported:  5,
+		Nodeid:             []byte{2},
+	}), time.Second, 10*time.Millisecond)
+
+	// Applications can answer other command classes
+	client.HandleCommandClass(cc.SwitchBinary, 1, func(c *Client, nodeID uint16, command cc.Command) encoding.BinaryMarshaler {
+		if _, ok := command.(*switchbinary.Get); ok {
+			return &switchbinary.Report{Value: 0xFF}
+		}
+		return nil
+	})
+
+	node.Emit([]byte{byte(cc.SwitchBinary), byte(switchbinary.CommandGet)})
+	assert.Eventually(t, received(&switchbinary.Report{Value: 0xFF}), time.Second, 10*time.Millisecond)
+}
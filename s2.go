@@ -0,0 +1,701 @@
+package gozw
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/gozwave/gozw/cc"
+	security2 "github.com/gozwave/gozw/cc/security-2"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// The KEX scheme and ECDH profile used for S2 inclusion: KEX scheme 1 and
+// Curve25519, which are the only ones defined.
+const (
+	kexScheme1    byte = 0x02
+	kexCurve25519 byte = 0x01
+)
+
+// KEX Fail types, which end an S2 inclusion.
+const (
+	kexFailKey       byte = 0x01
+	kexFailScheme         = 0x02
+	kexFailCurves         = 0x03
+	kexFailDecrypt        = 0x05
+	kexFailCancel         = 0x06
+	kexFailAuth           = 0x07
+	kexFailKeyGet         = 0x08
+	kexFailKeyVerify      = 0x09
+	kexFailKeyReport      = 0x0A
+)
+
+// s2KeysDbKey is where the S2 network keys are kept in the controller bucket,
+// in the order of security.S2KeyClasses.
+var s2KeysDbKey = []byte("s2NetworkKeys")
+
+type s2State struct {
+	lock       sync.Mutex
+	keys       map[byte][]byte
+	inclusions map[uint16]*s2Inclusion
+}
+
+// s2Inclusion is the key exchange with a node being included with S2.
+type s2Inclusion struct {
+	granted  byte
+	messages chan s2InclusionMessage
+}
+
+// s2InclusionMessage is a Security 2 command from a node being included.
+// Secure commands also carry the key class they were encrypted with. err is
+// set instead if a message from the node couldn't be decrypted.
+type s2InclusionMessage struct {
+	command  []byte
+	secure   bool
+	keyClass byte
+	err      error
+}
+
+// kexError is an error that ends an S2 inclusion with a KEX Fail.
+type kexError struct {
+	failType byte
+	err      error
+}
+
+func (e *kexError) Error() string {
+	return fmt.Sprintf("S2 inclusion failed (KEX Fail %#02x): %v", e.failType, e.err)
+}
+
+// SetS2InclusionCallbacks sets the callbacks that are asked during S2
+// inclusion. grantKeys is given the keys the node requested (a combination of
+// security.Key* classes) and returns the keys to grant it. confirmDSK is given
+// the node's DSK, formatted as on its label; if pinRequired is set (because
+// the authenticated or access key was granted), the first group is zero and
+// the PIN from the label must be returned instead. Either may return an error
+// to cancel the inclusion.
+//
+// Without grantKeys, all requested keys are granted, except the authenticated
+// and access keys if there is no confirmDSK either.
+func (c *Client) SetS2InclusionCallbacks(
+	grantKeys func(c *Client, nodeID uint16, requested byte) (byte, error),
+	confirmDSK func(c *Client, nodeID uint16, dsk string, pinRequired bool) (uint16, error),
+) {
+	c.S2GrantKeysCallback = grantKeys
+	c.S2DSKCallback = confirmDSK
+}
+
+// S2NetworkKey returns the S2 network key of the given key class.
+func (c *Client) S2NetworkKey(keyClass byte) []byte {
+	c.s2.lock.Lock()
+	defer c.s2.lock.Unlock()
+
+	return c.s2.keys[keyClass]
+}
+
+// initS2 loads the S2 network keys, generating them the first time the
+// network is used.
+func (c *Client) initS2() error {
+	c.s2Layer.SetHomeID(c.controller().HomeID)
+
+	var sealed []byte
+	c.db.View(func(tx *bolt.Tx) error {
+		sealed = append([]byte(nil), tx.Bucket([]byte("controller")).Get(s2KeysDbKey)...)
+		return nil
+	})
+
+	size := 16 * len(security.S2KeyClasses)
+
+	var keys []byte
+	switch {
+	case len(sealed) == 0:
+		keys = make([]byte, size)
+		if _, err := rand.Read(keys); err != nil {
+			return errors.Wrap(err, "generate S2 network keys")
+		}
+
+		if err := c.storeS2Keys(keys); err != nil {
+			return errors.Wrap(err, "store S2 network keys")
+		}
+
+	default:
+		var err error
+		if keys, err = openKey(c.keys, sealed); err != nil {
+			return errors.Wrap(err, "load S2 network keys")
+		}
+
+		if len(keys) != size {
+			return errors.Errorf("Invalid S2 network keys length %d", len(keys))
+		}
+	}
+
+	c.setS2Keys(keys)
+
+	return nil
+}
+
+// storeS2Keys stores the S2 network keys, in the order of
+// security.S2KeyClasses, encrypted with the client's KeyProvider.
+func (c *Client) storeS2Keys(keys []byte) error {
+	sealed, err := sealKey(c.keys, keys)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("controller")).Put(s2KeysDbKey, sealed)
+	})
+}
+
+func (c *Client) setS2Keys(keys []byte) {
+	c.s2.lock.Lock()
+	defer c.s2.lock.Unlock()
+
+	c.s2.keys = map[byte][]byte{}
+	for i, keyClass := range security.S2KeyClasses {
+		c.s2.keys[keyClass] = append([]byte(nil), keys[16*i:16*(i+1)]...)
+		c.s2Layer.SetNetworkKey(keyClass, c.s2.keys[keyClass])
+	}
+}
+
+// grantedKeyClasses returns the S2 key classes that messages from a node may
+// be encrypted with: those granted to it, or being granted during inclusion.
+func (c *Client) grantedKeyClasses(nodeID uint16) []byte {
+	var granted byte
+	if node, err := c.Node(nodeID); err == nil {
+		node.lock.Lock()
+		granted = node.GrantedKeys
+		node.lock.Unlock()
+	}
+
+	c.s2.lock.Lock()
+	if inclusion, ok := c.s2.inclusions[nodeID]; ok {
+		granted |= inclusion.granted
+	}
+	c.s2.lock.Unlock()
+
+	keyClasses := []byte{}
+	for _, keyClass := range security.S2KeyClasses {
+		if granted&keyClass != 0 {
+			keyClasses = append(keyClasses, keyClass)
+		}
+	}
+
+	return keyClasses
+}
+
+// sendDataS2 encrypts a message with the key of the given class (or the
+// temporary key, with security.KeyS2Temporary) and sends it, getting a nonce
+// from the node first if needed.
+func (c *Client) sendDataS2(ctx context.Context, dstNode uint16, message encoding.BinaryMarshaler, keyClass byte, txOptions byte) (*serialapi.TransmitReport, error) {
+	payload, err := message.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.sendS2Payload(ctx, dstNode, payload, keyClass, 0, txOptions)
+}
+
+// sendS2Payload is sendDataS2 for an encoded command. A non-zero groupID makes
+// the message the singlecast follow-up of a multicast to the group.
+func (c *Client) sendS2Payload(ctx context.Context, dstNode uint16, payload []byte, keyClass, groupID byte, txOptions byte) (*serialapi.TransmitReport, error) {
+	// the node must receive messages in the order of the SPAN's nonces
+	defer c.secureSends.acquire(dstNode)()
+
+	encapsulated, err := c.s2Layer.Encapsulate(c.controller().NodeID, dstNode, keyClass, payload, groupID)
+	if err == security.ErrS2NoSPAN {
+		if _, err = c.SendData(ctx, dstNode, c.s2Layer.NonceGet(dstNode), protocol.DefaultTransmitOptions); err != nil {
+			return nil, err
+		}
+
+		if err = c.s2Layer.WaitForNonceReport(dstNode, keyClass); err != nil {
+			return nil, err
+		}
+
+		encapsulated, err = c.s2Layer.Encapsulate(c.controller().NodeID, dstNode, keyClass, payload, groupID)
+	}
+
+	if err != nil {
+		c.l.Error("failed to encrypt S2 message", zap.Error(err), zap.String("node", fmt.Sprint(dstNode)))
+		return nil, err
+	}
+
+	return c.SendData(ctx, dstNode, encapsulated, txOptions)
+}
+
+// sendS2NonceReport sends a node a Nonce Report. With sos, it carries a new
+// entropy input to (re)establish the SPAN with; with mos, it asks for the MPAN
+// of a multicast group.
+func (c *Client) sendS2NonceReport(nodeID uint16, sos, mos bool) {
+	report, err := c.s2Layer.NonceReport(nodeID, sos, mos)
+	if err != nil {
+		c.l.Error("generating S2 nonce", zap.Error(err))
+		return
+	}
+
+	c.SendData(c.ctx, nodeID, report, protocol.DefaultTransmitOptions)
+}
+
+func (c *Client) interceptSecurity2CommandClass(cmd serialapi.ApplicationCommand) {
+	if len(cmd.CommandData) < 2 {
+		return
+	}
+
+	l := c.l.With(zap.String("node", fmt.Sprint(cmd.SrcNodeID)))
+
+	switch cc.CommandID(cmd.CommandData[1]) {
+
+	case security2.CommandNonceGet:
+		l.Info("S2 nonce get")
+		c.sendS2NonceReport(cmd.SrcNodeID, true, false)
+
+	case security2.CommandNonceReport:
+		l.Info("S2 nonce report")
+		if err := c.s2Layer.ReceiveNonceReport(cmd.SrcNodeID, cmd.CommandData); err != nil {
+			l.Warn("invalid S2 nonce report", zap.Error(err))
+		}
+
+	case security.CommandS2MessageEncapsulation:
+		multicast := cmd.ReceiveStatus&protocol.ReceiveStatusTypeMulti != 0
+
+		decrypted, err := c.s2Layer.Decrypt(cmd, c.grantedKeyClasses(cmd.SrcNodeID), multicast)
+		switch {
+		case err == security.ErrS2Duplicate:
+			l.Debug("dropping duplicate S2 message")
+			return
+
+		case err == security.ErrS2NoMPAN:
+			c.sendS2NonceReport(cmd.SrcNodeID, false, true)
+			return
+
+		case err != nil:
+			c.securityEvent(cmd.SrcNodeID, err)
+
+			// a node being included uses a different temporary key (such as
+			// after a wrong PIN), which ends the inclusion
+			if err == security.ErrS2Authentication && c.receiveS2InclusionMessage(cmd.SrcNodeID, s2InclusionMessage{err: err}) {
+				return
+			}
+
+			// multicasts are resynchronized by their singlecast follow-ups
+			if !multicast {
+				c.sendS2NonceReport(cmd.SrcNodeID, true, false)
+			}
+			return
+		}
+
+		if decrypted.MPANMissing {
+			c.sendS2NonceReport(cmd.SrcNodeID, false, true)
+		}
+
+		if len(decrypted.Payload) < 2 {
+			return
+		}
+
+		if cc.CommandClassID(decrypted.Payload[0]) == cc.Security2 &&
+			c.receiveS2InclusionMessage(cmd.SrcNodeID, s2InclusionMessage{command: decrypted.Payload, secure: true, keyClass: decrypted.KeyClass}) {
+			return
+		}
+
+		if decrypted.KeyClass == security.KeyS2Temporary {
+			l.Warn("dropping command encrypted with the temporary key")
+			return
+		}
+
+		l.Info("received S2 encapsulated message")
+
+		cmd.CommandData = decrypted.Payload
+		if c.respondToCommand(cmd, true) {
+			return
+		}
+
+		if node, err := c.Node(cmd.SrcNodeID); err == nil {
+			go node.receiveApplicationCommand(cmd)
+		} else {
+			l.Warn("received secure command for unknown node")
+		}
+
+	default:
+		// the key exchange starts in the clear
+		if !c.receiveS2InclusionMessage(cmd.SrcNodeID, s2InclusionMessage{command: cmd.CommandData}) {
+			l.Warn("unexpected S2 command", zap.String("data", spew.Sdump(cmd)))
+		}
+	}
+}
+
+// receiveS2InclusionMessage passes a command to the inclusion of the node that
+// sent it. It returns false if the node isn't being included.
+func (c *Client) receiveS2InclusionMessage(nodeID uint16, msg s2InclusionMessage) bool {
+	c.s2.lock.Lock()
+	inclusion, ok := c.s2.inclusions[nodeID]
+	c.s2.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	select {
+	case inclusion.messages <- msg:
+	default:
+		c.l.Warn("dropping S2 inclusion message", zap.String("node", fmt.Sprint(nodeID)))
+	}
+
+	return true
+}
+
+// includeS2Node runs S2 inclusion with a newly added node: the keys to grant
+// are negotiated, the node's DSK is confirmed, a temporary key is established
+// with ECDH and each granted network key is sent to the node with it. dsk is
+// the node's DSK if it is already known (from SmartStart), in which case it
+// isn't asked for.
+func (c *Client) includeS2Node(ctx context.Context, node *Node, dsk []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, MaxSecureInclusionDuration)
+	defer cancel()
+
+	inclusion := &s2Inclusion{messages: make(chan s2InclusionMessage, 10)}
+
+	c.s2.lock.Lock()
+	if c.s2.inclusions == nil {
+		c.s2.inclusions = map[uint16]*s2Inclusion{}
+	}
+	c.s2.inclusions[node.NodeID] = inclusion
+	c.s2.lock.Unlock()
+
+	defer func() {
+		c.s2.lock.Lock()
+		delete(c.s2.inclusions, node.NodeID)
+		c.s2.lock.Unlock()
+
+		c.s2Layer.ClearTemporaryKey(node.NodeID)
+	}()
+
+	c.l.Info("starting S2 inclusion", zap.String("node", fmt.Sprint(node.NodeID)))
+
+	granted, err := c.exchangeS2Keys(ctx, node, inclusion, dsk)
+	if err != nil {
+		c.l.Warn("S2 inclusion failed", zap.String("node", fmt.Sprint(node.NodeID)), zap.Error(err))
+
+		if kexErr, ok := err.(*kexError); ok {
+			c.sendKexFail(node.NodeID, kexErr.failType)
+		}
+
+		return err
+	}
+
+	node.lock.Lock()
+	node.GrantedKeys = granted
+	node.lock.Unlock()
+
+	return node.saveToDb()
+}
+
+// sendKexFail ends an S2 inclusion. Once the temporary key is established,
+// the KEX Fail is encrypted with it.
+func (c *Client) sendKexFail(nodeID uint16, failType byte) {
+	fail := &security2.KexFail{KexFailType: failType}
+
+	var err error
+	if c.s2Layer.CanEncapsulate(nodeID, security.KeyS2Temporary) {
+		_, err = c.sendDataS2(c.ctx, nodeID, fail, security.KeyS2Temporary, protocol.DefaultTransmitOptions)
+	} else {
+		_, err = c.SendData(c.ctx, nodeID, fail, protocol.DefaultTransmitOptions)
+	}
+
+	if err != nil {
+		c.l.Warn("sending KEX fail", zap.String("node", fmt.Sprint(nodeID)), zap.Error(err))
+	}
+}
+
+// awaitS2Command waits for one of the given commands from the node being
+// included. Secure commands must have been encrypted with the key of the given
+// class; other commands are ignored.
+func (c *Client) awaitS2Command(ctx context.Context, inclusion *s2Inclusion, secure bool, keyClass byte, commandIDs ...cc.CommandID) ([]byte, error) {
+	for {
+		select {
+		case msg := <-inclusion.messages:
+			if msg.err != nil {
+				return nil, &kexError{kexFailDecrypt, msg.err}
+			}
+
+			commandID := cc.CommandID(msg.command[1])
+
+			if commandID == security2.CommandKexFail {
+				fail := &security2.KexFail{}
+				fail.UnmarshalBinary(msg.command)
+				return nil, fmt.Errorf("node sent KEX Fail %#02x", fail.KexFailType)
+			}
+
+			if msg.secure == secure && (!secure || msg.keyClass == keyClass) {
+				for _, id := range commandIDs {
+					if commandID == id {
+						return msg.command, nil
+					}
+				}
+			}
+
+			c.l.Warn("unexpected S2 inclusion command", zap.String("command", fmt.Sprintf("%#02x", byte(commandID))))
+
+		case <-ctx.Done():
+			return nil, &kexError{kexFailCancel, errors.New("S2 inclusion timeout")}
+		}
+	}
+}
+
+// exchangeS2Keys runs the S2 key exchange with a node and returns the keys
+// that were granted and verified.
+func (c *Client) exchangeS2Keys(ctx context.Context, node *Node, inclusion *s2Inclusion, dsk []byte) (byte, error) {
+	if _, err := c.SendData(ctx, node.NodeID, &security2.KexGet{}, protocol.DefaultTransmitOptions); err != nil {
+		return 0, err
+	}
+
+	data, err := c.awaitS2Command(ctx, inclusion, false, 0, security2.CommandKexReport)
+	if err != nil {
+		return 0, err
+	}
+
+	kexReport := &security2.KexReport{}
+	if err = kexReport.UnmarshalBinary(data); err != nil {
+		return 0, &kexError{kexFailKey, err}
+	}
+
+	if kexReport.SupportedKexSchemes&kexScheme1 == 0 {
+		return 0, &kexError{kexFailScheme, errors.New("KEX scheme 1 not supported")}
+	}
+
+	if kexReport.SupportedEcdhProfiles&kexCurve25519 == 0 {
+		return 0, &kexError{kexFailCurves, errors.New("Curve25519 not supported")}
+	}
+
+	if len(kexReport.RequestedKeys) == 0 {
+		return 0, &kexError{kexFailKey, errors.New("No keys requested")}
+	}
+
+	granted, err := c.grantS2Keys(node.NodeID, kexReport.RequestedKeys[0], dsk != nil)
+	if err != nil {
+		return 0, err
+	}
+
+	c.s2.lock.Lock()
+	inclusion.granted = granted
+	c.s2.lock.Unlock()
+
+	kexSet := &security2.KexSet{
+		SelectedKexScheme:   kexScheme1,
+		SelectedEcdhProfile: kexCurve25519,
+		GrantedKeys:         []byte{granted},
+	}
+
+	if _, err = c.SendData(ctx, node.NodeID, kexSet, protocol.DefaultTransmitOptions); err != nil {
+		return 0, err
+	}
+
+	data, err = c.awaitS2Command(ctx, inclusion, false, 0, security2.CommandPublicKeyReport)
+	if err != nil {
+		return 0, err
+	}
+
+	nodeKey := &security2.PublicKeyReport{}
+	if err = nodeKey.UnmarshalBinary(data); err != nil || len(nodeKey.EcdhPublicKey) != 32 {
+		return 0, &kexError{kexFailKey, errors.New("invalid public key")}
+	}
+
+	publicKey := append([]byte(nil), nodeKey.EcdhPublicKey...)
+	if err = c.confirmDSK(node.NodeID, publicKey, granted, dsk); err != nil {
+		return 0, err
+	}
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return 0, err
+	}
+
+	ownKey := &security2.PublicKeyReport{EcdhPublicKey: privateKey.PublicKey().Bytes()}
+	ownKey.Properties1.IncludingNode = true
+
+	if _, err = c.SendData(ctx, node.NodeID, ownKey, protocol.DefaultTransmitOptions); err != nil {
+		return 0, err
+	}
+
+	if err = c.s2Layer.SetTemporaryKey(node.NodeID, privateKey, publicKey, true); err != nil {
+		return 0, &kexError{kexFailKey, err}
+	}
+
+	// The node echoes the KEX Set and we echo its KEX Report, encrypted with
+	// the temporary key, so that tampering with them is noticed
+	data, err = c.awaitS2Command(ctx, inclusion, true, security.KeyS2Temporary, security2.CommandKexSet)
+	if err != nil {
+		return 0, err
+	}
+
+	kexSet.Properties1.Echo = true
+	if expected, _ := kexSet.MarshalBinary(); !bytes.Equal(data, expected) {
+		return 0, &kexError{kexFailAuth, errors.New("KEX Set echo doesn't match")}
+	}
+
+	kexReport.Properties1.Echo = true
+	if _, err = c.sendDataS2(ctx, node.NodeID, kexReport, security.KeyS2Temporary, protocol.DefaultTransmitOptions); err != nil {
+		return 0, err
+	}
+
+	var verified byte
+	for {
+		data, err = c.awaitS2Command(ctx, inclusion, true, security.KeyS2Temporary, security2.CommandNetworkKeyGet, security2.CommandTransferEnd)
+		if err != nil {
+			return 0, err
+		}
+
+		if cc.CommandID(data[1]) == security2.CommandTransferEnd {
+			end := &security2.TransferEnd{}
+			if err = end.UnmarshalBinary(data); err != nil || !end.Properties1.KeyRequestComplete {
+				return 0, &kexError{kexFailKeyVerify, errors.New("invalid transfer end")}
+			}
+
+			return verified, nil
+		}
+
+		keyGet := &security2.NetworkKeyGet{}
+		if err = keyGet.UnmarshalBinary(data); err != nil {
+			return 0, &kexError{kexFailKeyGet, err}
+		}
+
+		keyClass := keyGet.RequestedKey
+		if keyClass&granted == 0 || keyClass&(keyClass-1) != 0 {
+			return 0, &kexError{kexFailKeyGet, fmt.Errorf("requested key %#02x wasn't granted", keyClass)}
+		}
+
+		if err = c.sendS2NetworkKey(ctx, node, inclusion, keyClass); err != nil {
+			return 0, err
+		}
+
+		verified |= keyClass
+
+		end := &security2.TransferEnd{}
+		end.Properties1.KeyVerified = true
+		if _, err = c.sendDataS2(ctx, node.NodeID, end, security.KeyS2Temporary, protocol.DefaultTransmitOptions); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// grantS2Keys chooses the keys to grant a node from those it requested.
+// Without callbacks, authenticated and access keys are only granted if the
+// node's DSK is known.
+func (c *Client) grantS2Keys(nodeID uint16, requested byte, dskKnown bool) (byte, error) {
+	granted := requested
+	if c.S2GrantKeysCallback != nil {
+		var err error
+		if granted, err = c.S2GrantKeysCallback(c, nodeID, requested); err != nil {
+			return 0, &kexError{kexFailCancel, err}
+		}
+	} else if c.S2DSKCallback == nil && !dskKnown {
+		granted &^= security.KeyS2Authenticated | security.KeyS2Access
+	}
+
+	if isZeroKey(c.NetworkKey()) {
+		granted &^= security.KeyS0
+	}
+
+	granted &= requested & (security.KeyS2Unauthenticated | security.KeyS2Authenticated | security.KeyS2Access | security.KeyS0)
+	if granted == 0 {
+		return 0, &kexError{kexFailKey, errors.New("no keys granted")}
+	}
+
+	return granted, nil
+}
+
+// confirmDSK checks the DSK at the start of a node's public key, and fills in
+// the PIN that the node leaves out when the authenticated or access key is
+// granted.
+func (c *Client) confirmDSK(nodeID uint16, publicKey []byte, granted byte, dsk []byte) error {
+	pinRequired := granted&(security.KeyS2Authenticated|security.KeyS2Access) != 0
+
+	if dsk != nil {
+		if len(dsk) != security.DSKSize || !bytes.Equal(publicKey[2:security.DSKSize], dsk[2:]) {
+			return &kexError{kexFailCancel, errors.New("public key doesn't match the DSK")}
+		}
+
+		copy(publicKey, dsk[:2])
+		return nil
+	}
+
+	if c.S2DSKCallback == nil {
+		if pinRequired {
+			return &kexError{kexFailCancel, errors.New("PIN required")}
+		}
+		return nil
+	}
+
+	pin, err := c.S2DSKCallback(c, nodeID, security.FormatDSK(publicKey[:security.DSKSize]), pinRequired)
+	if err != nil {
+		return &kexError{kexFailCancel, err}
+	}
+
+	if pinRequired {
+		binary.BigEndian.PutUint16(publicKey, pin)
+	}
+
+	return nil
+}
+
+// sendS2NetworkKey sends a node the network key of the given class, encrypted
+// with the temporary key, and waits for the node to show that it has the key
+// by sending a Network Key Verify encrypted with it.
+func (c *Client) sendS2NetworkKey(ctx context.Context, node *Node, inclusion *s2Inclusion, keyClass byte) error {
+	report := &security2.NetworkKeyReport{GrantedKey: keyClass}
+
+	if keyClass == security.KeyS0 {
+		report.NetworkKey = c.NetworkKey()
+	} else {
+		report.NetworkKey = c.S2NetworkKey(keyClass)
+	}
+
+	c.l.Info("sending S2 network key", zap.String("node", fmt.Sprint(node.NodeID)), zap.String("key", fmt.Sprintf("%#02x", keyClass)))
+
+	if keyClass != security.KeyS0 {
+		if _, err := c.sendDataS2(ctx, node.NodeID, report, security.KeyS2Temporary, protocol.DefaultTransmitOptions); err != nil {
+			return err
+		}
+
+		if _, err := c.awaitS2Command(ctx, inclusion, true, keyClass, security2.CommandNetworkKeyVerify); err != nil {
+			return &kexError{kexFailKeyVerify, err}
+		}
+
+		return nil
+	}
+
+	// The S0 key is verified with an S0 Network Key Verify, as in S0
+	// inclusion
+	step := c.secureInclusionStep.begin(node.NodeID)
+	defer c.secureInclusionStep.end(node.NodeID)
+
+	node.lock.Lock()
+	node.NetworkKeySent = true
+	node.lock.Unlock()
+
+	if _, err := c.sendDataS2(ctx, node.NodeID, report, security.KeyS2Temporary, protocol.DefaultTransmitOptions); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-step:
+		if err != nil {
+			return &kexError{kexFailKeyVerify, err}
+		}
+		return nil
+	case <-time.After(time.Second * 20):
+		return &kexError{kexFailKeyVerify, errors.New("S0 network key verify timeout")}
+	case <-ctx.Done():
+		return &kexError{kexFailCancel, ctx.Err()}
+	}
+}
@@ -0,0 +1,430 @@
+package gozw
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/cc"
+	security2 "github.com/gozwave/gozw/cc/security-2"
+	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/cc/version"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// s2Node plays a joining S2 node: it runs its side of the key exchange,
+// requesting the given keys, and afterwards decrypts the commands it receives
+// and answers the interview.
+type s2Node struct {
+	*emulator.VirtualNode
+
+	layer      *security.S2Layer
+	privateKey *ecdh.PrivateKey
+	requested  byte
+	granted    byte
+	keys       map[byte][]byte
+	pending    []s2Pending
+
+	messages chan []byte
+	fails    chan byte
+}
+
+// s2Pending is a secure reply waiting for a nonce from the controller.
+type s2Pending struct {
+	keyClass byte
+	payload  []byte
+}
+
+func newS2Node(t *testing.T, nodeID uint16, requested byte) *s2Node {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	node := &s2Node{
+		VirtualNode: emulator.NewVirtualNode(nodeID, 0x10, 0x01, byte(cc.Security2), byte(cc.Version), byte(cc.SwitchBinary)),
+		layer:       security.NewS2Layer(zap.NewNop()),
+		privateKey:  privateKey,
+		requested:   requested,
+		keys:        map[byte][]byte{},
+		messages:    make(chan []byte, 10),
+		fails:       make(chan byte, 10),
+	}
+
+	node.OnCommand = func(command []byte) [][]byte {
+		return node.receive(t, command)
+	}
+
+	node.OnMulticast = func(command []byte) {
+		decrypted, err := node.layer.Decrypt(node.applicationCommand(command), node.keyClasses(), true)
+		if err == nil {
+			node.messages <- decrypted.Payload
+		}
+	}
+
+	return node
+}
+
+// publicKey returns the node's public key as it is sent in its Public Key
+// Report, without the PIN if an authenticated key was granted.
+func (n *s2Node) publicKey() []byte {
+	publicKey := n.privateKey.PublicKey().Bytes()
+	if n.granted&(security.KeyS2Authenticated|security.KeyS2Access) != 0 {
+		publicKey[0], publicKey[1] = 0, 0
+	}
+
+	return publicKey
+}
+
+// dsk returns the DSK on the node's label.
+func (n *s2Node) dsk() []byte {
+	return n.privateKey.PublicKey().Bytes()[:security.DSKSize]
+}
+
+func (n *s2Node) pin() uint16 {
+	dsk := n.dsk()
+	return uint16(dsk[0])<<8 | uint16(dsk[1])
+}
+
+func (n *s2Node) keyClasses() []byte {
+	keyClasses := []byte{}
+	for _, keyClass := range security.S2KeyClasses {
+		if n.granted&keyClass != 0 {
+			keyClasses = append(keyClasses, keyClass)
+		}
+	}
+
+	return keyClasses
+}
+
+func (n *s2Node) applicationCommand(command []byte) serialapi.ApplicationCommand {
+	return serialapi.ApplicationCommand{SrcNodeID: 1, DstNodeID: n.NodeID, CommandData: command}
+}
+
+// secure queues a secure reply, returning a Nonce Get if there is no SPAN to
+// encrypt it with yet.
+func (n *s2Node) secure(t *testing.T, keyClass byte, payload []byte) [][]byte {
+	n.pending = append(n.pending, s2Pending{keyClass, payload})
+	if !n.layer.CanEncapsulate(1, keyClass) {
+		nonceGet, _ := n.layer.NonceGet(1).MarshalBinary()
+		return [][]byte{nonceGet}
+	}
+
+	return n.flush(t)
+}
+
+// flush encrypts the queued replies.
+func (n *s2Node) flush(t *testing.T) [][]byte {
+	var replies [][]byte
+	for len(n.pending) > 0 {
+		next := n.pending[0]
+		if !n.layer.CanEncapsulate(1, next.keyClass) {
+			nonceGet, _ := n.layer.NonceGet(1).MarshalBinary()
+			return append(replies, nonceGet)
+		}
+
+		msg, err := n.layer.Encapsulate(n.NodeID, 1, next.keyClass, next.payload, 0)
+		require.NoError(t, err)
+
+		data, _ := msg.MarshalBinary()
+		replies = append(replies, data)
+		n.pending = n.pending[1:]
+	}
+
+	return replies
+}
+
+// requestNextKey asks for the next granted key, or ends the key exchange.
+func (n *s2Node) requestNextKey(t *testing.T) [][]byte {
+	for _, keyClass := range n.keyClasses() {
+		if _, ok := n.keys[keyClass]; !ok {
+			get, _ := (&security2.NetworkKeyGet{RequestedKey: keyClass}).MarshalBinary()
+			return n.secure(t, security.KeyS2Temporary, get)
+		}
+	}
+
+	end := &security2.TransferEnd{}
+	end.Properties1.KeyRequestComplete = true
+	data, _ := end.MarshalBinary()
+
+	replies := n.secure(t, security.KeyS2Temporary, data)
+	n.layer.ClearTemporaryKey(1)
+
+	return replies
+}
+
+func (n *s2Node) receive(t *testing.T, command []byte) [][]byte {
+	if command[0] == byte(cc.Version) && command[1] == byte(version.CommandCommandClassGet) {
+		return [][]byte{{byte(cc.Version), byte(version.CommandCommandClassReport), command[2], 1}}
+	}
+
+	if command[0] != byte(cc.Security2) {
+		return nil
+	}
+
+	switch cc.CommandID(command[1]) {
+	case security2.CommandNonceGet:
+		report, err := n.layer.NonceReport(1, true, false)
+		require.NoError(t, err)
+		data, _ := report.MarshalBinary()
+		return [][]byte{data}
+
+	case security2.CommandNonceReport:
+		require.NoError(t, n.layer.ReceiveNonceReport(1, command))
+		return n.flush(t)
+
+	case security2.CommandKexGet:
+		report := &security2.KexReport{SupportedKexSchemes: 0x02, SupportedEcdhProfiles: 0x01, RequestedKeys: []byte{n.requested}}
+		data, _ := report.MarshalBinary()
+		return [][]byte{data}
+
+	case security2.CommandKexSet:
+		n.granted = command[5]
+
+		report := &security2.PublicKeyReport{EcdhPublicKey: n.publicKey()}
+		data, _ := report.MarshalBinary()
+		return [][]byte{data}
+
+	case security2.CommandPublicKeyReport:
+		require.NoError(t, n.layer.SetTemporaryKey(1, n.privateKey, command[3:], false))
+
+		echo := &security2.KexSet{SelectedKexScheme: 0x02, SelectedEcdhProfile: 0x01, GrantedKeys: []byte{n.granted}}
+		echo.Properties1.Echo = true
+		data, _ := echo.MarshalBinary()
+		return n.secure(t, security.KeyS2Temporary, data)
+
+	case security2.CommandKexFail:
+		n.fails <- command[2]
+
+	case security.CommandS2MessageEncapsulation:
+		decrypted, err := n.layer.Decrypt(n.applicationCommand(command), n.keyClasses(), false)
+		if err == security.ErrS2Duplicate {
+			return nil
+		}
+		if err != nil {
+			report, err := n.layer.NonceReport(1, true, false)
+			require.NoError(t, err)
+			data, _ := report.MarshalBinary()
+			return [][]byte{data}
+		}
+
+		return n.receiveSecure(t, decrypted)
+	}
+
+	return nil
+}
+
+func (n *s2Node) receiveSecure(t *testing.T, decrypted *security.S2DecryptedMessage) [][]byte {
+	payload := decrypted.Payload
+
+	if payload[0] == byte(cc.Security2) {
+		switch cc.CommandID(payload[1]) {
+		case security2.CommandKexReport:
+			return n.requestNextKey(t)
+
+		case security2.CommandNetworkKeyReport:
+			keyClass, key := payload[2], payload[3:]
+			n.layer.SetNetworkKey(keyClass, key)
+			n.keys[keyClass] = append([]byte(nil), key...)
+
+			verify, _ := (&security2.NetworkKeyVerify{}).MarshalBinary()
+			return n.secure(t, keyClass, verify)
+
+		case security2.CommandTransferEnd:
+			return n.requestNextKey(t)
+
+		case security2.CommandKexFail:
+			n.fails <- payload[2]
+			return nil
+
+		case security2.CommandCommandsSupportedGet:
+			report, _ := (&security2.CommandsSupportedReport{CommandClass: []byte{byte(cc.SwitchBinary)}}).MarshalBinary()
+			return n.secure(t, decrypted.KeyClass, report)
+		}
+	}
+
+	if payload[0] == byte(cc.Version) && payload[1] == byte(version.CommandCommandClassGet) {
+		return n.secure(t, decrypted.KeyClass, []byte{byte(cc.Version), byte(version.CommandCommandClassReport), payload[2], 1})
+	}
+
+	n.messages <- payload
+	return nil
+}
+
+func TestClientIncludesS2Node(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	node := newS2Node(t, 0, security.KeyS2Unauthenticated|security.KeyS2Authenticated)
+	node.layer.SetHomeID(controller.HomeID)
+	controller.QueueInclusion(node.VirtualNode)
+
+	var dsk string
+	client.SetS2InclusionCallbacks(nil, func(c *Client, nodeID uint16, formatted string, pinRequired bool) (uint16, error) {
+		assert.True(t, pinRequired)
+		dsk = formatted
+		return node.pin(), nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	included, err := client.AddNode(ctx)
+	require.NoError(t, err)
+
+	// The PIN is left out of the DSK that is shown
+	expected := security.FormatDSK(node.dsk())
+	assert.Equal(t, "00000"+expected[5:], dsk)
+
+	assert.Equal(t, security.KeyS2Unauthenticated|security.KeyS2Authenticated, included.GrantedKeys)
+	assert.True(t, included.CommandClasses.IsSecure(cc.SwitchBinary))
+	for _, keyClass := range security.S2KeyClasses[:2] {
+		assert.Equal(t, client.S2NetworkKey(keyClass), node.keys[keyClass])
+	}
+
+	// Commands are encrypted with the highest granted key
+	_, err = included.SendCommand(context.Background(), &switchbinary.Set{SwitchValue: 0xFF}, protocol.DefaultTransmitOptions)
+	require.NoError(t, err)
+
+	select {
+	case payload := <-node.messages:
+		assert.Equal(t, []byte{byte(cc.SwitchBinary), byte(switchbinary.CommandSet), 0xFF}, payload)
+	case <-time.After(time.Second):
+		assert.Fail(t, "command not received")
+	}
+
+	// and commands from the node are decrypted
+	commands := make(chan cc.Command, 10)
+	client.SetEventCallback(func(c *Client, nodeID uint16, command cc.Command) {
+		commands <- command
+	})
+
+	msg, err := node.layer.Encapsulate(node.NodeID, 1, security.KeyS2Authenticated, []byte{byte(cc.SwitchBinary), byte(switchbinary.CommandReport), 0xFF}, 0)
+	require.NoError(t, err)
+	data, _ := msg.MarshalBinary()
+	node.Emit(data)
+
+	select {
+	case command := <-commands:
+		assert.Equal(t, &switchbinary.Report{Value: 0xFF}, command)
+	case <-time.After(time.Second):
+		assert.Fail(t, "command not decrypted")
+	}
+
+	// The granted keys are stored with the node
+	stored, err := NewNode(client, included.NodeID)
+	require.NoError(t, err)
+	assert.Equal(t, included.GrantedKeys, stored.GrantedKeys)
+}
+
+func TestClientS2InclusionWithWrongPIN(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	node := newS2Node(t, 0, security.KeyS2Authenticated)
+	node.layer.SetHomeID(controller.HomeID)
+	controller.QueueInclusion(node.VirtualNode)
+
+	client.SetS2InclusionCallbacks(nil, func(c *Client, nodeID uint16, dsk string, pinRequired bool) (uint16, error) {
+		return node.pin() + 1, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.AddNode(ctx)
+	assert.Error(t, err)
+
+	select {
+	case failType := <-node.fails:
+		assert.EqualValues(t, kexFailDecrypt, failType)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no KEX fail")
+	}
+}
+
+func TestClientS2InclusionGrantsChosenKeys(t *testing.T) {
+	controller := emulator.NewController()
+	client := newTestClient(t, controller)
+
+	node := newS2Node(t, 0, security.KeyS2Unauthenticated|security.KeyS2Access)
+	node.layer.SetHomeID(controller.HomeID)
+	controller.QueueInclusion(node.VirtualNode)
+
+	client.SetS2InclusionCallbacks(func(c *Client, nodeID uint16, requested byte) (byte, error) {
+		assert.Equal(t, security.KeyS2Unauthenticated|security.KeyS2Access, requested)
+		return security.KeyS2Unauthenticated | security.KeyS2Authenticated, nil
+	}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Keys that weren't requested aren't granted, and no PIN is needed for
+	// the unauthenticated key
+	included, err := client.AddNode(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, security.KeyS2Unauthenticated, included.GrantedKeys)
+	assert.Equal(t, client.S2NetworkKey(security.KeyS2Unauthenticated), node.keys[security.KeyS2Unauthenticated])
+
+	// Canceling the inclusion fails it
+	node = newS2Node(t, 0, security.KeyS2Unauthenticated)
+	node.layer.SetHomeID(controller.HomeID)
+	controller.QueueInclusion(node.VirtualNode)
+
+	client.SetS2InclusionCallbacks(func(c *Client, nodeID uint16, requested byte) (byte, error) {
+		return 0, errors.New("canceled")
+	}, nil)
+
+	_, err = client.AddNode(ctx)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "canceled"))
+	assert.EqualValues(t, kexFailCancel, <-node.fails)
+}
+
+func TestClientMulticastS2(t *testing.T) {
+	controller := emulator.NewController()
+
+	nodes := []*s2Node{
+		newS2Node(t, 2, security.KeyS2Authenticated),
+		newS2Node(t, 3, security.KeyS2Authenticated),
+	}
+	for _, node := range nodes {
+		controller.AddNode(node.VirtualNode)
+	}
+
+	client := newTestClient(t, controller)
+
+	for _, node := range nodes {
+		node.granted = security.KeyS2Authenticated
+		node.layer.SetHomeID(controller.HomeID)
+		node.layer.SetNetworkKey(security.KeyS2Authenticated, client.S2NetworkKey(security.KeyS2Authenticated))
+
+		client.nodes[node.NodeID].GrantedKeys = security.KeyS2Authenticated
+		client.nodes[node.NodeID].CommandClasses.SetSecure(cc.SwitchBinary, true)
+	}
+
+	set := []byte{byte(cc.SwitchBinary), byte(switchbinary.CommandSet), 0xFF}
+
+	// The nodes learn the group's MPAN from the first follow-ups
+	results := client.Multicast(context.Background(), []uint16{2, 3}, &switchbinary.Set{SwitchValue: 0xFF})
+	for _, node := range nodes {
+		assert.True(t, results[node.NodeID].Secure)
+		assert.NoError(t, results[node.NodeID].Err)
+		assert.Equal(t, set, <-node.messages)
+	}
+
+	// after which they decrypt the multicast as well as the follow-up
+	results = client.Multicast(context.Background(), []uint16{2, 3}, &switchbinary.Set{SwitchValue: 0xFF})
+	for _, node := range nodes {
+		assert.NoError(t, results[node.NodeID].Err)
+		assert.Equal(t, set, <-node.messages)
+		assert.Equal(t, set, <-node.messages)
+	}
+}
@@ -0,0 +1,264 @@
+package gozw
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/cc/association"
+	zwsec "github.com/gozwave/gozw/cc/security"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/gozwave/gozw/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// secureNode plays an S0 node's side of secure messaging: it hands out nonces,
+// and decrypts and reassembles the messages it receives.
+type secureNode struct {
+	*emulator.VirtualNode
+
+	layer    *security.Layer
+	headers  chan byte
+	messages chan []byte
+	nonces   chan []byte
+}
+
+func newSecureNode(t *testing.T, nodeID uint16) *secureNode {
+	node := &secureNode{
+		VirtualNode: emulator.NewVirtualNode(nodeID, 0x10, 0x01, byte(cc.Security), byte(cc.Association)),
+		layer:       security.NewLayer(testNetworkKey, zap.NewNop()),
+		headers:     make(chan byte, 10),
+		messages:    make(chan []byte, 10),
+		nonces:      make(chan []byte, 10),
+	}
+
+	nonceReport := func() []byte {
+		nonce, err := node.layer.GenerateInternalNonce()
+		require.NoError(t, err)
+		return append([]byte{byte(cc.Security), byte(zwsec.CommandNonceReport)}, nonce...)
+	}
+
+	node.OnCommand = func(command []byte) [][]byte {
+		if command[0] != byte(cc.Security) {
+			return nil
+		}
+
+		switch cc.CommandID(command[1]) {
+		case zwsec.CommandNonceGet:
+			return [][]byte{nonceReport()}
+
+		case zwsec.CommandNonceReport:
+			node.nonces <- command[2:10]
+
+		case zwsec.CommandMessageEncapsulation, zwsec.CommandMessageEncapsulationNonceGet:
+			decrypted, err := node.layer.DecryptMessage(serialapi.ApplicationCommand{SrcNodeID: 1, DstNodeID: nodeID, CommandData: command}, false)
+			require.NoError(t, err)
+			node.headers <- decrypted[0]
+
+			payload, err := node.layer.ReassembleMessage(1, decrypted)
+			require.NoError(t, err)
+			if payload != nil {
+				node.messages <- payload
+			}
+
+			if cc.CommandID(command[1]) == zwsec.CommandMessageEncapsulationNonceGet {
+				return [][]byte{nonceReport()}
+			}
+		}
+
+		return nil
+	}
+
+	return node
+}
+
+// encapsulate encrypts a frame to the controller with a nonce from it.
+func (n *secureNode) encapsulate(t *testing.T, commandID cc.CommandID, header byte, payload []byte) []byte {
+	n.Emit([]byte{byte(cc.Security), byte(zwsec.CommandNonceGet)})
+
+	var nonce []byte
+	select {
+	case nonce = <-n.nonces:
+	case <-time.After(time.Second):
+		require.FailNow(t, "no nonce report")
+	}
+
+	msg, err := n.layer.EncapsulateMessage(n.NodeID, 1, commandID, security.GenerateNonce(), nonce, append([]byte{header}, payload...), false)
+	require.NoError(t, err)
+
+	encapsulated, _ := msg.MarshalBinary()
+	return encapsulated
+}
+
+func TestClientSendsSequencedSecureMessages(t *testing.T) {
+	controller := emulator.NewController()
+	node := newSecureNode(t, 2)
+	controller.AddNode(node.VirtualNode)
+
+	client := newTestClient(t, controller)
+	client.nodes[2].NetworkKeySent = true
+
+	// Too large for a single frame with the default (explore) transmit options
+	payload := []byte{byte(cc.Association), byte(association.CommandSet), 1}
+	for nodeID := byte(10); len(payload) < SecurePayloadMaxSizeExplore+10; nodeID++ {
+		payload = append(payload, nodeID)
+	}
+
+	_, err := client.SendDataSecure(context.Background(), 2, util.ByteMarshaler(payload), protocol.DefaultTransmitOptions)
+	require.NoError(t, err)
+
+	first, second := <-node.headers, <-node.headers
+	assert.EqualValues(t, security.SecuritySequenceSequencedFlag, first&^security.SecuritySequenceCounterMask)
+	assert.EqualValues(t, security.SecuritySequenceSequencedFlag|security.SecuritySequenceSecondFrameFlag, second&^security.SecuritySequenceCounterMask)
+	assert.Equal(t, first&security.SecuritySequenceCounterMask, second&security.SecuritySequenceCounterMask)
+
+	select {
+	case message := <-node.messages:
+		assert.Equal(t, payload, message)
+	case <-time.After(time.Second):
+		assert.Fail(t, "message not reassembled")
+	}
+
+	// Small messages aren't sequenced
+	_, err = client.SendDataSecure(context.Background(), 2, util.ByteMarshaler(payload[:4]), protocol.DefaultTransmitOptions)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, <-node.headers)
+
+	_, err = client.SendDataSecure(context.Background(), 2, util.ByteMarshaler(make([]byte, 2*SecurePayloadMaxSizeExplore+1)), protocol.DefaultTransmitOptions)
+	assert.Error(t, err)
+}
+
+func TestClientReceivesSequencedSecureMessages(t *testing.T) {
+	controller := emulator.NewController()
+	node := newSecureNode(t, 2)
+	controller.AddNode(node.VirtualNode)
+
+	client := newTestClient(t, controller)
+	client.nodes[2].NetworkKeySent = true
+
+	commands := make(chan cc.Command, 10)
+	client.SetEventCallback(func(c *Client, nodeID uint16, command cc.Command) {
+		commands <- command
+	})
+
+	report := &association.Report{
+		GroupingIdentifier: 1,
+		MaxNodesSupported:  40,
+		Nodeid:             []byte{10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39},
+	}
+	payload, err := report.MarshalBinary()
+	require.NoError(t, err)
+
+	node.Emit(node.encapsulate(t, zwsec.CommandMessageEncapsulation, 0x13, payload[:20]))
+	node.Emit(node.encapsulate(t, zwsec.CommandMessageEncapsulation, 0x33, payload[20:]))
+
+	select {
+	case command := <-commands:
+		assert.Equal(t, report, command)
+	case <-time.After(time.Second):
+		assert.Fail(t, "message not reassembled")
+	}
+}
+
+func TestClientAnswersMessageEncapsulationNonceGet(t *testing.T) {
+	controller := emulator.NewController()
+	node := newSecureNode(t, 2)
+	controller.AddNode(node.VirtualNode)
+
+	client := newTestClient(t, controller)
+	client.nodes[2].NetworkKeySent = true
+
+	commands := make(chan cc.Command, 10)
+	client.SetEventCallback(func(c *Client, nodeID uint16, command cc.Command) {
+		commands <- command
+	})
+
+	first := &association.Report{GroupingIdentifier: 1, MaxNodesSupported: 5, Nodeid: []byte{1}}
+	second := &association.Report{GroupingIdentifier: 2, MaxNodesSupported: 5, Nodeid: []byte{3}}
+
+	// A node streaming messages asks for the next nonce with each one
+	payload, _ := first.MarshalBinary()
+	node.Emit(node.encapsulate(t, zwsec.CommandMessageEncapsulationNonceGet, 0, payload))
+
+	var nonce []byte
+	select {
+	case nonce = <-node.nonces:
+	case <-time.After(time.Second):
+		require.FailNow(t, "no nonce report")
+	}
+
+	payload, _ = second.MarshalBinary()
+	msg, err := node.layer.EncapsulateMessage(2, 1, zwsec.CommandMessageEncapsulation, security.GenerateNonce(), nonce, append([]byte{0}, payload...), false)
+	require.NoError(t, err)
+	encapsulated, _ := msg.MarshalBinary()
+	node.Emit(encapsulated)
+
+	for _, expected := range []cc.Command{first, second} {
+		select {
+		case command := <-commands:
+			assert.Equal(t, expected, command)
+		case <-time.After(time.Second):
+			assert.Fail(t, "message not received")
+		}
+	}
+}
+
+func TestClientSerializesSecureSendsToNode(t *testing.T) {
+	controller := emulator.NewController()
+	node := newSecureNode(t, 2)
+	controller.AddNode(node.VirtualNode)
+
+	client := newTestClient(t, controller)
+	client.nodes[2].NetworkKeySent = true
+
+	const sends = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, sends)
+	for i := 0; i < sends; i++ {
+		wg.Add(1)
+		go func(groupID byte) {
+			defer wg.Done()
+
+			set := util.ByteMarshaler([]byte{byte(cc.Association), byte(association.CommandSet), groupID, 1})
+			_, err := client.SendDataSecure(context.Background(), 2, set, protocol.DefaultTransmitOptions)
+			errs <- err
+		}(byte(i + 1))
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// Each send used the nonce it asked for before the next one was requested
+	var sequence []cc.CommandID
+	for _, command := range node.Received() {
+		sequence = append(sequence, cc.CommandID(command[1]))
+	}
+
+	require.Len(t, sequence, 2*sends)
+	for i := 0; i < sends; i++ {
+		assert.Equal(t, []cc.CommandID{zwsec.CommandNonceGet, zwsec.CommandMessageEncapsulation}, sequence[2*i:2*i+2])
+	}
+
+	groups := map[byte]bool{}
+	for i := 0; i < sends; i++ {
+		select {
+		case message := <-node.messages:
+			groups[message[2]] = true
+		case <-time.After(time.Second):
+			require.FailNow(t, "message not received")
+		}
+	}
+	assert.Len(t, groups, sends)
+}
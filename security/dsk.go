@@ -0,0 +1,22 @@
+package security
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// DSKSize is the size of a device specific key: the first 16 bytes of a node's
+// S2 public key.
+const DSKSize = 16
+
+// FormatDSK formats a DSK the way it is printed on device labels: eight
+// groups of five decimal digits, the first of which is the node's PIN.
+func FormatDSK(dsk []byte) string {
+	groups := make([]string, 0, DSKSize/2)
+	for i := 0; i+1 < len(dsk) && i < DSKSize; i += 2 {
+		groups = append(groups, fmt.Sprintf("%05d", binary.BigEndian.Uint16(dsk[i:])))
+	}
+
+	return strings.Join(groups, "-")
+}
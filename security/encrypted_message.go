@@ -8,6 +8,11 @@ import (
 )
 
 type EncryptedMessage struct {
+	// Command is security.CommandMessageEncapsulation, or
+	// security.CommandMessageEncapsulationNonceGet to ask the receiver for
+	// another nonce (e.g. for the second frame of a sequenced message)
+	Command cc.CommandID
+
 	SenderNonce      []byte
 	EncryptedPayload []byte
 	ReceiverNonceID  byte
@@ -19,10 +24,18 @@ func (cmd EncryptedMessage) CommandClassID() cc.CommandClassID {
 }
 
 func (cmd EncryptedMessage) CommandID() cc.CommandID {
-	return security.CommandMessageEncapsulation
+	if cmd.Command == 0 {
+		return security.CommandMessageEncapsulation
+	}
+
+	return cmd.Command
 }
 
 func (cmd EncryptedMessage) CommandIDString() string {
+	if cmd.CommandID() == security.CommandMessageEncapsulationNonceGet {
+		return "SECURITY_MESSAGE_ENCAPSULATION_NONCE_GET"
+	}
+
 	return "SECURITY_MESSAGE_ENCAPSULATION"
 }
 
@@ -36,6 +49,7 @@ func (cmd *EncryptedMessage) UnmarshalBinary(data []byte) error {
 	payload := make([]byte, len(data))
 	copy(payload, data)
 
+	cmd.Command = cc.CommandID(payload[1])
 	cmd.SenderNonce = payload[2:10]
 	cmd.EncryptedPayload = payload[10 : len(payload)-9]
 	cmd.ReceiverNonceID = payload[10+len(cmd.EncryptedPayload)]
@@ -1,6 +1,7 @@
 package security
 
 import (
+	"crypto/hmac"
 	"errors"
 	"fmt"
 	"sync"
@@ -20,11 +21,14 @@ const (
 
 type ILayer interface {
 	DecryptMessage(cmd serialapi.ApplicationCommand, inclusionMode bool) ([]byte, error)
-	EncapsulateMessage(srcNode byte, dstNode byte, commandID cc.CommandID, senderNonce []byte, receiverNonce []byte, payload []byte, inclusionMode bool) (*EncryptedMessage, error)
+	EncapsulateMessage(srcNode uint16, dstNode uint16, commandID cc.CommandID, senderNonce []byte, receiverNonce []byte, payload []byte, inclusionMode bool) (*EncryptedMessage, error)
+	NextSequenceCounter(nodeID uint16) byte
+	ReassembleMessage(nodeID uint16, decrypted []byte) ([]byte, error)
 	GenerateInternalNonce() (Nonce, error)
-	GetExternalNonce(key byte) (Nonce, error)
-	ReceiveNonce(fromNode byte, report security.NonceReport)
-	WaitForExternalNonce(nodeID byte) (Nonce, error)
+	GetExternalNonce(nodeID uint16) (Nonce, error)
+	ReceiveNonce(fromNode uint16, report security.NonceReport)
+	WaitForExternalNonce(nodeID uint16) (Nonce, error)
+	SetNetworkKey(networkKey []byte)
 }
 
 type Layer struct {
@@ -32,6 +36,7 @@ type Layer struct {
 
 	networkEncKey  []byte
 	networkAuthKey []byte
+	keyLock        sync.RWMutex
 
 	// internal nonce table is keyed by the first byte of the nonce
 	internalNonceTable *NonceTable
@@ -40,9 +45,15 @@ type Layer struct {
 	externalNonceTable *NonceTable
 
 	// maps node id to channel
-	waitForNonce map[byte]chan bool
+	waitForNonce map[uint16]chan bool
 	waitMapLock  *sync.Mutex
 
+	sequenceCounter *SequenceCounter
+
+	// maps node id to the first frame of a sequenced message
+	sequences    map[uint16]*sequencedMessage
+	sequenceLock sync.Mutex
+
 	l *zap.Logger
 }
 
@@ -57,9 +68,12 @@ func NewLayer(networkKey []byte, logger *zap.Logger) *Layer {
 		internalNonceTable: NewNonceTable(),
 		externalNonceTable: NewNonceTable(),
 
-		waitForNonce: map[byte]chan bool{},
+		waitForNonce: map[uint16]chan bool{},
 		waitMapLock:  &sync.Mutex{},
 
+		sequenceCounter: NewSequenceCounter(),
+		sequences:       map[uint16]*sequencedMessage{},
+
 		l: logger,
 	}
 
@@ -67,8 +81,8 @@ func NewLayer(networkKey []byte, logger *zap.Logger) *Layer {
 }
 
 func (s *Layer) EncapsulateMessage(
-	srcNode byte,
-	dstNode byte,
+	srcNode uint16,
+	dstNode uint16,
 	commandID cc.CommandID,
 	senderNonce []byte,
 	receiverNonce []byte,
@@ -76,6 +90,11 @@ func (s *Layer) EncapsulateMessage(
 	inclusionMode bool,
 ) (*EncryptedMessage, error) {
 
+	// S0 only has room for 8-bit node IDs; Long Range nodes use S2
+	if srcNode > 0xFF || dstNode > 0xFF {
+		return nil, fmt.Errorf("S0 doesn't support node IDs above 255 (%d -> %d)", srcNode, dstNode)
+	}
+
 	var encKey, authKey []byte
 	if inclusionMode {
 		s.l.Debug("encrypting message using inclusion encryption")
@@ -83,23 +102,23 @@ func (s *Layer) EncapsulateMessage(
 		authKey = inclusionAuthKey
 	} else {
 		s.l.Debug("encrypting message using network encryption")
-		encKey = s.networkEncKey
-		authKey = s.networkAuthKey
+		encKey, authKey = s.networkKeys()
 	}
 
 	iv := append(senderNonce, receiverNonce...)
 
 	encryptedPayload := CryptMessage(payload, iv, encKey)
 
-	authDataBuf := append(iv, byte(security.CommandMessageEncapsulation))
-	authDataBuf = append(authDataBuf, srcNode) // sender node
-	authDataBuf = append(authDataBuf, dstNode) // receiver node
+	authDataBuf := append(iv, byte(commandID))
+	authDataBuf = append(authDataBuf, byte(srcNode)) // sender node
+	authDataBuf = append(authDataBuf, byte(dstNode)) // receiver node
 	authDataBuf = append(authDataBuf, byte(len(encryptedPayload)))
 	authDataBuf = append(authDataBuf, encryptedPayload...)
 
 	hmac := CalculateHMAC(authDataBuf, authKey)
 
 	return &EncryptedMessage{
+		Command:          commandID,
 		SenderNonce:      senderNonce,
 		EncryptedPayload: encryptedPayload,
 		ReceiverNonceID:  receiverNonce[0],
@@ -107,17 +126,34 @@ func (s *Layer) EncapsulateMessage(
 	}, nil
 }
 
-// @todo verify message hmac
+var (
+	// ErrUnknownNonce is returned for encapsulated messages whose receiver
+	// nonce was never issued, has expired or has already been used.
+	ErrUnknownNonce = errors.New("unknown, expired or used receiver nonce")
+
+	// ErrInvalidMAC is returned for encapsulated messages whose message
+	// authentication code doesn't match, i.e. that have been forged or
+	// tampered with, or that were encrypted with another key.
+	ErrInvalidMAC = errors.New("invalid message authentication code")
+)
+
+// DecryptMessage verifies and decrypts a Message Encapsulation sent by
+// cmd.SrcNodeID to cmd.DstNodeID. The receiver nonce it was encrypted with is
+// used up, so the message can't be replayed; it is kept if the message is
+// rejected, so that forged messages can't use up nonces either.
 func (s *Layer) DecryptMessage(cmd serialapi.ApplicationCommand, inclusionMode bool) ([]byte, error) {
-	var encKey /*, authKey*/ []byte
+	var encKey, authKey []byte
 	if inclusionMode {
 		s.l.Debug("decrypting message using inclusion encryption")
 		encKey = inclusionEncKey
-		// authKey = inclusionAuthKey
+		authKey = inclusionAuthKey
 	} else {
 		s.l.Debug("decrypting message using network encryption")
-		encKey = s.networkEncKey
-		// authKey = s.networkAuthKey
+		encKey, authKey = s.networkKeys()
+	}
+
+	if cmd.SrcNodeID > 0xFF || cmd.DstNodeID > 0xFF {
+		return nil, fmt.Errorf("S0 doesn't support node IDs above 255 (%d -> %d)", cmd.SrcNodeID, cmd.DstNodeID)
 	}
 
 	message := EncryptedMessage{}
@@ -126,18 +162,50 @@ func (s *Layer) DecryptMessage(cmd serialapi.ApplicationCommand, inclusionMode b
 		return nil, err
 	}
 
-	receiverNonce, err := s.internalNonceTable.Get(message.ReceiverNonceID)
-	if err != nil {
+	senderNonce := make([]byte, 8)
+	copy(senderNonce, message.SenderNonce)
+
+	receiverNonce, err := s.internalNonceTable.Use(uint16(message.ReceiverNonceID), func(receiverNonce Nonce) error {
+		// the same auth data EncapsulateMessage authenticates
+		authData := append(append([]byte(nil), senderNonce...), receiverNonce...)
+		authData = append(authData, cmd.CommandData[1], byte(cmd.SrcNodeID), byte(cmd.DstNodeID), byte(len(message.EncryptedPayload)))
+		authData = append(authData, message.EncryptedPayload...)
+
+		if !hmac.Equal(CalculateHMAC(authData, authKey), message.HMAC) {
+			return ErrInvalidMAC
+		}
+
+		return nil
+	})
+	if err == ErrInvalidMAC {
 		return nil, err
+	} else if err != nil {
+		return nil, ErrUnknownNonce
 	}
 
-	senderNonce := make([]byte, 8)
-	copy(senderNonce, message.SenderNonce)
 	iv := append(senderNonce, receiverNonce...)
 
 	return CryptMessage(message.EncryptedPayload, iv, encKey), nil
 }
 
+// SetNetworkKey replaces the network key, such as when the controller has
+// joined another network and received its key.
+func (s *Layer) SetNetworkKey(networkKey []byte) {
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+
+	s.networkKey = networkKey
+	s.networkEncKey = EncryptEBS(networkKey, encryptPassword)
+	s.networkAuthKey = EncryptEBS(networkKey, authPassword)
+}
+
+func (s *Layer) networkKeys() (encKey, authKey []byte) {
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
+
+	return s.networkEncKey, s.networkAuthKey
+}
+
 // GenerateInternalNonce returns a new internal nonce and stores it in the
 // internal nonce table.
 //
@@ -148,15 +216,15 @@ func (s *Layer) GenerateInternalNonce() (Nonce, error) {
 	return s.internalNonceTable.Generate(internalNonceTTL)
 }
 
-func (s *Layer) GetExternalNonce(key byte) (Nonce, error) {
-	return s.externalNonceTable.Get(key)
+func (s *Layer) GetExternalNonce(nodeID uint16) (Nonce, error) {
+	return s.externalNonceTable.Get(nodeID)
 }
 
 // ReceiveNonce stores the received nonce in the external nonce table. Additionally,
 // it sets a timeout on the nonce (after which the nonce will be deleted from the
 // nonce table) and notifies any goroutine that may be waiting for a nonce from
 // the given node
-func (s *Layer) ReceiveNonce(fromNode byte, report security.NonceReport) {
+func (s *Layer) ReceiveNonce(fromNode uint16, report security.NonceReport) {
 	s.externalNonceTable.Set(fromNode, report.NonceByte, externalNonceTTL)
 
 	// if there is no matching channel in the waitForNonce map, then apparently we
@@ -195,7 +263,7 @@ func (s *Layer) ReceiveNonce(fromNode byte, report security.NonceReport) {
 	}
 }
 
-func (s *Layer) WaitForExternalNonce(nodeID byte) (Nonce, error) {
+func (s *Layer) WaitForExternalNonce(nodeID uint16) (Nonce, error) {
 	var waitChan chan bool
 	var ok bool
 
@@ -207,6 +275,11 @@ func (s *Layer) WaitForExternalNonce(nodeID byte) (Nonce, error) {
 	}
 	s.waitMapLock.Unlock()
 
+	// the nonce may have arrived before we started waiting
+	if nonce, err := s.externalNonceTable.Get(nodeID); err == nil {
+		return nonce, nil
+	}
+
 	select {
 	case <-waitChan:
 	case <-time.After(nonceRequestTimeout):
@@ -9,43 +9,67 @@ import (
 
 type Nonce []byte
 
+// NonceTable holds nonces until they are used or expire. It is keyed by node
+// ID for external nonces, and by the nonce's first byte (its ID) for internal
+// ones.
 type NonceTable struct {
-	nonceList map[byte]Nonce
+	nonceList map[uint16]Nonce
 	lock      *sync.Mutex
-	timers    map[byte]*time.Timer
+	timers    map[uint16]*time.Timer
 }
 
 func NewNonceTable() *NonceTable {
 	return &NonceTable{
-		nonceList: map[byte]Nonce{},
+		nonceList: map[uint16]Nonce{},
 		lock:      &sync.Mutex{},
-		timers:    map[byte]*time.Timer{},
+		timers:    map[uint16]*time.Timer{},
 	}
 }
 
-func (t *NonceTable) Set(key byte, nonce Nonce, timeout time.Duration) {
+func (t *NonceTable) Set(key uint16, nonce Nonce, timeout time.Duration) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
 	t.set(key, nonce, timeout)
 }
 
-func (t *NonceTable) Get(key byte) (Nonce, error) {
+func (t *NonceTable) Get(key uint16) (Nonce, error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
 	return t.get(key)
 }
 
+// Use passes the nonce stored under key to check, and deletes it if check
+// accepts it, so that it can only ever be used once. The nonce is kept if check
+// returns an error, which is returned as is.
+func (t *NonceTable) Use(key uint16, check func(Nonce) error) (Nonce, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	nonce, err := t.peek(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = check(nonce); err != nil {
+		return nil, err
+	}
+
+	t.delete(key)
+
+	return nonce, nil
+}
+
 // @todo determine whether this is needed
-// func (t *NonceTable) Peek(key byte) (Nonce, error) {
+// func (t *NonceTable) Peek(key uint16) (Nonce, error) {
 // 	t.lock.Lock()
 // 	defer t.lock.Unlock()
 //
 // 	return t.peek(key)
 // }
 
-func (t *NonceTable) Delete(key byte) {
+func (t *NonceTable) Delete(key uint16) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -60,8 +84,8 @@ func (t *NonceTable) Generate(timeout time.Duration) (Nonce, error) {
 		nonce := GenerateNonce()
 
 		// !ok indicates the item was not in the map, so we're good to save it and break
-		if _, ok := t.nonceList[nonce[0]]; !ok {
-			t.set(nonce[0], nonce, timeout)
+		if _, ok := t.nonceList[uint16(nonce[0])]; !ok {
+			t.set(uint16(nonce[0]), nonce, timeout)
 			return nonce, nil
 		}
 
@@ -71,7 +95,7 @@ func (t *NonceTable) Generate(timeout time.Duration) (Nonce, error) {
 	}
 }
 
-func (t *NonceTable) set(key byte, nonce Nonce, timeout time.Duration) {
+func (t *NonceTable) set(key uint16, nonce Nonce, timeout time.Duration) {
 	// ensure the key does not exist in the map
 
 	t.nonceList[key] = nonce
@@ -85,7 +109,7 @@ func (t *NonceTable) set(key byte, nonce Nonce, timeout time.Duration) {
 	}
 }
 
-func (t *NonceTable) get(key byte) (Nonce, error) {
+func (t *NonceTable) get(key uint16) (Nonce, error) {
 	nonce, err := t.peek(key)
 	if err != nil {
 		return nil, err
@@ -96,7 +120,7 @@ func (t *NonceTable) get(key byte) (Nonce, error) {
 	return nonce, nil
 }
 
-func (t *NonceTable) peek(key byte) (Nonce, error) {
+func (t *NonceTable) peek(key uint16) (Nonce, error) {
 	if nonce, ok := t.nonceList[key]; ok {
 		return nonce, nil
 	}
@@ -104,7 +128,7 @@ func (t *NonceTable) peek(key byte) (Nonce, error) {
 	return nil, errors.New("key not found")
 }
 
-func (t *NonceTable) delete(key byte) {
+func (t *NonceTable) delete(key uint16) {
 	delete(t.nonceList, key)
 
 	if timer, ok := t.timers[key]; ok {
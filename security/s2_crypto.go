@@ -0,0 +1,311 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// The primitives S2 is built on (see SDS13783): AES-CMAC for key derivation,
+// AES-CCM for encapsulation and CTR_DRBG for nonce generation, all with
+// 128-bit AES keys.
+
+const (
+	// s2TagSize is the size of the CCM authentication tag (M)
+	s2TagSize = 8
+
+	// s2NonceSize is the size of the CCM nonce (15 - L, with L = 2)
+	s2NonceSize = 13
+
+	// s2EntropySize is the size of sender and receiver entropy inputs
+	s2EntropySize = 16
+)
+
+var (
+	constNetworkKey   = repeatByte(0x55, 15)
+	constTempExpand   = repeatByte(0x88, 15)
+	constEntropyInput = repeatByte(0x88, 15)
+	constTempExtract  = repeatByte(0x33, 16)
+	constNonce        = repeatByte(0x26, 16)
+)
+
+// ErrS2Authentication is returned for S2 messages that couldn't be
+// authenticated, e.g. because they were forged, tampered with, or encrypted
+// with another key or nonce.
+var ErrS2Authentication = errors.New("S2 message authentication failed")
+
+func repeatByte(b byte, n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = b
+	}
+
+	return buf
+}
+
+// aesCMAC calculates the AES-CMAC of a message as defined in RFC 4493.
+func aesCMAC(key, message []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	k1 = cmacSubkey(k1)
+	k2 := cmacSubkey(k1)
+
+	n := (len(message) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+
+	if n > 0 && len(message)%aes.BlockSize == 0 {
+		subtle.XORBytes(last, message[(n-1)*aes.BlockSize:], k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		copy(last, message[(n-1)*aes.BlockSize:])
+		last[len(message)-(n-1)*aes.BlockSize] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(mac, mac, message[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+
+	subtle.XORBytes(mac, mac, last)
+	block.Encrypt(mac, mac)
+
+	return mac
+}
+
+func cmacSubkey(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i := 0; i < len(in)-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[len(in)-1] = in[len(in)-1] << 1
+
+	if in[0]&0x80 != 0 {
+		out[len(in)-1] ^= 0x87
+	}
+
+	return out
+}
+
+// ccmEncrypt encrypts and authenticates plaintext with AES-CCM (RFC 3610)
+// with an 8-byte tag and a 2-byte length field, and returns the ciphertext
+// followed by the tag.
+func ccmEncrypt(key, nonce, plaintext, aad []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	tag := ccmMAC(block, nonce, plaintext, aad)
+
+	out := make([]byte, len(plaintext), len(plaintext)+s2TagSize)
+	ccmCTR(block, nonce, out, plaintext)
+
+	s0 := ccmCounterBlock(block, nonce, 0)
+	for i := 0; i < s2TagSize; i++ {
+		out = append(out, tag[i]^s0[i])
+	}
+
+	return out
+}
+
+// ccmDecrypt decrypts and verifies the output of ccmEncrypt.
+func ccmDecrypt(key, nonce, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < s2TagSize {
+		return nil, ErrS2Authentication
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	n := len(ciphertext) - s2TagSize
+	plaintext := make([]byte, n)
+	ccmCTR(block, nonce, plaintext, ciphertext[:n])
+
+	tag := ccmMAC(block, nonce, plaintext, aad)
+	s0 := ccmCounterBlock(block, nonce, 0)
+	subtle.XORBytes(tag, tag[:s2TagSize], s0)
+
+	if subtle.ConstantTimeCompare(tag[:s2TagSize], ciphertext[n:]) != 1 {
+		return nil, ErrS2Authentication
+	}
+
+	return plaintext, nil
+}
+
+func ccmMAC(block cipherBlock, nonce, plaintext, aad []byte) []byte {
+	b0 := make([]byte, aes.BlockSize)
+	b0[0] = byte((s2TagSize-2)/2)<<3 | 1
+	if len(aad) > 0 {
+		b0[0] |= 0x40
+	}
+	copy(b0[1:], nonce)
+	binary.BigEndian.PutUint16(b0[14:], uint16(len(plaintext)))
+
+	input := b0
+	if len(aad) > 0 {
+		input = append(input, byte(len(aad)>>8), byte(len(aad)))
+		input = append(input, aad...)
+		input = padPayloadToBlockSize(input)
+	}
+	input = padPayloadToBlockSize(append(input, plaintext...))
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < len(input); i += aes.BlockSize {
+		subtle.XORBytes(mac, mac, input[i:i+aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+
+	return mac
+}
+
+func ccmCTR(block cipherBlock, nonce, dst, src []byte) {
+	for i := 0; i < len(src); i += aes.BlockSize {
+		s := ccmCounterBlock(block, nonce, uint16(i/aes.BlockSize+1))
+		subtle.XORBytes(dst[i:], src[i:], s)
+	}
+}
+
+func ccmCounterBlock(block cipherBlock, nonce []byte, counter uint16) []byte {
+	a := make([]byte, aes.BlockSize)
+	a[0] = 1
+	copy(a[1:], nonce)
+	binary.BigEndian.PutUint16(a[14:], counter)
+	block.Encrypt(a, a)
+
+	return a
+}
+
+type cipherBlock interface {
+	Encrypt(dst, src []byte)
+}
+
+// ctrDRBG is the AES-128 CTR_DRBG of NIST SP 800-90A, without a derivation
+// function, that S2 generates SPAN nonces with.
+type ctrDRBG struct {
+	key []byte
+	v   []byte
+}
+
+// newCTRDRBG instantiates a CTR_DRBG with 32 bytes of entropy and a 32-byte
+// personalization string.
+func newCTRDRBG(entropy, personalization []byte) *ctrDRBG {
+	seed := make([]byte, 32)
+	subtle.XORBytes(seed, entropy, personalization)
+
+	d := &ctrDRBG{key: make([]byte, 16), v: make([]byte, 16)}
+	d.update(seed)
+
+	return d
+}
+
+func (d *ctrDRBG) update(data []byte) {
+	block, err := aes.NewCipher(d.key)
+	if err != nil {
+		panic(err)
+	}
+
+	temp := make([]byte, 32)
+	for i := 0; i < len(temp); i += aes.BlockSize {
+		incrementCounter(d.v)
+		block.Encrypt(temp[i:], d.v)
+	}
+
+	subtle.XORBytes(temp, temp, data)
+	d.key = temp[:16]
+	d.v = temp[16:]
+}
+
+// generate returns the next 16 bytes of output.
+func (d *ctrDRBG) generate() []byte {
+	block, err := aes.NewCipher(d.key)
+	if err != nil {
+		panic(err)
+	}
+
+	out := make([]byte, aes.BlockSize)
+	incrementCounter(d.v)
+	block.Encrypt(out, d.v)
+	d.update(make([]byte, 32))
+
+	return out
+}
+
+// nextNonce returns the next CCM nonce: the first 13 bytes of the next output.
+func (d *ctrDRBG) nextNonce() []byte {
+	return d.generate()[:s2NonceSize]
+}
+
+func incrementCounter(v []byte) {
+	for i := len(v) - 1; i >= 0; i-- {
+		v[i]++
+		if v[i] != 0 {
+			return
+		}
+	}
+}
+
+// s2Keys are the keys derived from a network key (or from the temporary key
+// exchanged during inclusion).
+type s2Keys struct {
+	ccm             []byte
+	personalization []byte
+	mpan            []byte
+}
+
+// expandNetworkKey derives the CCM key, nonce personalization string and MPAN
+// key from a permanent network key (CKDF-NetworkKeyExpand).
+func expandNetworkKey(networkKey []byte) *s2Keys {
+	t1 := aesCMAC(networkKey, append(append([]byte(nil), constNetworkKey...), 0x01))
+	t2 := aesCMAC(networkKey, concat(t1, constNetworkKey, []byte{0x02}))
+	t3 := aesCMAC(networkKey, concat(t2, constNetworkKey, []byte{0x03}))
+	t4 := aesCMAC(networkKey, concat(t3, constNetworkKey, []byte{0x04}))
+
+	return &s2Keys{ccm: t1, personalization: concat(t2, t3), mpan: t4}
+}
+
+// temporaryKeys derives the temporary keys used during inclusion from the
+// ECDH shared secret and the public keys of the joining node (A) and the
+// including node (B) (CKDF-TempExtract and CKDF-TempExpand).
+func temporaryKeys(sharedSecret, publicKeyA, publicKeyB []byte) *s2Keys {
+	prk := aesCMAC(constTempExtract, concat(sharedSecret, publicKeyA, publicKeyB))
+
+	t1 := aesCMAC(prk, append(append([]byte(nil), constTempExpand...), 0x01))
+	t2 := aesCMAC(prk, concat(t1, constTempExpand, []byte{0x02}))
+	t3 := aesCMAC(prk, concat(t2, constTempExpand, []byte{0x03}))
+
+	return &s2Keys{ccm: t1, personalization: concat(t2, t3)}
+}
+
+// mixEntropy derives the 32-byte mixed entropy input a SPAN is instantiated
+// with from the sender's and receiver's entropy inputs (CKDF-MEI-Extract and
+// CKDF-MEI-Expand).
+func mixEntropy(senderEI, receiverEI []byte) []byte {
+	prk := aesCMAC(constNonce, concat(senderEI, receiverEI))
+
+	t0 := append(append([]byte(nil), constEntropyInput...), 0x00)
+	t1 := aesCMAC(prk, concat(t0, constEntropyInput, []byte{0x01}))
+	t2 := aesCMAC(prk, concat(t1, constEntropyInput, []byte{0x02}))
+
+	return concat(t1, t2)
+}
+
+func concat(parts ...[]byte) []byte {
+	var buf []byte
+	for _, part := range parts {
+		buf = append(buf, part...)
+	}
+
+	return buf
+}
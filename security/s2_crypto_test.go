@@ -0,0 +1,223 @@
+package security
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func TestAESCMAC(t *testing.T) {
+	// RFC 4493 test vectors
+	key := unhex("2b7e151628aed2a6abf7158809cf4f3c")
+	message := unhex("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411")
+
+	assert.Equal(t, unhex("bb1d6929e95937287fa37d129b756746"), aesCMAC(key, nil))
+	assert.Equal(t, unhex("070a16b46b4d4144f79bdd9dd04a287c"), aesCMAC(key, message[:16]))
+	assert.Equal(t, unhex("dfa66747de9ae63030ca32611497c827"), aesCMAC(key, message[:40]))
+}
+
+func TestAESCCM(t *testing.T) {
+	// RFC 3610 packet vector #1
+	key := unhex("c0c1c2c3c4c5c6c7c8c9cacbcccdcecf")
+	nonce := unhex("00000003020100a0a1a2a3a4a5")
+	aad := unhex("0001020304050607")
+	plaintext := unhex("08090a0b0c0d0e0f101112131415161718191a1b1c1d1e")
+
+	ciphertext := ccmEncrypt(key, nonce, plaintext, aad)
+	assert.Equal(t, unhex("588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0"), ciphertext)
+
+	decrypted, err := ccmDecrypt(key, nonce, ciphertext, aad)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	ciphertext[0] ^= 1
+	_, err = ccmDecrypt(key, nonce, ciphertext, aad)
+	assert.Equal(t, ErrS2Authentication, err)
+}
+
+func TestFormatDSK(t *testing.T) {
+	dsk := unhex("d4a00102030405060708090a0b0c0d0e")
+	assert.Equal(t, "54432-00258-00772-01286-01800-02314-02828-03342", FormatDSK(dsk))
+}
+
+// newS2Pair returns the S2 layers of a controller (node 1) and a node (node
+// 2) that share a network key.
+func newS2Pair(keyClass byte) (*S2Layer, *S2Layer) {
+	controller, node := NewS2Layer(zap.NewNop()), NewS2Layer(zap.NewNop())
+	controller.SetHomeID(0xC0FFEE01)
+	node.SetHomeID(0xC0FFEE01)
+
+	key := GenerateNonce()
+	key = append(key, GenerateNonce()...)
+	controller.SetNetworkKey(keyClass, key)
+	node.SetNetworkKey(keyClass, key)
+
+	return controller, node
+}
+
+func establishSPAN(t *testing.T, sender, receiver *S2Layer, senderID, receiverID uint16) {
+	report, err := receiver.NonceReport(senderID, true, false)
+	require.NoError(t, err)
+
+	data, _ := report.MarshalBinary()
+	require.NoError(t, sender.ReceiveNonceReport(receiverID, data))
+}
+
+func s2Receive(receiver *S2Layer, msg *S2Message, src, dst uint16, keyClasses []byte) (*S2DecryptedMessage, error) {
+	data, _ := msg.MarshalBinary()
+	return receiver.Decrypt(serialapi.ApplicationCommand{SrcNodeID: src, DstNodeID: dst, CommandData: data}, keyClasses, false)
+}
+
+func TestS2SPAN(t *testing.T) {
+	controller, node := newS2Pair(KeyS2Authenticated)
+	granted := []byte{KeyS2Authenticated}
+
+	_, err := controller.Encapsulate(1, 2, KeyS2Authenticated, []byte{0x25, 0x01, 0xFF}, 0)
+	assert.Equal(t, ErrS2NoSPAN, err)
+
+	establishSPAN(t, controller, node, 1, 2)
+
+	msg, err := controller.Encapsulate(1, 2, KeyS2Authenticated, []byte{0x25, 0x01, 0xFF}, 0)
+	require.NoError(t, err)
+	assert.NotNil(t, msg.Extension(S2ExtensionSPAN))
+
+	decrypted, err := s2Receive(node, msg, 1, 2, granted)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x25, 0x01, 0xFF}, decrypted.Payload)
+	assert.Equal(t, KeyS2Authenticated, decrypted.KeyClass)
+
+	_, err = s2Receive(node, msg, 1, 2, granted)
+	assert.Equal(t, ErrS2Duplicate, err)
+
+	// The SPAN advances with messages in both directions
+	for i := 0; i < 3; i++ {
+		reply, err := node.Encapsulate(2, 1, KeyS2Authenticated, []byte{0x25, 0x03, byte(i)}, 0)
+		require.NoError(t, err)
+		assert.Nil(t, reply.Extension(S2ExtensionSPAN))
+
+		decrypted, err = s2Receive(controller, reply, 2, 1, granted)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x25, 0x03, byte(i)}, decrypted.Payload)
+	}
+
+	// Messages from nodes that weren't granted the key are rejected
+	msg, err = controller.Encapsulate(1, 2, KeyS2Authenticated, []byte{0x25, 0x01, 0x00}, 0)
+	require.NoError(t, err)
+	_, err = s2Receive(node, msg, 1, 2, []byte{KeyS2Unauthenticated})
+	assert.Equal(t, ErrS2NoSPAN, err)
+}
+
+func TestS2Resynchronization(t *testing.T) {
+	controller, node := newS2Pair(KeyS2Unauthenticated)
+	granted := []byte{KeyS2Unauthenticated}
+	establishSPAN(t, controller, node, 1, 2)
+
+	msg, err := controller.Encapsulate(1, 2, KeyS2Unauthenticated, []byte{0x20, 0x02}, 0)
+	require.NoError(t, err)
+	_, err = s2Receive(node, msg, 1, 2, granted)
+	require.NoError(t, err)
+
+	// A lost message leaves the SPANs out of sync
+	_, err = controller.Encapsulate(1, 2, KeyS2Unauthenticated, []byte{0x20, 0x02}, 0)
+	require.NoError(t, err)
+	msg, err = controller.Encapsulate(1, 2, KeyS2Unauthenticated, []byte{0x20, 0x02}, 0)
+	require.NoError(t, err)
+	_, err = s2Receive(node, msg, 1, 2, granted)
+	assert.Equal(t, ErrS2Authentication, err)
+
+	// which the receiver's Nonce Report fixes
+	establishSPAN(t, controller, node, 1, 2)
+	msg, err = controller.Encapsulate(1, 2, KeyS2Unauthenticated, []byte{0x20, 0x02}, 0)
+	require.NoError(t, err)
+	decrypted, err := s2Receive(node, msg, 1, 2, granted)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x20, 0x02}, decrypted.Payload)
+}
+
+func TestS2TemporaryKey(t *testing.T) {
+	controller, node := NewS2Layer(zap.NewNop()), NewS2Layer(zap.NewNop())
+
+	controllerKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	nodeKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	require.NoError(t, controller.SetTemporaryKey(2, controllerKey, nodeKey.PublicKey().Bytes(), true))
+	require.NoError(t, node.SetTemporaryKey(1, nodeKey, controllerKey.PublicKey().Bytes(), false))
+
+	establishSPAN(t, node, controller, 2, 1)
+
+	msg, err := node.Encapsulate(2, 1, KeyS2Temporary, []byte{0x9F, 0x06, 0x01}, 0)
+	require.NoError(t, err)
+
+	decrypted, err := s2Receive(controller, msg, 2, 1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, KeyS2Temporary, decrypted.KeyClass)
+	assert.Equal(t, []byte{0x9F, 0x06, 0x01}, decrypted.Payload)
+}
+
+func TestS2Multicast(t *testing.T) {
+	controller, node := newS2Pair(KeyS2Authenticated)
+	granted := []byte{KeyS2Authenticated}
+	establishSPAN(t, controller, node, 1, 2)
+
+	groupID, err := controller.MulticastGroup([]uint16{3, 2}, KeyS2Authenticated)
+	require.NoError(t, err)
+
+	sameGroup, err := controller.MulticastGroup([]uint16{2, 3}, KeyS2Authenticated)
+	require.NoError(t, err)
+	assert.Equal(t, groupID, sameGroup)
+
+	multicast := func() (*S2DecryptedMessage, error) {
+		msg, err := controller.EncapsulateMulticast(1, groupID, []byte{0x25, 0x01, 0xFF})
+		require.NoError(t, err)
+
+		data, _ := msg.MarshalBinary()
+		return node.Decrypt(serialapi.ApplicationCommand{SrcNodeID: 1, DstNodeID: 2, CommandData: data}, granted, true)
+	}
+
+	// The node doesn't know the MPAN until the first follow-up
+	decrypted, err := multicast()
+	assert.Equal(t, ErrS2NoMPAN, err)
+	assert.True(t, decrypted.MPANMissing)
+
+	followUp, err := controller.Encapsulate(1, 2, KeyS2Authenticated, []byte{0x25, 0x01, 0xFF}, groupID)
+	require.NoError(t, err)
+	assert.True(t, followUp.EncryptedExtension)
+
+	decrypted, err = s2Receive(node, followUp, 1, 2, granted)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x25, 0x01, 0xFF}, decrypted.Payload)
+	assert.Equal(t, groupID, decrypted.GroupID)
+	assert.False(t, decrypted.MPANMissing)
+
+	decrypted, err = multicast()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x25, 0x01, 0xFF}, decrypted.Payload)
+
+	// Missed multicasts are skipped
+	_, err = controller.EncapsulateMulticast(1, groupID, []byte{0x25, 0x01, 0x00})
+	require.NoError(t, err)
+	_, err = multicast()
+	assert.NoError(t, err)
+
+	// Later follow-ups don't carry the MPAN
+	followUp, err = controller.Encapsulate(1, 2, KeyS2Authenticated, []byte{0x25, 0x01, 0xFF}, groupID)
+	require.NoError(t, err)
+	assert.False(t, followUp.EncryptedExtension)
+}
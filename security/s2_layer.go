@@ -0,0 +1,682 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	security2 "github.com/gozwave/gozw/cc/security-2"
+	"github.com/gozwave/gozw/serialapi"
+	"go.uber.org/zap"
+)
+
+// S2 key classes, as they are requested and granted in KEX Reports and KEX
+// Sets. KeyS0 is the S0 network key, which can be granted along with the S2
+// keys.
+const (
+	KeyS2Unauthenticated byte = 0x01
+	KeyS2Authenticated   byte = 0x02
+	KeyS2Access          byte = 0x04
+	KeyS0                byte = 0x80
+)
+
+// KeyS2Temporary isn't a key class: it stands for the temporary key that
+// messages are encrypted with while a node is being included.
+const KeyS2Temporary byte = 0x00
+
+// S2KeyClasses are the S2 key classes, from the least to the most privileged.
+var S2KeyClasses = []byte{KeyS2Unauthenticated, KeyS2Authenticated, KeyS2Access}
+
+// mpanLookahead is how many MPAN states past the expected one are tried when
+// decrypting a multicast message, in case earlier ones were missed.
+const mpanLookahead = 5
+
+var (
+	// ErrS2NoSPAN is returned when there is no nonce to encrypt or decrypt a
+	// message with. The node must be sent a Nonce Get (to send a message) or a
+	// Nonce Report (after receiving one).
+	ErrS2NoSPAN = errors.New("no S2 nonce established with the node")
+
+	// ErrS2Duplicate is returned for a message that was already received.
+	ErrS2Duplicate = errors.New("duplicate S2 message")
+
+	// ErrS2UnknownKey is returned when there is no key of the given class.
+	ErrS2UnknownKey = errors.New("unknown S2 key class")
+
+	// ErrS2NoMPAN is returned for multicast messages from a group whose MPAN
+	// isn't known.
+	ErrS2NoMPAN = errors.New("no MPAN for the multicast group")
+)
+
+// S2DecryptedMessage is a decrypted S2 message encapsulation.
+type S2DecryptedMessage struct {
+	Payload []byte
+
+	// KeyClass is the key class the message was encrypted with, or
+	// KeyS2Temporary.
+	KeyClass byte
+
+	// GroupID is the multicast group of a multicast message or of its
+	// singlecast follow-up, or 0.
+	GroupID byte
+
+	// MPANMissing is set if the group's MPAN isn't known, in which case the
+	// sender should be told with a Nonce Report with the MOS flag.
+	MPANMissing bool
+}
+
+// s2Span is the singlecast nonce state (SPAN) shared with a node.
+type s2Span struct {
+	// localEI is the entropy input sent in the last Nonce Report to the node,
+	// until the node uses it
+	localEI []byte
+
+	// peerEI is the entropy input received in the node's last Nonce Report,
+	// until it is used
+	peerEI []byte
+
+	keyClass byte
+	drbg     *ctrDRBG
+}
+
+// s2MPAN is the multicast nonce state (MPAN) of a multicast group.
+type s2MPAN struct {
+	keyClass byte
+	state    []byte
+}
+
+func (m *s2MPAN) nonce(key []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	nonce := make([]byte, aes.BlockSize)
+	block.Encrypt(nonce, m.state)
+	incrementCounter(m.state)
+
+	return nonce[:s2NonceSize]
+}
+
+// s2Group is a multicast group the controller sends to.
+type s2Group struct {
+	nodeIDs  []uint16
+	mpan     *s2MPAN
+	sequence byte
+
+	// synced holds the nodes known to have the group's MPAN
+	synced map[uint16]bool
+}
+
+type mpanKey struct {
+	nodeID  uint16
+	groupID byte
+}
+
+// S2Layer encrypts and decrypts S2 messages and manages their nonces. Each
+// node shares a SPAN with the controller, which is established by exchanging
+// entropy inputs in Nonce Reports and SPAN extensions and then advanced with
+// every message in either direction.
+type S2Layer struct {
+	lock sync.Mutex
+
+	homeID   uint32
+	keys     map[byte]*s2Keys
+	tempKeys map[uint16]*s2Keys
+
+	spans      map[uint16]*s2Span
+	txSequence map[uint16]byte
+	rxSequence map[uint16]byte
+
+	groups    map[byte]*s2Group
+	peerMPANs map[mpanKey]*s2MPAN
+
+	nonceWaiters map[uint16]chan struct{}
+
+	l *zap.Logger
+}
+
+func NewS2Layer(logger *zap.Logger) *S2Layer {
+	return &S2Layer{
+		keys:         map[byte]*s2Keys{},
+		tempKeys:     map[uint16]*s2Keys{},
+		spans:        map[uint16]*s2Span{},
+		txSequence:   map[uint16]byte{},
+		rxSequence:   map[uint16]byte{},
+		groups:       map[byte]*s2Group{},
+		peerMPANs:    map[mpanKey]*s2MPAN{},
+		nonceWaiters: map[uint16]chan struct{}{},
+		l:            logger,
+	}
+}
+
+// SetHomeID sets the home ID of the network, which every message is
+// authenticated with.
+func (s *S2Layer) SetHomeID(homeID uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.homeID = homeID
+}
+
+// SetNetworkKey sets the network key of an S2 key class. A nil key removes it.
+func (s *S2Layer) SetNetworkKey(keyClass byte, networkKey []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if networkKey == nil {
+		delete(s.keys, keyClass)
+		return
+	}
+
+	s.keys[keyClass] = expandNetworkKey(networkKey)
+}
+
+// SetTemporaryKey derives the temporary key shared with a node during
+// inclusion from our private key and the node's public key. Messages to the
+// node are encrypted with it when sent with KeyS2Temporary, and messages from
+// the node are decrypted with it until ClearTemporaryKey. including says
+// whether we are the including node.
+func (s *S2Layer) SetTemporaryKey(nodeID uint16, privateKey *ecdh.PrivateKey, peerPublicKey []byte, including bool) error {
+	peerKey, err := ecdh.X25519().NewPublicKey(peerPublicKey)
+	if err != nil {
+		return err
+	}
+
+	sharedSecret, err := privateKey.ECDH(peerKey)
+	if err != nil {
+		return err
+	}
+
+	publicKeyA, publicKeyB := peerPublicKey, privateKey.PublicKey().Bytes()
+	if !including {
+		publicKeyA, publicKeyB = publicKeyB, publicKeyA
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tempKeys[nodeID] = temporaryKeys(sharedSecret, publicKeyA, publicKeyB)
+	s.resetSpan(nodeID)
+
+	return nil
+}
+
+// ClearTemporaryKey stops using the temporary key with a node, once its
+// inclusion has ended.
+func (s *S2Layer) ClearTemporaryKey(nodeID uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.tempKeys, nodeID)
+	if span, ok := s.spans[nodeID]; ok && span.keyClass == KeyS2Temporary {
+		s.resetSpan(nodeID)
+	}
+}
+
+func (s *S2Layer) resetSpan(nodeID uint16) {
+	if span, ok := s.spans[nodeID]; ok {
+		span.drbg = nil
+		span.peerEI = nil
+	}
+}
+
+func (s *S2Layer) span(nodeID uint16) *s2Span {
+	span, ok := s.spans[nodeID]
+	if !ok {
+		span = &s2Span{}
+		s.spans[nodeID] = span
+	}
+
+	return span
+}
+
+// keysFor returns the keys of a key class, or the temporary keys shared with
+// a node.
+func (s *S2Layer) keysFor(nodeID uint16, keyClass byte) (*s2Keys, error) {
+	keys, ok := s.keys[keyClass]
+	if keyClass == KeyS2Temporary {
+		keys, ok = s.tempKeys[nodeID]
+	}
+
+	if !ok {
+		return nil, ErrS2UnknownKey
+	}
+
+	return keys, nil
+}
+
+func (s *S2Layer) nextSequence(nodeID uint16) byte {
+	seq, ok := s.txSequence[nodeID]
+	if !ok {
+		buf := GenerateNonce()
+		seq = buf[0]
+	}
+
+	s.txSequence[nodeID] = seq + 1
+
+	return seq
+}
+
+// NonceGet returns a Nonce Get to send to a node, to get the entropy input to
+// establish a SPAN with.
+func (s *S2Layer) NonceGet(nodeID uint16) *security2.NonceGet {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return &security2.NonceGet{SequenceNumber: s.nextSequence(nodeID)}
+}
+
+// NonceReport returns a Nonce Report to send to a node. With sos, it carries a
+// new entropy input, which the node establishes a new SPAN with (e.g. after it
+// sent a Nonce Get or a message that couldn't be decrypted); with mos, it
+// tells the node that a multicast group's MPAN is missing.
+func (s *S2Layer) NonceReport(nodeID uint16, sos, mos bool) (*security2.NonceReport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report := &security2.NonceReport{SequenceNumber: s.nextSequence(nodeID)}
+	report.Properties1.Sos = sos
+	report.Properties1.Mos = mos
+
+	if sos {
+		entropy := make([]byte, s2EntropySize)
+		if _, err := rand.Read(entropy); err != nil {
+			return nil, err
+		}
+
+		span := s.span(nodeID)
+		span.localEI = entropy
+		span.drbg = nil
+		report.ReceiversEntropyInput = entropy
+	}
+
+	return report, nil
+}
+
+// ReceiveNonceReport handles a Nonce Report from a node. A new entropy input
+// replaces the SPAN, and a missing MPAN is sent to the node with the next
+// singlecast follow-up to the group. Anything waiting for a nonce from the
+// node is notified.
+func (s *S2Layer) ReceiveNonceReport(nodeID uint16, data []byte) error {
+	report, err := parseS2NonceReport(data)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if report.Properties1.Sos {
+		span := s.span(nodeID)
+		span.peerEI = report.ReceiversEntropyInput
+		span.drbg = nil
+	}
+
+	if report.Properties1.Mos {
+		for _, group := range s.groups {
+			delete(group.synced, nodeID)
+		}
+	}
+
+	if ch, ok := s.nonceWaiters[nodeID]; ok {
+		close(ch)
+		delete(s.nonceWaiters, nodeID)
+	}
+
+	return nil
+}
+
+// CanEncapsulate returns whether a message can be encrypted for a node with
+// the given key class without getting a nonce from it first.
+func (s *S2Layer) CanEncapsulate(nodeID uint16, keyClass byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.canEncapsulate(nodeID, keyClass)
+}
+
+func (s *S2Layer) canEncapsulate(nodeID uint16, keyClass byte) bool {
+	if _, err := s.keysFor(nodeID, keyClass); err != nil {
+		return false
+	}
+
+	span, ok := s.spans[nodeID]
+	if !ok {
+		return false
+	}
+
+	return span.peerEI != nil || (span.drbg != nil && span.keyClass == keyClass)
+}
+
+// WaitForNonceReport blocks until a message can be encrypted for a node with
+// the given key class, such as after sending it a Nonce Get.
+func (s *S2Layer) WaitForNonceReport(nodeID uint16, keyClass byte) error {
+	s.lock.Lock()
+	if s.canEncapsulate(nodeID, keyClass) {
+		s.lock.Unlock()
+		return nil
+	}
+
+	ch, ok := s.nonceWaiters[nodeID]
+	if !ok {
+		ch = make(chan struct{})
+		s.nonceWaiters[nodeID] = ch
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-ch:
+	case <-time.After(nonceRequestTimeout):
+		s.l.Warn("S2 nonce timeout", zap.String("node", fmt.Sprint(nodeID)))
+		return errors.New("nonce timeout")
+	}
+
+	if !s.CanEncapsulate(nodeID, keyClass) {
+		return ErrS2NoSPAN
+	}
+
+	return nil
+}
+
+// authData returns the additional authenticated data of a message: the
+// source and destination, the home ID, the message length and its header.
+func (s *S2Layer) authData(srcNode, dstNode uint16, messageLength int, header []byte) []byte {
+	var aad []byte
+	if srcNode > 0xFF || dstNode > 0xFF {
+		aad = []byte{byte(srcNode >> 8), byte(srcNode), byte(dstNode >> 8), byte(dstNode)}
+	} else {
+		aad = []byte{byte(srcNode), byte(dstNode)}
+	}
+
+	buf := make([]byte, 6)
+	binary.BigEndian.PutUint32(buf, s.homeID)
+	binary.BigEndian.PutUint16(buf[4:], uint16(messageLength))
+
+	return concat(aad, buf, header)
+}
+
+// Encapsulate encrypts a command for a node with the key of the given class,
+// or with the temporary key shared with it (KeyS2Temporary). If there is
+// no SPAN with the node, it returns ErrS2NoSPAN and the node must be sent a
+// Nonce Get first. A non-zero groupID makes the message the singlecast
+// follow-up of a multicast to the group, which carries the group's MPAN if the
+// node doesn't have it yet.
+func (s *S2Layer) Encapsulate(srcNode, dstNode uint16, keyClass byte, payload []byte, groupID byte) (*S2Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys, err := s.keysFor(dstNode, keyClass)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &S2Message{}
+
+	span := s.span(dstNode)
+	switch {
+	case span.drbg != nil && span.keyClass == keyClass:
+	case span.peerEI != nil:
+		senderEI := make([]byte, s2EntropySize)
+		if _, err = rand.Read(senderEI); err != nil {
+			return nil, err
+		}
+
+		span.drbg = newCTRDRBG(mixEntropy(senderEI, span.peerEI), keys.personalization)
+		span.keyClass = keyClass
+		span.peerEI = nil
+
+		msg.Extensions = append(msg.Extensions, S2Extension{Type: S2ExtensionSPAN, Critical: true, Data: senderEI})
+	default:
+		return nil, ErrS2NoSPAN
+	}
+
+	plaintext := payload
+	if group, ok := s.groups[groupID]; ok {
+		msg.Extensions = append(msg.Extensions, S2Extension{Type: S2ExtensionMGRP, Critical: true, Data: []byte{groupID}})
+
+		if !group.synced[dstNode] {
+			mpan := marshalS2Extensions([]S2Extension{{
+				Type:     S2ExtensionMPAN,
+				Critical: true,
+				Data:     append([]byte{groupID}, group.mpan.state...),
+			}})
+
+			plaintext = concat(mpan, payload)
+			msg.EncryptedExtension = true
+			group.synced[dstNode] = true
+		}
+	}
+
+	msg.SequenceNumber = s.nextSequence(dstNode)
+
+	header := msg.header()
+	aad := s.authData(srcNode, dstNode, len(header)+len(plaintext)+s2TagSize, header[2:])
+	msg.Ciphertext = ccmEncrypt(keys.ccm, span.drbg.nextNonce(), plaintext, aad)
+
+	return msg, nil
+}
+
+// MulticastGroup returns the multicast group of the given nodes for messages
+// encrypted with the given key class, creating it (and its MPAN) if needed.
+func (s *S2Layer) MulticastGroup(nodeIDs []uint16, keyClass byte) (byte, error) {
+	nodeIDs = append([]uint16(nil), nodeIDs...)
+	sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i] < nodeIDs[j] })
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for groupID, group := range s.groups {
+		if group.mpan.keyClass == keyClass && fmt.Sprint(group.nodeIDs) == fmt.Sprint(nodeIDs) {
+			return groupID, nil
+		}
+	}
+
+	for groupID := 1; groupID <= 0xFF; groupID++ {
+		if _, ok := s.groups[byte(groupID)]; ok {
+			continue
+		}
+
+		state := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(state); err != nil {
+			return 0, err
+		}
+
+		s.groups[byte(groupID)] = &s2Group{
+			nodeIDs:  nodeIDs,
+			mpan:     &s2MPAN{keyClass: keyClass, state: state},
+			sequence: GenerateNonce()[0],
+			synced:   map[uint16]bool{},
+		}
+
+		return byte(groupID), nil
+	}
+
+	return 0, errors.New("no free multicast group")
+}
+
+// EncapsulateMulticast encrypts a command for a multicast group with the
+// group's MPAN.
+func (s *S2Layer) EncapsulateMulticast(srcNode uint16, groupID byte, payload []byte) (*S2Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	group, ok := s.groups[groupID]
+	if !ok {
+		return nil, errors.New("unknown multicast group")
+	}
+
+	keys, ok := s.keys[group.mpan.keyClass]
+	if !ok {
+		return nil, ErrS2UnknownKey
+	}
+
+	msg := &S2Message{
+		SequenceNumber: group.sequence,
+		Extensions:     []S2Extension{{Type: S2ExtensionMGRP, Critical: true, Data: []byte{groupID}}},
+	}
+	group.sequence++
+
+	header := msg.header()
+	aad := s.authData(srcNode, uint16(groupID), len(header)+len(payload)+s2TagSize, header[2:])
+	msg.Ciphertext = ccmEncrypt(keys.ccm, group.mpan.nonce(keys.mpan), payload, aad)
+
+	return msg, nil
+}
+
+// Decrypt verifies and decrypts an S2 Message Encapsulation from a node that
+// has been granted the given key classes. Singlecast messages are decrypted
+// with the SPAN (which a SPAN extension establishes), multicast messages with
+// the group's MPAN. If the SPAN is missing or out of sync, ErrS2NoSPAN or
+// ErrS2Authentication is returned and the node should be sent a Nonce Report
+// to resynchronize.
+func (s *S2Layer) Decrypt(cmd serialapi.ApplicationCommand, keyClasses []byte, multicast bool) (*S2DecryptedMessage, error) {
+	msg := &S2Message{}
+	if err := msg.UnmarshalBinary(cmd.CommandData); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if multicast {
+		return s.decryptMulticast(cmd.SrcNodeID, msg)
+	}
+
+	if seq, ok := s.rxSequence[cmd.SrcNodeID]; ok && seq == msg.SequenceNumber {
+		return nil, ErrS2Duplicate
+	}
+
+	candidates := []byte{}
+	if _, ok := s.tempKeys[cmd.SrcNodeID]; ok {
+		candidates = append(candidates, KeyS2Temporary)
+	}
+	for _, keyClass := range keyClasses {
+		if _, ok := s.keys[keyClass]; ok {
+			candidates = append(candidates, keyClass)
+		}
+	}
+
+	header := msg.header()
+	aad := s.authData(cmd.SrcNodeID, cmd.DstNodeID, len(header)+len(msg.Ciphertext), header[2:])
+
+	var plaintext []byte
+	var keyClass byte
+	var err error
+
+	span := s.span(cmd.SrcNodeID)
+	if ext := msg.Extension(S2ExtensionSPAN); ext != nil {
+		if span.localEI == nil || len(ext.Data) != s2EntropySize {
+			return nil, ErrS2NoSPAN
+		}
+
+		mei := mixEntropy(ext.Data, span.localEI)
+
+		err = ErrS2Authentication
+		for _, keyClass = range candidates {
+			keys, _ := s.keysFor(cmd.SrcNodeID, keyClass)
+			drbg := newCTRDRBG(mei, keys.personalization)
+
+			if plaintext, err = ccmDecrypt(keys.ccm, drbg.nextNonce(), msg.Ciphertext, aad); err == nil {
+				span.drbg = drbg
+				span.keyClass = keyClass
+				span.localEI = nil
+				break
+			}
+		}
+	} else {
+		if span.drbg == nil || !containsKeyClass(candidates, span.keyClass) {
+			return nil, ErrS2NoSPAN
+		}
+
+		keyClass = span.keyClass
+		keys, _ := s.keysFor(cmd.SrcNodeID, keyClass)
+		plaintext, err = ccmDecrypt(keys.ccm, span.drbg.nextNonce(), msg.Ciphertext, aad)
+	}
+
+	if err != nil {
+		span.drbg = nil
+		return nil, err
+	}
+
+	s.rxSequence[cmd.SrcNodeID] = msg.SequenceNumber
+
+	decrypted := &S2DecryptedMessage{Payload: plaintext, KeyClass: keyClass}
+
+	if msg.EncryptedExtension {
+		var extensions []S2Extension
+		if extensions, decrypted.Payload, err = parseS2Extensions(plaintext); err != nil {
+			return nil, err
+		}
+
+		if ext := findS2Extension(extensions, S2ExtensionMPAN); ext != nil && len(ext.Data) == 1+aes.BlockSize {
+			s.peerMPANs[mpanKey{cmd.SrcNodeID, ext.Data[0]}] = &s2MPAN{
+				keyClass: keyClass,
+				state:    append([]byte(nil), ext.Data[1:]...),
+			}
+		}
+	}
+
+	if ext := msg.Extension(S2ExtensionMGRP); ext != nil && len(ext.Data) == 1 {
+		decrypted.GroupID = ext.Data[0]
+		_, ok := s.peerMPANs[mpanKey{cmd.SrcNodeID, decrypted.GroupID}]
+		decrypted.MPANMissing = !ok
+	}
+
+	return decrypted, nil
+}
+
+func (s *S2Layer) decryptMulticast(srcNode uint16, msg *S2Message) (*S2DecryptedMessage, error) {
+	ext := msg.Extension(S2ExtensionMGRP)
+	if ext == nil || len(ext.Data) != 1 {
+		return nil, errors.New("multicast message without a group")
+	}
+
+	groupID := ext.Data[0]
+
+	mpan, ok := s.peerMPANs[mpanKey{srcNode, groupID}]
+	if !ok {
+		return &S2DecryptedMessage{GroupID: groupID, MPANMissing: true}, ErrS2NoMPAN
+	}
+
+	keys, ok := s.keys[mpan.keyClass]
+	if !ok {
+		return nil, ErrS2UnknownKey
+	}
+
+	header := msg.header()
+	aad := s.authData(srcNode, uint16(groupID), len(header)+len(msg.Ciphertext), header[2:])
+
+	// Multicasts may have been missed, so try the next few states as well
+	for i := 0; i < mpanLookahead; i++ {
+		state := append([]byte(nil), mpan.state...)
+		for j := 0; j < i; j++ {
+			incrementCounter(state)
+		}
+
+		candidate := &s2MPAN{keyClass: mpan.keyClass, state: state}
+		plaintext, err := ccmDecrypt(keys.ccm, candidate.nonce(keys.mpan), msg.Ciphertext, aad)
+		if err == nil {
+			mpan.state = candidate.state
+			return &S2DecryptedMessage{Payload: plaintext, KeyClass: mpan.keyClass, GroupID: groupID}, nil
+		}
+	}
+
+	return &S2DecryptedMessage{GroupID: groupID, MPANMissing: true}, ErrS2Authentication
+}
+
+func containsKeyClass(keyClasses []byte, keyClass byte) bool {
+	for _, k := range keyClasses {
+		if k == keyClass {
+			return true
+		}
+	}
+
+	return false
+}
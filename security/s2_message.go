@@ -0,0 +1,188 @@
+package security
+
+import (
+	"errors"
+
+	"github.com/gozwave/gozw/cc"
+	security2 "github.com/gozwave/gozw/cc/security-2"
+)
+
+// CommandS2MessageEncapsulation is the S2 Message Encapsulation command.
+const CommandS2MessageEncapsulation cc.CommandID = 0x03
+
+// S2 message encapsulation extension types. SPAN and MGRP extensions are sent
+// in the clear (but authenticated); MPAN extensions are encrypted.
+const (
+	S2ExtensionSPAN byte = 0x01
+	S2ExtensionMPAN byte = 0x02
+	S2ExtensionMGRP byte = 0x03
+	S2ExtensionMOS  byte = 0x04
+)
+
+const (
+	s2ExtensionTypeMask     = 0x3F
+	s2ExtensionCritical     = 0x40
+	s2ExtensionMoreToFollow = 0x80
+
+	s2FlagExtension          = 0x01
+	s2FlagEncryptedExtension = 0x02
+)
+
+// S2Extension is a header extension of an S2 message encapsulation.
+type S2Extension struct {
+	Type     byte
+	Critical bool
+	Data     []byte
+}
+
+// S2Message is an S2 Message Encapsulation command. Ciphertext holds the
+// encrypted extensions and command, followed by the authentication tag.
+type S2Message struct {
+	SequenceNumber     byte
+	Extensions         []S2Extension
+	EncryptedExtension bool
+	Ciphertext         []byte
+}
+
+func (cmd S2Message) CommandClassID() cc.CommandClassID {
+	return cc.Security2
+}
+
+func (cmd S2Message) CommandID() cc.CommandID {
+	return CommandS2MessageEncapsulation
+}
+
+func (cmd S2Message) CommandIDString() string {
+	return "SECURITY_2_MESSAGE_ENCAPSULATION"
+}
+
+func (cmd *S2Message) UnmarshalBinary(data []byte) error {
+	// According to the docs, we must copy data if we wish to retain it after returning
+
+	if len(data) < 4+s2TagSize {
+		return errors.New("Payload length underflow")
+	}
+
+	payload := make([]byte, len(data))
+	copy(payload, data)
+
+	cmd.SequenceNumber = payload[2]
+	cmd.EncryptedExtension = payload[3]&s2FlagEncryptedExtension != 0
+	cmd.Extensions = nil
+
+	rest := payload[4:]
+	if payload[3]&s2FlagExtension != 0 {
+		var err error
+		if cmd.Extensions, rest, err = parseS2Extensions(rest); err != nil {
+			return err
+		}
+	}
+
+	if len(rest) < s2TagSize {
+		return errors.New("Payload length underflow")
+	}
+
+	cmd.Ciphertext = rest
+
+	return nil
+}
+
+func (cmd *S2Message) MarshalBinary() (payload []byte, err error) {
+	payload = append(cmd.header(), cmd.Ciphertext...)
+	return payload, nil
+}
+
+// header returns the message up to the ciphertext, which is authenticated
+// along with it.
+func (cmd *S2Message) header() []byte {
+	var flags byte
+	if len(cmd.Extensions) > 0 {
+		flags |= s2FlagExtension
+	}
+	if cmd.EncryptedExtension {
+		flags |= s2FlagEncryptedExtension
+	}
+
+	header := []byte{byte(cmd.CommandClassID()), byte(cmd.CommandID()), cmd.SequenceNumber, flags}
+
+	return append(header, marshalS2Extensions(cmd.Extensions)...)
+}
+
+// Extension returns the extension of the given type, if there is one.
+func (cmd *S2Message) Extension(extensionType byte) *S2Extension {
+	return findS2Extension(cmd.Extensions, extensionType)
+}
+
+func findS2Extension(extensions []S2Extension, extensionType byte) *S2Extension {
+	for i := range extensions {
+		if extensions[i].Type == extensionType {
+			return &extensions[i]
+		}
+	}
+
+	return nil
+}
+
+func marshalS2Extensions(extensions []S2Extension) []byte {
+	var buf []byte
+	for i, ext := range extensions {
+		flags := ext.Type & s2ExtensionTypeMask
+		if ext.Critical {
+			flags |= s2ExtensionCritical
+		}
+		if i < len(extensions)-1 {
+			flags |= s2ExtensionMoreToFollow
+		}
+
+		buf = append(buf, byte(len(ext.Data)+2), flags)
+		buf = append(buf, ext.Data...)
+	}
+
+	return buf
+}
+
+// parseS2Extensions parses a list of extensions and returns them along with
+// the data following them.
+func parseS2Extensions(data []byte) ([]S2Extension, []byte, error) {
+	var extensions []S2Extension
+
+	for {
+		if len(data) < 2 || int(data[0]) < 2 || int(data[0]) > len(data) {
+			return nil, nil, errors.New("invalid S2 extension")
+		}
+
+		length, flags := int(data[0]), data[1]
+		extensions = append(extensions, S2Extension{
+			Type:     flags & s2ExtensionTypeMask,
+			Critical: flags&s2ExtensionCritical != 0,
+			Data:     data[2:length],
+		})
+		data = data[length:]
+
+		if flags&s2ExtensionMoreToFollow == 0 {
+			return extensions, data, nil
+		}
+	}
+}
+
+// parseS2NonceReport parses an S2 Nonce Report. The receiver's entropy input
+// is only present if the SOS flag is set, which the generated command doesn't
+// allow for.
+func parseS2NonceReport(data []byte) (*security2.NonceReport, error) {
+	if len(data) < 4 {
+		return nil, errors.New("Payload length underflow")
+	}
+
+	report := &security2.NonceReport{SequenceNumber: data[2]}
+	report.Properties1.Sos = data[3]&0x01 != 0
+	report.Properties1.Mos = data[3]&0x02 != 0
+
+	if report.Properties1.Sos {
+		if len(data) < 4+s2EntropySize {
+			return nil, errors.New("Payload length underflow")
+		}
+		report.ReceiversEntropyInput = append([]byte(nil), data[4:4+s2EntropySize]...)
+	}
+
+	return report, nil
+}
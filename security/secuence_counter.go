@@ -7,20 +7,29 @@ const (
 	SecuritySequenceCounterMax      = 15
 )
 
+// The security header that precedes the payload of every encapsulated message:
+// the sequence counter shared by both frames of a sequenced message, and flags
+// marking sequenced messages and their second frame.
+const (
+	SecuritySequenceCounterMask     byte = 0x0F
+	SecuritySequenceSequencedFlag        = 0x10
+	SecuritySequenceSecondFrameFlag      = 0x20
+)
+
 type SequenceCounter struct {
 	// maps a node id to a sequence counter (unique per node)
-	counters map[byte]byte
+	counters map[uint16]byte
 	lock     *sync.Mutex
 }
 
 func NewSequenceCounter() *SequenceCounter {
 	return &SequenceCounter{
-		counters: map[byte]byte{},
+		counters: map[uint16]byte{},
 		lock:     &sync.Mutex{},
 	}
 }
 
-func (s *SequenceCounter) Get(nodeID byte) (counter byte) {
+func (s *SequenceCounter) Get(nodeID uint16) (counter byte) {
 	var ok bool
 
 	s.lock.Lock()
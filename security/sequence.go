@@ -0,0 +1,80 @@
+package security
+
+import (
+	"errors"
+	"time"
+)
+
+// sequenceTimeout is how long the first frame of a sequenced message is kept
+// while waiting for the second.
+var sequenceTimeout = time.Second * 10
+
+// ErrSequenceMismatch is returned for the second frame of a sequenced message
+// whose first frame wasn't received, has expired or had another sequence
+// counter.
+var ErrSequenceMismatch = errors.New("second frame without a matching first frame")
+
+// sequencedMessage is the first frame of a sequenced message from a node.
+type sequencedMessage struct {
+	counter byte
+	payload []byte
+	timer   *time.Timer
+}
+
+// NextSequenceCounter returns the sequence counter to send the next sequenced
+// message to a node with.
+func (s *Layer) NextSequenceCounter(nodeID uint16) byte {
+	return s.sequenceCounter.Get(nodeID)
+}
+
+// ReassembleMessage takes a decrypted message from a node (the security header
+// followed by the payload) and returns the complete payload. The first frame
+// of a sequenced message is kept until the second arrives, in which case the
+// payload is nil.
+func (s *Layer) ReassembleMessage(nodeID uint16, decrypted []byte) ([]byte, error) {
+	if len(decrypted) < 1 {
+		return nil, errors.New("Payload length underflow")
+	}
+
+	header, payload := decrypted[0], decrypted[1:]
+	if header&SecuritySequenceSequencedFlag == 0 {
+		return payload, nil
+	}
+
+	counter := header & SecuritySequenceCounterMask
+
+	s.sequenceLock.Lock()
+	defer s.sequenceLock.Unlock()
+
+	first, ok := s.sequences[nodeID]
+	if ok {
+		first.timer.Stop()
+		delete(s.sequences, nodeID)
+	}
+
+	if header&SecuritySequenceSecondFrameFlag == 0 {
+		message := &sequencedMessage{
+			counter: counter,
+			payload: append([]byte(nil), payload...),
+		}
+
+		message.timer = time.AfterFunc(sequenceTimeout, func() {
+			s.sequenceLock.Lock()
+			defer s.sequenceLock.Unlock()
+
+			if s.sequences[nodeID] == message {
+				delete(s.sequences, nodeID)
+			}
+		})
+
+		s.sequences[nodeID] = message
+
+		return nil, nil
+	}
+
+	if !ok || first.counter != counter {
+		return nil, ErrSequenceMismatch
+	}
+
+	return append(first.payload, payload...), nil
+}
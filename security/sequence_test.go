@@ -0,0 +1,47 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReassembleMessage(t *testing.T) {
+	layer := NewLayer(make([]byte, 16), zap.NewNop())
+
+	payload, err := layer.ReassembleMessage(2, []byte{0x00, 0x25, 0x01})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x25, 0x01}, payload)
+
+	payload, err = layer.ReassembleMessage(2, []byte{0x15, 0x70, 0x06})
+	assert.NoError(t, err)
+	assert.Nil(t, payload)
+
+	// Frames from other nodes are kept apart
+	_, err = layer.ReassembleMessage(3, []byte{0x35, 0x01})
+	assert.Equal(t, ErrSequenceMismatch, err)
+
+	payload, err = layer.ReassembleMessage(2, []byte{0x35, 0x01, 0x02})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x70, 0x06, 0x01, 0x02}, payload)
+
+	// The second frame must have the first frame's sequence counter
+	layer.ReassembleMessage(2, []byte{0x16, 0x70, 0x06})
+	_, err = layer.ReassembleMessage(2, []byte{0x37, 0x01})
+	assert.Equal(t, ErrSequenceMismatch, err)
+}
+
+func TestReassembleMessageTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { sequenceTimeout = timeout }(sequenceTimeout)
+	sequenceTimeout = 10 * time.Millisecond
+
+	layer := NewLayer(make([]byte, 16), zap.NewNop())
+
+	layer.ReassembleMessage(2, []byte{0x15, 0x70, 0x06})
+	time.Sleep(50 * time.Millisecond)
+
+	_, err := layer.ReassembleMessage(2, []byte{0x35, 0x01})
+	assert.Equal(t, ErrSequenceMismatch, err)
+}
@@ -0,0 +1,28 @@
+package gozw
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// SecurityEvent reports a secure message that was rejected, such as one that
+// was forged, tampered with or replayed. Err says why (e.g.
+// security.ErrInvalidMAC or security.ErrUnknownNonce).
+type SecurityEvent struct {
+	NodeID uint16
+	Err    error
+}
+
+// SetSecurityEventCallback will set the callback for rejected secure messages.
+func (c *Client) SetSecurityEventCallback(callback func(c *Client, event SecurityEvent)) {
+	c.SecurityEventCallback = callback
+}
+
+func (c *Client) securityEvent(nodeID uint16, err error) {
+	c.l.Warn("rejected secure message", zap.String("node", fmt.Sprint(nodeID)), zap.Error(err))
+
+	if c.SecurityEventCallback != nil {
+		c.SecurityEventCallback(c, SecurityEvent{NodeID: nodeID, Err: err})
+	}
+}
@@ -0,0 +1,97 @@
+package gozw
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/cc"
+	zwsec "github.com/gozwave/gozw/cc/security"
+	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/security"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestClientRejectsForgedSecureMessages(t *testing.T) {
+	controller := emulator.NewController()
+	node := emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.Security), byte(cc.SwitchBinary))
+	controller.AddNode(node)
+
+	nonces := make(chan []byte, 1)
+	node.OnCommand = func(command []byte) [][]byte {
+		if command[0] == byte(cc.Security) && command[1] == byte(zwsec.CommandNonceReport) {
+			nonces <- command[2:10]
+		}
+		return nil
+	}
+
+	client := newTestClient(t, controller)
+	client.nodes[2].NetworkKeySent = true
+
+	commands := make(chan cc.Command, 10)
+	client.SetEventCallback(func(c *Client, nodeID uint16, command cc.Command) {
+		commands <- command
+	})
+
+	events := make(chan SecurityEvent, 10)
+	client.SetSecurityEventCallback(func(c *Client, event SecurityEvent) {
+		events <- event
+	})
+
+	layer := security.NewLayer(testNetworkKey, zap.NewNop())
+	report := []byte{0, byte(cc.SwitchBinary), byte(switchbinary.CommandReport), 0xFF}
+
+	// encapsulate encrypts the report with a fresh nonce from the controller
+	encapsulate := func(srcNode uint16) []byte {
+		node.Emit([]byte{byte(cc.Security), byte(zwsec.CommandNonceGet)})
+
+		var nonce []byte
+		select {
+		case nonce = <-nonces:
+		case <-time.After(time.Second):
+			require.FailNow(t, "no nonce report")
+		}
+
+		msg, err := layer.EncapsulateMessage(srcNode, 1, zwsec.CommandMessageEncapsulation, security.GenerateNonce(), nonce, report, false)
+		require.NoError(t, err)
+
+		encapsulated, _ := msg.MarshalBinary()
+		return encapsulated
+	}
+
+	expectEvent := func(err error) {
+		select {
+		case event := <-events:
+			assert.Equal(t, SecurityEvent{NodeID: 2, Err: err}, event)
+		case <-time.After(time.Second):
+			assert.Fail(t, "no security event", err.Error())
+		}
+	}
+
+	// Tampered messages are rejected without using up the nonce
+	valid := encapsulate(2)
+	tampered := append([]byte(nil), valid...)
+	tampered[10] ^= 0xFF
+	node.Emit(tampered)
+	expectEvent(security.ErrInvalidMAC)
+
+	node.Emit(valid)
+	select {
+	case command := <-commands:
+		assert.Equal(t, &switchbinary.Report{Value: 0xFF}, command)
+	case <-time.After(time.Second):
+		assert.Fail(t, "valid message not received")
+	}
+
+	// Replays are rejected
+	node.Emit(valid)
+	expectEvent(security.ErrUnknownNonce)
+
+	// The sender is authenticated too
+	node.Emit(encapsulate(3))
+	expectEvent(security.ErrInvalidMAC)
+
+	assert.Empty(t, commands)
+}
@@ -0,0 +1,89 @@
+package gozw
+
+import (
+	"context"
+
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// initSerialAPISetup records the Serial API setup subcommands the controller
+// supports and enables extended transmit status reports.
+func (c *Client) initSerialAPISetup(ctx context.Context) error {
+	c.updateController(func(controller *Controller) {
+		controller.SupportedSetupCommands = nil
+		controller.MaxPayloadSize = 0
+	})
+
+	controller := c.controller()
+	if !controller.SupportsFunction(protocol.FnSerialAPISetup) {
+		return nil
+	}
+
+	commands, err := c.serialAPI.GetSupportedSetupCommands(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get supported setup commands")
+	}
+
+	controller.SupportedSetupCommands = commands
+
+	if controller.SupportsSetupCommand(protocol.SerialAPISetupSetTxStatusReport) {
+		if err = c.serialAPI.SetTxStatusReport(ctx, true); err != nil {
+			c.l.Warn("enabling tx status report", zap.Error(err))
+		}
+	}
+
+	var maxPayloadSize byte
+	if controller.SupportsSetupCommand(protocol.SerialAPISetupGetMaxPayloadSize) {
+		if maxPayloadSize, err = c.serialAPI.GetMaxPayloadSize(ctx); err != nil {
+			c.l.Warn("getting max payload size", zap.Error(err))
+		}
+	}
+
+	c.updateController(func(controller *Controller) {
+		controller.SupportedSetupCommands = commands
+		controller.MaxPayloadSize = maxPayloadSize
+	})
+
+	return nil
+}
+
+// RFRegion returns the controller's RF region (one of protocol.RFRegion*).
+func (c *Client) RFRegion(ctx context.Context) (byte, error) {
+	return c.serialAPI.GetRFRegion(ctx)
+}
+
+// SetRFRegion changes the controller's RF region (one of protocol.RFRegion*),
+// then resets the controller for the change to take effect.
+func (c *Client) SetRFRegion(ctx context.Context, region byte) error {
+	if err := c.serialAPI.SetRFRegion(ctx, region); err != nil {
+		return err
+	}
+
+	return c.softReset(ctx)
+}
+
+// Powerlevel returns the controller's transmit power.
+func (c *Client) Powerlevel(ctx context.Context) (*serialapi.Powerlevel, error) {
+	return c.serialAPI.GetPowerlevel(ctx)
+}
+
+// SetPowerlevel changes the controller's transmit power, then resets the
+// controller for the change to take effect.
+func (c *Client) SetPowerlevel(ctx context.Context, powerlevel serialapi.Powerlevel) error {
+	if err := c.serialAPI.SetPowerlevel(ctx, powerlevel); err != nil {
+		return err
+	}
+
+	return c.softReset(ctx)
+}
+
+// softReset resets the controller and initializes it again, since a reset
+// also reverts settings like the node ID type.
+func (c *Client) softReset(ctx context.Context) error {
+	c.serialAPI.SoftReset(ctx)
+
+	return errors.Wrap(c.initZWave(ctx), "reinitialize")
+}
@@ -0,0 +1,66 @@
+package gozw
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gozwave/gozw/cc"
+	switchbinary "github.com/gozwave/gozw/cc/switch-binary"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSerialAPISetup(t *testing.T) {
+	controller := emulator.NewController()
+	controller.SendsReady = true
+	controller.AddNode(emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary)))
+
+	client := newTestClient(t, controller)
+	ctx := context.Background()
+
+	assert.True(t, client.Controller.SupportsSetupCommand(protocol.SerialAPISetupSetRFRegion))
+	assert.False(t, client.Controller.SupportsSetupCommand(protocol.SerialAPISetupSetNodeIDType))
+	assert.EqualValues(t, 46, client.Controller.MaxPayloadSize)
+
+	// Extended transmit status reports are enabled during initialization
+	assert.True(t, controller.TxStatusReport)
+	report, err := client.SendData(ctx, 2, &switchbinary.Get{}, protocol.DefaultTransmitOptions)
+	require.NoError(t, err)
+	assert.True(t, report.Extended)
+	assert.EqualValues(t, -60, report.AckRSSI)
+
+	region, err := client.RFRegion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, protocol.RFRegionEurope, region)
+
+	require.NoError(t, client.SetRFRegion(ctx, protocol.RFRegionUSA))
+	assert.EqualValues(t, protocol.RFRegionUSA, controller.RFRegion)
+	assert.True(t, controller.TxStatusReport, "settings are restored after the reset")
+
+	require.NoError(t, client.SetPowerlevel(ctx, serialapi.Powerlevel{TxPower: -20, Measured0dBm: 30}))
+	powerlevel, err := client.Powerlevel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &serialapi.Powerlevel{TxPower: -20, Measured0dBm: 30}, powerlevel)
+}
+
+func TestClientUnsupportedFunction(t *testing.T) {
+	controller := emulator.NewController()
+	controller.HandleSetup(protocol.SerialAPISetupGetRFRegion, nil)
+
+	client := newTestClient(t, controller)
+
+	_, err := client.RFRegion(context.Background())
+	assert.Equal(t, serialapi.ErrUnsupportedFunction{
+		FunctionID: protocol.FnSerialAPISetup,
+		Subcommand: protocol.SerialAPISetupGetRFRegion,
+	}, err)
+
+	// The request isn't sent at all rather than waiting for a response that
+	// never comes
+	_, err = client.serialAPI.GetLongRangeNodes(context.Background())
+	assert.Equal(t, serialapi.ErrUnsupportedFunction{FunctionID: protocol.FnGetLongRangeNodes}, err)
+	assert.NotContains(t, controller.Requests(), []byte{protocol.FnGetLongRangeNodes, 0})
+}
@@ -1,8 +1,10 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gozwave/gozw/frame"
@@ -12,16 +14,50 @@ import (
 )
 
 // AddNode will put the controller into add node mode and handle operations for adding a node.
-func (s *Layer) AddNode() (*AddRemoveNodeCallback, error) {
+func (s *Layer) AddNode(ctx context.Context) (*AddRemoveNodeCallback, error) {
+	return s.addNode(ctx, protocol.AddNodeAny, nil)
+}
+
+// AddNodeDSK includes the node with the given DSK (the 16-byte device specific
+// key from its label or QR code), which must be powered up and waiting for
+// SmartStart inclusion. Z-Wave Long Range nodes can only be included this way;
+// set longRange to include the node with the Long Range protocol.
+func (s *Layer) AddNodeDSK(ctx context.Context, dsk []byte, longRange bool) (*AddRemoveNodeCallback, error) {
+	if len(dsk) != 16 {
+		return nil, fmt.Errorf("DSK must be 16 bytes, got %d", len(dsk))
+	}
+
+	// the home IDs the node uses while waiting for inclusion are derived from
+	// its DSK (SDS13944)
+	homeIDs := append([]byte(nil), dsk[8:16]...)
+	homeIDs[0] |= 0xC0
+	homeIDs[3] &^= 0x01
+	homeIDs[4] &^= 0xC0
+	homeIDs[7] |= 0x01
+
+	nodeProtocol := protocol.AddNodeProtocolZWave
+	if longRange {
+		nodeProtocol = protocol.AddNodeProtocolLongRange
+	}
+
+	return s.addNode(ctx, protocol.AddNodeSmartStartDSK, append(homeIDs, nodeProtocol))
+}
+
+// addNode runs inclusion in the given mode. trailer holds the mode's
+// parameters that follow the callback ID.
+func (s *Layer) addNode(ctx context.Context, mode byte, trailer []byte) (*AddRemoveNodeCallback, error) {
 
+	// callbacks are handled on their own goroutines
+	var lock sync.Mutex
 	var newNode *AddRemoveNodeCallback
 
-	addNodeDone := make(chan bool)
-	done := make(chan *frame.Frame)
+	addNodeDone := make(chan bool, 1)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnAddNodeToNetwork,
-		Payload:    []byte{protocol.AddNodeAny | protocol.AddNodeOptionNetworkWide | protocol.AddNodeOptionNormalPower},
+		Payload:    []byte{mode | protocol.AddNodeOptionNetworkWide | protocol.AddNodeOptionNormalPower},
+		Trailer:    trailer,
 
 		HasReturn:        false,
 		ReceivesCallback: true,
@@ -29,8 +65,13 @@ func (s *Layer) AddNode() (*AddRemoveNodeCallback, error) {
 		Timeout:          60 * time.Second,
 		Release:          addNodeDone,
 
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- nil
+			return false
+		},
+
 		Callback: func(cbFrame frame.Frame) {
-			cbData := parseAddRemoveNodeCallback(cbFrame.Payload)
+			cbData := s.parseAddRemoveNodeCallback(cbFrame.Payload)
 
 			switch cbData.Status {
 			case protocol.AddNodeStatusLearnReady:
@@ -41,13 +82,17 @@ func (s *Layer) AddNode() (*AddRemoveNodeCallback, error) {
 
 			case protocol.AddNodeStatusAddingSlave:
 				s.l.Debug("ADD NODE: adding slave node")
+				lock.Lock()
 				newNode = cbData
+				lock.Unlock()
 
 			case protocol.AddNodeStatusAddingController:
 				// hey, i just met you, and this is crazy
 				// but it could happen, so implement me maybe
 				s.l.Debug("ADD NODE: adding controller node")
+				lock.Lock()
 				newNode = cbData
+				lock.Unlock()
 
 			case protocol.AddNodeStatusProtocolDone:
 				s.l.Debug("ADD NODE: protocol done")
@@ -86,24 +131,37 @@ func (s *Layer) AddNode() (*AddRemoveNodeCallback, error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		// take the controller out of inclusion mode
+		s.sessionLayer.SendFrameDirect(addRemoveStatusFrame(protocol.FnAddNodeToNetwork, protocol.AddNodeStop, 0))
+		return nil, err
+	}
 
 	if ret == nil {
 		return nil, errors.New("Error adding node")
 	}
 
+	lock.Lock()
+	defer lock.Unlock()
+
 	return newNode, nil
 
 }
 
 // RemoveNode will put the controller into remove node mode  and handle all operations.
-func (s *Layer) RemoveNode() (*AddRemoveNodeCallback, error) {
+func (s *Layer) RemoveNode(ctx context.Context) (*AddRemoveNodeCallback, error) {
 
+	// callbacks are handled on their own goroutines
+	var lock sync.Mutex
 	var removedNode *AddRemoveNodeCallback
 
-	removeNodeDone := make(chan bool)
-	done := make(chan *frame.Frame)
+	removeNodeDone := make(chan bool, 1)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnRemoveNodeFromNetwork,
@@ -115,8 +173,13 @@ func (s *Layer) RemoveNode() (*AddRemoveNodeCallback, error) {
 		Timeout:          60 * time.Second,
 		Release:          removeNodeDone,
 
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- nil
+			return false
+		},
+
 		Callback: func(cbFrame frame.Frame) {
-			cbData := parseAddRemoveNodeCallback(cbFrame.Payload)
+			cbData := s.parseAddRemoveNodeCallback(cbFrame.Payload)
 
 			switch cbData.Status {
 			case protocol.RemoveNodeStatusLearnReady:
@@ -127,13 +190,17 @@ func (s *Layer) RemoveNode() (*AddRemoveNodeCallback, error) {
 
 			case protocol.RemoveNodeStatusRemovingSlave:
 				s.l.Debug("REMOVE NODE: removing slave node")
+				lock.Lock()
 				removedNode = cbData
+				lock.Unlock()
 
 			case protocol.RemoveNodeStatusRemovingController:
 				// hey, i just met you, and this is crazy
 				// but it could happen, so implement me maybe
 				s.l.Debug("REMOVE NODE: removing controller node")
+				lock.Lock()
 				removedNode = cbData
+				lock.Unlock()
 
 			case protocol.RemoveNodeStatusProtocolDone:
 				s.l.Debug("REMOVE NODE: protocol done")
@@ -172,13 +239,24 @@ func (s *Layer) RemoveNode() (*AddRemoveNodeCallback, error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		// take the controller out of exclusion mode
+		s.sessionLayer.SendFrameDirect(addRemoveStatusFrame(protocol.FnRemoveNodeFromNetwork, protocol.RemoveNodeStop, 0))
+		return nil, err
+	}
 
 	if ret == nil {
 		return nil, errors.New("Error removing node")
 	}
 
+	lock.Lock()
+	defer lock.Unlock()
+
 	return removedNode, nil
 
 }
@@ -196,7 +274,7 @@ type AddRemoveNodeCallback struct {
 	CommandID      byte
 	CallbackID     byte
 	Status         byte
-	Source         byte
+	Source         uint16
 	Length         byte
 	Basic          byte
 	Generic        byte
@@ -204,33 +282,41 @@ type AddRemoveNodeCallback struct {
 	CommandClasses []byte
 }
 
-func parseAddRemoveNodeCallback(payload []byte) *AddRemoveNodeCallback {
+func (s *Layer) parseAddRemoveNodeCallback(payload []byte) *AddRemoveNodeCallback {
 	val := &AddRemoveNodeCallback{
 		CommandID:  payload[0],
 		CallbackID: payload[1],
 		Status:     payload[2],
-		Source:     payload[3],
-		Length:     payload[4],
 	}
 
-	if val.Length == 0 {
+	source, n := s.parseNodeID(payload[3:])
+	val.Source = source
+
+	// [length, basic, generic, specific, command classes...]
+	if len(payload) <= 3+n {
+		return val
+	}
+	info := payload[3+n:]
+	val.Length = info[0]
+
+	if val.Length == 0 || len(info) < 4 {
 		return val
 	}
 
 	if val.Length >= 1 {
-		val.Basic = payload[5]
+		val.Basic = info[1]
 	}
 
 	if val.Length >= 2 {
-		val.Generic = payload[6]
+		val.Generic = info[2]
 	}
 
 	if val.Length >= 3 {
-		val.Specific = payload[7]
+		val.Specific = info[3]
 	}
 
 	if val.Length >= 4 {
-		val.CommandClasses = payload[8:]
+		val.CommandClasses = info[4:]
 	}
 
 	return val
@@ -1,36 +1,61 @@
 package serialapi
 
-import "github.com/gozwave/gozw/protocol"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gozwave/gozw/protocol"
+)
 
 // ApplicationCommand contains an application level command.
 type ApplicationCommand struct {
 	CommandID     byte
 	ReceiveStatus byte
-	DstNodeID     byte
-	SrcNodeID     byte
+	DstNodeID     uint16
+	SrcNodeID     uint16
 	CmdLength     byte
 	CommandData   []byte
 	// @todo implement multicast functionality (maybe? only needed for bridge library)
 }
 
-func parseApplicationCommand(payload []byte) ApplicationCommand {
-	if payload[0] == protocol.FnApplicationCommandHandler {
-		return ApplicationCommand{
-			CommandID:     payload[0],
-			ReceiveStatus: payload[1],
-			DstNodeID:     1, // always controller
-			SrcNodeID:     payload[2],
-			CmdLength:     payload[3],
-			CommandData:   payload[4 : 4+payload[3]],
-		}
+// parseApplicationCommand parses an application command handler frame:
+// [function, status, (destination node ID,) source node ID, length, command].
+// Truncated frames and empty commands are rejected.
+func (s *Layer) parseApplicationCommand(payload []byte) (ApplicationCommand, error) {
+	nodeIDSize := len(s.nodeIDBytes(0))
+
+	headerSize := 3 + nodeIDSize
+	if len(payload) > 0 && payload[0] == protocol.FnApplicationCommandHandlerBridge {
+		headerSize += nodeIDSize
+	}
+
+	if len(payload) < headerSize {
+		return ApplicationCommand{}, errors.New("Application command frame too short")
 	}
 
-	return ApplicationCommand{
+	cmd := ApplicationCommand{
 		CommandID:     payload[0],
 		ReceiveStatus: payload[1],
-		DstNodeID:     payload[2],
-		SrcNodeID:     payload[3],
-		CmdLength:     payload[4],
-		CommandData:   payload[5 : 5+payload[4]],
+		DstNodeID:     1, // always controller
+	}
+
+	i := 2
+	if payload[0] == protocol.FnApplicationCommandHandlerBridge {
+		var n int
+		cmd.DstNodeID, n = s.parseNodeID(payload[i:])
+		i += n
 	}
+
+	var n int
+	cmd.SrcNodeID, n = s.parseNodeID(payload[i:])
+	i += n
+
+	cmd.CmdLength = payload[i]
+	if cmd.CmdLength == 0 || len(payload) < i+1+int(cmd.CmdLength) {
+		return ApplicationCommand{}, fmt.Errorf("Invalid application command length %d in %d byte frame", cmd.CmdLength, len(payload))
+	}
+
+	cmd.CommandData = payload[i+1 : i+1+int(cmd.CmdLength)]
+
+	return cmd, nil
 }
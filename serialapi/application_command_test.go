@@ -0,0 +1,48 @@
+package serialapi
+
+import (
+	"testing"
+
+	"github.com/gozwave/gozw/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseApplicationCommand(t *testing.T) {
+	s := &Layer{}
+
+	cmd, err := s.parseApplicationCommand([]byte{protocol.FnApplicationCommandHandler, 0x00, 0x02, 0x03, 0x25, 0x03, 0xFF})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, cmd.SrcNodeID)
+	assert.Equal(t, []byte{0x25, 0x03, 0xFF}, cmd.CommandData)
+
+	cmd, err = s.parseApplicationCommand([]byte{protocol.FnApplicationCommandHandlerBridge, 0x00, 0x05, 0x02, 0x01, 0x20})
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, cmd.DstNodeID)
+	assert.EqualValues(t, 2, cmd.SrcNodeID)
+	assert.Equal(t, []byte{0x20}, cmd.CommandData)
+
+	s.nodeIDType = uint32(protocol.NodeIDType16Bit)
+	cmd, err = s.parseApplicationCommand([]byte{protocol.FnApplicationCommandHandler, 0x00, 0x01, 0x2C, 0x01, 0x20})
+	require.NoError(t, err)
+	assert.EqualValues(t, 300, cmd.SrcNodeID)
+}
+
+func TestParseInvalidApplicationCommand(t *testing.T) {
+	s := &Layer{}
+
+	for name, payload := range map[string][]byte{
+		"empty":          {},
+		"truncated":      {protocol.FnApplicationCommandHandler, 0x00, 0x02},
+		"bridge header":  {protocol.FnApplicationCommandHandlerBridge, 0x00, 0x05, 0x02},
+		"no command":     {protocol.FnApplicationCommandHandler, 0x00, 0x02, 0x00},
+		"length too big": {protocol.FnApplicationCommandHandler, 0x00, 0x02, 0x05, 0x25, 0x03},
+	} {
+		_, err := s.parseApplicationCommand(payload)
+		assert.Error(t, err, name)
+	}
+
+	s.nodeIDType = uint32(protocol.NodeIDType16Bit)
+	_, err := s.parseApplicationCommand([]byte{protocol.FnApplicationCommandHandler, 0x00, 0x01, 0x2C})
+	assert.Error(t, err)
+}
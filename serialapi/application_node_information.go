@@ -1,25 +1,30 @@
 package serialapi
 
-// func (s *ZWaveSessionLayer) ApplicationNodeInformation(
-// 	deviceOptions uint8,
-// 	genericType uint8,
-// 	specificType uint8,
-// 	supportedCommandClasses []uint8,
-// ) {
-//
-// 	s.execLock.Lock()
-// 	defer s.execLock.Unlock()
-//
-// 	payload := []byte{
-// 		FnApplicationNodeInformation,
-// 		deviceOptions,
-// 		genericType,
-// 		specificType,
-// 		uint8(len(supportedCommandClasses)),
-// 	}
-//
-// 	payload = append(payload, supportedCommandClasses...)
-//
-// 	s.write(frame.NewRequestFrame(payload))
-//
-// }
+import (
+	"context"
+
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// SetApplicationNodeInformation sets the node information frame the controller
+// sends to other nodes (e.g. when it is included or asked for it): its device
+// options (a combination of protocol.ApplicationNodeInfo* flags), device
+// classes and supported command classes. The controller doesn't respond.
+func (s *Layer) SetApplicationNodeInformation(ctx context.Context, deviceOptions, generic, specific byte, commandClasses []byte) error {
+
+	payload := []byte{
+		deviceOptions,
+		generic,
+		specific,
+		byte(len(commandClasses)),
+	}
+
+	request := &session.Request{
+		FunctionID: protocol.FnSerialAPIApplicationNodeInformation,
+		Payload:    append(payload, commandClasses...),
+		HasReturn:  false,
+	}
+
+	return s.makeRequest(ctx, request)
+}
@@ -0,0 +1,238 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+	"go.uber.org/zap"
+)
+
+// The functions in this file are only supported by the bridge controller
+// library, which hosts virtual slave nodes on behalf of the host.
+
+// SetSlaveNodeInformation sets the node information frame of a virtual node:
+// its device options (a combination of protocol.ApplicationNodeInfo* flags),
+// device classes and supported command classes. The controller doesn't
+// respond.
+func (s *Layer) SetSlaveNodeInformation(ctx context.Context, nodeID uint16, deviceOptions, generic, specific byte, commandClasses []byte) error {
+
+	payload := append(s.nodeIDBytes(nodeID),
+		deviceOptions,
+		generic,
+		specific,
+		byte(len(commandClasses)),
+	)
+
+	request := &session.Request{
+		FunctionID: protocol.FnSerialAPISlaveNodeInformation,
+		Payload:    append(payload, commandClasses...),
+		HasReturn:  false,
+	}
+
+	return s.makeRequest(ctx, request)
+}
+
+// SetSlaveLearnMode adds or removes a virtual node (nodeID 0 and
+// protocol.SlaveLearnModeAdd adds a new one), or lets another controller do so
+// with protocol.SlaveLearnModeEnable. It blocks until a node ID has been
+// assigned to or removed from the virtual node and returns the new node ID (0
+// if it was removed). If ctx is done first, slave learn mode is disabled.
+func (s *Layer) SetSlaveLearnMode(ctx context.Context, nodeID uint16, mode byte) (newNodeID uint16, err error) {
+
+	learnDone := make(chan bool, 1)
+	done := make(chan error, 1)
+
+	request := &session.Request{
+		FunctionID:       protocol.FnSetSlaveLearnMode,
+		Payload:          append(s.nodeIDBytes(nodeID), mode),
+		HasReturn:        true,
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          learnDone,
+		Timeout:          60 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				learnDone <- true
+				done <- err
+				return false
+			}
+
+			if len(ret.Payload) < 2 {
+				learnDone <- true
+				done <- errors.New("Invalid slave learn mode response")
+				return false
+			}
+
+			if ret.Payload[1] == 0 {
+				learnDone <- true
+				done <- ErrControllerBusy
+				return false
+			}
+
+			return true
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			// [function, callback ID, status, original node ID, new node ID]
+			if len(cbFrame.Payload) < 3+2*len(s.nodeIDBytes(0)) {
+				return
+			}
+
+			switch cbFrame.Payload[2] {
+			case protocol.SlaveLearnStatusRangeInfoUpdate:
+				s.l.Debug("SLAVE LEARN MODE: range info update")
+
+			case protocol.SlaveLearnStatusNodeIDDone, protocol.SlaveLearnStatusComplete:
+				_, n := s.parseNodeID(cbFrame.Payload[3:])
+				newNodeID, _ = s.parseNodeID(cbFrame.Payload[3+n:])
+				learnDone <- true
+				done <- nil
+
+			default:
+				s.l.Warn("SLAVE LEARN MODE: unknown status", zap.String("status", fmt.Sprint(cbFrame.Payload[2])))
+			}
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return 0, err
+	}
+
+	select {
+	case err = <-done:
+		return newNodeID, err
+	case <-ctx.Done():
+		disable := append([]byte{protocol.FnSetSlaveLearnMode}, s.nodeIDBytes(nodeID)...)
+		s.sessionLayer.SendFrameDirect(frame.NewRequestFrame(append(disable, protocol.SlaveLearnModeDisable, 0)))
+		return 0, ctx.Err()
+	}
+}
+
+// GetVirtualNodes returns the IDs of the controller's virtual nodes.
+func (s *Layer) GetVirtualNodes(ctx context.Context) ([]uint16, error) {
+
+	done := make(chan *frame.Frame, 1)
+
+	request := &session.Request{
+		FunctionID: protocol.FnGetVirtualNodes,
+		HasReturn:  true,
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- ret
+			return false
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret == nil {
+		return nil, errors.New("Error getting virtual nodes")
+	}
+
+	nodeIDs := []uint16{}
+	for nodeID := uint16(1); nodeID <= protocol.MaxClassicNodeID; nodeID++ {
+		if isBitSet(ret.Payload[1:], nodeID) {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+
+	return nodeIDs, nil
+}
+
+// SendSlaveNodeInformation sends a virtual node's node information frame to a
+// node (or protocol.NodeBroadcast), using the given transmit options.
+func (s *Layer) SendSlaveNodeInformation(ctx context.Context, srcNodeID, dstNodeID uint16, txOptions byte) (*TransmitReport, error) {
+	payload := append(s.nodeIDBytes(srcNodeID), s.nodeIDBytes(dstNodeID)...)
+
+	return s.bridgeTransmit(ctx, protocol.FnSendSlaveNodeInformation, append(payload, txOptions))
+}
+
+// SendDataBridge is like SendData, but sends the payload from srcNodeID, which
+// is the controller or one of its virtual nodes.
+func (s *Layer) SendDataBridge(ctx context.Context, srcNodeID, dstNodeID uint16, payload []byte, txOptions byte) (*TransmitReport, error) {
+	buf := append(s.nodeIDBytes(srcNodeID), s.nodeIDBytes(dstNodeID)...)
+	buf = append(append(buf, byte(len(payload))), payload...)
+
+	// The four route bytes are reserved and must be zero
+	return s.bridgeTransmit(ctx, protocol.FnSendDataBridge, append(buf, txOptions, 0, 0, 0, 0))
+}
+
+// bridgeTransmit makes a request that is answered with a response saying
+// whether the frame was queued, followed by a callback with the transmit
+// status.
+func (s *Layer) bridgeTransmit(ctx context.Context, functionID byte, payload []byte) (*TransmitReport, error) {
+
+	transmitDone := make(chan bool, 1)
+	retStatus := make(chan error, 1)
+	txStatus := make(chan *TransmitReport, 1)
+
+	request := &session.Request{
+		FunctionID:       functionID,
+		Payload:          payload,
+		HasReturn:        true,
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          transmitDone,
+		Timeout:          10 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				transmitDone <- true
+				retStatus <- err
+				return false
+			}
+
+			if len(ret.Payload) < 2 {
+				transmitDone <- true
+				retStatus <- errors.New("Invalid transmit response")
+				return false
+			}
+
+			if ret.Payload[1] == 0 {
+				transmitDone <- true
+				retStatus <- ErrTransmitQueueFull
+				return false
+			}
+
+			retStatus <- nil
+			return true
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			transmitDone <- true
+			txStatus <- parseTransmitReport(cbFrame.Payload)
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case err := <-retStatus:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case report := <-txStatus:
+		return report, report.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
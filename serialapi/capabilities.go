@@ -1,7 +1,9 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
 	"github.com/gozwave/gozw/frame"
 	"github.com/gozwave/gozw/protocol"
@@ -9,9 +11,9 @@ import (
 )
 
 // GetCapabilities will return the serial api capabilities.
-func (s *Layer) GetCapabilities() (*Capabilities, error) {
+func (s *Layer) GetCapabilities(ctx context.Context) (*Capabilities, error) {
 
-	done := make(chan *frame.Frame)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnSerialAPIGetCapabilities,
@@ -22,13 +24,21 @@ func (s *Layer) GetCapabilities() (*Capabilities, error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
 
-	if ret == nil {
+	if ret == nil || len(ret.Payload) < 9 {
 		return nil, errors.New("Error getting home/node id")
 	}
 
+	s.supportedFunctions.Store(append([]byte(nil), ret.Payload[9:]...))
+
 	val := Capabilities{
 		ApplicationVersion:  ret.Payload[1],
 		ApplicationRevision: ret.Payload[2],
@@ -63,10 +73,36 @@ func (s *Capabilities) GetSupportedFunctions() []byte {
 
 	var i byte
 	for i = 1; i < 255; i++ {
-		if isBitSet(s.SupportedFunctions, i) {
+		if isBitSet(s.SupportedFunctions, uint16(i)) {
 			supportedFunctions = append(supportedFunctions, i)
 		}
 	}
 
 	return supportedFunctions
 }
+
+// ErrUnsupportedFunction is returned instead of making a request the
+// controller doesn't support.
+type ErrUnsupportedFunction struct {
+	FunctionID byte
+
+	// Subcommand is the unsupported subcommand if FunctionID is
+	// protocol.FnSerialAPISetup, which is supported itself.
+	Subcommand byte
+}
+
+func (e ErrUnsupportedFunction) Error() string {
+	if e.FunctionID == protocol.FnSerialAPISetup && e.Subcommand != 0 {
+		return fmt.Sprintf("serial api setup subcommand 0x%02X not supported by controller", e.Subcommand)
+	}
+
+	return fmt.Sprintf("serial api function 0x%02X not supported by controller", e.FunctionID)
+}
+
+// supports reports whether the controller supports a function. Every function
+// is assumed to be supported until GetCapabilities has been called.
+func (s *Layer) supports(functionID byte) bool {
+	mask, _ := s.supportedFunctions.Load().([]byte)
+
+	return mask == nil || functionID == protocol.FnSerialAPIGetCapabilities || isBitSet(mask, uint16(functionID))
+}
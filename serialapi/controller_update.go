@@ -5,7 +5,7 @@ import "github.com/gozwave/gozw/protocol"
 // ControllerUpdate .
 type ControllerUpdate struct {
 	Status         byte
-	NodeID         byte
+	NodeID         uint16
 	Length         byte
 	Basic          byte
 	Generic        byte
@@ -13,11 +13,16 @@ type ControllerUpdate struct {
 	CommandClasses []byte
 }
 
-func parseControllerUpdate(payload []byte) ControllerUpdate {
+func (s *Layer) parseControllerUpdate(payload []byte) ControllerUpdate {
+	nodeID, n := s.parseNodeID(payload[2:])
+
+	// [length, basic, generic, specific, command classes...]
+	info := payload[2+n:]
+
 	val := ControllerUpdate{
 		Status: payload[1],
-		NodeID: payload[2],
-		Length: payload[3],
+		NodeID: nodeID,
+		Length: info[0],
 	}
 
 	if val.Length == 0 || val.Status == protocol.UpdateStateNodeInfoReqFailed {
@@ -25,19 +30,19 @@ func parseControllerUpdate(payload []byte) ControllerUpdate {
 	}
 
 	if val.Length >= 1 {
-		val.Basic = payload[4]
+		val.Basic = info[1]
 	}
 
 	if val.Length >= 2 {
-		val.Generic = payload[5]
+		val.Generic = info[2]
 	}
 
 	if val.Length >= 3 {
-		val.Specific = payload[6]
+		val.Specific = info[3]
 	}
 
 	if val.Length >= 4 {
-		val.CommandClasses = payload[7:]
+		val.CommandClasses = info[4:]
 	}
 
 	return val
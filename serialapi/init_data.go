@@ -1,6 +1,7 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
 
 	"github.com/gozwave/gozw/frame"
@@ -19,9 +20,9 @@ type InitAppData struct {
 }
 
 // GetInitAppData will return data required to initialize the application.
-func (s *Layer) GetInitAppData() (*InitAppData, error) {
+func (s *Layer) GetInitAppData(ctx context.Context) (*InitAppData, error) {
 
-	done := make(chan *frame.Frame)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnSerialAPIGetInitAppData,
@@ -32,8 +33,14 @@ func (s *Layer) GetInitAppData() (*InitAppData, error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
 
 	if ret == nil {
 		return nil, errors.New("Error getting node information")
@@ -50,8 +57,8 @@ func (s *Layer) GetInitAppData() (*InitAppData, error) {
 
 }
 
-func isBitSet(mask []byte, nodeID byte) bool {
-	if (nodeID > 0) && (nodeID <= 232) {
+func isBitSet(mask []byte, nodeID uint16) bool {
+	if (nodeID > 0) && int(nodeID-1)>>3 < len(mask) {
 		return ((mask[(nodeID-1)>>3] & (1 << ((nodeID - 1) & 0x07))) != 0)
 	}
 
@@ -60,7 +67,7 @@ func isBitSet(mask []byte, nodeID byte) bool {
 
 // GetAPIType will return the API type (slave or controller)
 func (n *InitAppData) GetAPIType() string {
-	if n.CommandID&0x80 == 0x80 {
+	if n.Capabilities&0x01 == 0x01 {
 		return "Slave"
 	}
 
@@ -69,7 +76,7 @@ func (n *InitAppData) GetAPIType() string {
 
 // TimerFunctionsSupported returns whether timer functions are supported.
 func (n *InitAppData) TimerFunctionsSupported() bool {
-	if n.CommandID&0x40 == 0x40 {
+	if n.Capabilities&0x02 == 0x02 {
 		return true
 	}
 
@@ -78,19 +85,25 @@ func (n *InitAppData) TimerFunctionsSupported() bool {
 
 // IsPrimaryController returns if this is the primary controller.
 func (n *InitAppData) IsPrimaryController() bool {
-	if n.CommandID&0x20 == 0x20 {
+	if n.Capabilities&0x04 == 0x04 {
 		return false
 	}
 
 	return true
 }
 
-// GetNodeIDs will return all node ids
-func (n *InitAppData) GetNodeIDs() []byte {
-	nodes := []byte{}
+// IsSIS returns whether the controller is the SUC ID server (SIS).
+func (n *InitAppData) IsSIS() bool {
+	return n.Capabilities&0x08 == 0x08
+}
+
+// GetNodeIDs will return all node ids (Long Range nodes are listed by
+// GetLongRangeNodes)
+func (n *InitAppData) GetNodeIDs() []uint16 {
+	nodes := []uint16{}
 
-	var i byte
-	for i = 1; i <= 232; i++ {
+	var i uint16
+	for i = 1; i <= protocol.MaxClassicNodeID; i++ {
 		if isBitSet(n.Nodes, i) {
 			nodes = append(nodes, i)
 		}
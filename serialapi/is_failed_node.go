@@ -1,6 +1,7 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
 
 	"github.com/gozwave/gozw/frame"
@@ -9,13 +10,13 @@ import (
 )
 
 // IsFailedNode Will return if a node has failed.
-func (s *Layer) IsFailedNode(nodeID byte) (failed bool, err error) {
+func (s *Layer) IsFailedNode(ctx context.Context, nodeID uint16) (failed bool, err error) {
 
-	done := make(chan *frame.Frame)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnIsNodeFailed,
-		Payload:    []byte{nodeID},
+		Payload:    s.nodeIDBytes(nodeID),
 		HasReturn:  true,
 		ReturnCallback: func(err error, ret *frame.Frame) bool {
 			done <- ret
@@ -23,8 +24,14 @@ func (s *Layer) IsFailedNode(nodeID byte) (failed bool, err error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return false, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return false, err
+	}
 
 	if ret == nil {
 		err = errors.New("Error checking failure status")
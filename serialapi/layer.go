@@ -2,7 +2,9 @@ package serialapi
 
 import (
 	"context"
+	"sync/atomic"
 
+	"github.com/gozwave/gozw/frame"
 	"github.com/gozwave/gozw/protocol"
 	"github.com/gozwave/gozw/session"
 	"github.com/davecgh/go-spew/spew"
@@ -13,18 +15,54 @@ import (
 type ILayer interface {
 	ControllerUpdates() chan ControllerUpdate
 	ControllerCommands() chan ApplicationCommand
-	AddNode() (*AddRemoveNodeCallback, error)
-	RemoveNode() (*AddRemoveNodeCallback, error)
-	GetCapabilities() (*Capabilities, error)
-	GetVersion() (version *Version, err error)
-	MemoryGetID() (homeID uint32, nodeID byte, err error)
-	GetInitAppData() (*InitAppData, error)
-	GetNodeProtocolInfo(nodeID byte) (nodeInfo *NodeProtocolInfo, err error)
-	SendData(nodeID byte, payload []byte) (txTime uint16, err error)
-	IsFailedNode(nodeID byte) (failed bool, err error)
-	RemoveFailedNode(nodeID byte) (removed bool, err error)
-	RequestNodeInfo(nodeInfo byte) (*NodeInfoFrame, error)
-	SoftReset()
+	SerialAPIReady() chan byte
+	AddNode(ctx context.Context) (*AddRemoveNodeCallback, error)
+	AddNodeDSK(ctx context.Context, dsk []byte, longRange bool) (*AddRemoveNodeCallback, error)
+	RemoveNode(ctx context.Context) (*AddRemoveNodeCallback, error)
+	GetCapabilities(ctx context.Context) (*Capabilities, error)
+	GetSupportedSetupCommands(ctx context.Context) ([]byte, error)
+	SetTxStatusReport(ctx context.Context, enable bool) error
+	GetPowerlevel(ctx context.Context) (*Powerlevel, error)
+	SetPowerlevel(ctx context.Context, powerlevel Powerlevel) error
+	GetMaxPayloadSize(ctx context.Context) (byte, error)
+	GetRFRegion(ctx context.Context) (byte, error)
+	SetRFRegion(ctx context.Context, region byte) error
+	GetVersion(ctx context.Context) (version *Version, err error)
+	MemoryGetID(ctx context.Context) (homeID uint32, nodeID uint16, err error)
+	GetInitAppData(ctx context.Context) (*InitAppData, error)
+	GetLongRangeNodes(ctx context.Context) ([]uint16, error)
+	SetNodeIDType(ctx context.Context, nodeIDType byte) error
+	GetNodeProtocolInfo(ctx context.Context, nodeID uint16) (nodeInfo *NodeProtocolInfo, err error)
+	SendData(ctx context.Context, nodeID uint16, payload []byte, txOptions byte) (*TransmitReport, error)
+	SendDataMulti(ctx context.Context, nodeIDs []uint16, payload []byte, txOptions byte) (*TransmitReport, error)
+	IsFailedNode(ctx context.Context, nodeID uint16) (failed bool, err error)
+	RemoveFailedNode(ctx context.Context, nodeID uint16) (removed bool, err error)
+	ReplaceFailedNode(ctx context.Context, nodeID uint16) error
+	RequestNodeNeighborUpdate(ctx context.Context, nodeID uint16) error
+	AssignReturnRoute(ctx context.Context, nodeID, destID uint16) error
+	DeleteReturnRoute(ctx context.Context, nodeID uint16) error
+	GetRoutingInfo(ctx context.Context, nodeID uint16, removeBad, removeNonRepeaters bool) (neighbors []uint16, err error)
+	RequestNodeInfo(ctx context.Context, nodeID uint16) (*NodeInfoFrame, error)
+	SoftReset(ctx context.Context)
+	SetApplicationNodeInformation(ctx context.Context, deviceOptions, generic, specific byte, commandClasses []byte) error
+	SetSlaveNodeInformation(ctx context.Context, nodeID uint16, deviceOptions, generic, specific byte, commandClasses []byte) error
+	SetSlaveLearnMode(ctx context.Context, nodeID uint16, mode byte) (newNodeID uint16, err error)
+	GetVirtualNodes(ctx context.Context) ([]uint16, error)
+	SendSlaveNodeInformation(ctx context.Context, srcNodeID, dstNodeID uint16, txOptions byte) (*TransmitReport, error)
+	SendDataBridge(ctx context.Context, srcNodeID, dstNodeID uint16, payload []byte, txOptions byte) (*TransmitReport, error)
+	SetDefault(ctx context.Context) error
+	SetLearnMode(ctx context.Context, mode byte) (nodeID uint16, err error)
+	ReplicationCommandComplete()
+	GetSUCNodeID(ctx context.Context) (nodeID uint16, err error)
+	SetSUCNodeID(ctx context.Context, nodeID uint16, enable bool, capabilities byte, local bool) error
+	RequestNetworkUpdate(ctx context.Context) error
+	NVMGetID(ctx context.Context) (*NVMID, error)
+	NVMExtReadLongBuffer(ctx context.Context, offset uint32, length uint16) ([]byte, error)
+	NVMExtWriteLongBuffer(ctx context.Context, offset uint32, data []byte) error
+	NVMBackupOpen(ctx context.Context) (size uint16, err error)
+	NVMBackupRead(ctx context.Context, offset uint16, length byte) (data []byte, eof bool, err error)
+	NVMBackupWrite(ctx context.Context, offset uint16, data []byte) error
+	NVMBackupClose(ctx context.Context) error
 }
 
 // Layer contains the serial api layer.
@@ -32,8 +70,19 @@ type Layer struct {
 	sessionLayer        session.ILayer
 	controllerUpdates   chan ControllerUpdate
 	applicationCommands chan ApplicationCommand
+	serialAPIReady      chan byte
 	l                   *zap.Logger
 	ctx                 context.Context
+
+	// nodeIDType is the protocol.NodeIDType* in use (0 until set); accessed
+	// atomically
+	nodeIDType uint32
+
+	// supportedFunctions is the function bitmask from GetCapabilities and
+	// supportedSetupCommands the subcommands from GetSupportedSetupCommands
+	// (both []byte, unset until they have been called)
+	supportedFunctions     atomic.Value
+	supportedSetupCommands atomic.Value
 }
 
 // NewLayer returns a new serialapi layer.
@@ -43,6 +92,7 @@ func NewLayer(ctx context.Context, sessionLayer session.ILayer, logger *zap.Logg
 		sessionLayer:        sessionLayer,
 		controllerUpdates:   make(chan ControllerUpdate, 10),
 		applicationCommands: make(chan ApplicationCommand, 10),
+		serialAPIReady:      make(chan byte, 1),
 		l:                   logger,
 		ctx:                 ctx,
 	}
@@ -66,15 +116,42 @@ func (s *Layer) ControllerCommands() chan ApplicationCommand {
 	return s.applicationCommands
 }
 
+// SerialAPIReady returns a channel that receives the reset reason whenever the
+// controller reports that its Serial API is ready (after startup or a reset).
+// Only 700/800-series controllers send this notification.
+func (s *Layer) SerialAPIReady() chan byte {
+	return s.serialAPIReady
+}
+
 func (s *Layer) handleUnsolicitedFrames() {
 	for {
 		select {
 		case fr := <-s.sessionLayer.UnsolicitedFramesChan():
 			switch fr.Payload[0] {
 			case protocol.FnApplicationCommandHandler, protocol.FnApplicationCommandHandlerBridge:
-				s.applicationCommands <- parseApplicationCommand(fr.Payload)
+				cmd, err := s.parseApplicationCommand(fr.Payload)
+				if err != nil {
+					s.l.Warn("dropping application command", zap.Error(err))
+					continue
+				}
+
+				s.applicationCommands <- cmd
 			case protocol.FnApplicationControllerUpdate:
-				s.controllerUpdates <- parseControllerUpdate(fr.Payload)
+				s.controllerUpdates <- s.parseControllerUpdate(fr.Payload)
+			case protocol.FnSerialAPIReady:
+				var reason byte
+				if len(fr.Payload) > 1 {
+					reason = fr.Payload[1]
+				}
+
+				s.l.Info("serial api ready", zap.Int("reason", int(reason)))
+
+				// nobody may be waiting; only keep the latest notification
+				select {
+				case <-s.serialAPIReady:
+				default:
+				}
+				s.serialAPIReady <- reason
 			default:
 				s.l.Warn("Unknown unsolicited frame!", zap.String("frame_info", spew.Sdump(fr)))
 			}
@@ -85,3 +162,27 @@ func (s *Layer) handleUnsolicitedFrames() {
 		}
 	}
 }
+
+// makeRequest queues a request, or returns ErrUnsupportedFunction without
+// sending it if the controller doesn't support its function.
+func (s *Layer) makeRequest(ctx context.Context, request *session.Request) error {
+	if !s.supports(request.FunctionID) {
+		return ErrUnsupportedFunction{FunctionID: request.FunctionID}
+	}
+
+	s.sessionLayer.MakeRequest(ctx, request)
+
+	return nil
+}
+
+// wait waits for the frame sent to done by a request's ReturnCallback or
+// Callback, or returns ctx.Err() if ctx is done first. Channels passed to wait
+// must be buffered, as nobody receives from them once ctx is done.
+func wait(ctx context.Context, done <-chan *frame.Frame) (*frame.Frame, error) {
+	select {
+	case ret := <-done:
+		return ret, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+	"go.uber.org/zap"
+)
+
+// SetLearnMode puts the controller into learn mode (one of the
+// protocol.LearnMode* modes), so it can be included into another controller's
+// network. It blocks until the controller has been included and returns the
+// node ID it was assigned. If ctx is done first, learn mode is disabled.
+func (s *Layer) SetLearnMode(ctx context.Context, mode byte) (nodeID uint16, err error) {
+
+	learnDone := make(chan bool, 1)
+	done := make(chan error, 1)
+
+	request := &session.Request{
+		FunctionID:       protocol.FnSetLearnMode,
+		Payload:          []byte{mode},
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          learnDone,
+		Timeout:          60 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				learnDone <- true
+				done <- err
+			}
+
+			return false
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			if len(cbFrame.Payload) < 3+len(s.nodeIDBytes(0)) {
+				return
+			}
+
+			switch cbFrame.Payload[2] {
+			case protocol.LearnModeStatusStarted:
+				s.l.Debug("LEARN MODE: started")
+
+			case protocol.LearnModeStatusDone:
+				s.l.Debug("LEARN MODE: done")
+				nodeID, _ = s.parseNodeID(cbFrame.Payload[3:])
+				learnDone <- true
+				done <- nil
+
+			case protocol.LearnModeStatusFailed:
+				s.l.Error("LEARN MODE: failed")
+				learnDone <- true
+				done <- errors.New("Learn mode failed")
+
+			default:
+				s.l.Warn("LEARN MODE: unknown status", zap.String("status", fmt.Sprint(cbFrame.Payload[2])))
+			}
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return 0, err
+	}
+
+	select {
+	case err = <-done:
+		return nodeID, err
+	case <-ctx.Done():
+		s.sessionLayer.SendFrameDirect(frame.NewRequestFrame([]byte{protocol.FnSetLearnMode, protocol.LearnModeDisable, 0}))
+		return 0, ctx.Err()
+	}
+}
+
+// ReplicationCommandComplete tells the controller that a controller
+// replication command received in learn mode has been handled. It is sent
+// directly, as the session is locked while learn mode is active.
+func (s *Layer) ReplicationCommandComplete() {
+	s.sessionLayer.SendFrameDirect(frame.NewRequestFrame([]byte{protocol.FnReplicationCommandComplete}))
+}
@@ -0,0 +1,75 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// longRangeSegmentLength is the length of the node bitmask returned for each
+// segment of the Long Range node list.
+const longRangeSegmentLength = 128
+
+// GetLongRangeNodes returns the IDs of the Z-Wave Long Range nodes in the
+// network. Classic nodes are listed by GetInitAppData.
+func (s *Layer) GetLongRangeNodes(ctx context.Context) ([]uint16, error) {
+	nodeIDs := []uint16{}
+
+	for segment := byte(0); ; segment++ {
+		ret, err := s.getLongRangeNodes(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+
+		// [function, more segments, segment, mask length, mask...]
+		if len(ret.Payload) < 4 || len(ret.Payload) < 4+int(ret.Payload[3]) {
+			return nil, errors.New("Error getting long range nodes")
+		}
+
+		mask := ret.Payload[4 : 4+int(ret.Payload[3])]
+		first := protocol.MinLongRangeNodeID + uint16(segment)*longRangeSegmentLength*8
+
+		for i := 0; i < len(mask)*8; i++ {
+			if mask[i>>3]&(1<<uint(i&0x07)) != 0 {
+				nodeIDs = append(nodeIDs, first+uint16(i))
+			}
+		}
+
+		if ret.Payload[1] == 0 {
+			return nodeIDs, nil
+		}
+	}
+}
+
+func (s *Layer) getLongRangeNodes(ctx context.Context, segment byte) (*frame.Frame, error) {
+
+	done := make(chan *frame.Frame, 1)
+
+	request := &session.Request{
+		FunctionID: protocol.FnGetLongRangeNodes,
+		Payload:    []byte{segment},
+		HasReturn:  true,
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- ret
+			return false
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret == nil {
+		return nil, errors.New("Error getting long range nodes")
+	}
+
+	return ret, nil
+}
@@ -1,6 +1,7 @@
 package serialapi
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 
@@ -10,9 +11,9 @@ import (
 )
 
 // MemoryGetID will get the home/node id.
-func (s *Layer) MemoryGetID() (homeID uint32, nodeID byte, err error) {
+func (s *Layer) MemoryGetID(ctx context.Context) (homeID uint32, nodeID uint16, err error) {
 
-	done := make(chan *frame.Frame)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnMemoryGetID,
@@ -23,15 +24,21 @@ func (s *Layer) MemoryGetID() (homeID uint32, nodeID byte, err error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return 0, 0, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return 0, 0, err
+	}
 
-	if ret == nil {
+	if ret == nil || len(ret.Payload) < 5+len(s.nodeIDBytes(0)) {
 		return 0, 0, errors.New("Error getting home/node id")
 	}
 
 	homeID = binary.BigEndian.Uint32(ret.Payload[1:5])
-	nodeID = ret.Payload[5]
+	nodeID, _ = s.parseNodeID(ret.Payload[5:])
 
 	return
 }
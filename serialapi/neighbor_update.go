@@ -0,0 +1,76 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// ErrNeighborUpdateFailed is returned by RequestNodeNeighborUpdate when the
+// node could not discover its neighbors (e.g. it is asleep or out of range).
+var ErrNeighborUpdateFailed = errors.New("neighbor update failed")
+
+// neighborUpdateTimeout is how long a neighbor update may take. The node
+// pings every other node in the network, so this grows with the network.
+const neighborUpdateTimeout = 60 * time.Second
+
+// RequestNodeNeighborUpdate asks a node to rediscover its neighbors and report
+// them to the controller. It blocks until the update is done or has failed;
+// the controller can't do anything else in the meantime.
+func (s *Layer) RequestNodeNeighborUpdate(ctx context.Context, nodeID uint16) error {
+
+	updateDone := make(chan bool, 1)
+	result := make(chan error, 1)
+
+	request := &session.Request{
+		FunctionID:       protocol.FnRequestNodeNeighborUpdate,
+		Payload:          s.nodeIDBytes(nodeID),
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          updateDone,
+		Timeout:          neighborUpdateTimeout,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				updateDone <- true
+				result <- err
+			}
+
+			return false
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			if len(cbFrame.Payload) < 3 {
+				return
+			}
+
+			switch cbFrame.Payload[2] {
+			case protocol.RequestNeighborUpdateStarted:
+				s.l.Debug("neighbor update started")
+			case protocol.RequestNeighborUpdateDone:
+				updateDone <- true
+				result <- nil
+			case protocol.RequestNeighborUpdateFailed:
+				updateDone <- true
+				result <- ErrNeighborUpdateFailed
+			}
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(neighborUpdateTimeout):
+		return errors.New("neighbor update timed out")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,42 @@
+package serialapi
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+// longRange reports whether 16-bit node IDs have been enabled with
+// SetNodeIDType.
+func (s *Layer) longRange() bool {
+	return atomic.LoadUint32(&s.nodeIDType) == uint32(protocol.NodeIDType16Bit)
+}
+
+// nodeIDBytes encodes a node ID for a Serial API frame: a single byte, or two
+// (most significant first) in 16-bit node ID mode.
+func (s *Layer) nodeIDBytes(nodeID uint16) []byte {
+	if s.longRange() {
+		return []byte{byte(nodeID >> 8), byte(nodeID)}
+	}
+
+	return []byte{byte(nodeID)}
+}
+
+// parseNodeID decodes the node ID at the start of buf and returns it along
+// with the number of bytes it takes up.
+func (s *Layer) parseNodeID(buf []byte) (uint16, int) {
+	if s.longRange() {
+		if len(buf) < 2 {
+			return 0, 2
+		}
+
+		return binary.BigEndian.Uint16(buf), 2
+	}
+
+	if len(buf) < 1 {
+		return 0, 1
+	}
+
+	return uint16(buf[0]), 1
+}
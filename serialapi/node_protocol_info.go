@@ -1,6 +1,7 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
 
 	"github.com/gozwave/gozw/frame"
@@ -9,13 +10,13 @@ import (
 )
 
 // GetNodeProtocolInfo will retrieve protocol info for a node.
-func (s *Layer) GetNodeProtocolInfo(nodeID byte) (nodeInfo *NodeProtocolInfo, err error) {
+func (s *Layer) GetNodeProtocolInfo(ctx context.Context, nodeID uint16) (nodeInfo *NodeProtocolInfo, err error) {
 
-	done := make(chan *frame.Frame)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnGetNodeProtocolInfo,
-		Payload:    []byte{nodeID},
+		Payload:    s.nodeIDBytes(nodeID),
 		HasReturn:  true,
 		ReturnCallback: func(err error, ret *frame.Frame) bool {
 			done <- ret
@@ -23,8 +24,14 @@ func (s *Layer) GetNodeProtocolInfo(nodeID byte) (nodeInfo *NodeProtocolInfo, er
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
 
 	if ret == nil {
 		return nil, errors.New("Error getting home/node id")
@@ -55,6 +62,12 @@ func (n *NodeProtocolInfo) IsListening() bool {
 	return n.Capability&0x80 == 0x80
 }
 
+// IsFLiRS returns whether a node is a frequently listening (FLiRS) node, which
+// wakes up every 250ms or 1000ms to listen for a beam.
+func (n *NodeProtocolInfo) IsFLiRS() bool {
+	return n.Security&0x60 != 0
+}
+
 // GetBasicDeviceClassName will return the basic device class as a string
 func (n *NodeProtocolInfo) GetBasicDeviceClassName() string {
 	return protocol.GetBasicDeviceTypeName(n.BasicDeviceClass)
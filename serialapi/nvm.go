@@ -0,0 +1,173 @@
+package serialapi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// NVMID describes the external NVM of a 500-series controller.
+type NVMID struct {
+	Manufacturer byte
+	MemoryType   byte
+
+	// Size is the size of the NVM in bytes (0 if unknown).
+	Size uint32
+}
+
+// NVMGetID returns the manufacturer, type and size of a 500-series
+// controller's external NVM.
+func (s *Layer) NVMGetID(ctx context.Context) (*NVMID, error) {
+	ret, err := s.nvmRequest(ctx, protocol.FnNVMGetID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret.Payload) < 5 {
+		return nil, errors.New("Error getting NVM id")
+	}
+
+	id := &NVMID{
+		Manufacturer: ret.Payload[2],
+		MemoryType:   ret.Payload[3],
+	}
+
+	// the size is given as a power of two; 0xFF means unknown
+	if sizeCode := ret.Payload[4]; sizeCode < 32 {
+		id.Size = 1 << sizeCode
+	}
+
+	return id, nil
+}
+
+// NVMExtReadLongBuffer reads length bytes from a 500-series controller's
+// external NVM, starting at offset.
+func (s *Layer) NVMExtReadLongBuffer(ctx context.Context, offset uint32, length uint16) ([]byte, error) {
+	payload := []byte{byte(offset >> 16), byte(offset >> 8), byte(offset), byte(length >> 8), byte(length)}
+
+	ret, err := s.nvmRequest(ctx, protocol.FnNVMExtReadLongBuffer, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret.Payload)-1 != int(length) {
+		return nil, fmt.Errorf("NVM read returned %d bytes, expected %d", len(ret.Payload)-1, length)
+	}
+
+	return ret.Payload[1:], nil
+}
+
+// NVMExtWriteLongBuffer writes data to a 500-series controller's external NVM,
+// starting at offset.
+func (s *Layer) NVMExtWriteLongBuffer(ctx context.Context, offset uint32, data []byte) error {
+	payload := []byte{byte(offset >> 16), byte(offset >> 8), byte(offset), byte(len(data) >> 8), byte(len(data))}
+
+	ret, err := s.nvmRequest(ctx, protocol.FnNVMExtWriteLongBuffer, append(payload, data...))
+	if err != nil {
+		return err
+	}
+
+	if len(ret.Payload) < 2 || ret.Payload[1] == 0 {
+		return errors.New("NVM write failed")
+	}
+
+	return nil
+}
+
+// NVMBackupOpen opens a 700/800-series controller's NVM for backup or restore
+// and returns its size. The controller's radio is stopped until NVMBackupClose
+// is called.
+func (s *Layer) NVMBackupOpen(ctx context.Context) (size uint16, err error) {
+	ret, err := s.nvmBackupRestore(ctx, protocol.NVMBackupRestoreOpen, 0, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(ret[3:5]), nil
+}
+
+// NVMBackupRead reads up to length bytes of a 700/800-series controller's NVM,
+// starting at offset. eof is true once the end of the NVM has been reached.
+func (s *Layer) NVMBackupRead(ctx context.Context, offset uint16, length byte) (data []byte, eof bool, err error) {
+	ret, err := s.nvmBackupRestore(ctx, protocol.NVMBackupRestoreRead, offset, length, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	dataLength := int(ret[2])
+	if len(ret) < 5+dataLength {
+		return nil, false, errors.New("NVM read response too short")
+	}
+
+	return ret[5 : 5+dataLength], ret[1] == protocol.NVMBackupRestoreStatusEndOfFile, nil
+}
+
+// NVMBackupWrite writes data to a 700/800-series controller's NVM, starting at
+// offset.
+func (s *Layer) NVMBackupWrite(ctx context.Context, offset uint16, data []byte) error {
+	_, err := s.nvmBackupRestore(ctx, protocol.NVMBackupRestoreWrite, offset, byte(len(data)), data)
+	return err
+}
+
+// NVMBackupClose closes the NVM after a backup or restore.
+func (s *Layer) NVMBackupClose(ctx context.Context) error {
+	_, err := s.nvmBackupRestore(ctx, protocol.NVMBackupRestoreClose, 0, 0, nil)
+	return err
+}
+
+// nvmBackupRestore makes an NVM backup/restore request and returns the response
+// payload: [function, status, length, offset (2 bytes), data...].
+func (s *Layer) nvmBackupRestore(ctx context.Context, operation byte, offset uint16, length byte, data []byte) ([]byte, error) {
+	payload := append([]byte{operation, length, byte(offset >> 8), byte(offset)}, data...)
+
+	ret, err := s.nvmRequest(ctx, protocol.FnNVMBackupRestore, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret.Payload) < 5 {
+		return nil, errors.New("NVM backup/restore response too short")
+	}
+
+	switch status := ret.Payload[1]; status {
+	case protocol.NVMBackupRestoreStatusOK, protocol.NVMBackupRestoreStatusEndOfFile:
+		return ret.Payload, nil
+	default:
+		return nil, fmt.Errorf("NVM backup/restore operation %d failed with status %d", operation, status)
+	}
+}
+
+func (s *Layer) nvmRequest(ctx context.Context, functionID byte, payload []byte) (*frame.Frame, error) {
+
+	done := make(chan *frame.Frame, 1)
+
+	request := &session.Request{
+		FunctionID: functionID,
+		Payload:    payload,
+		HasReturn:  true,
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- ret
+			return false
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret == nil {
+		return nil, errors.New("No response to NVM request")
+	}
+
+	return ret, nil
+}
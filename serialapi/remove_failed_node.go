@@ -1,6 +1,7 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -10,18 +11,24 @@ import (
 )
 
 // RemoveFailedNode will remove a failed node.
-func (s *Layer) RemoveFailedNode(nodeID byte) (removed bool, err error) {
+func (s *Layer) RemoveFailedNode(ctx context.Context, nodeID uint16) (removed bool, err error) {
 
-	done := make(chan frame.Frame)
+	done := make(chan frame.Frame, 1)
+	failed := make(chan error, 1)
 
 	request := &session.Request{
 		FunctionID:       protocol.FnRemoveFailingNode,
-		Payload:          []byte{nodeID},
+		Payload:          s.nodeIDBytes(nodeID),
 		HasReturn:        true,
 		ReceivesCallback: true,
 		Timeout:          time.Second * 10,
 
 		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				failed <- err
+				return false
+			}
+
 			return true
 		},
 
@@ -30,9 +37,18 @@ func (s *Layer) RemoveFailedNode(nodeID byte) (removed bool, err error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
+	if err := s.makeRequest(ctx, request); err != nil {
+		return false, err
+	}
 
-	result := <-done
+	var result frame.Frame
+	select {
+	case result = <-done:
+	case err := <-failed:
+		return false, err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
 
 	switch result.Payload[2] {
 	case protocol.NodeOk:
@@ -0,0 +1,100 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// ReplaceFailedNode will replace a failed node with a new device, which keeps
+// the failed node's ID. Once the controller is ready, the new device must be
+// put into learn mode (as for inclusion). It blocks until the device has been
+// included or ctx is done, in which case replace mode is stopped.
+func (s *Layer) ReplaceFailedNode(ctx context.Context, nodeID uint16) error {
+
+	replaceDone := make(chan bool, 1)
+	done := make(chan error, 1)
+
+	request := &session.Request{
+		FunctionID:       protocol.FnReplaceFailedNode,
+		Payload:          s.nodeIDBytes(nodeID),
+		HasReturn:        true,
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          replaceDone,
+		Timeout:          60 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err == nil && len(ret.Payload) < 2 {
+				err = errors.New("Replace failed node response too short")
+			} else if err == nil {
+				err = replaceFailedNodeError(ret.Payload[1])
+			}
+
+			if err != nil {
+				replaceDone <- true
+				done <- err
+				return false
+			}
+
+			return true
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			if len(cbFrame.Payload) < 3 {
+				replaceDone <- true
+				done <- errors.New("Replace failed node callback too short")
+				return
+			}
+
+			switch cbFrame.Payload[2] {
+			case protocol.NodeOk:
+				replaceDone <- true
+				done <- errors.New("Node was not failing")
+			case protocol.FailedNodeReplace:
+				s.l.Info("REPLACE NODE: ready, waiting for the new device")
+			case protocol.FailedNodeReplaceDone:
+				replaceDone <- true
+				done <- nil
+			case protocol.FailedNodeReplaceFailed:
+				replaceDone <- true
+				done <- errors.New("Error replacing node")
+			}
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// replace mode is stopped the same way as inclusion mode
+		s.sessionLayer.SendFrameDirect(addRemoveStatusFrame(protocol.FnAddNodeToNetwork, protocol.AddNodeStop, 0))
+		return ctx.Err()
+	}
+}
+
+// replaceFailedNodeError returns the error for the flags in the response to
+// ReplaceFailedNode, or nil if the replacement was started.
+func replaceFailedNodeError(flags byte) error {
+	switch {
+	case flags == 0:
+		return nil
+	case flags&0x02 != 0:
+		return errors.New("Not the primary controller")
+	case flags&0x08 != 0:
+		return errors.New("Node not found in the failed node list")
+	case flags&0x10 != 0:
+		return ErrControllerBusy
+	default:
+		return fmt.Errorf("Replacing node failed: %#x", flags)
+	}
+}
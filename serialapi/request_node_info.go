@@ -1,6 +1,7 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -11,14 +12,14 @@ import (
 )
 
 // RequestNodeInfo will request info for a node.
-func (s *Layer) RequestNodeInfo(nodeID byte) (*NodeInfoFrame, error) {
+func (s *Layer) RequestNodeInfo(ctx context.Context, nodeID uint16) (*NodeInfoFrame, error) {
 	var nodeInfo NodeInfoFrame
 
-	done := make(chan *frame.Frame)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnRequestNodeInfo,
-		Payload:    []byte{nodeID},
+		Payload:    s.nodeIDBytes(nodeID),
 		HasReturn:  true,
 		ReturnCallback: func(err error, ret *frame.Frame) bool {
 			done <- ret
@@ -27,8 +28,14 @@ func (s *Layer) RequestNodeInfo(nodeID byte) (*NodeInfoFrame, error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
 
 	if ret == nil {
 		return nil, errors.New("Error requesting node information frame")
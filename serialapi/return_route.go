@@ -0,0 +1,96 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// ErrControllerBusy is returned when the controller refuses to start an
+// operation because it is busy with another one.
+var ErrControllerBusy = errors.New("controller busy")
+
+// returnRouteTimeout is how long the controller may take to deliver return
+// routes to a node.
+const returnRouteTimeout = 65 * time.Second
+
+// AssignReturnRoute assigns a node return routes to destID, which it then uses
+// to reach destID (e.g. to send unsolicited reports to an association target).
+// The error is one of the ErrTransmit* sentinels if the routes could not be
+// delivered to the node.
+func (s *Layer) AssignReturnRoute(ctx context.Context, nodeID, destID uint16) error {
+	return s.returnRouteRequest(ctx, protocol.FnAssignReturnRoute, append(s.nodeIDBytes(nodeID), s.nodeIDBytes(destID)...))
+}
+
+// DeleteReturnRoute deletes all return routes assigned to a node.
+func (s *Layer) DeleteReturnRoute(ctx context.Context, nodeID uint16) error {
+	return s.returnRouteRequest(ctx, protocol.FnDeleteReturnRoute, s.nodeIDBytes(nodeID))
+}
+
+// returnRouteRequest makes a request that is answered with a response saying
+// whether the operation was started, followed by a callback with the transmit
+// status.
+func (s *Layer) returnRouteRequest(ctx context.Context, functionID byte, payload []byte) error {
+
+	transmitDone := make(chan bool, 1)
+	retStatus := make(chan error, 1)
+	txStatus := make(chan *TransmitReport, 1)
+
+	request := &session.Request{
+		FunctionID:       functionID,
+		Payload:          payload,
+		HasReturn:        true,
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          transmitDone,
+		Timeout:          returnRouteTimeout,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				transmitDone <- true
+				retStatus <- err
+				return false
+			}
+
+			if ret.Payload[1] == 0 {
+				transmitDone <- true
+				retStatus <- ErrControllerBusy
+				return false
+			}
+
+			retStatus <- nil
+			return true
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			transmitDone <- true
+			txStatus <- parseTransmitReport(cbFrame.Payload)
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-retStatus:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case report := <-txStatus:
+		return report.Err()
+	case <-time.After(returnRouteTimeout):
+		return errors.New("return route timed out")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
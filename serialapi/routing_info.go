@@ -0,0 +1,64 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// nodeMaskLength is the length of a node bitmask covering node IDs 1-232.
+const nodeMaskLength = 29
+
+// GetRoutingInfo returns the neighbors of a node, as stored in the
+// controller's routing table. If removeBad is set, neighbors the controller
+// knows to be failing are left out; if removeNonRepeaters is set, neighbors
+// that can't repeat frames are left out.
+func (s *Layer) GetRoutingInfo(ctx context.Context, nodeID uint16, removeBad, removeNonRepeaters bool) (neighbors []uint16, err error) {
+
+	done := make(chan *frame.Frame, 1)
+
+	request := &session.Request{
+		FunctionID: protocol.FnGetRoutingInfo,
+		Payload:    append(s.nodeIDBytes(nodeID), boolToByte(removeBad), boolToByte(removeNonRepeaters), 0),
+		HasReturn:  true,
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- ret
+			return false
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret == nil || len(ret.Payload) < 1+nodeMaskLength {
+		return nil, errors.New("Error getting routing info")
+	}
+
+	mask := ret.Payload[1 : 1+nodeMaskLength]
+
+	neighbors = []uint16{}
+	for i := uint16(1); i <= protocol.MaxClassicNodeID; i++ {
+		if isBitSet(mask, i) {
+			neighbors = append(neighbors, i)
+		}
+	}
+
+	return neighbors, nil
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -1,9 +1,7 @@
 package serialapi
 
 import (
-	"encoding/binary"
-	"errors"
-	"fmt"
+	"context"
 	"time"
 
 	"github.com/gozwave/gozw/frame"
@@ -11,20 +9,18 @@ import (
 	"github.com/gozwave/gozw/session"
 )
 
-type transmitStatus struct {
-	Status byte
-	TxTime uint16
-}
-
-// SendData will send data to a node.
-func (s *Layer) SendData(nodeID byte, payload []byte) (txTime uint16, err error) {
+// SendData will send data to a node using the given transmit options (a
+// combination of protocol.TransmitOption* flags). The report is returned
+// whenever the controller sent a callback, even if the transmission failed, in
+// which case the error is one of the ErrTransmit* sentinels.
+func (s *Layer) SendData(ctx context.Context, nodeID uint16, payload []byte, txOptions byte) (*TransmitReport, error) {
 
-	transmitDone := make(chan bool)
-	retStatus := make(chan error)
-	txStatus := make(chan transmitStatus)
+	transmitDone := make(chan bool, 1)
+	retStatus := make(chan error, 1)
+	txStatus := make(chan *TransmitReport, 1)
 
-	payload = append([]byte{nodeID, byte(len(payload))}, payload...)
-	payload = append(payload, protocol.TransmitOptionAck)
+	payload = append(append(s.nodeIDBytes(nodeID), byte(len(payload))), payload...)
+	payload = append(payload, txOptions)
 
 	request := &session.Request{
 		FunctionID:       protocol.FnSendData,
@@ -44,7 +40,7 @@ func (s *Layer) SendData(nodeID byte, payload []byte) (txTime uint16, err error)
 
 			if ret.Payload[1] == 0 {
 				transmitDone <- true
-				retStatus <- errors.New("SendData: transmit buffer overflow")
+				retStatus <- ErrTransmitQueueFull
 			} else {
 				retStatus <- nil
 			}
@@ -53,37 +49,28 @@ func (s *Layer) SendData(nodeID byte, payload []byte) (txTime uint16, err error)
 		},
 
 		Callback: func(cbFrame frame.Frame) {
-			status := transmitStatus{}
-			status.Status = cbFrame.Payload[2]
-			if len(cbFrame.Payload) == 5 {
-				status.TxTime = binary.BigEndian.Uint16(cbFrame.Payload[3:5])
-			}
-
 			transmitDone <- true
-			txStatus <- status
+			txStatus <- parseTransmitReport(cbFrame.Payload)
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
 
-	err = <-retStatus
-	if err != nil {
-		return 0, err
+	select {
+	case err := <-retStatus:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 
-	status := <-txStatus
-	switch status.Status {
-	case protocol.TransmitCompleteOk:
-		return status.TxTime, nil
-	case protocol.TransmitCompleteNoAck:
-		return status.TxTime, errors.New("Transmit complete: no ack from destination")
-	case protocol.TransmitCompleteFail:
-		return status.TxTime, errors.New("Transmit failure: network busy/jammed")
-	case protocol.TransmitRoutingNotIdle:
-		return status.TxTime, errors.New("Transmit failure: routing not idle")
-	case protocol.TransmitCompleteNoRoute:
-		return status.TxTime, errors.New("Transmit complete: no route")
-	default:
-		return status.TxTime, fmt.Errorf("Unknown tranmission status: %d", status.Status)
+	select {
+	case report := <-txStatus:
+		return report, report.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
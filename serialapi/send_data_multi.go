@@ -0,0 +1,90 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// SendDataMulti will send data to several nodes at once with a multicast
+// frame. Multicast frames are not acknowledged by the destinations, so a
+// successful report only means the frame was sent. See SendData for the
+// transmit options, report and errors.
+func (s *Layer) SendDataMulti(ctx context.Context, nodeIDs []uint16, payload []byte, txOptions byte) (*TransmitReport, error) {
+	if len(nodeIDs) == 0 {
+		return nil, errors.New("SendDataMulti: no destination nodes")
+	}
+
+	transmitDone := make(chan bool, 1)
+	retStatus := make(chan error, 1)
+	txStatus := make(chan *TransmitReport, 1)
+
+	data := []byte{byte(len(nodeIDs))}
+	for _, nodeID := range nodeIDs {
+		data = append(data, s.nodeIDBytes(nodeID)...)
+	}
+	data = append(data, byte(len(payload)))
+	data = append(data, payload...)
+	data = append(data, txOptions)
+
+	// the frame length also counts the type, function ID, callback ID and checksum
+	if len(data)+4 > 0xFF {
+		return nil, errors.New("SendDataMulti: too many destination nodes for payload")
+	}
+
+	request := &session.Request{
+		FunctionID:       protocol.FnSendDataMulti,
+		Payload:          data,
+		HasReturn:        true,
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          transmitDone,
+		Timeout:          10 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				transmitDone <- true
+				retStatus <- err
+				return false
+			}
+
+			if ret.Payload[1] == 0 {
+				transmitDone <- true
+				retStatus <- ErrTransmitQueueFull
+			} else {
+				retStatus <- nil
+			}
+
+			return true
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			transmitDone <- true
+			txStatus <- parseTransmitReport(cbFrame.Payload)
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case err := <-retStatus:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case report := <-txStatus:
+		return report, report.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
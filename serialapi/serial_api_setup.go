@@ -0,0 +1,231 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// Powerlevel is the controller's transmit power, in tenths of a dBm.
+type Powerlevel struct {
+	// TxPower is the normal transmit power.
+	TxPower int8
+
+	// Measured0dBm is the output power measured at the antenna when TxPower
+	// is 0 dBm, which calibrates the power set.
+	Measured0dBm int8
+}
+
+// GetSupportedSetupCommands returns the Serial API setup subcommands the
+// controller supports. Once it has been called, the other setup calls return
+// ErrUnsupportedFunction for subcommands that aren't supported.
+func (s *Layer) GetSupportedSetupCommands(ctx context.Context) ([]byte, error) {
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupGetSupportedCommands)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret) < 3 {
+		return nil, errors.New("Error getting supported setup commands")
+	}
+
+	commands := []byte{}
+
+	if len(ret) > 3 {
+		// Extended bitmask, where bit n is subcommand n+1
+		mask := ret[3:]
+		for i := 0; i < len(mask)*8 && i < 0xFF; i++ {
+			if mask[i>>3]&(1<<uint(i&0x07)) != 0 {
+				commands = append(commands, byte(i+1))
+			}
+		}
+	} else {
+		// Older controllers only report the subcommands that are powers of
+		// two, as a single byte
+		for i := uint(0); i < 8; i++ {
+			if ret[2]&(1<<i) != 0 {
+				commands = append(commands, 1<<i)
+			}
+		}
+	}
+
+	s.supportedSetupCommands.Store(commands)
+
+	return commands, nil
+}
+
+// SetTxStatusReport enables or disables the extended transmit status report in
+// SendData callbacks (see TransmitReport.Extended).
+func (s *Layer) SetTxStatusReport(ctx context.Context, enable bool) error {
+	var value byte
+	if enable {
+		value = 0xFF
+	}
+
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupSetTxStatusReport, value)
+	if err != nil {
+		return err
+	}
+
+	return setupResult(ret, "Controller refused to set tx status report")
+}
+
+// GetPowerlevel returns the controller's transmit power.
+func (s *Layer) GetPowerlevel(ctx context.Context) (*Powerlevel, error) {
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupGetPowerlevel)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret) < 4 {
+		return nil, errors.New("Error getting powerlevel")
+	}
+
+	return &Powerlevel{
+		TxPower:      int8(ret[2]),
+		Measured0dBm: int8(ret[3]),
+	}, nil
+}
+
+// SetPowerlevel sets the controller's transmit power. It takes effect after a
+// soft reset.
+func (s *Layer) SetPowerlevel(ctx context.Context, powerlevel Powerlevel) error {
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupSetPowerlevel,
+		byte(powerlevel.TxPower), byte(powerlevel.Measured0dBm))
+	if err != nil {
+		return err
+	}
+
+	return setupResult(ret, "Controller refused to set powerlevel")
+}
+
+// GetMaxPayloadSize returns the largest command payload the controller can
+// send in a single frame.
+func (s *Layer) GetMaxPayloadSize(ctx context.Context) (byte, error) {
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupGetMaxPayloadSize)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ret) < 3 {
+		return 0, errors.New("Error getting max payload size")
+	}
+
+	return ret[2], nil
+}
+
+// GetRFRegion returns the controller's RF region (one of protocol.RFRegion*).
+func (s *Layer) GetRFRegion(ctx context.Context) (byte, error) {
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupGetRFRegion)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ret) < 3 {
+		return 0, errors.New("Error getting rf region")
+	}
+
+	return ret[2], nil
+}
+
+// SetRFRegion sets the controller's RF region (one of protocol.RFRegion*). It
+// takes effect after a soft reset.
+func (s *Layer) SetRFRegion(ctx context.Context, region byte) error {
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupSetRFRegion, region)
+	if err != nil {
+		return err
+	}
+
+	return setupResult(ret, "Controller refused to set rf region")
+}
+
+// SetNodeIDType selects the width of node IDs in Serial API frames
+// (protocol.NodeIDType8Bit or protocol.NodeIDType16Bit). 16-bit node IDs are
+// needed to address Z-Wave Long Range nodes; every node ID sent or received
+// afterwards uses the new width.
+func (s *Layer) SetNodeIDType(ctx context.Context, nodeIDType byte) error {
+	ret, err := s.serialAPISetup(ctx, protocol.SerialAPISetupSetNodeIDType, nodeIDType)
+	if err != nil {
+		return err
+	}
+
+	if err = setupResult(ret, "Controller refused to set node id type"); err != nil {
+		return err
+	}
+
+	atomic.StoreUint32(&s.nodeIDType, uint32(nodeIDType))
+
+	return nil
+}
+
+// setupResult checks the status byte of a setup subcommand that sets
+// something.
+func setupResult(ret []byte, message string) error {
+	if len(ret) < 3 || ret[2] == 0 {
+		return errors.New(message)
+	}
+
+	return nil
+}
+
+// supportsSetupCommand reports whether the controller supports a setup
+// subcommand. Every subcommand is assumed to be supported until
+// GetSupportedSetupCommands has been called.
+func (s *Layer) supportsSetupCommand(subcommand byte) bool {
+	commands, ok := s.supportedSetupCommands.Load().([]byte)
+	if !ok || subcommand == protocol.SerialAPISetupGetSupportedCommands {
+		return true
+	}
+
+	for _, command := range commands {
+		if command == subcommand {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serialAPISetup makes a Serial API setup request and returns the response
+// payload: [function, subcommand, data...].
+func (s *Layer) serialAPISetup(ctx context.Context, subcommand byte, payload ...byte) ([]byte, error) {
+
+	if !s.supportsSetupCommand(subcommand) {
+		return nil, ErrUnsupportedFunction{FunctionID: protocol.FnSerialAPISetup, Subcommand: subcommand}
+	}
+
+	done := make(chan *frame.Frame, 1)
+
+	request := &session.Request{
+		FunctionID: protocol.FnSerialAPISetup,
+		Payload:    append([]byte{subcommand}, payload...),
+		HasReturn:  true,
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- ret
+			return false
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret == nil || len(ret.Payload) < 2 {
+		return nil, errors.New("Error in serial api setup")
+	}
+
+	if ret.Payload[1] == protocol.SerialAPISetupUnsupported {
+		return nil, ErrUnsupportedFunction{FunctionID: protocol.FnSerialAPISetup, Subcommand: subcommand}
+	}
+
+	return ret.Payload, nil
+}
@@ -0,0 +1,55 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// SetDefault resets the controller to its factory defaults: all nodes are
+// forgotten and the controller starts a new network with a new home ID. It
+// blocks until the controller reports that the reset is done.
+func (s *Layer) SetDefault(ctx context.Context) error {
+
+	resetDone := make(chan bool, 1)
+	done := make(chan error, 1)
+
+	request := &session.Request{
+		FunctionID:       protocol.FnSetDefault,
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          resetDone,
+		Timeout:          10 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err != nil {
+				resetDone <- true
+				done <- err
+			}
+
+			return false
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			resetDone <- true
+			done <- nil
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(request.Timeout):
+		return errors.New("Factory reset timeout")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,24 +1,44 @@
 package serialapi
 
 import (
+	"context"
 	"time"
 
 	"github.com/gozwave/gozw/protocol"
 	"github.com/gozwave/gozw/session"
+	"go.uber.org/zap"
 )
 
+// softResetTimeout is how long to wait for the controller to come back after a
+// soft reset if it doesn't announce itself with FnSerialAPIReady.
+const softResetTimeout = 1500 * time.Millisecond
+
 // SoftReset will perform a  soft reset on the device.
 // WARNING: This can (and often will) cause the device to get a new USB address,
-// rendering the serial port's file descriptor invalid.
-func (s *Layer) SoftReset() {
+// rendering the serial port's file descriptor invalid. Transports created with
+// transport.Open will reopen the device when that happens.
+func (s *Layer) SoftReset(ctx context.Context) {
+
+	// discard any stale ready notification
+	select {
+	case <-s.serialAPIReady:
+	default:
+	}
 
 	request := &session.Request{
 		FunctionID: protocol.FnSerialAPISoftReset,
 		HasReturn:  false,
 	}
 
-	s.sessionLayer.MakeRequest(request)
+	if err := s.makeRequest(ctx, request); err != nil {
+		s.l.Warn("soft reset", zap.Error(err))
+		return
+	}
 
-	time.Sleep(1500 * time.Millisecond)
+	select {
+	case <-s.serialAPIReady:
+	case <-time.After(softResetTimeout):
+	case <-ctx.Done():
+	}
 
 }
@@ -0,0 +1,201 @@
+package serialapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/session"
+)
+
+// Errors returned by RequestNetworkUpdate.
+var (
+	ErrNetworkUpdateAborted  = errors.New("network update aborted")
+	ErrNetworkUpdateBusy     = errors.New("network update: SUC busy")
+	ErrNetworkUpdateDisabled = errors.New("network update: SUC disabled")
+	ErrNetworkUpdateOverflow = errors.New("network update: too many changes, replication needed")
+	ErrNoSUC                 = errors.New("no SUC in the network")
+)
+
+// GetSUCNodeID returns the node ID of the network's static update controller
+// (SUC), or 0 if there is none.
+func (s *Layer) GetSUCNodeID(ctx context.Context) (nodeID uint16, err error) {
+
+	done := make(chan *frame.Frame, 1)
+
+	request := &session.Request{
+		FunctionID: protocol.FnGetSUCNodeID,
+		HasReturn:  true,
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- ret
+			return false
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return 0, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return 0, err
+	}
+
+	if ret == nil || len(ret.Payload) < 1+len(s.nodeIDBytes(0)) {
+		return 0, errors.New("Error getting SUC node id")
+	}
+
+	nodeID, _ = s.parseNodeID(ret.Payload[1:])
+
+	return nodeID, nil
+}
+
+// SetSUCNodeID makes a controller the network's static update controller (or
+// stops it from being one if enable is false). capabilities is either 0 or
+// protocol.SUCFuncNodeIDServer to make it a SIS. Set local if nodeID is the
+// controller itself, which completes without a callback.
+func (s *Layer) SetSUCNodeID(ctx context.Context, nodeID uint16, enable bool, capabilities byte, local bool) error {
+
+	setDone := make(chan bool, 1)
+	retStatus := make(chan error, 1)
+	cbStatus := make(chan byte, 1)
+
+	request := &session.Request{
+		FunctionID:       protocol.FnSetSUCNodeID,
+		Payload:          append(s.nodeIDBytes(nodeID), boolToByte(enable), 0, capabilities),
+		HasReturn:        true,
+		ReceivesCallback: !local,
+		Lock:             !local,
+		Release:          setDone,
+		Timeout:          10 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err == nil && ret.Payload[1] == 0 {
+				err = errors.New("Controller refused to set SUC node id")
+			}
+
+			if err != nil {
+				setDone <- true
+				retStatus <- err
+				return false
+			}
+
+			retStatus <- nil
+			return !local
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			setDone <- true
+			cbStatus <- cbFrame.Payload[2]
+		},
+	}
+
+	if local {
+		// the callback id is still expected, but must be 0
+		request.Payload = append(request.Payload, 0)
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-retStatus:
+		if err != nil || local {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case status := <-cbStatus:
+		if status != protocol.SUCSetSucceeded {
+			return errors.New("Setting SUC node id failed")
+		}
+		return nil
+	case <-time.After(request.Timeout):
+		return errors.New("Setting SUC node id timed out")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RequestNetworkUpdate asks the SUC for the changes to the network since the
+// controller's last update. It blocks until the update is done.
+func (s *Layer) RequestNetworkUpdate(ctx context.Context) error {
+
+	updateDone := make(chan bool, 1)
+	retStatus := make(chan error, 1)
+	cbStatus := make(chan byte, 1)
+
+	request := &session.Request{
+		FunctionID:       protocol.FnRequestNetworkUpdate,
+		HasReturn:        true,
+		ReceivesCallback: true,
+		Lock:             true,
+		Release:          updateDone,
+		Timeout:          65 * time.Second,
+
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			if err == nil && ret.Payload[1] == 0 {
+				err = ErrNoSUC
+			}
+
+			if err != nil {
+				updateDone <- true
+				retStatus <- err
+				return false
+			}
+
+			retStatus <- nil
+			return true
+		},
+
+		Callback: func(cbFrame frame.Frame) {
+			updateDone <- true
+			cbStatus <- cbFrame.Payload[2]
+		},
+	}
+
+	if err := s.makeRequest(ctx, request); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-retStatus:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case status := <-cbStatus:
+		return networkUpdateError(status)
+	case <-time.After(request.Timeout):
+		return errors.New("Network update timed out")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func networkUpdateError(status byte) error {
+	switch status {
+	case protocol.SUCUpdateDone:
+		return nil
+	case protocol.SUCUpdateAbort:
+		return ErrNetworkUpdateAborted
+	case protocol.SUCUpdateWait:
+		return ErrNetworkUpdateBusy
+	case protocol.SUCUpdateDisabled:
+		return ErrNetworkUpdateDisabled
+	case protocol.SUCUpdateOverflow:
+		return ErrNetworkUpdateOverflow
+	default:
+		return errors.New("Unknown network update status")
+	}
+}
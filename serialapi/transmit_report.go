@@ -0,0 +1,113 @@
+package serialapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+// Errors returned by SendData for unsuccessful transmissions. The transmit
+// report is returned alongside them.
+var (
+	ErrTransmitNoAck          = errors.New("transmit complete: no ack from destination")
+	ErrTransmitFail           = errors.New("transmit failure: network busy/jammed")
+	ErrTransmitRoutingNotIdle = errors.New("transmit failure: routing not idle")
+	ErrTransmitNoRoute        = errors.New("transmit complete: no route")
+	ErrTransmitQueueFull      = errors.New("transmit failure: transmit buffer overflow")
+)
+
+// extendedReportLength is the length of the extended transmit status report
+// appended to the SendData callback by newer firmware.
+const extendedReportLength = 17
+
+// RSSI values from 125 up are markers rather than measurements: 125 means
+// below sensitivity, 126 receiver saturated and 127 not available (e.g. an
+// unused hop).
+const rssiMarkers int8 = 125
+
+// TransmitReport describes the outcome of a SendData call, as reported in the
+// controller's callback.
+type TransmitReport struct {
+	Status byte
+	TxTime time.Duration
+
+	// Extended is true if the controller appended the extended transmit status
+	// report; the remaining fields are only set if it did.
+	Extended bool
+
+	Repeaters    byte
+	AckRSSI      int8
+	RepeaterRSSI [4]int8
+	AckChannel   byte
+	TxChannel    byte
+
+	RouteSchemeState   byte
+	LastRouteRepeaters [4]byte
+	RouteSpeed         byte
+	RouteTries         byte
+	LastFailedLinkFrom byte
+	LastFailedLinkTo   byte
+}
+
+func parseTransmitReport(payload []byte) *TransmitReport {
+	report := &TransmitReport{}
+
+	if len(payload) > 2 {
+		report.Status = payload[2]
+	}
+
+	if len(payload) >= 5 {
+		report.TxTime = time.Duration(binary.BigEndian.Uint16(payload[3:5])) * 10 * time.Millisecond
+	}
+
+	if len(payload) < 5+extendedReportLength {
+		return report
+	}
+
+	ext := payload[5:]
+
+	report.Extended = true
+	report.Repeaters = ext[0]
+	report.AckRSSI = int8(ext[1])
+	for i := range report.RepeaterRSSI {
+		report.RepeaterRSSI[i] = int8(ext[2+i])
+	}
+	report.AckChannel = ext[6]
+	report.TxChannel = ext[7]
+	report.RouteSchemeState = ext[8]
+	copy(report.LastRouteRepeaters[:], ext[9:13])
+	report.RouteSpeed = ext[13] & 0x07
+	report.RouteTries = ext[14]
+	report.LastFailedLinkFrom = ext[15]
+	report.LastFailedLinkTo = ext[16]
+
+	return report
+}
+
+// Err returns the sentinel error for the transmit status, or nil if the frame
+// was delivered.
+func (r *TransmitReport) Err() error {
+	switch r.Status {
+	case protocol.TransmitCompleteOk:
+		return nil
+	case protocol.TransmitCompleteNoAck:
+		return ErrTransmitNoAck
+	case protocol.TransmitCompleteFail:
+		return ErrTransmitFail
+	case protocol.TransmitRoutingNotIdle:
+		return ErrTransmitRoutingNotIdle
+	case protocol.TransmitCompleteNoRoute:
+		return ErrTransmitNoRoute
+	default:
+		return fmt.Errorf("unknown transmission status: %d", r.Status)
+	}
+}
+
+// RSSIAvailable returns whether rssi (in dBm) is a measurement rather than one
+// of the marker values.
+func RSSIAvailable(rssi int8) bool {
+	return rssi < rssiMarkers
+}
@@ -0,0 +1,47 @@
+package serialapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransmitReport(t *testing.T) {
+	report := parseTransmitReport([]byte{protocol.FnSendData, 0x01, protocol.TransmitCompleteOk, 0x00, 0x0A})
+
+	assert.NoError(t, report.Err())
+	assert.Equal(t, 100*time.Millisecond, report.TxTime)
+	assert.False(t, report.Extended)
+}
+
+func TestParseExtendedTransmitReport(t *testing.T) {
+	report := parseTransmitReport([]byte{
+		protocol.FnSendData, 0x01, protocol.TransmitCompleteNoAck, 0x00, 0x20,
+		0x01,                   // repeaters
+		0xC4,                   // ack rssi
+		0xBF, 0x7F, 0x7F, 0x7F, // rssi per hop
+		0x00, 0x01, // ack and tx channel
+		0x05,                   // route scheme state
+		0x07, 0x00, 0x00, 0x00, // last route repeaters
+		0x02,       // route speed
+		0x03,       // route tries
+		0x07, 0x02, // last failed link
+	})
+
+	assert.Equal(t, ErrTransmitNoAck, report.Err())
+	assert.Equal(t, 320*time.Millisecond, report.TxTime)
+	assert.True(t, report.Extended)
+	assert.EqualValues(t, 1, report.Repeaters)
+	assert.EqualValues(t, -60, report.AckRSSI)
+	assert.Equal(t, [4]int8{-65, 127, 127, 127}, report.RepeaterRSSI)
+	assert.True(t, RSSIAvailable(report.RepeaterRSSI[0]))
+	assert.False(t, RSSIAvailable(report.RepeaterRSSI[1]))
+	assert.EqualValues(t, 1, report.TxChannel)
+	assert.Equal(t, [4]byte{7, 0, 0, 0}, report.LastRouteRepeaters)
+	assert.EqualValues(t, protocol.RouteSpeed40k, report.RouteSpeed)
+	assert.EqualValues(t, 3, report.RouteTries)
+	assert.EqualValues(t, 7, report.LastFailedLinkFrom)
+	assert.EqualValues(t, 2, report.LastFailedLinkTo)
+}
@@ -1,6 +1,7 @@
 package serialapi
 
 import (
+	"context"
 	"errors"
 
 	"github.com/gozwave/gozw/frame"
@@ -9,11 +10,11 @@ import (
 )
 
 // GetVersion will retrieve version information.
-func (s *Layer) GetVersion() (version *Version, err error) {
+func (s *Layer) GetVersion(ctx context.Context) (version *Version, err error) {
 
 	s.l.Debug("getting version")
 
-	done := make(chan *frame.Frame)
+	done := make(chan *frame.Frame, 1)
 
 	request := &session.Request{
 		FunctionID: protocol.FnGetVersion,
@@ -24,8 +25,14 @@ func (s *Layer) GetVersion() (version *Version, err error) {
 		},
 	}
 
-	s.sessionLayer.MakeRequest(request)
-	ret := <-done
+	if err := s.makeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	ret, err := wait(ctx, done)
+	if err != nil {
+		return nil, err
+	}
 
 	if ret == nil {
 		return nil, errors.New("Error getting version")
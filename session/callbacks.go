@@ -0,0 +1,101 @@
+package session
+
+import (
+	"time"
+)
+
+const (
+	minSequenceNumber = 1
+	maxSequenceNumber = 127
+
+	// defaultCallbackLifetime is how long a callback stays registered if the
+	// request doesn't set a Timeout.
+	defaultCallbackLifetime = 65 * time.Second
+)
+
+// callbackRegistration ties a callback ID to the request waiting for it.
+type callbackRegistration struct {
+	request *Request
+	expires time.Time
+}
+
+func (r *callbackRegistration) expired(now time.Time) bool {
+	return now.After(r.expires)
+}
+
+// registerCallback allocates a callback ID for request and registers its
+// callback until it is unregistered or expires.
+func (s *Layer) registerCallback(request *Request) byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	for id, registration := range s.callbacks {
+		if registration.expired(now) {
+			delete(s.callbacks, id)
+		}
+	}
+
+	lifetime := request.Timeout
+	if lifetime <= 0 {
+		lifetime = defaultCallbackLifetime
+	}
+
+	seqNo := s.nextSequenceNumber()
+	s.callbacks[seqNo] = &callbackRegistration{
+		request: request,
+		expires: now.Add(lifetime),
+	}
+
+	return seqNo
+}
+
+// unregisterCallback removes the callback for seqNo, unless the sequence number
+// has since been reused by another request.
+func (s *Layer) unregisterCallback(seqNo byte, request *Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if registration, ok := s.callbacks[seqNo]; ok && registration.request == request {
+		delete(s.callbacks, seqNo)
+	}
+}
+
+// lookupCallback returns the request registered for callbackID, if it hasn't
+// expired.
+func (s *Layer) lookupCallback(callbackID byte) (*Request, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	registration, ok := s.callbacks[callbackID]
+	if !ok {
+		return nil, false
+	}
+
+	if registration.expired(time.Now()) {
+		delete(s.callbacks, callbackID)
+		return nil, false
+	}
+
+	return registration.request, true
+}
+
+// nextSequenceNumber returns the next callback ID that isn't in use. If all of
+// them are, the next one is reused. Must be called with the lock held.
+func (s *Layer) nextSequenceNumber() byte {
+	for i := minSequenceNumber; i <= maxSequenceNumber; i++ {
+		if s.sequenceNumber >= maxSequenceNumber {
+			s.sequenceNumber = minSequenceNumber
+		} else {
+			s.sequenceNumber++
+		}
+
+		if _, ok := s.callbacks[s.sequenceNumber]; !ok {
+			return s.sequenceNumber
+		}
+	}
+
+	s.l.Warn("all callback ids in use, reusing one")
+
+	return s.sequenceNumber
+}
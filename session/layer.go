@@ -3,38 +3,50 @@ package session
 import (
 	"context"
 	"encoding/hex"
-	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gozwave/gozw/frame"
 	"github.com/gozwave/gozw/protocol"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 const (
-	minSequenceNumber = 1
-	maxSequenceNumber = 127
+	// responseTimeout is how long to wait for the response to a request.
+	responseTimeout = 10 * time.Second
 )
 
 // ILayer is an interface for  a  session layer.
 type ILayer interface {
-	MakeRequest(request *Request)
+	MakeRequest(ctx context.Context, request *Request)
 	SendFrameDirect(req *frame.Frame)
 	UnsolicitedFramesChan() chan frame.Frame
+	QueueDepths() map[Priority]int
+	Pause()
+	Resume()
 }
 
 // Layer contains a session layer.
 type Layer struct {
 	frameLayer        frame.ILayer
 	UnsolicitedFrames chan frame.Frame
-	lastRequestFuncID byte
 	responses         chan frame.Frame
+	requestQueue      *requestQueue
+
+	// lock guards the request/response and callback state below, which is
+	// shared by the send and receive threads.
+	lock              sync.Mutex
+	lastRequestFuncID byte
 	sequenceNumber    byte
-	callbacks         map[byte]CallbackFunc
-	requestQueue      chan *Request
+	callbacks         map[byte]*callbackRegistration
 	l                 *zap.Logger
 	ctx               context.Context
+
+	pauseLock sync.Mutex
+	paused    bool
+	resumed   chan struct{}
 }
 
 // NewSessionLayer will return a new session layer.
@@ -42,11 +54,9 @@ func NewSessionLayer(ctx context.Context, frameLayer frame.ILayer, logger *zap.L
 	session := &Layer{
 		frameLayer:        frameLayer,
 		UnsolicitedFrames: make(chan frame.Frame, 10),
-		lastRequestFuncID: 0,
 		responses:         make(chan frame.Frame, 1),
-		sequenceNumber:    0,
-		callbacks:         map[byte]CallbackFunc{},
-		requestQueue:      make(chan *Request, 10),
+		callbacks:         map[byte]*callbackRegistration{},
+		requestQueue:      newRequestQueue(10),
 		l:                 logger,
 		ctx:               ctx,
 	}
@@ -57,14 +67,79 @@ func NewSessionLayer(ctx context.Context, frameLayer frame.ILayer, logger *zap.L
 	return session
 }
 
-// MakeRequest will queue a request.
-func (s *Layer) MakeRequest(request *Request) {
-	s.requestQueue <- request
+// MakeRequest will queue a request. The request is abandoned when ctx is done:
+// if it hasn't been sent yet it is dropped, if it is waiting for a response
+// ReturnCallback receives ctx.Err(), and its callback is unregistered. Callers
+// must not block in their callbacks once they have stopped waiting.
+//
+// Requests are sent in order of the priority set on ctx with WithPriority
+// (PriorityNormal if none is set).
+func (s *Layer) MakeRequest(ctx context.Context, request *Request) {
+	request.ctx = ctx
+
+	request.priority = PriorityNormal
+	if priority, ok := PriorityFromContext(ctx); ok && priority >= 0 && priority < numPriorities {
+		request.priority = priority
+	}
+
+	s.requestQueue.push(request, s.ctx.Done())
+}
+
+// QueueDepths returns the number of queued requests for each priority.
+func (s *Layer) QueueDepths() map[Priority]int {
+	return s.requestQueue.depths()
+}
+
+// Pause stops the session layer from sending queued requests (e.g. while the
+// controller is unavailable). Requests made while paused are kept in the queue
+// and sent once Resume is called.
+func (s *Layer) Pause() {
+	s.pauseLock.Lock()
+	defer s.pauseLock.Unlock()
+
+	if !s.paused {
+		s.paused = true
+		s.resumed = make(chan struct{})
+	}
+}
+
+// Resume resumes sending queued requests after Pause.
+func (s *Layer) Resume() {
+	s.pauseLock.Lock()
+	defer s.pauseLock.Unlock()
+
+	if s.paused {
+		s.paused = false
+		close(s.resumed)
+	}
+}
+
+// waitWhilePaused blocks until the layer is not paused. It returns false if the
+// context is canceled first.
+func (s *Layer) waitWhilePaused() bool {
+	s.pauseLock.Lock()
+	paused, resumed := s.paused, s.resumed
+	s.pauseLock.Unlock()
+
+	if !paused {
+		return true
+	}
+
+	s.l.Debug("session paused")
+
+	select {
+	case <-resumed:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
 }
 
 // SendFrameDirect should only be called inside a callback.
 func (s *Layer) SendFrameDirect(req *frame.Frame) {
-	s.frameLayer.Write(req)
+	if err := s.frameLayer.Write(req); err != nil {
+		s.l.Error("sending frame", zap.Error(err))
+	}
 }
 
 // UnsolicitedFramesChan will return the unsolicited frames channel.
@@ -72,74 +147,28 @@ func (s *Layer) UnsolicitedFramesChan() chan frame.Frame {
 	return s.UnsolicitedFrames
 }
 
+// receiveThread routes received frames. Responses are matched against the
+// request waiting for one; requests are either callbacks for a registered
+// callback ID or unsolicited frames. Requests that arrive while the host is
+// waiting for a response (e.g. a node report crossing a SendData) are handled
+// the same way; the response is still expected afterwards.
 func (s *Layer) receiveThread() {
 	for {
 		select {
 		case frameIn := <-s.frameLayer.GetOutputChannel():
 			s.l.Debug("frame recieved")
 
+			if len(frameIn.Payload) == 0 {
+				s.l.Warn("received frame without payload")
+				continue
+			}
+
 			if frameIn.IsResponse() {
-				s.l.Debug("was response")
-
-				if frameIn.Payload[0] == s.lastRequestFuncID {
-					select {
-					case s.responses <- frameIn:
-					default:
-					}
-
-					s.lastRequestFuncID = 0
-				} else {
-					s.l.Warn("received an unexpected response frame",
-						zap.String("expected", fmt.Sprint(s.lastRequestFuncID)),
-						zap.String("actual", fmt.Sprint(frameIn.Payload[0])),
-					)
-				}
+				s.handleResponse(frameIn)
 			} else {
-				var callbackID byte
-
-				if s.lastRequestFuncID != 0 {
-					s.l.Warn("REQUEST/RESPONSE COLLISION; SENDING CAN FRAME AND RETRYING PREVIOUS SEND")
-					s.frameLayer.Write(frame.NewCanFrame())
-					select {
-					case s.responses <- *frame.NewCanFrame():
-					default:
-					}
-					return
-				}
-
-				switch frameIn.Payload[0] {
-
-				// These commands, when received as requests, are always callbacks and will
-				// have the callback id as the first byte after the function id
-				case protocol.FnAddNodeToNetwork,
-					protocol.FnRemoveNodeFromNetwork,
-					protocol.FnSendData,
-					protocol.FnSetDefault,
-					protocol.FnRequestNetworkUpdate,
-					protocol.FnRemoveFailingNode:
-
-					callbackID = frameIn.Payload[1]
-
-				// These commands are never callbacks and shouldn't ever be handled as such
-				case protocol.FnApplicationControllerUpdate,
-					protocol.FnApplicationCommandHandler,
-					protocol.FnApplicationCommandHandlerBridge:
-
-					callbackID = 0
-
-					// Log in case we need to set up a callback for a function
-				default:
-					s.l.Warn("got unknown callback for func: ", zap.String("callback", hex.EncodeToString([]byte{frameIn.Payload[0]})))
-					callbackID = 0
-				}
-
-				if callback, ok := s.callbacks[callbackID]; ok {
-					go callback(frameIn)
-				} else {
-					s.UnsolicitedFrames <- frameIn
-				}
-
+				s.handleRequest(frameIn)
 			}
+
 		case <-s.ctx.Done():
 			s.l.Info("stopping session receive thread")
 			return
@@ -147,87 +176,230 @@ func (s *Layer) receiveThread() {
 	}
 }
 
+func (s *Layer) handleResponse(frameIn frame.Frame) {
+	s.l.Debug("was response")
+
+	s.lock.Lock()
+	expected := s.lastRequestFuncID
+	if frameIn.Payload[0] == expected {
+		s.lastRequestFuncID = 0
+	}
+	s.lock.Unlock()
+
+	if frameIn.Payload[0] != expected {
+		s.l.Warn("received an unexpected response frame",
+			zap.String("expected", fmt.Sprint(expected)),
+			zap.String("actual", fmt.Sprint(frameIn.Payload[0])),
+		)
+		return
+	}
+
+	select {
+	case s.responses <- frameIn:
+	default:
+	}
+}
+
+func (s *Layer) handleRequest(frameIn frame.Frame) {
+	var callbackID byte
+
+	switch frameIn.Payload[0] {
+
+	// These commands, when received as requests, are always callbacks and will
+	// have the callback id as the first byte after the function id
+	case protocol.FnAddNodeToNetwork,
+		protocol.FnRemoveNodeFromNetwork,
+		protocol.FnSendData,
+		protocol.FnSendDataMulti,
+		protocol.FnSetDefault,
+		protocol.FnSetLearnMode,
+		protocol.FnSetSUCNodeID,
+		protocol.FnAssignReturnRoute,
+		protocol.FnDeleteReturnRoute,
+		protocol.FnRequestNodeNeighborUpdate,
+		protocol.FnRequestNetworkUpdate,
+		protocol.FnRemoveFailingNode,
+		protocol.FnReplaceFailedNode,
+		protocol.FnSendSlaveNodeInformation,
+		protocol.FnSetSlaveLearnMode,
+		protocol.FnSendDataBridge:
+
+		if len(frameIn.Payload) > 1 {
+			callbackID = frameIn.Payload[1]
+		}
+
+	// These commands are never callbacks and shouldn't ever be handled as such
+	case protocol.FnApplicationControllerUpdate,
+		protocol.FnApplicationCommandHandler,
+		protocol.FnApplicationCommandHandlerBridge,
+		protocol.FnSerialAPIReady:
+
+		callbackID = 0
+
+		// Log in case we need to set up a callback for a function
+	default:
+		s.l.Warn("got unknown callback for func: ", zap.String("callback", hex.EncodeToString([]byte{frameIn.Payload[0]})))
+		callbackID = 0
+	}
+
+	if callbackID != 0 {
+		if request, ok := s.lookupCallback(callbackID); ok {
+			go request.Callback(frameIn)
+			return
+		}
+
+		s.l.Warn("received callback without registration", zap.Int("callback_id", int(callbackID)))
+	}
+
+	select {
+	case s.UnsolicitedFrames <- frameIn:
+	case <-s.ctx.Done():
+	}
+}
+
 // This function currently assumes that every single function that expects a callback
 // sets the callback id as the last byte in the payload.
 func (s *Layer) sendThread() {
 	for {
-		select {
-		case request := <-s.requestQueue:
-			var seqNo byte
+		if !s.waitWhilePaused() {
+			s.l.Info("stopping session send thread")
+			return
+		}
 
-			s.l.Debug("received request")
+		request, ok := s.requestQueue.pop(s.ctx.Done())
+		if !ok {
+			s.l.Info("stopping session send thread")
+			return
+		}
 
-			if request.ReceivesCallback {
-				seqNo = s.getSequenceNumber()
-				request.Payload = append(request.Payload, seqNo)
-				s.callbacks[seqNo] = request.Callback
-			}
+		if request.ctx.Err() != nil {
+			s.l.Debug("dropping canceled request")
+			continue
+		}
 
-			if request.Payload == nil {
-				request.Payload = []byte{}
-			}
+		if !s.send(request) {
+			s.l.Info("stopping session send thread")
+			return
+		}
+	}
+}
 
-			s.l.Debug("creating request frame")
+// send sends a single request and waits for its response and, for locking
+// requests, its release. It returns false if the send thread should stop.
+func (s *Layer) send(request *Request) bool {
+	var seqNo byte
 
-			var frame = frame.NewRequestFrame(append([]byte{request.FunctionID}, request.Payload...))
-			attempts := 0
+	s.l.Debug("received request")
 
-		retry:
-			if request.HasReturn {
-				s.lastRequestFuncID = request.FunctionID
-			}
+	if request.ReceivesCallback {
+		seqNo = s.registerCallback(request)
+		request.Payload = append(request.Payload, seqNo)
+	}
+
+	request.Payload = append(request.Payload, request.Trailer...)
+
+	if request.Payload == nil {
+		request.Payload = []byte{}
+	}
+
+	s.l.Debug("creating request frame")
+
+	var frame = frame.NewRequestFrame(append([]byte{request.FunctionID}, request.Payload...))
 
-			s.l.Debug("writing frame")
-
-			s.frameLayer.Write(frame)
-
-			if request.HasReturn {
-				select {
-				case response := <-s.responses:
-					if response.IsCan() {
-						// Hopefully we won't collide again if we wait for 10ms :)
-						time.Sleep(100 * time.Millisecond)
-						if attempts > 3 {
-							s.l.Error("too many retries")
-							request.ReturnCallback(errors.New("Too many retries sending command"), nil)
-							return
-						}
-
-						attempts++
-						goto retry // https://xkcd.com/292/
-					}
-
-					if request.ReturnCallback(nil, &response) == false {
-						continue
-					}
-
-				case <-time.After(10 * time.Second):
-					if request.ReturnCallback(errors.New("Response timeout"), nil) == false {
-						continue
-					}
-				}
+	if err := s.write(request, frame); err != nil {
+		s.l.Error("frame not delivered", zap.Error(err))
+
+		s.setLastRequestFuncID(0)
+		s.unregisterCallback(seqNo, request)
+
+		if request.ReturnCallback != nil {
+			request.ReturnCallback(err, nil)
+		}
+
+		return true
+	}
+
+	if request.HasReturn {
+		select {
+		case response := <-s.responses:
+			if request.ReturnCallback(nil, &response) == false {
+				s.unregisterCallback(seqNo, request)
+				return true
 			}
 
-			if request.ReceivesCallback && request.Lock {
-				select {
-				case <-request.Release:
-				case <-time.After(request.Timeout):
-					s.l.Warn("session lock timeout")
-				}
+		case <-time.After(responseTimeout):
+			s.setLastRequestFuncID(0)
+			if request.ReturnCallback(errors.New("Response timeout"), nil) == false {
+				s.unregisterCallback(seqNo, request)
+				return true
 			}
+
+		case <-request.ctx.Done():
+			s.setLastRequestFuncID(0)
+			s.unregisterCallback(seqNo, request)
+			request.ReturnCallback(request.ctx.Err(), nil)
+			return true
+
 		case <-s.ctx.Done():
-			s.l.Info("stopping session send thread")
-			return
+			return false
+		}
+	}
+
+	if !request.ReceivesCallback {
+		return true
+	}
+
+	if !request.Lock {
+		// The callback stays registered until the caller is done with it or it
+		// expires
+		if done := request.ctx.Done(); done != nil {
+			go func() {
+				<-done
+				s.unregisterCallback(seqNo, request)
+			}()
 		}
+
+		return true
+	}
+
+	select {
+	case <-request.Release:
+	case <-time.After(request.Timeout):
+		s.l.Warn("session lock timeout")
+	case <-request.ctx.Done():
+		s.l.Debug("session lock canceled")
+	case <-s.ctx.Done():
+		return false
 	}
+
+	s.unregisterCallback(seqNo, request)
+
+	return true
 }
 
-func (s *Layer) getSequenceNumber() byte {
-	if s.sequenceNumber == maxSequenceNumber {
-		s.sequenceNumber = minSequenceNumber
-	} else {
-		s.sequenceNumber = s.sequenceNumber + 1
+// write writes the request frame. If the controller drops the frame with CAN
+// because it collided with a frame the controller was sending, the frame layer
+// handles the controller's frame and retransmits the request as specified in
+// INS12350.
+func (s *Layer) write(request *Request, fr *frame.Frame) error {
+	// Discard a late response to an earlier request
+	select {
+	case <-s.responses:
+	default:
 	}
 
-	return s.sequenceNumber
+	if request.HasReturn {
+		s.setLastRequestFuncID(request.FunctionID)
+	}
+
+	s.l.Debug("writing frame")
+
+	return s.frameLayer.Write(fr)
+}
+
+func (s *Layer) setLastRequestFuncID(functionID byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastRequestFuncID = functionID
 }
@@ -0,0 +1,187 @@
+package session
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testDevice plays the controller's end of the in-memory pipe.
+type testDevice struct {
+	t   *testing.T
+	end *testutil.PipeEnd
+}
+
+func newTestSession(t *testing.T) (*Layer, *testDevice) {
+	host, device := testutil.NewPipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	frameLayer, err := frame.NewFrameLayer(ctx, host, zap.NewNop())
+	require.NoError(t, err)
+
+	return NewSessionLayer(ctx, frameLayer, zap.NewNop()), &testDevice{t: t, end: device}
+}
+
+// receive reads a data frame from the host and replies with header (ACK, NAK
+// or CAN). It returns the frame payload.
+func (d *testDevice) receive(header byte) []byte {
+	buf := make([]byte, 2)
+	_, err := io.ReadFull(d.end, buf)
+	require.NoError(d.t, err)
+	require.EqualValues(d.t, frame.HeaderData, buf[0])
+
+	rest := make([]byte, buf[1])
+	_, err = io.ReadFull(d.end, rest)
+	require.NoError(d.t, err)
+
+	d.end.Write([]byte{header})
+
+	// strip the type and checksum
+	return rest[1 : len(rest)-1]
+}
+
+// send writes a frame to the host and waits for its ACK.
+func (d *testDevice) send(f *frame.Frame) {
+	buf, _ := f.MarshalBinary()
+	d.end.Write(buf)
+
+	ack, err := d.end.ReadByte()
+	require.NoError(d.t, err)
+	require.EqualValues(d.t, frame.HeaderAck, ack)
+}
+
+// getVersion queues a request for FnGetVersion and returns a channel that
+// receives its response.
+func getVersion(s *Layer) <-chan *frame.Frame {
+	done := make(chan *frame.Frame, 1)
+
+	s.MakeRequest(context.Background(), &Request{
+		FunctionID: protocol.FnGetVersion,
+		HasReturn:  true,
+		ReturnCallback: func(err error, ret *frame.Frame) bool {
+			done <- ret
+			return false
+		},
+	})
+
+	return done
+}
+
+func TestRequestWhileAwaitingResponse(t *testing.T) {
+	s, device := newTestSession(t)
+
+	done := getVersion(s)
+	assert.Equal(t, []byte{protocol.FnGetVersion}, device.receive(frame.HeaderAck))
+
+	// A node report arrives before the response
+	device.send(frame.NewRequestFrame([]byte{protocol.FnApplicationCommandHandler, 0x00, 0x02, 0x03, 0x25, 0x03, 0xFF}))
+	device.send(frame.NewResponseFrame([]byte{protocol.FnGetVersion, 0x01}))
+
+	select {
+	case unsolicited := <-s.UnsolicitedFramesChan():
+		assert.EqualValues(t, protocol.FnApplicationCommandHandler, unsolicited.Payload[0])
+	case <-time.After(time.Second):
+		t.Fatal("unsolicited frame not delivered")
+	}
+
+	select {
+	case ret := <-done:
+		require.NotNil(t, ret)
+		assert.Equal(t, []byte{protocol.FnGetVersion, 0x01}, ret.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("no response")
+	}
+
+	// Both threads are still running
+	done = getVersion(s)
+	device.receive(frame.HeaderAck)
+	device.send(frame.NewResponseFrame([]byte{protocol.FnGetVersion, 0x02}))
+
+	select {
+	case ret := <-done:
+		require.NotNil(t, ret)
+		assert.EqualValues(t, 0x02, ret.Payload[1])
+	case <-time.After(time.Second):
+		t.Fatal("no response to second request")
+	}
+}
+
+func TestCollidedRequestIsRetried(t *testing.T) {
+	s, device := newTestSession(t)
+
+	done := getVersion(s)
+
+	// The frame layer retransmits the request up to three times
+	for i := 0; i < 3; i++ {
+		device.receive(frame.HeaderCan)
+	}
+
+	device.receive(frame.HeaderAck)
+	device.send(frame.NewResponseFrame([]byte{protocol.FnGetVersion, 0x01}))
+
+	select {
+	case ret := <-done:
+		assert.NotNil(t, ret)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no response")
+	}
+}
+
+func TestCollidedRequestFailsAfterRetransmissions(t *testing.T) {
+	s, device := newTestSession(t)
+
+	done := getVersion(s)
+
+	for i := 0; i < 4; i++ {
+		device.receive(frame.HeaderCan)
+	}
+
+	select {
+	case ret := <-done:
+		assert.Nil(t, ret)
+	case <-time.After(2 * time.Second):
+		t.Fatal("request not failed")
+	}
+}
+
+func TestCallbackRegistration(t *testing.T) {
+	s := &Layer{callbacks: map[byte]*callbackRegistration{}, l: zap.NewNop()}
+
+	first := &Request{Timeout: time.Minute}
+	second := &Request{Timeout: time.Millisecond}
+
+	assert.EqualValues(t, 1, s.registerCallback(first))
+	assert.EqualValues(t, 2, s.registerCallback(second))
+
+	request, ok := s.lookupCallback(1)
+	assert.True(t, ok)
+	assert.True(t, request == first)
+
+	// Registrations expire
+	time.Sleep(5 * time.Millisecond)
+	_, ok = s.lookupCallback(2)
+	assert.False(t, ok)
+
+	// IDs that are still registered are skipped when the sequence wraps
+	s.sequenceNumber = maxSequenceNumber
+	assert.EqualValues(t, 2, s.registerCallback(second))
+
+	// A stale unregister doesn't remove a reused ID
+	s.unregisterCallback(1, second)
+	_, ok = s.lookupCallback(1)
+	assert.True(t, ok)
+
+	s.unregisterCallback(1, first)
+	_, ok = s.lookupCallback(1)
+	assert.False(t, ok)
+}
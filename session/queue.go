@@ -0,0 +1,154 @@
+package session
+
+import (
+	"context"
+)
+
+// Priority determines the order in which queued requests are sent.
+type Priority int
+
+const (
+	// PriorityInteractive is for requests a user is waiting on, such as
+	// turning on a light.
+	PriorityInteractive Priority = iota
+	// PriorityNormal is the default priority.
+	PriorityNormal
+	// PriorityBackground is for requests nobody is waiting on, such as node
+	// interviews.
+	PriorityBackground
+
+	numPriorities = 3
+)
+
+// maxPassedOver is the number of times a queued request may be passed over
+// for higher priority requests before it is sent anyway.
+const maxPassedOver = 5
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityNormal:
+		return "normal"
+	case PriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that makes requests use the given
+// priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set with WithPriority, if any.
+func PriorityFromContext(ctx context.Context) (Priority, bool) {
+	priority, ok := ctx.Value(priorityKey{}).(Priority)
+	return priority, ok
+}
+
+// requestQueue holds pending requests in one FIFO per priority. Higher
+// priority requests are sent first, but a lower priority request that has
+// been passed over maxPassedOver times is sent next.
+type requestQueue struct {
+	queues     [numPriorities]chan *Request
+	passedOver [numPriorities]int
+}
+
+func newRequestQueue(depth int) *requestQueue {
+	q := &requestQueue{}
+	for i := range q.queues {
+		q.queues[i] = make(chan *Request, depth)
+	}
+
+	return q
+}
+
+// push queues a request, blocking while its queue is full. It returns false if
+// the request's context is done or done is closed first.
+func (q *requestQueue) push(request *Request, done <-chan struct{}) bool {
+	select {
+	case q.queues[request.priority] <- request:
+		return true
+	case <-request.ctx.Done():
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// pop returns the next request to send, blocking until one is queued. It
+// returns false if done is closed first. pop must only be called from a
+// single goroutine.
+func (q *requestQueue) pop(done <-chan struct{}) (*Request, bool) {
+	if request := q.tryPop(); request != nil {
+		return request, true
+	}
+
+	select {
+	case request := <-q.queues[PriorityInteractive]:
+		q.taken(PriorityInteractive)
+		return request, true
+	case request := <-q.queues[PriorityNormal]:
+		q.taken(PriorityNormal)
+		return request, true
+	case request := <-q.queues[PriorityBackground]:
+		q.taken(PriorityBackground)
+		return request, true
+	case <-done:
+		return nil, false
+	}
+}
+
+func (q *requestQueue) tryPop() *Request {
+	for priority := range q.queues {
+		if q.passedOver[priority] >= maxPassedOver {
+			if request := q.popFrom(Priority(priority)); request != nil {
+				return request
+			}
+		}
+	}
+
+	for priority := range q.queues {
+		if request := q.popFrom(Priority(priority)); request != nil {
+			return request
+		}
+	}
+
+	return nil
+}
+
+func (q *requestQueue) popFrom(priority Priority) *Request {
+	select {
+	case request := <-q.queues[priority]:
+		q.taken(priority)
+		return request
+	default:
+		return nil
+	}
+}
+
+// taken records that a request with the given priority was sent while lower
+// priority requests were waiting.
+func (q *requestQueue) taken(priority Priority) {
+	q.passedOver[priority] = 0
+
+	for lower := priority + 1; lower < numPriorities; lower++ {
+		if len(q.queues[lower]) > 0 {
+			q.passedOver[lower]++
+		}
+	}
+}
+
+func (q *requestQueue) depths() map[Priority]int {
+	depths := map[Priority]int{}
+	for priority, queue := range q.queues {
+		depths[Priority(priority)] = len(queue)
+	}
+
+	return depths
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queuedRequest(priority Priority, functionID byte) *Request {
+	return &Request{FunctionID: functionID, ctx: context.Background(), priority: priority}
+}
+
+func TestRequestQueueOrdersByPriority(t *testing.T) {
+	q := newRequestQueue(10)
+	done := make(chan struct{})
+
+	q.push(queuedRequest(PriorityBackground, 1), done)
+	q.push(queuedRequest(PriorityNormal, 2), done)
+	q.push(queuedRequest(PriorityInteractive, 3), done)
+	q.push(queuedRequest(PriorityInteractive, 4), done)
+
+	assert.Equal(t, map[Priority]int{PriorityInteractive: 2, PriorityNormal: 1, PriorityBackground: 1}, q.depths())
+
+	var order []byte
+	for i := 0; i < 4; i++ {
+		request, ok := q.pop(done)
+		require.True(t, ok)
+		order = append(order, request.FunctionID)
+	}
+
+	assert.Equal(t, []byte{3, 4, 2, 1}, order)
+}
+
+func TestRequestQueuePreventsStarvation(t *testing.T) {
+	q := newRequestQueue(20)
+	done := make(chan struct{})
+
+	q.push(queuedRequest(PriorityBackground, 0xFF), done)
+	for i := 0; i < 10; i++ {
+		q.push(queuedRequest(PriorityInteractive, byte(i)), done)
+	}
+
+	var order []byte
+	for i := 0; i < 11; i++ {
+		request, ok := q.pop(done)
+		require.True(t, ok)
+		order = append(order, request.FunctionID)
+	}
+
+	// The background request is sent after being passed over maxPassedOver times
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 0xFF, 5, 6, 7, 8, 9}, order)
+}
+
+func TestRequestQueuePopStopsWhenDone(t *testing.T) {
+	q := newRequestQueue(1)
+	done := make(chan struct{})
+	close(done)
+
+	_, ok := q.pop(done)
+	assert.False(t, ok)
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"context"
 	"time"
 
 	"github.com/gozwave/gozw/frame"
@@ -16,7 +17,10 @@ type Request struct {
 	FunctionID byte
 	Payload    []byte
 
-	HasReturn      bool
+	HasReturn bool
+	// ReturnCallback receives the response to the request. It is also called
+	// with an error (even if HasReturn is false) when the request frame could
+	// not be delivered to the controller.
 	ReturnCallback func(error, *frame.Frame) bool
 
 	ReceivesCallback bool
@@ -24,4 +28,11 @@ type Request struct {
 	Lock             bool
 	Release          chan bool
 	Timeout          time.Duration
+
+	// Trailer is sent after the callback ID, for the few functions that take
+	// parameters after it.
+	Trailer []byte
+
+	ctx      context.Context
+	priority Priority
 }
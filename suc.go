@@ -0,0 +1,70 @@
+package gozw
+
+import (
+	"context"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+// GetSUCNodeID refreshes Controller.SUCNodeID from the controller and returns
+// it.
+func (c *Client) GetSUCNodeID(ctx context.Context) (uint16, error) {
+	nodeID, err := c.serialAPI.GetSUCNodeID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.setSUCNodeID(nodeID)
+
+	return nodeID, nil
+}
+
+// setSUCNodeID records a new SUC, from this client or from the controller.
+func (c *Client) setSUCNodeID(nodeID uint16) {
+	c.updateController(func(controller *Controller) {
+		controller.SUCNodeID = nodeID
+	})
+}
+
+// SetSUCNodeID makes a controller in the network the static update controller
+// (SUC), which keeps track of network changes so that secondary controllers
+// and battery devices can get route updates. If sis is set, it is also made
+// the SUC ID server (SIS), which lets other controllers include nodes.
+func (c *Client) SetSUCNodeID(ctx context.Context, nodeID uint16, sis bool) error {
+	var capabilities byte
+	if sis {
+		capabilities = protocol.SUCFuncNodeIDServer
+	}
+
+	local := nodeID == c.controller().NodeID
+
+	if err := c.serialAPI.SetSUCNodeID(ctx, nodeID, true, capabilities, local); err != nil {
+		return err
+	}
+
+	c.updateController(func(controller *Controller) {
+		controller.SUCNodeID = nodeID
+		if local {
+			controller.IsSIS = sis
+		}
+	})
+
+	return nil
+}
+
+// EnableSIS makes this controller the network's SIS.
+func (c *Client) EnableSIS(ctx context.Context) error {
+	return c.SetSUCNodeID(ctx, c.controller().NodeID, true)
+}
+
+// RequestNetworkUpdate asks the SUC for the changes to the network since the
+// last update, such as nodes added by another controller. It fails with
+// serialapi.ErrNoSUC if there is no SUC, or if this controller is the SUC.
+// Afterwards the controller is reinitialized to pick up new nodes.
+func (c *Client) RequestNetworkUpdate(ctx context.Context) error {
+	if err := c.serialAPI.RequestNetworkUpdate(ctx); err != nil {
+		return err
+	}
+
+	return c.initZWave(ctx)
+}
@@ -0,0 +1,47 @@
+package gozw
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/serialapi"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSUC(t *testing.T) {
+	controller := emulator.NewController()
+	controller.InitDataCapabilities = 0
+	controller.AddNode(emulator.NewVirtualNode(2, 0x02, 0x01, byte(cc.Security)))
+
+	client := newTestClient(t, controller)
+	ctx := context.Background()
+
+	info := client.controller()
+	assert.Zero(t, info.SUCNodeID)
+	assert.False(t, info.IsSUC())
+	assert.Equal(t, serialapi.ErrNoSUC, client.RequestNetworkUpdate(ctx))
+
+	require.NoError(t, client.EnableSIS(ctx))
+	info = client.controller()
+	assert.True(t, info.IsSUC())
+	assert.True(t, info.IsSIS)
+	assert.EqualValues(t, 1, controller.SUCNodeID)
+	assert.Contains(t, controller.Requests(), []byte{protocol.FnSetSUCNodeID, 1, 1, 0, protocol.SUCFuncNodeIDServer, 0})
+
+	// The SUC doesn't request updates from itself
+	assert.Equal(t, serialapi.ErrNoSUC, client.RequestNetworkUpdate(ctx))
+
+	require.NoError(t, client.SetSUCNodeID(ctx, 2, false))
+	info = client.controller()
+	assert.False(t, info.IsSUC())
+
+	sucNodeID, err := client.GetSUCNodeID(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, sucNodeID)
+
+	assert.NoError(t, client.RequestNetworkUpdate(ctx))
+}
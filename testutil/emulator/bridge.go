@@ -0,0 +1,175 @@
+package emulator
+
+import (
+	"sort"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+// EnableBridge makes the controller a bridge controller, which hosts virtual
+// nodes on behalf of the host.
+func (c *Controller) EnableBridge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.LibraryType = protocol.LibraryControllerBridge
+	c.virtual = map[uint16][]byte{}
+
+	c.handlers[protocol.FnSerialAPISlaveNodeInformation] = handleSlaveNodeInformation
+	c.handlers[protocol.FnSetSlaveLearnMode] = handleSetSlaveLearnMode
+	c.handlers[protocol.FnGetVirtualNodes] = handleGetVirtualNodes
+	c.handlers[protocol.FnSendSlaveNodeInformation] = handleSendSlaveNodeInformation
+	c.handlers[protocol.FnSendDataBridge] = handleSendDataBridge
+}
+
+// VirtualNodes returns the IDs of the virtual nodes hosted by the controller.
+func (c *Controller) VirtualNodes() []uint16 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	nodeIDs := []uint16{}
+	for nodeID := range c.virtual {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+
+	sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i] < nodeIDs[j] })
+
+	return nodeIDs
+}
+
+// VirtualNodeInformation returns the node information frame set by the host
+// for a virtual node (device options, device classes, command class count and
+// command classes), or nil.
+func (c *Controller) VirtualNodeInformation(nodeID uint16) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]byte(nil), c.virtual[nodeID]...)
+}
+
+// EmitBridge sends an application command from a node to one of the virtual
+// nodes.
+func (c *Controller) EmitBridge(srcNodeID, dstNodeID uint16, command []byte) {
+	payload := append([]byte{protocol.FnApplicationCommandHandlerBridge, 0x00}, c.nodeIDBytes(dstNodeID)...)
+	payload = append(payload, c.nodeIDBytes(srcNodeID)...)
+	payload = append(payload, byte(len(command)))
+
+	// No multicast node mask
+	c.Send(append(append(payload, command...), 0)...)
+}
+
+// isVirtual reports whether the controller hosts a virtual node.
+func (c *Controller) isVirtual(nodeID uint16) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.virtual[nodeID]
+	return ok
+}
+
+func handleSlaveNodeInformation(c *Controller, payload []byte) {
+	nodeID, n := c.parseNodeID(payload[1:])
+
+	c.lock.Lock()
+	if _, ok := c.virtual[nodeID]; ok {
+		c.virtual[nodeID] = append([]byte(nil), payload[1+n:]...)
+	}
+	c.lock.Unlock()
+}
+
+// handleSetSlaveLearnMode adds and removes virtual nodes locally, as a primary
+// bridge controller does. Other modes aren't emulated.
+func handleSetSlaveLearnMode(c *Controller, payload []byte) {
+	nodeID, n := c.parseNodeID(payload[1:])
+	mode := payload[1+n]
+	funcID := payload[len(payload)-1]
+
+	var newNodeID uint16
+
+	c.lock.Lock()
+	switch {
+	case mode == protocol.SlaveLearnModeAdd && nodeID == 0:
+		newNodeID = c.nextFreeNodeID(false)
+		if newNodeID != 0 {
+			c.virtual[newNodeID] = nil
+		}
+
+	case mode == protocol.SlaveLearnModeRemove:
+		if _, ok := c.virtual[nodeID]; !ok {
+			mode = protocol.SlaveLearnModeDisable
+		}
+		delete(c.virtual, nodeID)
+
+	default:
+		mode = protocol.SlaveLearnModeDisable
+	}
+	c.lock.Unlock()
+
+	if mode == protocol.SlaveLearnModeDisable || (mode == protocol.SlaveLearnModeAdd && newNodeID == 0) {
+		c.Respond(protocol.FnSetSlaveLearnMode, 0)
+		return
+	}
+
+	c.Respond(protocol.FnSetSlaveLearnMode, 1)
+
+	res := append([]byte{protocol.FnSetSlaveLearnMode, funcID, protocol.SlaveLearnStatusNodeIDDone}, c.nodeIDBytes(nodeID)...)
+	c.Send(append(res, c.nodeIDBytes(newNodeID)...)...)
+}
+
+func handleGetVirtualNodes(c *Controller, payload []byte) {
+	mask := make([]byte, 29)
+	for _, nodeID := range c.VirtualNodes() {
+		setBit(mask, nodeID)
+	}
+
+	c.Respond(append([]byte{protocol.FnGetVirtualNodes}, mask...)...)
+}
+
+func handleSendSlaveNodeInformation(c *Controller, payload []byte) {
+	srcNodeID, n := c.parseNodeID(payload[1:])
+	dstNodeID, _ := c.parseNodeID(payload[1+n:])
+	funcID := payload[len(payload)-1]
+
+	c.Respond(protocol.FnSendSlaveNodeInformation, 1)
+
+	status := protocol.TransmitCompleteOk
+	if !c.isVirtual(srcNodeID) || (dstNodeID != protocol.NodeBroadcast && c.Node(dstNodeID) == nil) {
+		status = protocol.TransmitCompleteNoAck
+	}
+
+	c.Send(protocol.FnSendSlaveNodeInformation, funcID, status)
+}
+
+// handleSendDataBridge delivers a command from the controller or one of its
+// virtual nodes to a node, whose replies are sent back to the sender.
+func handleSendDataBridge(c *Controller, payload []byte) {
+	srcNodeID, n := c.parseNodeID(payload[1:])
+	dstNodeID, m := c.parseNodeID(payload[1+n:])
+	i := 1 + n + m
+	command := payload[i+1 : i+1+int(payload[i])]
+	funcID := payload[len(payload)-1]
+
+	c.Respond(protocol.FnSendDataBridge, 1)
+
+	node := c.Node(dstNodeID)
+
+	status := protocol.TransmitCompleteOk
+	if node == nil || node.Failed || (srcNodeID != c.NodeID && !c.isVirtual(srcNodeID)) {
+		status = protocol.TransmitCompleteNoAck
+	}
+
+	var replies [][]byte
+	if status == protocol.TransmitCompleteOk {
+		replies = node.receive(command)
+	}
+
+	c.Send(protocol.FnSendDataBridge, funcID, status, 0x00, 0x0A)
+
+	for _, reply := range replies {
+		if srcNodeID == c.NodeID {
+			c.Emit(dstNodeID, reply)
+		} else {
+			c.EmitBridge(dstNodeID, srcNodeID, reply)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Package emulator provides a software Z-Wave controller that speaks the Serial
+// API frame protocol (INS12350) over an in-memory pipe, so that everything above
+// the transport can be tested without hardware.
+package emulator
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/gozwave/gozw/protocol"
+	"github.com/gozwave/gozw/testutil"
+)
+
+// ackTimeout is how long the controller waits for the host to acknowledge a
+// data frame before sending the next one (INS12350 section 6.2.2).
+const ackTimeout = 1600 * time.Millisecond
+
+// HandlerFunc handles a request frame received from the host. The payload
+// starts with the function ID. Handlers reply using Respond and Send.
+type HandlerFunc func(c *Controller, payload []byte)
+
+// Controller is an emulated Serial API controller. The exported fields describe
+// the controller and may be changed before the host starts talking to it.
+type Controller struct {
+	HomeID      uint32
+	NodeID      uint16
+	Version     string
+	LibraryType byte
+
+	ApplicationVersion  byte
+	ApplicationRevision byte
+	ManufacturerID      uint16
+	ProductType         uint16
+	ProductID           uint16
+
+	InitDataVersion      byte
+	InitDataCapabilities byte
+	ChipType             byte
+	ChipVersion          byte
+
+	// SUCNodeID is the network's static update controller, or 0.
+	SUCNodeID uint16
+
+	// SendsReady makes the controller send FnSerialAPIReady whenever it is
+	// started, as 700/800-series controllers do after a reset.
+	SendsReady bool
+
+	// Radio settings reported and changed with the Serial API setup
+	// subcommands. TxStatusReport is set when the host enables extended
+	// transmit status reports.
+	RFRegion       byte
+	TxPower        int8
+	Measured0dBm   int8
+	MaxPayloadSize byte
+	TxStatusReport bool
+
+	device *testutil.PipeEnd
+	acks   chan bool
+
+	lock       sync.Mutex
+	writeLock  sync.Mutex
+	nodes      map[uint16]*VirtualNode
+	handlers   map[byte]HandlerFunc
+	setup      map[byte]HandlerFunc
+	requests   [][]byte
+	inclusions []*VirtualNode
+	exclusions []uint16
+	including  *VirtualNode
+	excluding  *VirtualNode
+	joining    *Network
+	nvm        []byte
+	nvmOpen    bool
+	nodeInfo   []byte
+
+	// virtual holds the node information frames of the virtual nodes hosted
+	// when emulating a bridge controller, by node ID
+	virtual map[uint16][]byte
+
+	// nodeIDType is the node ID width selected by the host, accessed
+	// atomically since it is read while the lock is held.
+	nodeIDType uint32
+}
+
+// Network is another controller's network, which the emulated controller joins
+// when the host puts it in learn mode.
+type Network struct {
+	HomeID uint32
+
+	// NodeID is the node ID assigned to the emulated controller.
+	NodeID uint16
+
+	// PrimaryID is the node ID of the including controller, which must be one
+	// of Nodes.
+	PrimaryID uint16
+	Nodes     []*VirtualNode
+
+	// Commands are sent to the host by the including controller once the
+	// emulated controller has joined (e.g. to start the security key
+	// exchange).
+	Commands [][]byte
+}
+
+// NewController will return a new emulated controller with a single node (the
+// controller itself) and handlers for the basic Serial API functions.
+func NewController() *Controller {
+	c := &Controller{
+		HomeID:      0xC0FFEE01,
+		NodeID:      1,
+		Version:     "Z-Wave 4.05",
+		LibraryType: 0x01, // static controller
+
+		ApplicationVersion:  1,
+		ApplicationRevision: 0,
+		ManufacturerID:      0x0086,
+		ProductType:         0x0001,
+		ProductID:           0x005A,
+
+		InitDataVersion:      5,
+		InitDataCapabilities: 0x08, // SIS
+		ChipType:             5,
+		ChipVersion:          0,
+
+		RFRegion:       protocol.RFRegionEurope,
+		TxPower:        0,
+		Measured0dBm:   33,
+		MaxPayloadSize: 46,
+
+		acks:     make(chan bool, 1),
+		nodes:    map[uint16]*VirtualNode{},
+		handlers: map[byte]HandlerFunc{},
+		setup:    map[byte]HandlerFunc{},
+	}
+
+	c.registerDefaultHandlers()
+
+	return c
+}
+
+// Start starts serving requests from the host and returns the host end of a new
+// pipe, which satisfies transport.Transport. The controller stops when ctx is
+// canceled or the pipe is closed. Start may be called again after Unplug to
+// emulate the controller being plugged back in; the network is preserved.
+func (c *Controller) Start(ctx context.Context) *testutil.PipeEnd {
+	host, device := testutil.NewPipe()
+	incoming := make(chan []byte, 16)
+
+	c.lock.Lock()
+	c.device = device
+	c.lock.Unlock()
+
+	go c.serve(device, incoming)
+	go c.dispatch(incoming)
+	go func() {
+		<-ctx.Done()
+		device.Close()
+	}()
+
+	if c.SendsReady {
+		go c.Send(protocol.FnSerialAPIReady, 0x00)
+	}
+
+	return host
+}
+
+// Unplug closes the pipe to the host, as if the controller was unplugged.
+func (c *Controller) Unplug() {
+	c.lock.Lock()
+	device := c.device
+	c.lock.Unlock()
+
+	if device != nil {
+		device.Close()
+	}
+}
+
+// Handle registers a handler for the given function ID, replacing the default
+// handler (if any).
+func (c *Controller) Handle(functionID byte, handler HandlerFunc) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.handlers[functionID] = handler
+}
+
+// Requests returns the payloads of every request frame received from the host.
+func (c *Controller) Requests() [][]byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([][]byte(nil), c.requests...)
+}
+
+// NodeInformation returns the node information frame last set by the host
+// (device options, device classes, command class count and command classes),
+// or nil.
+func (c *Controller) NodeInformation() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]byte(nil), c.nodeInfo...)
+}
+
+// AddNode adds a virtual node to the network.
+func (c *Controller) AddNode(node *VirtualNode) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	node.controller = c
+	c.nodes[node.NodeID] = node
+}
+
+// Node returns the virtual node with the given ID, or nil.
+func (c *Controller) Node(nodeID uint16) *VirtualNode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.nodes[nodeID]
+}
+
+// Nodes returns all virtual nodes in the network.
+func (c *Controller) Nodes() []*VirtualNode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	nodes := make([]*VirtualNode, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// QueueInclusion queues a node to be found the next time the host puts the
+// controller in inclusion mode (or replace mode, in which case it takes over
+// the failed node's ID). If node.NodeID is zero, the next free ID is assigned.
+func (c *Controller) QueueInclusion(node *VirtualNode) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.inclusions = append(c.inclusions, node)
+}
+
+// QueueLearn queues a network to be joined the next time the host puts the
+// controller in learn mode. The controller's own nodes are replaced by the
+// network's, and it becomes a secondary controller.
+func (c *Controller) QueueLearn(network *Network) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.joining = network
+}
+
+// QueueExclusion queues a node to be found the next time the host puts the
+// controller in exclusion mode.
+func (c *Controller) QueueExclusion(nodeID uint16) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.exclusions = append(c.exclusions, nodeID)
+}
+
+// Emit sends an application command from a virtual node to the host.
+func (c *Controller) Emit(nodeID uint16, command []byte) {
+	payload := append([]byte{0x04, 0x00}, c.nodeIDBytes(nodeID)...)
+	payload = append(payload, byte(len(command)))
+	c.Send(append(payload, command...)...)
+}
+
+// Respond writes a response frame to the host.
+func (c *Controller) Respond(payload ...byte) {
+	c.write(frame.NewResponseFrame(payload))
+}
+
+// Send writes a request frame (a callback or an unsolicited frame) to the host.
+func (c *Controller) Send(payload ...byte) {
+	c.write(frame.NewRequestFrame(payload))
+}
+
+// write writes a data frame to the host and waits for it to be acknowledged, so
+// that frames are never sent back to back.
+func (c *Controller) write(fr *frame.Frame) {
+	buf, _ := fr.MarshalBinary()
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.lock.Lock()
+	device := c.device
+	c.lock.Unlock()
+
+	select {
+	case <-c.acks:
+	default:
+	}
+
+	if _, err := device.Write(buf); err != nil {
+		return
+	}
+
+	select {
+	case <-c.acks:
+	case <-time.After(ackTimeout):
+	}
+}
+
+// serve reads frames from the host, acknowledges them, and queues them for
+// dispatch.
+func (c *Controller) serve(device *testutil.PipeEnd, incoming chan<- []byte) {
+	defer close(incoming)
+
+	for {
+		header, err := device.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch header {
+		case frame.HeaderAck:
+			select {
+			case c.acks <- true:
+			default:
+			}
+			continue
+		case frame.HeaderData:
+		default:
+			// NAK and CAN from the host are ignored; the emulator never retransmits
+			continue
+		}
+
+		length, err := device.ReadByte()
+		if err != nil {
+			return
+		}
+
+		raw := make([]byte, int(length)+2)
+		raw[0], raw[1] = header, length
+		if _, err := io.ReadFull(device, raw[2:]); err != nil {
+			return
+		}
+
+		fr := frame.UnmarshalFrame(raw)
+		if fr.VerifyChecksum() != nil || len(fr.Payload) == 0 {
+			device.Write([]byte{frame.HeaderNak})
+			continue
+		}
+
+		device.Write([]byte{frame.HeaderAck})
+
+		payload := append([]byte(nil), fr.Payload...)
+
+		c.lock.Lock()
+		c.requests = append(c.requests, payload)
+		c.lock.Unlock()
+
+		incoming <- payload
+	}
+}
+
+// dispatch runs the handler for each frame received from the host, one at a
+// time.
+func (c *Controller) dispatch(incoming <-chan []byte) {
+	for payload := range incoming {
+		c.lock.Lock()
+		handler, ok := c.handlers[payload[0]]
+		c.lock.Unlock()
+
+		if ok {
+			handler(c, payload)
+		}
+	}
+}
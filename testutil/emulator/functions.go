@@ -0,0 +1,600 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+// serialAPIReadySoftwareReset is the FnSerialAPIReady wake up reason sent after
+// a soft reset.
+const serialAPIReadySoftwareReset byte = 0x07
+
+func (c *Controller) registerDefaultHandlers() {
+	c.handlers[protocol.FnGetVersion] = handleGetVersion
+	c.handlers[protocol.FnSerialAPIApplicationNodeInformation] = handleApplicationNodeInformation
+	c.handlers[protocol.FnSerialAPISoftReset] = handleSoftReset
+	c.handlers[protocol.FnMemoryGetID] = handleMemoryGetID
+	c.handlers[protocol.FnSerialAPIGetCapabilities] = handleGetCapabilities
+	c.handlers[protocol.FnSerialAPIGetInitAppData] = handleGetInitAppData
+	c.handlers[protocol.FnGetNodeProtocolInfo] = handleGetNodeProtocolInfo
+	c.handlers[protocol.FnIsNodeFailed] = handleIsFailedNode
+	c.handlers[protocol.FnRemoveFailingNode] = handleRemoveFailedNode
+	c.handlers[protocol.FnReplaceFailedNode] = handleReplaceFailedNode
+	c.handlers[protocol.FnRequestNodeInfo] = handleRequestNodeInfo
+	c.handlers[protocol.FnSendData] = handleSendData
+	c.handlers[protocol.FnSendDataMulti] = handleSendDataMulti
+	c.handlers[protocol.FnRequestNodeNeighborUpdate] = handleRequestNodeNeighborUpdate
+	c.handlers[protocol.FnAssignReturnRoute] = handleAssignReturnRoute
+	c.handlers[protocol.FnDeleteReturnRoute] = handleDeleteReturnRoute
+	c.handlers[protocol.FnGetRoutingInfo] = handleGetRoutingInfo
+	c.handlers[protocol.FnSetDefault] = handleSetDefault
+	c.handlers[protocol.FnSetLearnMode] = handleSetLearnMode
+	c.handlers[protocol.FnReplicationCommandComplete] = handleReplicationCommandComplete
+	c.handlers[protocol.FnGetSUCNodeID] = handleGetSUCNodeID
+	c.handlers[protocol.FnSetSUCNodeID] = handleSetSUCNodeID
+	c.handlers[protocol.FnRequestNetworkUpdate] = handleRequestNetworkUpdate
+	c.handlers[protocol.FnAddNodeToNetwork] = handleAddNode
+	c.handlers[protocol.FnRemoveNodeFromNetwork] = handleRemoveNode
+
+	c.registerSetupHandlers()
+}
+
+func handleGetVersion(c *Controller, payload []byte) {
+	version := make([]byte, 12)
+	copy(version, c.Version)
+
+	res := append([]byte{protocol.FnGetVersion}, version...)
+	c.Respond(append(res, c.LibraryType)...)
+}
+
+// handleApplicationNodeInformation stores the node information frame. The
+// function has no response.
+func handleApplicationNodeInformation(c *Controller, payload []byte) {
+	c.lock.Lock()
+	c.nodeInfo = append([]byte(nil), payload[1:]...)
+	c.lock.Unlock()
+}
+
+// handleSoftReset restarts the controller, which reverts the settings made
+// with Serial API setup that only last until a reset.
+func handleSoftReset(c *Controller, payload []byte) {
+	atomic.StoreUint32(&c.nodeIDType, uint32(protocol.NodeIDType8Bit))
+
+	c.lock.Lock()
+	c.TxStatusReport = false
+	c.lock.Unlock()
+
+	if c.SendsReady {
+		c.Send(protocol.FnSerialAPIReady, serialAPIReadySoftwareReset)
+	}
+}
+
+func handleMemoryGetID(c *Controller, payload []byte) {
+	res := make([]byte, 5)
+	res[0] = protocol.FnMemoryGetID
+	binary.BigEndian.PutUint32(res[1:5], c.HomeID)
+
+	c.Respond(append(res, c.nodeIDBytes(c.NodeID)...)...)
+}
+
+func handleGetCapabilities(c *Controller, payload []byte) {
+	c.lock.Lock()
+	supported := make([]byte, 32)
+	for functionID := range c.handlers {
+		setBit(supported, uint16(functionID))
+	}
+	c.lock.Unlock()
+
+	res := []byte{
+		protocol.FnSerialAPIGetCapabilities,
+		c.ApplicationVersion,
+		c.ApplicationRevision,
+		byte(c.ManufacturerID >> 8), byte(c.ManufacturerID),
+		byte(c.ProductType >> 8), byte(c.ProductType),
+		byte(c.ProductID >> 8), byte(c.ProductID),
+	}
+
+	c.Respond(append(res, supported...)...)
+}
+
+func handleGetInitAppData(c *Controller, payload []byte) {
+	c.lock.Lock()
+	nodes := make([]byte, 29)
+	setBit(nodes, c.NodeID)
+	for nodeID := range c.nodes {
+		setBit(nodes, nodeID)
+	}
+	for nodeID := range c.virtual {
+		setBit(nodes, nodeID)
+	}
+	c.lock.Unlock()
+
+	res := []byte{
+		protocol.FnSerialAPIGetInitAppData,
+		c.InitDataVersion,
+		c.InitDataCapabilities,
+		byte(len(nodes)),
+	}
+	res = append(res, nodes...)
+
+	c.Respond(append(res, c.ChipType, c.ChipVersion)...)
+}
+
+func handleGetNodeProtocolInfo(c *Controller, payload []byte) {
+	nodeID, _ := c.parseNodeID(payload[1:])
+	res := []byte{protocol.FnGetNodeProtocolInfo, 0, 0, 0, 0, 0, 0}
+
+	if node := c.Node(nodeID); node != nil {
+		res = []byte{
+			protocol.FnGetNodeProtocolInfo,
+			node.Capability,
+			node.Security,
+			0,
+			node.BasicDeviceClass,
+			node.GenericDeviceClass,
+			node.SpecificDeviceClass,
+		}
+	} else if nodeID == c.NodeID {
+		res = []byte{protocol.FnGetNodeProtocolInfo, 0xD3, 0x16, 0, 0x02, 0x02, 0x01}
+	}
+
+	c.Respond(res...)
+}
+
+func handleIsFailedNode(c *Controller, payload []byte) {
+	nodeID, _ := c.parseNodeID(payload[1:])
+
+	var failed byte
+	if node := c.Node(nodeID); node != nil && node.Failed {
+		failed = 1
+	}
+
+	c.Respond(protocol.FnIsNodeFailed, failed)
+}
+
+func handleRemoveFailedNode(c *Controller, payload []byte) {
+	nodeID, n := c.parseNodeID(payload[1:])
+	funcID := payload[1+n]
+
+	c.Respond(protocol.FnRemoveFailingNode, 0)
+
+	node := c.Node(nodeID)
+	if node == nil || !node.Failed {
+		c.Send(protocol.FnRemoveFailingNode, funcID, protocol.NodeOk)
+		return
+	}
+
+	c.lock.Lock()
+	delete(c.nodes, nodeID)
+	c.lock.Unlock()
+
+	c.Send(protocol.FnRemoveFailingNode, funcID, protocol.FailedNodeRemoved)
+}
+
+// handleReplaceFailedNode replaces a failed node with the next node queued for
+// inclusion, which takes over its node ID.
+func handleReplaceFailedNode(c *Controller, payload []byte) {
+	nodeID, n := c.parseNodeID(payload[1:])
+	funcID := payload[1+n]
+
+	if node := c.Node(nodeID); node == nil || !node.Failed {
+		c.Respond(protocol.FnReplaceFailedNode, 0x08)
+		return
+	}
+
+	c.Respond(protocol.FnReplaceFailedNode, 0)
+	c.Send(protocol.FnReplaceFailedNode, funcID, protocol.FailedNodeReplace)
+
+	c.lock.Lock()
+	if len(c.inclusions) == 0 {
+		c.lock.Unlock()
+		return
+	}
+
+	node := c.inclusions[0]
+	c.inclusions = c.inclusions[1:]
+	node.NodeID = nodeID
+	node.controller = c
+	c.nodes[nodeID] = node
+	c.lock.Unlock()
+
+	c.Send(protocol.FnReplaceFailedNode, funcID, protocol.FailedNodeReplaceDone)
+}
+
+func handleRequestNodeInfo(c *Controller, payload []byte) {
+	nodeID, _ := c.parseNodeID(payload[1:])
+
+	c.Respond(protocol.FnRequestNodeInfo, 1)
+
+	node := c.Node(nodeID)
+	if node == nil || node.Failed {
+		c.controllerUpdate(protocol.UpdateStateNodeInfoReqFailed, 0, nil)
+		return
+	}
+
+	c.controllerUpdate(protocol.UpdateStateNodeInfoReceived, node.NodeID, node.nodeInfo())
+}
+
+// handleSendData acknowledges the transmission, delivers the command to the
+// destination node and sends back whatever the node replies with.
+func handleSendData(c *Controller, payload []byte) {
+	nodeID, n := c.parseNodeID(payload[1:])
+	length := int(payload[1+n])
+	command := payload[2+n : 2+n+length]
+	funcID := payload[len(payload)-1]
+
+	c.Respond(protocol.FnSendData, 1)
+
+	if nodeID == protocol.NodeBroadcast || nodeID == protocol.NodeBroadcastLongRange {
+		for _, node := range c.Nodes() {
+			if protocol.IsLongRange(node.NodeID) == (nodeID == protocol.NodeBroadcastLongRange) {
+				node.receive(command)
+			}
+		}
+
+		if funcID != 0 {
+			c.Send(c.transmitStatus(funcID, protocol.TransmitCompleteOk)...)
+		}
+		return
+	}
+
+	node := c.Node(nodeID)
+
+	status := protocol.TransmitCompleteOk
+	if node == nil || node.Failed {
+		status = protocol.TransmitCompleteNoAck
+	}
+
+	var replies [][]byte
+	if status == protocol.TransmitCompleteOk {
+		replies = node.receive(command)
+	}
+
+	if funcID != 0 {
+		c.Send(c.transmitStatus(funcID, status)...)
+	}
+
+	for _, reply := range replies {
+		c.Emit(nodeID, reply)
+	}
+}
+
+// transmitStatus builds a SendData callback, with an extended transmit status
+// report (a direct route with an ack RSSI of -60 dBm) if the host enabled them.
+func (c *Controller) transmitStatus(funcID, status byte) []byte {
+	res := []byte{protocol.FnSendData, funcID, status, 0x00, 0x0A}
+
+	c.lock.Lock()
+	extended := c.TxStatusReport
+	c.lock.Unlock()
+
+	if extended {
+		res = append(res, 0, 0xC4, 0x7F, 0x7F, 0x7F, 0x7F, 0, 0, 0, 0, 0, 0, 0, 0x02, 1, 0, 0)
+	}
+
+	return res
+}
+
+// handleSendDataMulti delivers the command to every destination node. Replies
+// are dropped, as nodes don't answer multicast commands.
+func handleSendDataMulti(c *Controller, payload []byte) {
+	count := int(payload[1])
+	nodeIDs := make([]uint16, count)
+	i := 2
+	for j := range nodeIDs {
+		var n int
+		nodeIDs[j], n = c.parseNodeID(payload[i:])
+		i += n
+	}
+	length := int(payload[i])
+	command := payload[i+1 : i+1+length]
+	funcID := payload[len(payload)-1]
+
+	c.Respond(protocol.FnSendDataMulti, 1)
+
+	for _, nodeID := range nodeIDs {
+		if node := c.Node(nodeID); node != nil && !node.Failed {
+			node.receiveMulticast(command)
+		}
+	}
+
+	if funcID != 0 {
+		c.Send(protocol.FnSendDataMulti, funcID, protocol.TransmitCompleteOk)
+	}
+}
+
+func handleRequestNodeNeighborUpdate(c *Controller, payload []byte) {
+	nodeID, n := c.parseNodeID(payload[1:])
+	funcID := payload[1+n]
+
+	c.Send(protocol.FnRequestNodeNeighborUpdate, funcID, protocol.RequestNeighborUpdateStarted)
+
+	if node := c.Node(nodeID); node == nil || node.Failed {
+		c.Send(protocol.FnRequestNodeNeighborUpdate, funcID, protocol.RequestNeighborUpdateFailed)
+		return
+	}
+
+	c.Send(protocol.FnRequestNodeNeighborUpdate, funcID, protocol.RequestNeighborUpdateDone)
+}
+
+func handleAssignReturnRoute(c *Controller, payload []byte) {
+	nodeID, _ := c.parseNodeID(payload[1:])
+	handleReturnRoute(c, protocol.FnAssignReturnRoute, nodeID, payload[len(payload)-1])
+}
+
+func handleDeleteReturnRoute(c *Controller, payload []byte) {
+	nodeID, _ := c.parseNodeID(payload[1:])
+	handleReturnRoute(c, protocol.FnDeleteReturnRoute, nodeID, payload[len(payload)-1])
+}
+
+// handleReturnRoute reports that the return routes were delivered to nodeID,
+// unless it is missing or failed.
+func handleReturnRoute(c *Controller, functionID byte, nodeID uint16, funcID byte) {
+	c.Respond(functionID, 1)
+
+	status := protocol.TransmitCompleteOk
+	if node := c.Node(nodeID); node == nil || node.Failed {
+		status = protocol.TransmitCompleteNoAck
+	}
+
+	c.Send(functionID, funcID, status)
+}
+
+func handleGetRoutingInfo(c *Controller, payload []byte) {
+	nodeID, _ := c.parseNodeID(payload[1:])
+	mask := make([]byte, 29)
+
+	if node := c.Node(nodeID); node != nil {
+		for _, neighbor := range node.Neighbors {
+			setBit(mask, neighbor)
+		}
+	} else if nodeID == c.NodeID {
+		for _, node := range c.Nodes() {
+			for _, neighbor := range node.Neighbors {
+				if neighbor == c.NodeID {
+					setBit(mask, node.NodeID)
+				}
+			}
+		}
+	}
+
+	c.Respond(append([]byte{protocol.FnGetRoutingInfo}, mask...)...)
+}
+
+// handleSetDefault forgets all nodes and starts a new network.
+func handleSetDefault(c *Controller, payload []byte) {
+	funcID := payload[len(payload)-1]
+
+	c.lock.Lock()
+	c.nodes = map[uint16]*VirtualNode{}
+	c.HomeID++
+	c.NodeID = 1
+	c.InitDataCapabilities &^= 0x04
+	c.lock.Unlock()
+
+	c.Send(protocol.FnSetDefault, funcID)
+}
+
+// handleSetLearnMode joins the network queued with QueueLearn, replicating its
+// node list.
+func handleSetLearnMode(c *Controller, payload []byte) {
+	mode, funcID := payload[1], payload[len(payload)-1]
+
+	if mode == protocol.LearnModeDisable {
+		return
+	}
+
+	c.Send(c.status(protocol.FnSetLearnMode, funcID, protocol.LearnModeStatusStarted, 0)...)
+
+	c.lock.Lock()
+	network := c.joining
+	c.joining = nil
+	if network == nil {
+		c.lock.Unlock()
+		return
+	}
+
+	c.HomeID = network.HomeID
+	c.NodeID = network.NodeID
+	c.InitDataCapabilities |= 0x04 // secondary controller
+	c.nodes = map[uint16]*VirtualNode{}
+	for _, node := range network.Nodes {
+		node.controller = c
+		c.nodes[node.NodeID] = node
+	}
+	c.lock.Unlock()
+
+	// the including controller transfers its association groups
+	c.Emit(network.PrimaryID, []byte{0x21, 0x31, 0x01, 0x01, byte(network.PrimaryID)})
+
+	c.Send(c.status(protocol.FnSetLearnMode, funcID, protocol.LearnModeStatusDone, network.NodeID)...)
+
+	for _, command := range network.Commands {
+		c.Emit(network.PrimaryID, command)
+	}
+}
+
+func handleReplicationCommandComplete(c *Controller, payload []byte) {}
+
+func handleGetSUCNodeID(c *Controller, payload []byte) {
+	c.Respond(append([]byte{protocol.FnGetSUCNodeID}, c.nodeIDBytes(c.SUCNodeID)...)...)
+}
+
+// handleSetSUCNodeID sets the SUC. Setting the controller itself completes
+// without a callback; a remote SUC is announced with a controller update.
+func handleSetSUCNodeID(c *Controller, payload []byte) {
+	nodeID, n := c.parseNodeID(payload[1:])
+	enable, capabilities, funcID := payload[1+n], payload[3+n], payload[4+n]
+
+	if nodeID == c.NodeID {
+		c.lock.Lock()
+		c.SUCNodeID = 0
+		c.InitDataCapabilities &^= 0x08
+		if enable != 0 {
+			c.SUCNodeID = nodeID
+			if capabilities&protocol.SUCFuncNodeIDServer != 0 {
+				c.InitDataCapabilities |= 0x08
+			}
+		}
+		c.lock.Unlock()
+
+		c.Respond(protocol.FnSetSUCNodeID, 1)
+		return
+	}
+
+	c.Respond(protocol.FnSetSUCNodeID, 1)
+
+	if node := c.Node(nodeID); node == nil || node.Failed {
+		c.Send(protocol.FnSetSUCNodeID, funcID, protocol.SUCSetFailed)
+		return
+	}
+
+	c.SUCNodeID = nodeID
+	c.Send(protocol.FnSetSUCNodeID, funcID, protocol.SUCSetSucceeded)
+	c.controllerUpdate(protocol.UpdateStateSucID, nodeID, nil)
+}
+
+// handleRequestNetworkUpdate fails unless another node is the SUC.
+func handleRequestNetworkUpdate(c *Controller, payload []byte) {
+	funcID := payload[len(payload)-1]
+
+	if c.SUCNodeID == 0 || c.SUCNodeID == c.NodeID {
+		c.Respond(protocol.FnRequestNetworkUpdate, 0)
+		return
+	}
+
+	c.Respond(protocol.FnRequestNetworkUpdate, 1)
+	c.Send(protocol.FnRequestNetworkUpdate, funcID, protocol.SUCUpdateDone)
+}
+
+func handleAddNode(c *Controller, payload []byte) {
+	mode, funcID := payload[1]&0x0F, payload[len(payload)-1]
+
+	// SmartStart inclusion takes the DSK-derived home IDs and the protocol
+	// after the callback ID
+	longRange := false
+	if mode == protocol.AddNodeSmartStartDSK {
+		funcID = payload[2]
+		longRange = payload[len(payload)-1] == protocol.AddNodeProtocolLongRange
+	}
+
+	if mode == protocol.AddNodeStop || mode == protocol.AddNodeStopFailed {
+		c.lock.Lock()
+		node := c.including
+		c.including = nil
+		c.lock.Unlock()
+
+		if node != nil && funcID != 0 {
+			c.Send(c.status(protocol.FnAddNodeToNetwork, funcID, protocol.AddNodeStatusDone, node.NodeID)...)
+		}
+		return
+	}
+
+	c.Send(c.status(protocol.FnAddNodeToNetwork, funcID, protocol.AddNodeStatusLearnReady, 0)...)
+
+	c.lock.Lock()
+	if len(c.inclusions) == 0 {
+		c.lock.Unlock()
+		return
+	}
+
+	node := c.inclusions[0]
+	c.inclusions = c.inclusions[1:]
+	if node.NodeID == 0 {
+		node.NodeID = c.nextFreeNodeID(longRange)
+	}
+	node.controller = c
+	c.nodes[node.NodeID] = node
+	c.including = node
+	c.lock.Unlock()
+
+	c.Send(c.status(protocol.FnAddNodeToNetwork, funcID, protocol.AddNodeStatusNodeFound, 0)...)
+	c.Send(c.statusInfo(protocol.FnAddNodeToNetwork, funcID, protocol.AddNodeStatusAddingSlave, node.NodeID, node.nodeInfo())...)
+	c.Send(c.status(protocol.FnAddNodeToNetwork, funcID, protocol.AddNodeStatusProtocolDone, node.NodeID)...)
+}
+
+func handleRemoveNode(c *Controller, payload []byte) {
+	mode, funcID := payload[1]&0x0F, payload[len(payload)-1]
+
+	if mode == protocol.RemoveNodeStop {
+		c.lock.Lock()
+		node := c.excluding
+		c.excluding = nil
+		if node != nil {
+			delete(c.nodes, node.NodeID)
+		}
+		c.lock.Unlock()
+
+		if node != nil && funcID != 0 {
+			c.Send(c.status(protocol.FnRemoveNodeFromNetwork, funcID, protocol.RemoveNodeStatusDone, node.NodeID)...)
+		}
+		return
+	}
+
+	c.Send(c.status(protocol.FnRemoveNodeFromNetwork, funcID, protocol.RemoveNodeStatusLearnReady, 0)...)
+
+	c.lock.Lock()
+	if len(c.exclusions) == 0 {
+		c.lock.Unlock()
+		return
+	}
+
+	node := c.nodes[c.exclusions[0]]
+	c.exclusions = c.exclusions[1:]
+	c.excluding = node
+	c.lock.Unlock()
+
+	if node == nil {
+		return
+	}
+
+	c.Send(c.status(protocol.FnRemoveNodeFromNetwork, funcID, protocol.RemoveNodeStatusNodeFound, 0)...)
+	c.Send(c.statusInfo(protocol.FnRemoveNodeFromNetwork, funcID, protocol.RemoveNodeStatusRemovingSlave, node.NodeID, node.nodeInfo())...)
+	c.Send(c.status(protocol.FnRemoveNodeFromNetwork, funcID, protocol.RemoveNodeStatusProtocolDone, node.NodeID)...)
+}
+
+// nextFreeNodeID returns the lowest unused classic or Long Range node ID. It
+// must be called with the lock held.
+func (c *Controller) nextFreeNodeID(longRange bool) uint16 {
+	first, last := uint16(1), protocol.MaxClassicNodeID
+	if longRange {
+		first, last = protocol.MinLongRangeNodeID, protocol.MaxLongRangeNodeID
+	}
+
+	for nodeID := first; nodeID <= last; nodeID++ {
+		_, virtual := c.virtual[nodeID]
+		if _, ok := c.nodes[nodeID]; !ok && !virtual && nodeID != c.NodeID {
+			return nodeID
+		}
+	}
+
+	return 0
+}
+
+// status builds a callback frame reporting a status for a node:
+// [function, callback ID, status, node ID, 0].
+func (c *Controller) status(functionID, funcID, status byte, nodeID uint16) []byte {
+	return c.statusInfo(functionID, funcID, status, nodeID, nil)
+}
+
+// statusInfo is like status, followed by the node's info.
+func (c *Controller) statusInfo(functionID, funcID, status byte, nodeID uint16, info []byte) []byte {
+	res := append([]byte{functionID, funcID, status}, c.nodeIDBytes(nodeID)...)
+	return append(append(res, byte(len(info))), info...)
+}
+
+// controllerUpdate sends an application controller update about a node.
+func (c *Controller) controllerUpdate(status byte, nodeID uint16, info []byte) {
+	update := append([]byte{protocol.FnApplicationControllerUpdate, status}, c.nodeIDBytes(nodeID)...)
+	c.Send(append(append(update, byte(len(info))), info...)...)
+}
+
+func setBit(mask []byte, id uint16) {
+	if id == 0 || int(id-1)>>3 >= len(mask) {
+		return
+	}
+
+	mask[(id-1)>>3] |= 1 << ((id - 1) & 0x07)
+}
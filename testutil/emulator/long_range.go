@@ -0,0 +1,66 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+// longRangeSegmentLength is the length of the node bitmask returned for each
+// segment of the Long Range node list.
+const longRangeSegmentLength = 128
+
+// EnableLongRange makes the controller support Z-Wave Long Range: the host may
+// switch it to 16-bit node IDs and list its Long Range nodes, and nodes
+// included with SmartStart over Long Range get IDs from 256 up.
+func (c *Controller) EnableLongRange() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.setup[protocol.SerialAPISetupSetNodeIDType] = handleSetupSetNodeIDType
+	c.handlers[protocol.FnGetLongRangeNodes] = handleGetLongRangeNodes
+}
+
+// nodeIDBytes encodes a node ID in the width selected by the host.
+func (c *Controller) nodeIDBytes(nodeID uint16) []byte {
+	if atomic.LoadUint32(&c.nodeIDType) == uint32(protocol.NodeIDType16Bit) {
+		return []byte{byte(nodeID >> 8), byte(nodeID)}
+	}
+
+	return []byte{byte(nodeID)}
+}
+
+// parseNodeID decodes the node ID at the start of buf and returns it along with
+// the number of bytes it takes up.
+func (c *Controller) parseNodeID(buf []byte) (uint16, int) {
+	if atomic.LoadUint32(&c.nodeIDType) == uint32(protocol.NodeIDType16Bit) {
+		return binary.BigEndian.Uint16(buf), 2
+	}
+
+	return uint16(buf[0]), 1
+}
+
+func handleGetLongRangeNodes(c *Controller, payload []byte) {
+	segment := payload[1]
+	first := protocol.MinLongRangeNodeID + uint16(segment)*longRangeSegmentLength*8
+
+	mask := make([]byte, longRangeSegmentLength)
+
+	c.lock.Lock()
+	for nodeID := range c.nodes {
+		if nodeID >= first && int(nodeID-first) < len(mask)*8 {
+			i := nodeID - first
+			mask[i>>3] |= 1 << (i & 0x07)
+		}
+	}
+	c.lock.Unlock()
+
+	var more byte
+	if int(first)+len(mask)*8 <= int(protocol.MaxLongRangeNodeID) {
+		more = 1
+	}
+
+	res := []byte{protocol.FnGetLongRangeNodes, more, segment, byte(len(mask))}
+	c.Respond(append(res, mask...)...)
+}
@@ -0,0 +1,101 @@
+package emulator
+
+// VirtualNode is a node in the emulated network.
+type VirtualNode struct {
+	NodeID uint16
+
+	// Node protocol info, as returned by GetNodeProtocolInfo
+	Capability          byte
+	Security            byte
+	BasicDeviceClass    byte
+	GenericDeviceClass  byte
+	SpecificDeviceClass byte
+	CommandClasses      []byte
+
+	// Neighbors are the nodes reported by GetRoutingInfo. The controller's
+	// neighbors are the nodes that list it.
+	Neighbors []uint16
+
+	// Failed nodes never acknowledge frames and are reported by IsFailedNode.
+	Failed bool
+
+	// OnCommand, if set, is called with every command sent to the node. The node
+	// sends each returned command back to the host as an application command.
+	OnCommand func(command []byte) [][]byte
+
+	// OnMulticast, if set, is called instead of OnCommand with commands sent
+	// to the node in a multicast frame, which can't be answered.
+	OnMulticast func(command []byte)
+
+	received   [][]byte
+	controller *Controller
+}
+
+// NewVirtualNode will return a new listening node with the given device classes
+// and supported command classes.
+func NewVirtualNode(nodeID uint16, generic, specific byte, commandClasses ...byte) *VirtualNode {
+	return &VirtualNode{
+		NodeID:              nodeID,
+		Capability:          0xD3, // listening, routing, 40k
+		Security:            0x16,
+		BasicDeviceClass:    0x04, // routing slave
+		GenericDeviceClass:  generic,
+		SpecificDeviceClass: specific,
+		CommandClasses:      commandClasses,
+	}
+}
+
+// Received returns every command sent to the node.
+func (n *VirtualNode) Received() [][]byte {
+	n.controller.lock.Lock()
+	defer n.controller.lock.Unlock()
+
+	return append([][]byte(nil), n.received...)
+}
+
+// Emit sends an application command from the node to the host.
+func (n *VirtualNode) Emit(command []byte) {
+	n.controller.Emit(n.NodeID, command)
+}
+
+// EmitTo sends an application command from the node to one of the bridge
+// controller's virtual nodes.
+func (n *VirtualNode) EmitTo(dstNodeID uint16, command []byte) {
+	n.controller.EmitBridge(n.NodeID, dstNodeID, command)
+}
+
+func (n *VirtualNode) nodeInfo() []byte {
+	info := []byte{n.BasicDeviceClass, n.GenericDeviceClass, n.SpecificDeviceClass}
+	return append(info, n.CommandClasses...)
+}
+
+// receive records a command and returns the node's replies.
+func (n *VirtualNode) receive(command []byte) [][]byte {
+	n.controller.lock.Lock()
+	n.received = append(n.received, append([]byte(nil), command...))
+	onCommand := n.OnCommand
+	n.controller.lock.Unlock()
+
+	if onCommand == nil {
+		return nil
+	}
+
+	return onCommand(command)
+}
+
+// receiveMulticast records a command sent in a multicast frame.
+func (n *VirtualNode) receiveMulticast(command []byte) {
+	n.controller.lock.Lock()
+	onMulticast := n.OnMulticast
+	if onMulticast != nil {
+		n.received = append(n.received, append([]byte(nil), command...))
+	}
+	n.controller.lock.Unlock()
+
+	if onMulticast == nil {
+		n.receive(command)
+		return
+	}
+
+	onMulticast(command)
+}
@@ -0,0 +1,117 @@
+package emulator
+
+import (
+	"math/bits"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+// SetNVM gives the controller an NVM holding image, accessible with the
+// 500-series ExtNVM functions or, if backupRestore is set, the 700/800-series
+// NVM backup/restore function. With ExtNVM, the image size must be a power of
+// two.
+func (c *Controller) SetNVM(image []byte, backupRestore bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.nvm = append([]byte(nil), image...)
+
+	if backupRestore {
+		c.handlers[protocol.FnNVMBackupRestore] = handleNVMBackupRestore
+	} else {
+		c.handlers[protocol.FnNVMGetID] = handleNVMGetID
+		c.handlers[protocol.FnNVMExtReadLongBuffer] = handleNVMExtReadLongBuffer
+		c.handlers[protocol.FnNVMExtWriteLongBuffer] = handleNVMExtWriteLongBuffer
+	}
+}
+
+// NVM returns a copy of the controller's NVM image.
+func (c *Controller) NVM() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]byte(nil), c.nvm...)
+}
+
+func handleNVMGetID(c *Controller, payload []byte) {
+	c.lock.Lock()
+	sizeCode := byte(bits.TrailingZeros(uint(len(c.nvm))))
+	c.lock.Unlock()
+
+	c.Respond(protocol.FnNVMGetID, 0x00, 0x01, 0x02, sizeCode)
+}
+
+// nvmRange returns the offset and length of an ExtNVM request, and whether
+// they're within the NVM. It must be called with the lock held.
+func (c *Controller) nvmRange(payload []byte) (offset, length int, ok bool) {
+	offset = int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+	length = int(payload[4])<<8 | int(payload[5])
+
+	return offset, length, offset+length <= len(c.nvm)
+}
+
+func handleNVMExtReadLongBuffer(c *Controller, payload []byte) {
+	c.lock.Lock()
+	offset, length, ok := c.nvmRange(payload)
+	var data []byte
+	if ok {
+		data = append(data, c.nvm[offset:offset+length]...)
+	}
+	c.lock.Unlock()
+
+	c.Respond(append([]byte{protocol.FnNVMExtReadLongBuffer}, data...)...)
+}
+
+func handleNVMExtWriteLongBuffer(c *Controller, payload []byte) {
+	c.lock.Lock()
+	offset, length, ok := c.nvmRange(payload)
+	ok = ok && len(payload) == 6+length
+	if ok {
+		copy(c.nvm[offset:], payload[6:])
+	}
+	c.lock.Unlock()
+
+	if ok {
+		c.Respond(protocol.FnNVMExtWriteLongBuffer, 0x01)
+	} else {
+		c.Respond(protocol.FnNVMExtWriteLongBuffer, 0x00)
+	}
+}
+
+func handleNVMBackupRestore(c *Controller, payload []byte) {
+	operation, length := payload[1], int(payload[2])
+	offset := int(payload[3])<<8 | int(payload[4])
+
+	c.lock.Lock()
+	status, res := protocol.NVMBackupRestoreStatusOK, []byte{}
+
+	switch {
+	case operation == protocol.NVMBackupRestoreOpen:
+		c.nvmOpen = true
+		offset = len(c.nvm)
+
+	case operation == protocol.NVMBackupRestoreClose:
+		c.nvmOpen = false
+		offset = 0
+
+	case !c.nvmOpen || offset > len(c.nvm):
+		status = 0x01
+
+	case operation == protocol.NVMBackupRestoreRead:
+		if offset+length >= len(c.nvm) {
+			length = len(c.nvm) - offset
+			status = protocol.NVMBackupRestoreStatusEndOfFile
+		}
+		res = append(res, c.nvm[offset:offset+length]...)
+
+	case operation == protocol.NVMBackupRestoreWrite:
+		if offset+length > len(c.nvm) || len(payload) != 5+length {
+			status = 0x01
+			break
+		}
+		copy(c.nvm[offset:], payload[5:])
+	}
+	c.lock.Unlock()
+
+	c.Respond(append([]byte{protocol.FnNVMBackupRestore, status, byte(len(res)), byte(offset >> 8), byte(offset)}, res...)...)
+}
@@ -0,0 +1,109 @@
+package emulator
+
+import (
+	"sync/atomic"
+
+	"github.com/gozwave/gozw/protocol"
+)
+
+func (c *Controller) registerSetupHandlers() {
+	c.handlers[protocol.FnSerialAPISetup] = handleSerialAPISetup
+
+	c.setup[protocol.SerialAPISetupGetSupportedCommands] = handleSetupGetSupportedCommands
+	c.setup[protocol.SerialAPISetupSetTxStatusReport] = handleSetupSetTxStatusReport
+	c.setup[protocol.SerialAPISetupGetPowerlevel] = handleSetupGetPowerlevel
+	c.setup[protocol.SerialAPISetupSetPowerlevel] = handleSetupSetPowerlevel
+	c.setup[protocol.SerialAPISetupGetMaxPayloadSize] = handleSetupGetMaxPayloadSize
+	c.setup[protocol.SerialAPISetupGetRFRegion] = handleSetupGetRFRegion
+	c.setup[protocol.SerialAPISetupSetRFRegion] = handleSetupSetRFRegion
+}
+
+// HandleSetup registers a handler for the given Serial API setup subcommand,
+// replacing the default handler (if any). A nil handler makes the subcommand
+// unsupported.
+func (c *Controller) HandleSetup(subcommand byte, handler HandlerFunc) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if handler == nil {
+		delete(c.setup, subcommand)
+		return
+	}
+
+	c.setup[subcommand] = handler
+}
+
+// handleSerialAPISetup runs the handler for the subcommand in payload[1], or
+// reports it as unsupported.
+func handleSerialAPISetup(c *Controller, payload []byte) {
+	c.lock.Lock()
+	handler, ok := c.setup[payload[1]]
+	c.lock.Unlock()
+
+	if !ok {
+		c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupUnsupported, payload[1])
+		return
+	}
+
+	handler(c, payload)
+}
+
+// handleSetupGetSupportedCommands reports the subcommands with handlers, both
+// as the legacy single byte and as the extended bitmask.
+func handleSetupGetSupportedCommands(c *Controller, payload []byte) {
+	var legacy byte
+	mask := make([]byte, 32)
+
+	c.lock.Lock()
+	for subcommand := range c.setup {
+		if subcommand&(subcommand-1) == 0 {
+			legacy |= subcommand
+		}
+		setBit(mask, uint16(subcommand))
+	}
+	c.lock.Unlock()
+
+	res := []byte{protocol.FnSerialAPISetup, protocol.SerialAPISetupGetSupportedCommands, legacy}
+	c.Respond(append(res, mask...)...)
+}
+
+func handleSetupSetTxStatusReport(c *Controller, payload []byte) {
+	c.lock.Lock()
+	c.TxStatusReport = payload[2] != 0
+	c.lock.Unlock()
+
+	c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupSetTxStatusReport, 1)
+}
+
+func handleSetupGetPowerlevel(c *Controller, payload []byte) {
+	c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupGetPowerlevel, byte(c.TxPower), byte(c.Measured0dBm))
+}
+
+func handleSetupSetPowerlevel(c *Controller, payload []byte) {
+	c.lock.Lock()
+	c.TxPower, c.Measured0dBm = int8(payload[2]), int8(payload[3])
+	c.lock.Unlock()
+
+	c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupSetPowerlevel, 1)
+}
+
+func handleSetupGetMaxPayloadSize(c *Controller, payload []byte) {
+	c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupGetMaxPayloadSize, c.MaxPayloadSize)
+}
+
+func handleSetupGetRFRegion(c *Controller, payload []byte) {
+	c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupGetRFRegion, c.RFRegion)
+}
+
+func handleSetupSetRFRegion(c *Controller, payload []byte) {
+	c.lock.Lock()
+	c.RFRegion = payload[2]
+	c.lock.Unlock()
+
+	c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupSetRFRegion, 1)
+}
+
+func handleSetupSetNodeIDType(c *Controller, payload []byte) {
+	atomic.StoreUint32(&c.nodeIDType, uint32(payload[2]))
+	c.Respond(protocol.FnSerialAPISetup, protocol.SerialAPISetupSetNodeIDType, 1)
+}
@@ -0,0 +1,105 @@
+package testutil
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrPipeClosed is returned when reading from or writing to a closed pipe.
+var ErrPipeClosed = errors.New("pipe closed")
+
+// pipeBuffer is an unbounded byte queue. Reads block until data is available.
+type pipeBuffer struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	buf := &pipeBuffer{}
+	buf.cond = sync.NewCond(&buf.lock)
+	return buf
+}
+
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for len(b.data) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+
+	return n, nil
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return 0, ErrPipeClosed
+	}
+
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+
+	return len(p), nil
+}
+
+func (b *pipeBuffer) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// PipeEnd is one end of an in-memory, buffered, full-duplex pipe. Unlike
+// net.Pipe, writes never block, so both ends can write at the same time (as a
+// host and a Z-Wave controller do).
+type PipeEnd struct {
+	in, out *pipeBuffer
+}
+
+// NewPipe returns both ends of a new pipe. Bytes written to one end can be read
+// from the other.
+func NewPipe() (*PipeEnd, *PipeEnd) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &PipeEnd{in: a, out: b}, &PipeEnd{in: b, out: a}
+}
+
+// Read implements io.Reader.
+func (p *PipeEnd) Read(buf []byte) (int, error) {
+	return p.in.read(buf)
+}
+
+// ReadByte implements io.ByteReader.
+func (p *PipeEnd) ReadByte() (byte, error) {
+	buf := make([]byte, 1)
+	if _, err := p.in.read(buf); err != nil {
+		return 0, err
+	}
+
+	return buf[0], nil
+}
+
+// Write implements io.Writer.
+func (p *PipeEnd) Write(buf []byte) (int, error) {
+	return p.out.write(buf)
+}
+
+// Close closes both directions of the pipe. Pending and future reads on either
+// end return io.EOF once any buffered data has been read.
+func (p *PipeEnd) Close() {
+	p.in.close()
+	p.out.close()
+}
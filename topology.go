@@ -0,0 +1,286 @@
+package gozw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gozwave/gozw/protocol"
+	"github.com/pkg/errors"
+)
+
+// NodeStatus describes how a node can be reached.
+type NodeStatus string
+
+// Node statuses, derived from the node's protocol info.
+const (
+	NodeStatusListening NodeStatus = "listening"
+	NodeStatusFLiRS     NodeStatus = "flirs"
+	NodeStatusSleeping  NodeStatus = "sleeping"
+)
+
+// TopologyNode is a node in the network topology.
+type TopologyNode struct {
+	NodeID    uint16
+	Status    NodeStatus
+	Neighbors []uint16
+}
+
+// Topology is the neighbor graph of the network, as known to the controller.
+type Topology struct {
+	ControllerID uint16
+
+	// Nodes is ordered by node ID.
+	Nodes []*TopologyNode
+}
+
+// Topology retrieves the neighbors of every node in the controller's node list
+// from its routing table. Long Range nodes aren't in the routing table; they
+// only ever talk to the controller directly, which is their one neighbor.
+func (c *Client) Topology(ctx context.Context) (*Topology, error) {
+	controller := c.controller()
+	topology := &Topology{ControllerID: controller.NodeID}
+
+	for _, nodeID := range controller.NodeList {
+		neighbors := []uint16{controller.NodeID}
+
+		if !protocol.IsLongRange(nodeID) {
+			var err error
+			neighbors, err = c.serialAPI.GetRoutingInfo(ctx, nodeID, false, false)
+			if err != nil {
+				return nil, errors.Wrapf(err, "get routing info for node %d", nodeID)
+			}
+		}
+
+		topology.Nodes = append(topology.Nodes, &TopologyNode{
+			NodeID:    nodeID,
+			Status:    c.nodeStatus(nodeID),
+			Neighbors: neighbors,
+		})
+	}
+
+	sort.Slice(topology.Nodes, func(i, j int) bool { return topology.Nodes[i].NodeID < topology.Nodes[j].NodeID })
+
+	return topology, nil
+}
+
+func (c *Client) nodeStatus(nodeID uint16) NodeStatus {
+	if nodeID == c.controller().NodeID {
+		return NodeStatusListening
+	}
+
+	node, err := c.Node(nodeID)
+	switch {
+	case err != nil:
+		return NodeStatusSleeping
+	case node.IsListening():
+		return NodeStatusListening
+	case node.IsFLiRS():
+		return NodeStatusFLiRS
+	default:
+		return NodeStatusSleeping
+	}
+}
+
+// Node returns the node with the given ID, or nil.
+func (t *Topology) Node(nodeID uint16) *TopologyNode {
+	for _, node := range t.Nodes {
+		if node.NodeID == nodeID {
+			return node
+		}
+	}
+
+	return nil
+}
+
+// Links returns each pair of neighbors once, lower node ID first. Nodes are
+// linked if either of them lists the other as a neighbor.
+func (t *Topology) Links() [][2]uint16 {
+	seen := map[[2]uint16]bool{}
+	links := [][2]uint16{}
+
+	for _, node := range t.Nodes {
+		for _, neighbor := range node.Neighbors {
+			link := [2]uint16{node.NodeID, neighbor}
+			if neighbor < node.NodeID {
+				link = [2]uint16{neighbor, node.NodeID}
+			}
+
+			if !seen[link] && link[0] != link[1] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		if links[i][0] != links[j][0] {
+			return links[i][0] < links[j][0]
+		}
+		return links[i][1] < links[j][1]
+	})
+
+	return links
+}
+
+// Isolated returns the nodes that have no path to the controller.
+func (t *Topology) Isolated() []uint16 {
+	reachable := t.reachable(0)
+
+	isolated := []uint16{}
+	for _, node := range t.Nodes {
+		if !reachable[node.NodeID] {
+			isolated = append(isolated, node.NodeID)
+		}
+	}
+
+	return isolated
+}
+
+// SinglePointsOfFailure returns the nodes that every path from the controller
+// to some other node goes through: if one of them fails, the nodes behind it
+// are cut off.
+func (t *Topology) SinglePointsOfFailure() []uint16 {
+	reachable := len(t.reachable(0))
+
+	points := []uint16{}
+	for _, node := range t.Nodes {
+		if node.NodeID == t.ControllerID {
+			continue
+		}
+
+		if len(t.reachable(node.NodeID)) < reachable-1 {
+			points = append(points, node.NodeID)
+		}
+	}
+
+	return points
+}
+
+// reachable returns the nodes that can be reached from the controller without
+// going through the excluded node (0 excludes none).
+func (t *Topology) reachable(excluded uint16) map[uint16]bool {
+	adjacent := map[uint16][]uint16{}
+	for _, link := range t.Links() {
+		adjacent[link[0]] = append(adjacent[link[0]], link[1])
+		adjacent[link[1]] = append(adjacent[link[1]], link[0])
+	}
+
+	reached := map[uint16]bool{t.ControllerID: true}
+	queue := []uint16{t.ControllerID}
+
+	for len(queue) > 0 {
+		nodeID := queue[0]
+		queue = queue[1:]
+
+		for _, neighbor := range adjacent[nodeID] {
+			if neighbor != excluded && !reached[neighbor] {
+				reached[neighbor] = true
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+
+	return reached
+}
+
+// DOT returns the topology as an undirected Graphviz graph. Listening nodes are
+// drawn solid, FLiRS nodes dashed and sleeping nodes dotted; isolated nodes
+// are red and single points of failure orange.
+func (t *Topology) DOT() string {
+	isolated := idSet(t.Isolated())
+	points := idSet(t.SinglePointsOfFailure())
+
+	var buf bytes.Buffer
+	buf.WriteString("graph zwave {\n")
+
+	for _, node := range t.Nodes {
+		attrs := fmt.Sprintf("label=\"%d\\n%s\"", node.NodeID, node.Status)
+
+		switch node.Status {
+		case NodeStatusFLiRS:
+			attrs += ", style=dashed"
+		case NodeStatusSleeping:
+			attrs += ", style=dotted"
+		}
+
+		switch {
+		case node.NodeID == t.ControllerID:
+			attrs += ", shape=doublecircle"
+		case isolated[node.NodeID]:
+			attrs += ", color=red"
+		case points[node.NodeID]:
+			attrs += ", color=orange"
+		}
+
+		fmt.Fprintf(&buf, "  %d [%s];\n", node.NodeID, attrs)
+	}
+
+	for _, link := range t.Links() {
+		fmt.Fprintf(&buf, "  %d -- %d;\n", link[0], link[1])
+	}
+
+	buf.WriteString("}\n")
+
+	return buf.String()
+}
+
+type jsonTopologyNode struct {
+	NodeID    int        `json:"node_id"`
+	Status    NodeStatus `json:"status"`
+	Neighbors []int      `json:"neighbors"`
+}
+
+type jsonTopology struct {
+	ControllerID          int                `json:"controller_id"`
+	Nodes                 []jsonTopologyNode `json:"nodes"`
+	Links                 [][2]int           `json:"links"`
+	Isolated              []int              `json:"isolated"`
+	SinglePointsOfFailure []int              `json:"single_points_of_failure"`
+}
+
+// MarshalJSON encodes the topology along with its links, isolated nodes and
+// single points of failure. Node IDs are encoded as numbers.
+func (t *Topology) MarshalJSON() ([]byte, error) {
+	out := jsonTopology{
+		ControllerID:          int(t.ControllerID),
+		Nodes:                 []jsonTopologyNode{},
+		Links:                 [][2]int{},
+		Isolated:              idsToInts(t.Isolated()),
+		SinglePointsOfFailure: idsToInts(t.SinglePointsOfFailure()),
+	}
+
+	for _, node := range t.Nodes {
+		out.Nodes = append(out.Nodes, jsonTopologyNode{
+			NodeID:    int(node.NodeID),
+			Status:    node.Status,
+			Neighbors: idsToInts(node.Neighbors),
+		})
+	}
+
+	for _, link := range t.Links() {
+		out.Links = append(out.Links, [2]int{int(link[0]), int(link[1])})
+	}
+
+	return json.Marshal(out)
+}
+
+func idSet(ids []uint16) map[uint16]bool {
+	set := map[uint16]bool{}
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
+
+func idsToInts(ids []uint16) []int {
+	ints := make([]int, len(ids))
+	for i, id := range ids {
+		ints[i] = int(id)
+	}
+
+	return ints
+}
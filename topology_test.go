@@ -0,0 +1,69 @@
+package gozw
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/gozwave/gozw/cc"
+	"github.com/gozwave/gozw/testutil/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTopology(t *testing.T) {
+	controller := emulator.NewController()
+
+	hop := emulator.NewVirtualNode(2, 0x10, 0x01, byte(cc.SwitchBinary))
+	hop.Neighbors = []uint16{1, 3}
+	controller.AddNode(hop)
+
+	relay := emulator.NewVirtualNode(3, 0x10, 0x01, byte(cc.SwitchBinary))
+	relay.Neighbors = []uint16{2, 4}
+	controller.AddNode(relay)
+
+	lock := emulator.NewVirtualNode(4, 0x40, 0x03)
+	lock.Capability = 0x53
+	lock.Security = 0x5C
+	lock.Neighbors = []uint16{3}
+	controller.AddNode(lock)
+
+	sensor := emulator.NewVirtualNode(5, 0x20, 0x01)
+	sensor.Capability = 0x53
+	controller.AddNode(sensor)
+
+	client := newTestClient(t, controller)
+
+	topology, err := client.Topology(context.Background())
+	require.NoError(t, err)
+	require.Len(t, topology.Nodes, 5)
+
+	assert.Equal(t, []uint16{2}, topology.Node(1).Neighbors)
+	assert.Equal(t, NodeStatusListening, topology.Node(2).Status)
+	assert.Equal(t, NodeStatusFLiRS, topology.Node(4).Status)
+	assert.Equal(t, NodeStatusSleeping, topology.Node(5).Status)
+
+	assert.Equal(t, [][2]uint16{{1, 2}, {2, 3}, {3, 4}}, topology.Links())
+	assert.Equal(t, []uint16{5}, topology.Isolated())
+	assert.Equal(t, []uint16{2, 3}, topology.SinglePointsOfFailure())
+
+	dot := topology.DOT()
+	assert.Contains(t, dot, "graph zwave {")
+	assert.Contains(t, dot, "  2 -- 3;")
+	assert.Contains(t, dot, "  4 [label=\"4\\nflirs\", style=dashed];")
+	assert.Contains(t, dot, "  5 [label=\"5\\nsleeping\", style=dotted, color=red];")
+
+	data, err := json.Marshal(topology)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Nodes []struct {
+			NodeID    int   `json:"node_id"`
+			Neighbors []int `json:"neighbors"`
+		} `json:"nodes"`
+		Isolated []int `json:"isolated"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, []int{1, 3}, decoded.Nodes[1].Neighbors)
+	assert.Equal(t, []int{5}, decoded.Isolated)
+}
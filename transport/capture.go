@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Direction indicates which way captured bytes were travelling.
+type Direction byte
+
+const (
+	// Inbound bytes were read from the controller.
+	Inbound Direction = iota
+	// Outbound bytes were written to the controller.
+	Outbound
+)
+
+// A capture file is plain text so that it can be attached to bug reports and
+// read (or trimmed) by hand. It starts with a header line, followed by one
+// record per line:
+//
+//	<seconds since start> <rx|tx> <hex encoded bytes>
+//
+// Lines starting with '#' are comments.
+const captureHeader = "# gozw capture v1"
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "tx"
+	}
+
+	return "rx"
+}
+
+// CaptureRecord is a single entry in a capture file.
+type CaptureRecord struct {
+	// Offset is the time elapsed since the start of the capture.
+	Offset    time.Duration
+	Direction Direction
+	Data      []byte
+}
+
+// String formats the record as a line of a capture file.
+func (r CaptureRecord) String() string {
+	return fmt.Sprintf("%.6f %s %s", r.Offset.Seconds(), r.Direction, hex.EncodeToString(r.Data))
+}
+
+// ReadCapture parses a capture file.
+func ReadCapture(reader io.Reader) ([]CaptureRecord, error) {
+	records := []CaptureRecord{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, errors.Errorf("line %d: expected 3 fields, got %d", lineNo, len(fields))
+		}
+
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d: parse offset", lineNo)
+		}
+
+		var direction Direction
+		switch fields[1] {
+		case "rx":
+			direction = Inbound
+		case "tx":
+			direction = Outbound
+		default:
+			return nil, errors.Errorf("line %d: unknown direction %q", lineNo, fields[1])
+		}
+
+		data, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d: decode data", lineNo)
+		}
+
+		records = append(records, CaptureRecord{
+			Offset:    time.Duration(seconds * float64(time.Second)),
+			Direction: direction,
+			Data:      data,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read capture")
+	}
+
+	return records, nil
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gozwave/gozw/frame"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type bufferTransport struct {
+	bytes.Buffer
+	written bytes.Buffer
+}
+
+func (b *bufferTransport) Write(buf []byte) (int, error) {
+	return b.written.Write(buf)
+}
+
+func (b *bufferTransport) Close() {}
+
+func TestRecorderWritesCapture(t *testing.T) {
+	t.Parallel()
+
+	inner := &bufferTransport{}
+	inner.Buffer.Write([]byte{0x01, 0x04, 0x01, 0x13, 0x01, 0xe8})
+
+	output := &bytes.Buffer{}
+	recorder := NewRecorder(inner, output)
+
+	recorder.Write([]byte{0x01, 0x04, 0x00, 0x13, 0x01, 0xe9})
+	for i := 0; i < 6; i++ {
+		_, err := recorder.ReadByte()
+		require.NoError(t, err)
+	}
+	recorder.Write([]byte{0x06})
+	recorder.Close()
+
+	assert.NoError(t, recorder.Err())
+	assert.True(t, strings.HasPrefix(output.String(), captureHeader))
+
+	records, err := ReadCapture(output)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, Outbound, records[0].Direction)
+	assert.Equal(t, []byte{0x01, 0x04, 0x00, 0x13, 0x01, 0xe9}, records[0].Data)
+	assert.Equal(t, Inbound, records[1].Direction)
+	assert.Equal(t, []byte{0x01, 0x04, 0x01, 0x13, 0x01, 0xe8}, records[1].Data)
+	assert.Equal(t, Outbound, records[2].Direction)
+	assert.Equal(t, []byte{0x06}, records[2].Data)
+}
+
+func TestReplayThroughFrameLayer(t *testing.T) {
+	t.Parallel()
+
+	capture := strings.NewReader(captureHeader + `
+0.000000 rx 0104011301e8
+0.050000 tx 06
+`)
+
+	replay, err := NewReplayTransport(capture)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, _ := zap.NewProduction()
+
+	frameLayer, err := frame.NewFrameLayer(ctx, replay, logger)
+	require.NoError(t, err)
+
+	fr := <-frameLayer.GetOutputChannel()
+	assert.True(t, fr.IsResponse())
+	assert.EqualValues(t, 0x13, fr.Payload[0])
+
+	select {
+	case <-replay.Done():
+	case <-time.After(time.Second):
+		t.Fatal("replay did not complete")
+	}
+
+	assert.NoError(t, replay.Err())
+}
+
+func TestReplayReportsMismatch(t *testing.T) {
+	t.Parallel()
+
+	replay, err := NewReplayTransportFromRecords([]CaptureRecord{
+		{Direction: Outbound, Data: []byte{0x01, 0x03, 0x00, 0x15, 0xe9}},
+		{Offset: 10 * time.Millisecond, Direction: Inbound, Data: []byte{0x06}},
+	})
+	require.NoError(t, err)
+
+	replay.Write([]byte{0x01, 0x03, 0x00, 0x07, 0xfb})
+
+	byt, err := replay.ReadByte()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0x06, byt)
+
+	assert.Len(t, replay.Mismatches(), 1)
+	assert.Error(t, replay.Err())
+}
+
+func TestReplayRejectsEmptyInboundRecord(t *testing.T) {
+	_, err := NewReplayTransportFromRecords([]CaptureRecord{
+		{Direction: Outbound, Data: []byte{0x06}},
+		{Direction: Inbound, Data: []byte{}},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Transport is implemented by every transport. The frame layer requires both
+// io.ReadWriter and io.ByteReader.
+type Transport interface {
+	io.ReadWriter
+	io.ByteReader
+	Close()
+}
+
+// Open will return a transport for the given address. Addresses of the form
+// tcp://host:port open a raw TCP connection and rfc2217://host:port open a
+// telnet COM port control connection (as provided by ser2net). Anything else is
+// treated as the path to a local serial device.
+//
+// The returned transport is a ReconnectingTransport, so it is transparently
+// reopened if the device is unplugged or the connection drops.
+func Open(address string, baud int) (*ReconnectingTransport, error) {
+	open, err := opener(address, baud)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReconnectingTransport(open)
+}
+
+func opener(address string, baud int) (OpenFunc, error) {
+	if !strings.Contains(address, "://") {
+		return func() (Transport, error) {
+			return NewSerialPortTransport(address, baud)
+		}, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse transport address")
+	}
+
+	if u.Host == "" {
+		return nil, errors.Errorf("missing host in transport address: %s", address)
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		return func() (Transport, error) {
+			return NewTCPTransport(u.Host)
+		}, nil
+	case "rfc2217", "telnet":
+		return func() (Transport, error) {
+			return NewRFC2217Transport(u.Host, baud)
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported transport scheme: %s", u.Scheme)
+	}
+}
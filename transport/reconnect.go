@@ -0,0 +1,226 @@
+package transport
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	minReopenDelay = 500 * time.Millisecond
+	maxReopenDelay = 30 * time.Second
+)
+
+// ErrTransportClosed is returned when reading from or writing to a transport
+// that has been closed.
+var ErrTransportClosed = errors.New("transport closed")
+
+// ConnectionState is the state of a ReconnectingTransport.
+type ConnectionState int
+
+const (
+	// Connected means the underlying transport is open.
+	Connected ConnectionState = iota
+	// Disconnected means the underlying transport failed and is being reopened.
+	Disconnected
+)
+
+func (s ConnectionState) String() string {
+	if s == Disconnected {
+		return "disconnected"
+	}
+
+	return "connected"
+}
+
+// StateNotifier is implemented by transports that can report changes to their
+// connection state.
+type StateNotifier interface {
+	ConnectionStates() <-chan ConnectionState
+}
+
+// OpenFunc opens a new underlying transport.
+type OpenFunc func() (Transport, error)
+
+// ReconnectingTransport wraps a transport and reopens it (with exponential
+// backoff) whenever a read or write fails, e.g. because a USB stick was
+// unplugged or re-enumerated after a soft reset, or a network connection
+// dropped. Reads and writes block until the transport has been reopened.
+type ReconnectingTransport struct {
+	open OpenFunc
+
+	lock    sync.Mutex
+	ready   *sync.Cond
+	current Transport
+	closed  bool
+
+	states chan ConnectionState
+}
+
+// NewReconnectingTransport opens a transport using open, and returns a
+// transport that will use open again whenever the transport fails.
+func NewReconnectingTransport(open OpenFunc) (*ReconnectingTransport, error) {
+	current, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &ReconnectingTransport{
+		open:    open,
+		current: current,
+		states:  make(chan ConnectionState, 10),
+	}
+	transport.ready = sync.NewCond(&transport.lock)
+
+	return transport, nil
+}
+
+// ConnectionStates returns a channel that receives every change to the
+// connection state. If the channel isn't read, the oldest changes are dropped
+// once it is full.
+func (t *ReconnectingTransport) ConnectionStates() <-chan ConnectionState {
+	return t.states
+}
+
+// Close will close the transport.
+func (t *ReconnectingTransport) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.closed = true
+	if t.current != nil {
+		t.current.Close()
+	}
+
+	t.ready.Broadcast()
+}
+
+// Read implements the io.Reader interface.
+func (t *ReconnectingTransport) Read(p []byte) (int, error) {
+	for {
+		current, err := t.wait()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := current.Read(p)
+		if err == nil {
+			return n, nil
+		}
+
+		t.reopen(current)
+	}
+}
+
+// ReadByte implements the io.ByteReader interface.
+func (t *ReconnectingTransport) ReadByte() (byte, error) {
+	for {
+		current, err := t.wait()
+		if err != nil {
+			return 0, err
+		}
+
+		byt, err := current.ReadByte()
+		if err == nil {
+			return byt, nil
+		}
+
+		t.reopen(current)
+	}
+}
+
+// Write implements the io.Writer interface.
+func (t *ReconnectingTransport) Write(buf []byte) (int, error) {
+	for {
+		current, err := t.wait()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := current.Write(buf)
+		if err == nil {
+			return n, nil
+		}
+
+		t.reopen(current)
+	}
+}
+
+// wait returns the current transport, waiting for it to be reopened if
+// necessary.
+func (t *ReconnectingTransport) wait() (Transport, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for t.current == nil && !t.closed {
+		t.ready.Wait()
+	}
+
+	if t.closed {
+		return nil, ErrTransportClosed
+	}
+
+	return t.current, nil
+}
+
+// reopen replaces failed with a new transport. Only the first caller to notice
+// a failed transport reopens it; everyone else waits for it in wait().
+func (t *ReconnectingTransport) reopen(failed Transport) {
+	t.lock.Lock()
+	if t.closed || t.current != failed {
+		t.lock.Unlock()
+		return
+	}
+
+	t.current.Close()
+	t.current = nil
+	t.lock.Unlock()
+
+	t.notify(Disconnected)
+
+	delay := minReopenDelay
+	for {
+		time.Sleep(delay)
+
+		t.lock.Lock()
+		closed := t.closed
+		t.lock.Unlock()
+		if closed {
+			return
+		}
+
+		next, err := t.open()
+		if err == nil {
+			t.lock.Lock()
+			t.current = next
+			t.ready.Broadcast()
+			t.lock.Unlock()
+
+			t.notify(Connected)
+			return
+		}
+
+		delay *= 2
+		if delay > maxReopenDelay {
+			delay = maxReopenDelay
+		}
+	}
+}
+
+// notify sends a state change. If nobody has been reading the states, the
+// oldest one is dropped, so the latest state is never lost.
+func (t *ReconnectingTransport) notify(state ConnectionState) {
+	for {
+		select {
+		case t.states <- state:
+			return
+		default:
+		}
+
+		select {
+		case <-t.states:
+		default:
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// inboundCoalesceWindow is how long the recorder waits for more inbound bytes
+// before writing a record. The frame layer reads one byte at a time, so this
+// keeps a frame on a single line of the capture.
+const inboundCoalesceWindow = 5 * time.Millisecond
+
+// Recorder wraps a transport and writes everything read from or written to it
+// to a capture file, which can later be fed back through the stack with a
+// ReplayTransport.
+type Recorder struct {
+	transport Transport
+	output    io.Writer
+	start     time.Time
+
+	lock         sync.Mutex
+	pending      *CaptureRecord
+	pendingTimer *time.Timer
+	err          error
+}
+
+// NewRecorder will return a new recorder that wraps transport and writes the
+// capture to output.
+func NewRecorder(transport Transport, output io.Writer) *Recorder {
+	recorder := &Recorder{
+		transport: transport,
+		output:    output,
+		start:     time.Now(),
+	}
+
+	_, recorder.err = fmt.Fprintf(output, "%s %s\n", captureHeader, recorder.start.Format(time.RFC3339Nano))
+
+	return recorder
+}
+
+// Close will flush the capture and close the wrapped transport.
+func (r *Recorder) Close() {
+	r.Flush()
+	r.transport.Close()
+}
+
+// Err returns the first error encountered while writing the capture, if any.
+func (r *Recorder) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.err
+}
+
+// Flush writes any buffered inbound bytes to the capture.
+func (r *Recorder) Flush() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.flush()
+}
+
+// Read implements the io.Reader interface.
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.transport.Read(p)
+	if n > 0 {
+		r.recordInbound(p[:n])
+	}
+
+	return n, err
+}
+
+// ReadByte implements the io.ByteReader interface.
+func (r *Recorder) ReadByte() (byte, error) {
+	byt, err := r.transport.ReadByte()
+	if err == nil {
+		r.recordInbound([]byte{byt})
+	}
+
+	return byt, err
+}
+
+// Write implements the io.Writer interface.
+func (r *Recorder) Write(buf []byte) (int, error) {
+	r.lock.Lock()
+	r.flush()
+	r.write(CaptureRecord{
+		Offset:    time.Since(r.start),
+		Direction: Outbound,
+		Data:      append([]byte(nil), buf...),
+	})
+	r.lock.Unlock()
+
+	return r.transport.Write(buf)
+}
+
+func (r *Recorder) recordInbound(buf []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.pending == nil {
+		r.pending = &CaptureRecord{
+			Offset:    time.Since(r.start),
+			Direction: Inbound,
+		}
+	}
+
+	r.pending.Data = append(r.pending.Data, buf...)
+
+	if r.pendingTimer == nil {
+		r.pendingTimer = time.AfterFunc(inboundCoalesceWindow, r.Flush)
+	} else {
+		r.pendingTimer.Reset(inboundCoalesceWindow)
+	}
+}
+
+func (r *Recorder) flush() {
+	if r.pending == nil {
+		return
+	}
+
+	r.write(*r.pending)
+	r.pending = nil
+}
+
+func (r *Recorder) write(record CaptureRecord) {
+	_, err := fmt.Fprintln(r.output, record.String())
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
@@ -0,0 +1,269 @@
+package transport
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReplayTransport feeds the inbound bytes of a capture back to the stack and
+// checks the bytes written by the stack against the outbound bytes in the
+// capture.
+//
+// Inbound records are delivered in order, and never before all of the outbound
+// bytes that preceded them in the capture have been written (so a response is
+// never delivered before its request). The time between records is preserved.
+// Once every inbound record has been delivered, reads return io.EOF.
+type ReplayTransport struct {
+	records []CaptureRecord
+
+	// expected is every outbound byte in the capture, in order; outboundBefore[i]
+	// is the number of those bytes that precede records[i]
+	expected       []byte
+	outboundBefore []int
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	written int
+	rxIndex int
+	rxPos   int
+	closed  bool
+
+	// offset (in capture time) and wall time of the last record replayed, used
+	// to schedule the next inbound record
+	lastOffset time.Duration
+	lastAt     time.Time
+
+	mismatches []error
+	done       chan struct{}
+}
+
+// NewReplayTransport will return a new replay transport for the capture read
+// from reader.
+func NewReplayTransport(reader io.Reader) (*ReplayTransport, error) {
+	records, err := ReadCapture(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplayTransportFromRecords(records)
+}
+
+// NewReplayTransportFromRecords will return a new replay transport for the
+// given capture records. Inbound records must not be empty.
+func NewReplayTransportFromRecords(records []CaptureRecord) (*ReplayTransport, error) {
+	for i, record := range records {
+		if record.Direction == Inbound && len(record.Data) == 0 {
+			return nil, errors.Errorf("record %d: empty inbound record", i)
+		}
+	}
+
+	transport := &ReplayTransport{
+		records:        records,
+		expected:       []byte{},
+		outboundBefore: make([]int, len(records)),
+		lastAt:         time.Now(),
+		done:           make(chan struct{}),
+	}
+	transport.cond = sync.NewCond(&transport.lock)
+
+	for i, record := range records {
+		transport.outboundBefore[i] = len(transport.expected)
+		if record.Direction == Outbound {
+			transport.expected = append(transport.expected, record.Data...)
+		}
+	}
+
+	transport.lock.Lock()
+	transport.checkDone()
+	transport.lock.Unlock()
+
+	return transport, nil
+}
+
+// Close will close the transport.
+func (t *ReplayTransport) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.closed = true
+	t.cond.Broadcast()
+}
+
+// Done returns a channel that is closed once every record in the capture has
+// been replayed.
+func (t *ReplayTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+// Mismatches returns every difference found so far between the bytes written
+// to the transport and the outbound bytes in the capture.
+func (t *ReplayTransport) Mismatches() []error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return append([]error(nil), t.mismatches...)
+}
+
+// Err returns the first mismatch, or nil if the outbound bytes have matched the
+// capture so far.
+func (t *ReplayTransport) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.mismatches) == 0 {
+		return nil
+	}
+
+	return t.mismatches[0]
+}
+
+// Read implements the io.Reader interface.
+func (t *ReplayTransport) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	byt, err := t.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	p[0] = byt
+	return 1, nil
+}
+
+// ReadByte implements the io.ByteReader interface.
+func (t *ReplayTransport) ReadByte() (byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for {
+		if t.closed {
+			return 0, ErrTransportClosed
+		}
+
+		for t.rxIndex < len(t.records) && t.records[t.rxIndex].Direction != Inbound {
+			t.rxIndex++
+		}
+
+		if t.rxIndex >= len(t.records) {
+			return 0, io.EOF
+		}
+
+		record := t.records[t.rxIndex]
+
+		if t.written < t.outboundBefore[t.rxIndex] {
+			t.cond.Wait()
+			continue
+		}
+
+		if t.rxPos == 0 {
+			if wait := time.Until(t.lastAt.Add(record.Offset - t.lastOffset)); wait > 0 {
+				t.lock.Unlock()
+				time.Sleep(wait)
+				t.lock.Lock()
+				continue
+			}
+
+			t.mark(record.Offset)
+		}
+
+		byt := record.Data[t.rxPos]
+		t.rxPos++
+
+		if t.rxPos == len(record.Data) {
+			t.rxIndex++
+			t.rxPos = 0
+			t.checkDone()
+		}
+
+		return byt, nil
+	}
+}
+
+// Write implements the io.Writer interface. Writes never fail; differences from
+// the capture are available from Mismatches.
+func (t *ReplayTransport) Write(buf []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return 0, ErrTransportClosed
+	}
+
+	start := t.written
+	for i, byt := range buf {
+		pos := start + i
+
+		if pos >= len(t.expected) {
+			t.mismatches = append(t.mismatches, errors.Errorf(
+				"unexpected outbound bytes after end of capture: %x", buf[i:],
+			))
+			break
+		}
+
+		if t.expected[pos] != byt {
+			t.mismatches = append(t.mismatches, errors.Errorf(
+				"outbound byte %d (%s): expected %#02x, got %#02x",
+				pos, t.recordAt(pos).Offset, t.expected[pos], byt,
+			))
+			break
+		}
+	}
+
+	t.written += len(buf)
+	if t.written > len(t.expected) {
+		t.written = len(t.expected)
+	}
+
+	if t.written > start {
+		t.mark(t.recordAt(t.written - 1).Offset)
+	}
+
+	t.checkDone()
+	t.cond.Broadcast()
+
+	return len(buf), nil
+}
+
+// recordAt returns the outbound record containing the given outbound byte.
+func (t *ReplayTransport) recordAt(pos int) CaptureRecord {
+	var found CaptureRecord
+	for i, record := range t.records {
+		if record.Direction == Outbound && t.outboundBefore[i] <= pos {
+			found = record
+		}
+	}
+
+	return found
+}
+
+func (t *ReplayTransport) mark(offset time.Duration) {
+	if offset >= t.lastOffset {
+		t.lastOffset = offset
+		t.lastAt = time.Now()
+	}
+}
+
+func (t *ReplayTransport) checkDone() {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+
+	rxDone := true
+	for i := t.rxIndex; i < len(t.records); i++ {
+		if t.records[i].Direction == Inbound {
+			rxDone = false
+			break
+		}
+	}
+
+	if rxDone && t.written >= len(t.expected) {
+		close(t.done)
+	}
+}
@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Telnet protocol bytes (RFC 854) and options used by RFC 2217.
+const (
+	telnetSE   byte = 240
+	telnetSB   byte = 250
+	telnetWILL byte = 251
+	telnetWONT byte = 252
+	telnetDO   byte = 253
+	telnetDONT byte = 254
+	telnetIAC  byte = 255
+
+	telnetOptionBinary          byte = 0
+	telnetOptionSuppressGoAhead byte = 3
+	telnetOptionComPortControl  byte = 44
+	comPortSetBaudRate          byte = 1
+	comPortSetDataSize          byte = 2
+	comPortSetParity            byte = 3
+	comPortSetStopSize          byte = 4
+	comPortParityNone           byte = 1
+	comPortStopSizeOne          byte = 1
+	comPortDataSizeEight        byte = 8
+)
+
+// errTelnetCommand is returned by readTelnetCommand when the bytes read were a
+// telnet command rather than data, so the caller should keep reading.
+var errTelnetCommand = errors.New("telnet command")
+
+// rfc2217Negotiation builds the option negotiation sent right after connecting:
+// binary transmission in both directions, COM port control, and 8N1 at the
+// given baud rate (which is left alone if zero).
+func rfc2217Negotiation(baud int) []byte {
+	buf := []byte{
+		telnetIAC, telnetWILL, telnetOptionBinary,
+		telnetIAC, telnetDO, telnetOptionBinary,
+		telnetIAC, telnetDO, telnetOptionSuppressGoAhead,
+		telnetIAC, telnetWILL, telnetOptionComPortControl,
+	}
+
+	if baud > 0 {
+		rate := make([]byte, 4)
+		binary.BigEndian.PutUint32(rate, uint32(baud))
+		buf = append(buf, comPortCommand(comPortSetBaudRate, rate...)...)
+	}
+
+	buf = append(buf, comPortCommand(comPortSetDataSize, comPortDataSizeEight)...)
+	buf = append(buf, comPortCommand(comPortSetParity, comPortParityNone)...)
+	buf = append(buf, comPortCommand(comPortSetStopSize, comPortStopSizeOne)...)
+
+	return buf
+}
+
+func comPortCommand(command byte, value ...byte) []byte {
+	buf := []byte{telnetIAC, telnetSB, telnetOptionComPortControl, command}
+	buf = append(buf, telnetEscape(value)...)
+	return append(buf, telnetIAC, telnetSE)
+}
+
+// telnetEscape doubles every IAC byte so that it is treated as data.
+func telnetEscape(buf []byte) []byte {
+	out := make([]byte, 0, len(buf))
+	for _, byt := range buf {
+		if byt == telnetIAC {
+			out = append(out, telnetIAC)
+		}
+		out = append(out, byt)
+	}
+
+	return out
+}
+
+// readTelnetCommand handles everything following an IAC byte. An escaped IAC is
+// returned as data; anything else is consumed (and answered if necessary), in
+// which case errTelnetCommand is returned.
+func (t *TCPTransport) readTelnetCommand() (byte, error) {
+	command, err := t.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch command {
+	case telnetIAC:
+		return telnetIAC, nil
+
+	case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+		option, err := t.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		// We already asked for everything we support when connecting, so only
+		// requests for unsupported options need an answer.
+		var reply byte
+		switch {
+		case command == telnetDO && option != telnetOptionBinary && option != telnetOptionComPortControl:
+			reply = telnetWONT
+		case command == telnetWILL && option != telnetOptionBinary && option != telnetOptionSuppressGoAhead &&
+			option != telnetOptionComPortControl:
+			reply = telnetDONT
+		default:
+			return 0, errTelnetCommand
+		}
+
+		t.writeLock.Lock()
+		_, err = t.conn.Write([]byte{telnetIAC, reply, option})
+		t.writeLock.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+	case telnetSB:
+		// Subnegotiations from the server are COM port notifications (line and
+		// modem state) that the Z-Wave stack has no use for.
+		for {
+			byt, err := t.reader.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+
+			if byt != telnetIAC {
+				continue
+			}
+
+			byt, err = t.reader.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+
+			if byt == telnetSE {
+				break
+			}
+		}
+	}
+
+	return 0, errTelnetCommand
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const tcpDialTimeout = 5 * time.Second
+
+// TCPTransport is a transport for Z-Wave controllers exposed over the network
+// (e.g. with ser2net). It supports both raw TCP and RFC 2217 (telnet COM port
+// control) connections. A TCPTransport wraps a single connection; use Open (or
+// wrap it in a ReconnectingTransport) to re-establish dropped connections.
+type TCPTransport struct {
+	rfc2217 bool
+
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeLock sync.Mutex
+}
+
+// NewTCPTransport will return a new raw TCP transport connected to address.
+func NewTCPTransport(address string) (*TCPTransport, error) {
+	return newTCPTransport(address, false, 0)
+}
+
+// NewRFC2217Transport will return a new TCP transport connected to address
+// that negotiates the serial port settings with the remote end using the
+// RFC 2217 telnet COM port control option.
+func NewRFC2217Transport(address string, baud int) (*TCPTransport, error) {
+	return newTCPTransport(address, true, baud)
+}
+
+func newTCPTransport(address string, rfc2217 bool, baud int) (*TCPTransport, error) {
+	conn, err := net.DialTimeout("tcp", address, tcpDialTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial")
+	}
+
+	if rfc2217 {
+		if _, err = conn.Write(rfc2217Negotiation(baud)); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "rfc2217 negotiation")
+		}
+	}
+
+	transport := &TCPTransport{
+		rfc2217: rfc2217,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+	}
+
+	return transport, nil
+}
+
+// Close will close the transport.
+func (t *TCPTransport) Close() {
+	t.conn.Close()
+}
+
+// Read implements the io.Reader interface.
+func (t *TCPTransport) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	byt, err := t.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	p[0] = byt
+	return 1, nil
+}
+
+// ReadByte implements the io.ByteReader interface.
+func (t *TCPTransport) ReadByte() (byte, error) {
+	for {
+		byt, err := t.reader.ReadByte()
+		if err != nil || !t.rfc2217 || byt != telnetIAC {
+			return byt, err
+		}
+
+		byt, err = t.readTelnetCommand()
+		if err != errTelnetCommand {
+			return byt, err
+		}
+	}
+}
+
+// Write implements the io.Writer interface.
+func (t *TCPTransport) Write(buf []byte) (int, error) {
+	out := buf
+	if t.rfc2217 {
+		out = telnetEscape(buf)
+	}
+
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	if _, err := t.conn.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(buf), nil
+}
@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenReconnectsTCP(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	transport, err := Open("tcp://"+listener.Addr().String(), 115200)
+	require.NoError(t, err)
+	defer transport.Close()
+
+	first := <-conns
+	first.Write([]byte{0x06})
+
+	byt, err := transport.ReadByte()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0x06, byt)
+
+	// Drop the connection; a pending read should redial and keep reading
+	read := make(chan byte)
+	go func() {
+		byt, _ := transport.ReadByte()
+		read <- byt
+	}()
+
+	first.Close()
+
+	select {
+	case second := <-conns:
+		defer second.Close()
+		second.Write([]byte{0x15})
+	case <-time.After(5 * time.Second):
+		t.Fatal("transport did not reconnect")
+	}
+
+	assert.EqualValues(t, 0x15, <-read)
+	assert.Equal(t, Disconnected, <-transport.ConnectionStates())
+	assert.Equal(t, Connected, <-transport.ConnectionStates())
+}
+
+func TestRFC2217TransportUnescapesData(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	transport, err := Open("rfc2217://"+listener.Addr().String(), 115200)
+	require.NoError(t, err)
+	defer transport.Close()
+
+	conn := <-accepted
+	defer conn.Close()
+
+	negotiation := make([]byte, len(rfc2217Negotiation(115200)))
+	_, err = conn.Read(negotiation)
+	require.NoError(t, err)
+	assert.Equal(t, rfc2217Negotiation(115200), negotiation)
+
+	// A subnegotiation notification, then an escaped 0xFF data byte
+	conn.Write([]byte{
+		telnetIAC, telnetSB, telnetOptionComPortControl, 107, 0x00, telnetIAC, telnetSE,
+		0x01, telnetIAC, telnetIAC, 0x02,
+	})
+
+	for _, expected := range []byte{0x01, 0xFF, 0x02} {
+		byt, err := transport.ReadByte()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, byt)
+	}
+
+	_, err = transport.Write([]byte{0x01, 0xFF})
+	require.NoError(t, err)
+
+	written := make([]byte, 3)
+	_, err = conn.Read(written)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, telnetIAC, telnetIAC}, written)
+}
+
+func TestReconnectingTransportKeepsLatestState(t *testing.T) {
+	transport := &ReconnectingTransport{states: make(chan ConnectionState, 2)}
+
+	transport.notify(Disconnected)
+	transport.notify(Connected)
+	transport.notify(Disconnected)
+	transport.notify(Connected)
+
+	assert.Equal(t, Disconnected, <-transport.ConnectionStates())
+	assert.Equal(t, Connected, <-transport.ConnectionStates())
+}